@@ -0,0 +1,100 @@
+// Package cli implements the cube command's client subcommands, which talk
+// to a running manager over its REST API.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultManager is the manager address subcommands use when neither the
+// -manager flag nor CUBE_MANAGER gives one.
+const DefaultManager = "localhost:5556"
+
+// Run runs "cube run": it reads a task manifest, in JSON or YAML, from the
+// file named by -f, or from stdin when that is "-", submits the task to the
+// manager and prints the submitted task's ID to stdout.
+func Run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	file := fs.String("f", "", `task manifest to submit, or "-" for stdin`)
+	addr := fs.String("manager", managerAddr(), "address of the manager API")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("run: -f is required")
+	}
+
+	r := stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("run: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	t, err := task.ParseManifest(r)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+
+	submitted, err := submit(*addr, t)
+	if err != nil {
+		return fmt.Errorf("run: %w", err)
+	}
+	fmt.Fprintln(stdout, submitted.ID)
+	return nil
+}
+
+// managerAddr returns CUBE_MANAGER, or DefaultManager when it is unset.
+func managerAddr() string {
+	if addr := os.Getenv("CUBE_MANAGER"); addr != "" {
+		return addr
+	}
+	return DefaultManager
+}
+
+// submit posts t to the manager at addr as a new pending task and returns
+// the task as the manager recorded it.
+func submit(addr string, t task.Task) (task.Task, error) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	t.State = task.Pending
+	te := task.TaskEvent{ID: uuid.New(), State: task.Pending, Timestamp: time.Now(), Task: t}
+	data, err := json.Marshal(te)
+	if err != nil {
+		return t, fmt.Errorf("marshalling task event: %w", err)
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/tasks", addr), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return t, fmt.Errorf("submitting task: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		var e cubeerrors.Envelope
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error.Message == "" {
+			return t, fmt.Errorf("submitting task: manager answered %s", resp.Status)
+		}
+		return t, fmt.Errorf("submitting task: %s", e.Error.Message)
+	}
+
+	var got task.Task
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		return t, fmt.Errorf("decoding response: %w", err)
+	}
+	return got, nil
+}
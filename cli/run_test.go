@@ -0,0 +1,74 @@
+package cli_test
+
+import (
+	"bytes"
+	"github.com/christinavaneyssen/cube/cli"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRun_ReadsManifestFromStdin(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"yaml", "name: web\nimage: strm/helloworld-http\nmemory: 64\nenv:\n  - PORT=8080\n"},
+		{"json", `{"Name": "web", "Image": "strm/helloworld-http", "Memory": 64, "Env": ["PORT=8080"]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &manager.Manager{
+				TaskDb:        make(map[string][]*task.Task),
+				EventDb:       make(map[string][]*task.TaskEvent),
+				WorkerTaskMap: make(map[string][]uuid.UUID),
+				TaskWorkerMap: make(map[uuid.UUID]string),
+			}
+			srv := httptest.NewServer((&manager.Api{Manager: m}).Handler())
+			defer srv.Close()
+
+			// Pipe the manifest in as "cat task.yaml | cube run -f -" would.
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("Pipe() error = %v", err)
+			}
+			defer r.Close()
+			go func() {
+				w.WriteString(tt.manifest)
+				w.Close()
+			}()
+
+			var stdout bytes.Buffer
+			args := []string{"-f", "-", "-manager", strings.TrimPrefix(srv.URL, "http://")}
+			if err := cli.Run(args, r, &stdout); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			id, err := uuid.Parse(strings.TrimSpace(stdout.String()))
+			if err != nil {
+				t.Fatalf("output %q is not a task ID: %v", stdout.String(), err)
+			}
+			got, err := m.GetTask(id)
+			if err != nil {
+				t.Fatalf("GetTask() error = %v", err)
+			}
+			if got.Name != "web" || got.Image != "strm/helloworld-http" || got.Memory != 64 || len(got.Env) != 1 || got.Env[0] != "PORT=8080" {
+				t.Errorf("submitted task = %+v, want the manifest's", got)
+			}
+			if got.State != task.Pending {
+				t.Errorf("state = %v, want %v", got.State, task.Pending)
+			}
+		})
+	}
+}
+
+func TestRun_RejectsUnknownKey(t *testing.T) {
+	err := cli.Run([]string{"-f", "-", "-manager", "127.0.0.1:1"}, strings.NewReader("name: web\nimgae: nginx\n"), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "imgae") {
+		t.Errorf("Run() error = %v, want the unknown key reported", err)
+	}
+}
@@ -0,0 +1,51 @@
+package clock_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+
+	c.Advance(90 * time.Second)
+	if got, want := c.Now(), start.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("Now() after Advance = %v, want %v", got, want)
+	}
+
+	c.Set(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("Now() after Set = %v, want %v", got, start)
+	}
+}
+
+func TestFake_After(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+
+	fired := c.After(time.Minute)
+	c.Advance(59 * time.Second)
+	select {
+	case <-fired:
+		t.Fatal("After() fired before its time")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case got := <-fired:
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("After() sent %v, want %v", got, want)
+		}
+	default:
+		t.Fatal("After() did not fire once its time came")
+	}
+
+	select {
+	case <-c.After(0):
+	default:
+		t.Error("After(0) did not fire straight away")
+	}
+}
@@ -0,0 +1,98 @@
+// Package errors defines the sentinel errors shared across the orchestrator so
+// callers can match a failure with Is regardless of which layer reported it.
+// It re-exports Is, As and Unwrap from the standard library so a single
+// import covers both.
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrNotFound is returned when a task, worker or other record does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrWorkerUnavailable is returned when a worker cannot be reached
+	ErrWorkerUnavailable = errors.New("worker unavailable")
+
+	// ErrInvalidState is returned when a task cannot make the requested state change
+	ErrInvalidState = errors.New("invalid state")
+
+	// ErrNoCapacity is returned when no worker has room for a task
+	ErrNoCapacity = errors.New("no capacity")
+
+	// ErrImagePull is returned when a task's image cannot be pulled
+	ErrImagePull = errors.New("image pull failed")
+
+	// ErrOverloaded is returned when work is turned away until load drops
+	ErrOverloaded = errors.New("overloaded")
+
+	// ErrInvalidRequest is returned when a request is malformed or asks for
+	// something that can never succeed
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrQuotaExceeded is returned when a submission would take its
+	// namespace past a resource quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrAPIVersionTooOld is returned when a task uses a feature the Docker
+	// API version spoken with the daemon does not support
+	ErrAPIVersionTooOld = errors.New("Docker API version too old")
+)
+
+// Wrap marks err as a kind of failure, so Is(result, kind) holds while err
+// stays reachable through the chain. Wrap returns nil when err is nil.
+func Wrap(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// Wrapf returns a new error of the given kind with a formatted message.
+func Wrapf(kind error, format string, args ...any) error {
+	return fmt.Errorf("%w: %s", kind, fmt.Sprintf(format, args...))
+}
+
+// Is reports whether any error in err's chain matches target.
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target.
+func As(err error, target any) bool {
+	return errors.As(err, target)
+}
+
+// Unwrap returns the error err wraps, or nil.
+func Unwrap(err error) error {
+	return errors.Unwrap(err)
+}
+
+// HTTPStatus returns the HTTP status code an API should answer err with.
+func HTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrInvalidRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidState):
+		return http.StatusConflict
+	case errors.Is(err, ErrNoCapacity), errors.Is(err, ErrWorkerUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrImagePull):
+		return http.StatusBadGateway
+	case errors.Is(err, ErrOverloaded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrQuotaExceeded):
+		return http.StatusForbidden
+	case errors.Is(err, ErrAPIVersionTooOld):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
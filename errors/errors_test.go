@@ -0,0 +1,90 @@
+package errors_test
+
+import (
+	stderrors "errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/errors"
+	"net/http"
+	"testing"
+)
+
+func TestWrap_MatchesKindAndCause(t *testing.T) {
+	cause := stderrors.New("manifest unknown")
+	err := fmt.Errorf("starting task: %w", errors.Wrap(errors.ErrImagePull, cause))
+
+	if !errors.Is(err, errors.ErrImagePull) {
+		t.Errorf("Is(%v, ErrImagePull) = false, want true", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("Is(%v, cause) = false, want true", err)
+	}
+	if errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("Is(%v, ErrNotFound) = true, want false", err)
+	}
+	if errors.Wrap(errors.ErrNotFound, nil) != nil {
+		t.Error("Wrap() of a nil error is not nil")
+	}
+}
+
+func TestWrapf(t *testing.T) {
+	err := errors.Wrapf(errors.ErrInvalidState, "cannot pause a %s task", "Completed")
+
+	if !errors.Is(err, errors.ErrInvalidState) {
+		t.Errorf("Is(%v, ErrInvalidState) = false, want true", err)
+	}
+	if got, want := err.Error(), "invalid state: cannot pause a Completed task"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.Newf(errors.ErrInvalidRequest, "bad ID"), http.StatusBadRequest},
+		{errors.Wrapf(errors.ErrNotFound, "task 1"), http.StatusNotFound},
+		{errors.Wrapf(errors.ErrInvalidState, "paused"), http.StatusConflict},
+		{errors.ErrNoCapacity, http.StatusServiceUnavailable},
+		{errors.ErrWorkerUnavailable, http.StatusServiceUnavailable},
+		{errors.ErrImagePull, http.StatusBadGateway},
+		{errors.ErrOverloaded, http.StatusTooManyRequests},
+		{errors.Wrapf(errors.ErrQuotaExceeded, "namespace team-a"), http.StatusForbidden},
+		{errors.Wrapf(errors.ErrAPIVersionTooOld, "GPUs need API 1.40"), http.StatusNotImplemented},
+		{stderrors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := errors.HTTPStatus(tt.err); got != tt.want {
+			t.Errorf("HTTPStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	err := fmt.Errorf("stopping: %w", errors.WithTask(errors.Newf(errors.ErrInvalidState, "task is Completed"), "t-1"))
+
+	got := errors.Response(err)
+	want := errors.Envelope{Error: errors.Body{Code: errors.CodeInvalidState, Message: "stopping: task is Completed", TaskID: "t-1"}}
+	if got != want {
+		t.Errorf("Response() = %+v, want %+v", got, want)
+	}
+	if got := errors.Code(stderrors.New("boom")); got != errors.CodeInternal {
+		t.Errorf("Code() = %q, want %q", got, errors.CodeInternal)
+	}
+}
+
+func TestKind(t *testing.T) {
+	kinds := []error{
+		errors.ErrInvalidRequest, errors.ErrNotFound, errors.ErrInvalidState, errors.ErrNoCapacity,
+		errors.ErrWorkerUnavailable, errors.ErrImagePull, errors.ErrOverloaded, errors.ErrQuotaExceeded,
+		errors.ErrAPIVersionTooOld,
+	}
+	for _, kind := range kinds {
+		if got := errors.Kind(errors.Code(kind)); got != kind {
+			t.Errorf("Kind(Code(%v)) = %v, want %v", kind, got, kind)
+		}
+	}
+	if got := errors.Kind(errors.CodeInternal); got != nil {
+		t.Errorf("Kind(%q) = %v, want nil", errors.CodeInternal, got)
+	}
+}
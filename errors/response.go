@@ -0,0 +1,137 @@
+package errors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Codes identify the kind of a failure in an error response.
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeNotFound          = "not_found"
+	CodeInvalidState      = "invalid_state"
+	CodeNoCapacity        = "no_capacity"
+	CodeWorkerUnavailable = "worker_unavailable"
+	CodeImagePull         = "image_pull_failed"
+	CodeOverloaded        = "overloaded"
+	CodeQuotaExceeded     = "quota_exceeded"
+	CodeAPIVersionTooOld  = "api_version_too_old"
+	CodeInternal          = "internal"
+)
+
+// Code returns the code an API reports err with, derived from its kind.
+func Code(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidRequest):
+		return CodeInvalidRequest
+	case errors.Is(err, ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrInvalidState):
+		return CodeInvalidState
+	case errors.Is(err, ErrNoCapacity):
+		return CodeNoCapacity
+	case errors.Is(err, ErrWorkerUnavailable):
+		return CodeWorkerUnavailable
+	case errors.Is(err, ErrImagePull):
+		return CodeImagePull
+	case errors.Is(err, ErrOverloaded):
+		return CodeOverloaded
+	case errors.Is(err, ErrQuotaExceeded):
+		return CodeQuotaExceeded
+	case errors.Is(err, ErrAPIVersionTooOld):
+		return CodeAPIVersionTooOld
+	default:
+		return CodeInternal
+	}
+}
+
+// Kind returns the sentinel error a code reports, or nil for CodeInternal
+// and codes it does not know.
+func Kind(code string) error {
+	switch code {
+	case CodeInvalidRequest:
+		return ErrInvalidRequest
+	case CodeNotFound:
+		return ErrNotFound
+	case CodeInvalidState:
+		return ErrInvalidState
+	case CodeNoCapacity:
+		return ErrNoCapacity
+	case CodeWorkerUnavailable:
+		return ErrWorkerUnavailable
+	case CodeImagePull:
+		return ErrImagePull
+	case CodeOverloaded:
+		return ErrOverloaded
+	case CodeQuotaExceeded:
+		return ErrQuotaExceeded
+	case CodeAPIVersionTooOld:
+		return ErrAPIVersionTooOld
+	default:
+		return nil
+	}
+}
+
+// Envelope is the body both APIs answer a failed request with.
+type Envelope struct {
+	Error Body `json:"error"`
+}
+
+// Body describes a failed request.
+type Body struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	TaskID  string `json:"taskId,omitempty"`
+}
+
+// kindError is a failure of a given kind whose message leaves the kind out.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string        { return e.msg }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// Newf returns an error matching kind whose message is just the formatted
+// text, for failures reported to API clients as they are.
+func Newf(kind error, format string, args ...any) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// taskError ties a failure to the task it concerns.
+type taskError struct {
+	err    error
+	taskID string
+}
+
+func (e *taskError) Error() string { return e.err.Error() }
+func (e *taskError) Unwrap() error { return e.err }
+
+// WithTask records that err concerns the task with the given ID, so its
+// error response names the task. WithTask returns nil when err is nil.
+func WithTask(err error, taskID string) error {
+	if err == nil {
+		return nil
+	}
+	return &taskError{err: err, taskID: taskID}
+}
+
+// Response returns the envelope describing err.
+func Response(err error) Envelope {
+	body := Body{Code: Code(err), Message: err.Error()}
+	var te *taskError
+	if errors.As(err, &te) {
+		body.TaskID = te.taskID
+	}
+	return Envelope{Error: body}
+}
+
+// WriteHTTP answers a request with the status and envelope for err.
+func WriteHTTP(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatus(err))
+	json.NewEncoder(w).Encode(Response(err))
+}
@@ -0,0 +1,110 @@
+// Package logging writes the orchestrator's log lines through the standard
+// logger at a level, so the manager, workers and Docker calls can be made
+// more or less talkative together.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is how important a log line is. Lines below the level set with
+// SetLevel are dropped.
+type Level int32
+
+const (
+	// Debug lines trace routine work, such as each scheduling tick
+	Debug Level = iota
+
+	// Info lines record the changes the orchestrator makes
+	Info
+
+	// Warn lines report trouble the orchestrator works around
+	Warn
+
+	// Error lines report failures
+	Error
+)
+
+// levelNames maps each level to the name ParseLevel accepts and log lines
+// are prefixed with.
+var levelNames = map[Level]string{
+	Debug: "debug",
+	Info:  "info",
+	Warn:  "warn",
+	Error: "error",
+}
+
+// level is the lowest level logged; Info until SetLevel is called.
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(Info))
+}
+
+// ParseLevel parses a level by name, in any case.
+func ParseLevel(s string) (Level, error) {
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %q; use debug, info, warn or error", s)
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// SetLevel sets the lowest level logged.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// Enabled reports whether lines at l are logged.
+func Enabled(l Level) bool {
+	return l >= Level(level.Load())
+}
+
+// Debugf logs a line at Debug level.
+func Debugf(format string, args ...any) {
+	logf(Debug, format, args...)
+}
+
+// Infof logs a line at Info level.
+func Infof(format string, args ...any) {
+	logf(Info, format, args...)
+}
+
+// Warnf logs a line at Warn level.
+func Warnf(format string, args ...any) {
+	logf(Warn, format, args...)
+}
+
+// Errorf logs a line at Error level.
+func Errorf(format string, args ...any) {
+	logf(Error, format, args...)
+}
+
+// Printer logs every line at its level. It suits code that logs through a
+// Printf method, such as task.Docker's Logger.
+type Printer Level
+
+// Printf logs a line at the printer's level.
+func (p Printer) Printf(format string, args ...any) {
+	logf(Level(p), format, args...)
+}
+
+// logf writes the line to the standard logger, prefixed with its level,
+// when its level is enabled.
+func logf(l Level, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	log.Output(3, strings.ToUpper(l.String())+" "+fmt.Sprintf(format, args...))
+}
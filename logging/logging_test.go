@@ -0,0 +1,51 @@
+package logging_test
+
+import (
+	"bytes"
+	"github.com/christinavaneyssen/cube/logging"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetLevelSuppressesDebugAtInfo(t *testing.T) {
+	logs := bytes.Buffer{}
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	defer logging.SetLevel(logging.Info)
+
+	logging.SetLevel(logging.Info)
+	logging.Debugf("scheduling tick %d", 1)
+	logging.Infof("sending task %s", "web")
+	logging.Printer(logging.Debug).Printf("pulling image %s", "nginx")
+	if got := logs.String(); strings.Contains(got, "tick") || strings.Contains(got, "pulling") || !strings.Contains(got, "INFO sending task web") {
+		t.Errorf("logs at info level:\n%s\nwant the info line only", got)
+	}
+
+	logs.Reset()
+	logging.SetLevel(logging.Debug)
+	logging.Debugf("scheduling tick %d", 2)
+	if got := logs.String(); !strings.Contains(got, "DEBUG scheduling tick 2") {
+		t.Errorf("logs at debug level:\n%s\nwant the debug line", got)
+	}
+
+	logs.Reset()
+	logging.SetLevel(logging.Error)
+	logging.Warnf("worker %s is down", "w1")
+	logging.Errorf("sending task failed")
+	if got := logs.String(); strings.Contains(got, "down") || !strings.Contains(got, "ERROR sending task failed") {
+		t.Errorf("logs at error level:\n%s\nwant the error line only", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]logging.Level{"debug": logging.Debug, "INFO": logging.Info, "Warn": logging.Warn, "error": logging.Error} {
+		if got, err := logging.ParseLevel(name); err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := logging.ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(\"verbose\") succeeded, want an error")
+	}
+}
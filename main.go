@@ -1,67 +1,204 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"github.com/christinavaneyssen/cube/cli"
+	"github.com/christinavaneyssen/cube/logging"
 	"github.com/christinavaneyssen/cube/manager"
-	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/ratelimit"
+	"github.com/christinavaneyssen/cube/rpc"
+	"github.com/christinavaneyssen/cube/store"
 	"github.com/christinavaneyssen/cube/task"
 	"github.com/christinavaneyssen/cube/worker"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		if err := cli.Run(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if name := os.Getenv("CUBE_LOG_LEVEL"); name != "" {
+		level, err := logging.ParseLevel(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logging.SetLevel(level)
+	}
+
+	host := os.Getenv("CUBE_HOST")
+	port, err := strconv.Atoi(os.Getenv("CUBE_PORT"))
+	if err != nil {
+		port = 5555
+	}
+
+	dc, err := task.NewDockerClient(os.Getenv("CUBE_DOCKER_API_VERSION"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := worker.Worker{
+		Name:           "first-worker",
+		Queue:          *queue.New(),
+		Db:             make(map[uuid.UUID]*task.Task),
+		MaxConcurrent:  2,
+		Client:         dc,
+		LogDir:         os.Getenv("CUBE_LOG_DIR"),
+		DiskPath:       os.Getenv("CUBE_DISK_PATH"),
+		RegistryMirror: os.Getenv("CUBE_REGISTRY_MIRROR"),
+		WarmPool:       envList("CUBE_WARM_IMAGES"),
+		DrainTimeout:   20 * time.Second,
+		SecretsDir:     os.Getenv("CUBE_SECRETS_DIR"),
+	}
+	var s store.Store
+	if path := os.Getenv("CUBE_STORE"); path != "" {
+		var opts []store.Option
+		if os.Getenv("CUBE_STORE_CODEC") == "gob" {
+			opts = append(opts, store.WithCodec(store.Gob))
+		}
+		fs, err := store.NewFileStore(path, opts...)
+		if err != nil {
+			log.Fatalf("Error opening store: %v", err)
+		}
+		s = fs
+		w.Store = s
+	}
+	api := worker.Api{Address: host, Port: port, Worker: &w, Limiter: rateLimiter()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go worker.Watchdog(ctx, "task loop", func(ctx context.Context) { w.RunTasks(ctx, 10*time.Second) })
+	go worker.Watchdog(ctx, "update loop", func(ctx context.Context) { w.RunUpdates(ctx, 15*time.Second) })
+	go func() {
+		if err := api.Start(); err != nil {
+			log.Fatalf("Worker API stopped: %v", err)
+		}
+	}()
+	go w.RunWarmPool(ctx, 10*time.Minute)
+	go w.RunHeartbeats(ctx, fmt.Sprintf("%s:%d", host, port+1), fmt.Sprintf("%s:%d", host, port), 15*time.Second)
+
+	m := manager.Manager{
+		Pending:       *queue.New(),
+		TaskDb:        map[string][]*task.Task{},
+		EventDb:       map[string][]*task.TaskEvent{},
+		WorkerTaskMap: map[string][]uuid.UUID{},
+		TaskWorkerMap: map[uuid.UUID]string{},
+		Store:         s,
+		Retry: manager.RetryPolicy{
+			Retries:          3,
+			Backoff:          500 * time.Millisecond,
+			MaxBackoff:       5 * time.Second,
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Webhook: manager.Webhook{
+			URL:          os.Getenv("CUBE_WEBHOOK_URL"),
+			AllowedHosts: envList("CUBE_WEBHOOK_HOSTS"),
+			Retry:        manager.RetryPolicy{Retries: 5, Backoff: time.Second, MaxBackoff: time.Minute},
+		},
+	}
+	if err := m.Restore(); err != nil {
+		log.Fatalf("Error restoring manager state: %v", err)
+	}
+	if path := os.Getenv("CUBE_CONFIG"); path != "" {
+		if err := m.ReloadConfig(path); err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go m.WatchConfig(ctx, path, hup, 5*time.Second)
+	}
+
+	mapi := manager.Api{Address: host, Port: port + 1, Manager: &m, Limiter: rateLimiter()}
+	go func() {
+		if err := mapi.Start(); err != nil {
+			log.Fatalf("Manager API stopped: %v", err)
+		}
+	}()
+
+	if grpcPort, err := strconv.Atoi(os.Getenv("CUBE_GRPC_PORT")); err == nil {
+		go serveGRPC(fmt.Sprintf("%s:%d", host, grpcPort), &rpc.WorkerServer{Worker: &w})
+		go serveGRPC(fmt.Sprintf("%s:%d", host, grpcPort+1), &rpc.ManagerServer{Manager: &m})
+	}
+
 	t := task.Task{
 		ID:     uuid.New(),
 		Name:   "first-task",
 		State:  task.Pending,
-		Image:  "Image-1",
+		Image:  "strm/helloworld-http",
 		Memory: 1024,
 		Disk:   1,
 	}
-
-	te := task.TaskEvent{
+	m.AddTask(task.TaskEvent{
 		ID:        uuid.New(),
 		State:     task.Pending,
 		Timestamp: time.Now(),
 		Task:      t,
+	})
+
+	go m.ProcessTasks(ctx, 10*time.Second)
+	go m.RunUpdates(ctx, 15*time.Second)
+	go m.RunSweeper(ctx, time.Hour)
+
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := m.Shutdown(shutdownCtx); err != nil {
+		logging.Errorf("Error shutting down manager: %v", err)
+	}
+	if err := w.Shutdown(shutdownCtx); err != nil {
+		logging.Errorf("Error shutting down worker: %v", err)
 	}
+}
 
-	fmt.Printf("task: %v\n", t)
-	fmt.Printf("task event: %v\n", te)
+// serveGRPC serves cube.Tasks from srv on addr.
+func serveGRPC(addr string, srv rpc.TasksServer) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Error listening for gRPC on %s: %v", addr, err)
+	}
+	s := rpc.NewServer(srv)
+	if err := s.Serve(lis); err != nil {
+		log.Fatalf("gRPC server on %s stopped: %v", addr, err)
+	}
+}
 
-	w := worker.Worker{
-		Name:  "first-worker",
-		Queue: *queue.New(),
-		Db:    make(map[uuid.UUID]*task.Task),
+// rateLimiter returns the limiter for the APIs configured by CUBE_RATE_LIMIT,
+// in requests a second per client, and CUBE_RATE_BURST, or nil when no limit
+// is set.
+func rateLimiter() *ratelimit.Limiter {
+	rate, err := strconv.ParseFloat(os.Getenv("CUBE_RATE_LIMIT"), 64)
+	if err != nil || rate <= 0 {
+		return nil
 	}
-	fmt.Printf("worker: %v\n", w)
-	w.CollectStats()
-	w.RunTask()
-	w.StartTask()
-	w.StopTask()
+	burst, err := strconv.Atoi(os.Getenv("CUBE_RATE_BURST"))
+	if err != nil || burst <= 0 {
+		burst = max(int(rate), 1)
+	}
+	return &ratelimit.Limiter{Rate: rate, Burst: burst}
+}
 
-	m := manager.Manager{
-		Pending:       *queue.New(),
-		TaskDb:        map[string][]*task.Task{},
-		EventDb:       map[string][]*task.TaskEvent{},
-		Workers:       []string{w.Name},
-		WorkerTaskMap: nil,
-		TaskWorkerMap: nil,
-	}
-	fmt.Printf("manager: %v\n", m)
-	m.SelectWorker()
-	m.UpdateTasks()
-	m.SendWork()
-
-	n := node.Node{
-		Name:   "first-node",
-		Ip:     "192.168.1.1",
-		Cores:  4,
-		Memory: 1024,
-		Disk:   25,
-		Role:   "worker",
+// envList returns the comma-separated items of the named environment
+// variable, with the spaces around each trimmed and empty items dropped.
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	fmt.Printf("node: %v\n", n)
+	return items
 }
@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/christinavaneyssen/cube/ratelimit"
+	"github.com/christinavaneyssen/cube/trace"
+	"net/http"
+)
+
+// Api exposes a manager over HTTP.
+type Api struct {
+	Address string
+	Port    int
+	Manager *Manager
+	Router  *http.ServeMux
+
+	// Limiter, when set, rejects clients that call the API too often
+	Limiter *ratelimit.Limiter
+}
+
+func (a *Api) initRouter() {
+	a.Router = http.NewServeMux()
+	a.Router.HandleFunc("POST /tasks", a.StartTaskHandler)
+	a.Router.HandleFunc("GET /tasks", a.GetTasksHandler)
+	a.Router.HandleFunc("GET /tasks/events", a.StreamEventsHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}", a.GetTaskHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/events", a.GetTaskEventsHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/wait", a.WaitTaskHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/usage", a.GetTaskUsageHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/diff", a.GetTaskDiffHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/result", a.GetTaskResultHandler)
+	a.Router.HandleFunc("DELETE /tasks/{taskID}", a.StopTaskHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/restart", a.RestartTaskHandler)
+	a.Router.HandleFunc("POST /jobs", a.StartJobHandler)
+	a.Router.HandleFunc("GET /jobs/{jobID}", a.GetJobHandler)
+	a.Router.HandleFunc("DELETE /jobs/{jobID}", a.CancelJobHandler)
+	a.Router.HandleFunc("GET /audit", a.GetAuditHandler)
+	a.Router.HandleFunc("GET /stats", a.GetStatsHandler)
+	a.Router.HandleFunc("GET /nodes", a.GetNodesHandler)
+	a.Router.HandleFunc("POST /nodes", a.RegisterNodeHandler)
+	a.Router.HandleFunc("GET /nodes/events", a.GetNodeEventsHandler)
+	a.Router.HandleFunc("POST /nodes/{name}/cordon", a.CordonNodeHandler)
+	a.Router.HandleFunc("POST /nodes/{name}/uncordon", a.UncordonNodeHandler)
+	a.Router.HandleFunc("GET /snapshot", a.GetSnapshotHandler)
+	a.Router.HandleFunc("POST /restore", a.RestoreHandler)
+}
+
+// Handler returns the HTTP handler serving the manager API.
+func (a *Api) Handler() http.Handler {
+	if a.Router == nil {
+		a.initRouter()
+	}
+	h := trace.Middleware(a.Router)
+	if a.Limiter != nil {
+		h = a.Limiter.Middleware(h)
+	}
+	return h
+}
+
+// Start serves the manager API on the configured address and port.
+func (a *Api) Start() error {
+	return http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Handler())
+}
@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"time"
+)
+
+// auditPrefix is the store key prefix for placement decisions. Entries are
+// keyed by task ID and then by time, so a task's decisions list in order.
+const auditPrefix = "manager/audit/"
+
+// AuditEntry records a placement decision: the workers considered for a
+// task, how the scheduler scored them, which one was chosen and why. An
+// empty Chosen means the task could not be placed and went back on the
+// pending queue.
+type AuditEntry struct {
+	TaskID     uuid.UUID
+	Timestamp  time.Time
+	Candidates []string
+	Scores     map[string]float64 `json:",omitempty"`
+	Chosen     string             `json:",omitempty"`
+	Reason     string
+}
+
+// DefaultMaxAuditEntries is how many placement decisions are kept for each
+// task when Manager.MaxAuditEntries is zero.
+const DefaultMaxAuditEntries = 20
+
+// recordDecision appends a placement decision to the audit log, dropping the
+// task's oldest decisions past MaxAuditEntries, so a task that waits long for
+// a worker does not fill the log. Failing to record one is logged rather than
+// holding up scheduling.
+func (m *Manager) recordDecision(e AuditEntry) {
+	s := m.recordStore()
+	prefix := fmt.Sprintf("%s%s/", auditPrefix, e.TaskID)
+	key := fmt.Sprintf("%s%020d", prefix, e.Timestamp.UnixNano())
+	if err := s.Put(key, e); err != nil {
+		logging.Errorf("Error recording placement of task %v: %v", e.TaskID, err)
+		return
+	}
+
+	limit := m.MaxAuditEntries
+	if limit <= 0 {
+		limit = DefaultMaxAuditEntries
+	}
+	keys, err := s.List(prefix)
+	if err != nil {
+		logging.Errorf("Error listing placements of task %v: %v", e.TaskID, err)
+		return
+	}
+	for _, key := range keys[:max(len(keys)-limit, 0)] {
+		if err := s.Delete(key); err != nil {
+			logging.Errorf("Error pruning placement %s: %v", key, err)
+		}
+	}
+}
+
+// AuditLog returns the placement decisions recorded for the task with the
+// given ID, or for every task when taskID is empty, oldest first.
+func (m *Manager) AuditLog(taskID string) ([]AuditEntry, error) {
+	prefix := auditPrefix
+	if taskID != "" {
+		prefix += taskID + "/"
+	}
+
+	s := m.recordStore()
+	keys, err := s.List(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit entries: %w", err)
+	}
+
+	entries := make([]AuditEntry, 0, len(keys))
+	for _, key := range keys {
+		e := AuditEntry{}
+		if err := s.Get(key, &e); err != nil {
+			return nil, fmt.Errorf("reading audit entry %s: %w", strings.TrimPrefix(key, auditPrefix), err)
+		}
+		entries = append(entries, e)
+	}
+	slices.SortStableFunc(entries, func(a, b AuditEntry) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return entries, nil
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Autoscaler adjusts the number of replicas of tasks that opt in by setting
+// Task.Replicas. Replicas are the tasks sharing a Name; the autoscaler adds
+// one when their average CPU usage is above ScaleUpCPU and stops the
+// least-loaded one when it is below ScaleDownCPU.
+type Autoscaler struct {
+	Manager *Manager
+
+	// ScaleUpCPU is the average CPU percentage above which a replica is added
+	ScaleUpCPU float64
+
+	// ScaleDownCPU is the average CPU percentage below which a replica is stopped
+	ScaleDownCPU float64
+
+	// MinReplicas is the fewest replicas to keep; at least one is always kept
+	MinReplicas int
+
+	// MaxReplicas is the most replicas to run
+	MaxReplicas int
+
+	// Usage reports the CPU percentage of a running task. When nil the
+	// task's worker is asked through Manager.TaskStats.
+	Usage func(t task.Task) (float64, error)
+}
+
+// ReasonScaledDown is the reason recorded for a replica the autoscaler stops.
+const ReasonScaledDown = "scaled down by autoscaler"
+
+// Run scales every interval until ctx is cancelled.
+func (a *Autoscaler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Scale()
+		}
+	}
+}
+
+// Scale adds or stops at most one replica of each autoscaled task.
+func (a *Autoscaler) Scale() {
+	for name, replicas := range a.replicaSets() {
+		a.scaleSet(name, replicas)
+	}
+}
+
+// replicaSets groups the autoscaled tasks that are not finished by name.
+func (a *Autoscaler) replicaSets() map[string][]*task.Task {
+	sets := make(map[string][]*task.Task)
+	for _, t := range a.Manager.GetTasks() {
+		if t.Replicas == 0 || t.State.Terminal() {
+			continue
+		}
+		sets[t.Name] = append(sets[t.Name], t)
+	}
+	return sets
+}
+
+func (a *Autoscaler) scaleSet(name string, replicas []*task.Task) {
+	var total float64
+	var measured int
+	var leastLoaded *task.Task
+	var leastUsage float64
+
+	for _, t := range replicas {
+		if t.State != task.Running {
+			continue
+		}
+		usage, err := a.usage(*t)
+		if err != nil {
+			logging.Errorf("Error getting usage of task %v: %v", t.ID, err)
+			continue
+		}
+		total += usage
+		measured++
+		if leastLoaded == nil || usage < leastUsage {
+			leastLoaded, leastUsage = t, usage
+		}
+	}
+	if measured == 0 {
+		return
+	}
+
+	average := total / float64(measured)
+	switch {
+	case average > a.ScaleUpCPU && len(replicas) < a.MaxReplicas:
+		logging.Infof("Scaling %s up to %d replicas (average CPU %.1f%%)", name, len(replicas)+1, average)
+		a.addReplica(*replicas[0], len(replicas)+1)
+	case average < a.ScaleDownCPU && len(replicas) > max(a.MinReplicas, 1):
+		logging.Infof("Scaling %s down to %d replicas (average CPU %.1f%%)", name, len(replicas)-1, average)
+		if err := a.Manager.StopTask(leastLoaded.ID, ReasonScaledDown); err != nil {
+			logging.Errorf("Error stopping replica %v: %v", leastLoaded.ID, err)
+			return
+		}
+		a.Manager.setReplicas(name, len(replicas)-1)
+	}
+}
+
+// addReplica queues a copy of spec's configuration as a new task.
+func (a *Autoscaler) addReplica(spec task.Task, replicas int) {
+	replica := spec
+	replica.ID = uuid.New()
+	replica.State = task.Pending
+	replica.ContainerID = ""
+	replica.CreatedAt = time.Time{}
+	replica.StartTime = time.Time{}
+	replica.FinishTime = time.Time{}
+	replica.ScheduledAt = time.Time{}
+	replica.ExitCode = 0
+	replica.Replicas = replicas
+
+	a.Manager.setReplicas(spec.Name, replicas)
+	a.Manager.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: a.Manager.now().UTC(),
+		Task:      replica,
+	})
+}
+
+func (a *Autoscaler) usage(t task.Task) (float64, error) {
+	if a.Usage != nil {
+		return a.Usage(t)
+	}
+	stats, err := a.Manager.TaskStats(t.ID)
+	return stats.CPUPercent, err
+}
@@ -0,0 +1,51 @@
+package manager_test
+
+import (
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestAutoscaler_ScaleUp(t *testing.T) {
+	m := newManager()
+	usage := map[uuid.UUID]float64{}
+	for _, cpu := range []float64{90, 70} {
+		te := pendingEvent("api")
+		te.Task.Replicas = 2
+		m.AddTask(te)
+		m.Pending.Dequeue()
+		m.TaskDb[te.Task.ID.String()][0].State = task.Running
+		usage[te.Task.ID] = cpu
+	}
+
+	a := &manager.Autoscaler{
+		Manager:      m,
+		ScaleUpCPU:   75,
+		ScaleDownCPU: 10,
+		MaxReplicas:  3,
+		Usage: func(t task.Task) (float64, error) {
+			return usage[t.ID], nil
+		},
+	}
+
+	a.Scale()
+	if m.Pending.Len() != 1 {
+		t.Fatalf("pending = %d, want a new replica queued", m.Pending.Len())
+	}
+	replica := m.Pending.Peek().(task.TaskEvent).Task
+	if replica.Name != "api" || replica.Replicas != 3 {
+		t.Errorf("replica = %+v, want a third api replica", replica)
+	}
+	for _, tk := range m.GetTasks() {
+		if tk.Replicas != 3 {
+			t.Errorf("task %v replicas = %d, want 3", tk.ID, tk.Replicas)
+		}
+	}
+
+	// The set is already at its maximum, however busy it is.
+	a.Scale()
+	if m.Pending.Len() != 1 {
+		t.Errorf("pending = %d, want no replica beyond the maximum", m.Pending.Len())
+	}
+}
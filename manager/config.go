@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/docker/docker/api/types/container"
+	"os"
+	"time"
+)
+
+// Config holds the manager settings that can change while it runs. Zero
+// intervals leave the loops running at the interval they were started with.
+type Config struct {
+	// ScheduleInterval, UpdateInterval and SweepInterval are how often
+	// ProcessTasks, RunUpdates and RunSweeper run
+	ScheduleInterval time.Duration
+	UpdateInterval   time.Duration
+	SweepInterval    time.Duration
+
+	// DefaultProfile, Retention and KeepLast, when set, replace the
+	// manager's fields of the same name. Left unset, the field goes back to
+	// what it was before the first config was applied.
+	DefaultProfile *Profile
+	Retention      *time.Duration
+	KeepLast       *int
+}
+
+// baseSettings holds the fields a Config may replace as they were before
+// the first config was applied.
+type baseSettings struct {
+	defaultProfile Profile
+	retention      time.Duration
+	keepLast       int
+}
+
+// configFile is the JSON form of Config, with durations written as strings
+// such as "10s".
+type configFile struct {
+	ScheduleInterval string
+	UpdateInterval   string
+	SweepInterval    string
+	DefaultProfile   *Profile
+	Retention        string
+	KeepLast         *int
+}
+
+// LoadConfig reads and validates the JSON config file at path.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	f := configFile{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	c := Config{DefaultProfile: f.DefaultProfile, KeepLast: f.KeepLast}
+	var errs []error
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"scheduleInterval", f.ScheduleInterval, &c.ScheduleInterval},
+		{"updateInterval", f.UpdateInterval, &c.UpdateInterval},
+		{"sweepInterval", f.SweepInterval, &c.SweepInterval},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.name, err))
+		}
+	}
+	if f.Retention != "" {
+		retention, err := time.ParseDuration(f.Retention)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("retention: %w", err))
+		}
+		c.Retention = &retention
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Validate returns an error listing every setting out of range.
+func (c Config) Validate() error {
+	var errs []error
+	durations := map[string]time.Duration{
+		"schedule interval": c.ScheduleInterval,
+		"update interval":   c.UpdateInterval,
+		"sweep interval":    c.SweepInterval,
+	}
+	if c.Retention != nil {
+		durations["retention"] = *c.Retention
+	}
+	for name, d := range durations {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s %v is negative", name, d))
+		}
+	}
+	if c.KeepLast != nil && *c.KeepLast < 0 {
+		errs = append(errs, fmt.Errorf("keepLast %d is negative", *c.KeepLast))
+	}
+	if p := c.DefaultProfile; p != nil {
+		if p.Cpu < 0 || p.Memory < 0 || p.Disk < 0 {
+			errs = append(errs, fmt.Errorf("default profile has negative resources"))
+		}
+		if err := container.ValidateRestartPolicy(container.RestartPolicy{Name: container.RestartPolicyMode(p.RestartPolicy)}); err != nil {
+			errs = append(errs, fmt.Errorf("default profile: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ApplyConfig validates c and, if it is valid, puts it into effect: tasks
+// submitted from now on get its default profile, the next sweep uses its
+// retention, and the running loops switch to its intervals. Settings c
+// leaves out go back to the values the manager had before any config was
+// applied. An invalid config is rejected and the current one kept.
+func (m *Manager) ApplyConfig(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.base == nil {
+		m.base = &baseSettings{defaultProfile: m.DefaultProfile, retention: m.Retention, keepLast: m.KeepLast}
+	}
+	m.config = c
+	m.DefaultProfile = m.base.defaultProfile
+	if c.DefaultProfile != nil {
+		m.DefaultProfile = *c.DefaultProfile
+	}
+	m.Retention = m.base.retention
+	if c.Retention != nil {
+		m.Retention = *c.Retention
+	}
+	m.KeepLast = m.base.keepLast
+	if c.KeepLast != nil {
+		m.KeepLast = *c.KeepLast
+	}
+	if m.reconfigured != nil {
+		close(m.reconfigured)
+		m.reconfigured = nil
+	}
+	return nil
+}
+
+// ReloadConfig loads the config file at path and applies it, keeping the
+// current config if the file cannot be read or is invalid.
+func (m *Manager) ReloadConfig(path string) error {
+	c, err := LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	if err := m.ApplyConfig(c); err != nil {
+		return err
+	}
+	logging.Infof("Reloaded config from %s", path)
+	return nil
+}
+
+// WatchConfig reloads the config file at path whenever a signal, such as
+// SIGHUP, arrives on reload and whenever the file's modification time
+// changes, checked every poll interval, until ctx is cancelled or the
+// manager shuts down. Failed reloads are logged and the config kept.
+func (m *Manager) WatchConfig(ctx context.Context, path string, reload <-chan os.Signal, poll time.Duration) {
+	modTime := func() time.Time {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}
+		}
+		return fi.ModTime()
+	}
+	load := func() {
+		if err := m.ReloadConfig(path); err != nil {
+			logging.Errorf("Error reloading config, keeping the current one: %v", err)
+		}
+	}
+
+	last := modTime()
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done():
+			return
+		case <-reload:
+			last = modTime()
+			load()
+		case <-ticker.C:
+			if mt := modTime(); !mt.Equal(last) {
+				last = mt
+				load()
+			}
+		}
+	}
+}
+
+// interval returns a function reporting the interval a loop should run at:
+// the one pick takes from the current config, or fallback when it is zero.
+func (m *Manager) interval(pick func(Config) time.Duration, fallback time.Duration) func() time.Duration {
+	return func() time.Duration {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if d := pick(m.config); d > 0 {
+			return d
+		}
+		return fallback
+	}
+}
+
+// configChanged returns a channel closed the next time a config is applied.
+func (m *Manager) configChanged() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reconfigured == nil {
+		m.reconfigured = make(chan struct{})
+	}
+	return m.reconfigured
+}
@@ -0,0 +1,103 @@
+package manager_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/christinavaneyssen/cube/task"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestManager_ReloadConfigChangesInterval(t *testing.T) {
+	var polls atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		json.NewEncoder(w).Encode([]task.Task{})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.RunUpdates(ctx, time.Hour)
+
+	waitFor := func(n int32) bool {
+		deadline := time.Now().Add(2 * time.Second)
+		for polls.Load() < n {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return true
+	}
+	if !waitFor(1) {
+		t.Fatal("the update loop never polled the worker")
+	}
+
+	path := filepath.Join(t.TempDir(), "manager.json")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"updateInterval": "-1s", "keepLast": 3}`)
+	if err := m.ReloadConfig(path); err == nil {
+		t.Fatal("ReloadConfig() of a negative interval succeeded, want an error")
+	}
+	if m.KeepLast != 0 {
+		t.Errorf("KeepLast = %d after a rejected reload, want the old 0", m.KeepLast)
+	}
+
+	write(`{"updateInterval": "10ms", "retention": "24h", "keepLast": 3, "defaultProfile": {"memory": 256}}`)
+	if err := m.ReloadConfig(path); err != nil {
+		t.Fatalf("ReloadConfig() error = %v", err)
+	}
+	if !waitFor(4) {
+		t.Fatalf("worker polled %d times after the interval dropped to 10ms, want the loop to speed up", polls.Load())
+	}
+	if got := m.AddTask(pendingEvent("web")); got.Memory != 256 {
+		t.Errorf("task memory = %d, want 256 from the reloaded default profile", got.Memory)
+	}
+}
+
+func TestManager_ReloadConfigKeepsOmittedSettings(t *testing.T) {
+	m := newManager()
+	m.Retention = 48 * time.Hour
+	m.KeepLast = 2
+
+	path := filepath.Join(t.TempDir(), "manager.json")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ReloadConfig(path); err != nil {
+			t.Fatalf("ReloadConfig(%s) error = %v", config, err)
+		}
+	}
+
+	write(`{"updateInterval": "10s"}`)
+	if m.Retention != 48*time.Hour || m.KeepLast != 2 {
+		t.Errorf("retention %v, keepLast %d after a config leaving them out, want 48h and 2", m.Retention, m.KeepLast)
+	}
+
+	write(`{"retention": "1h", "keepLast": 0}`)
+	if m.Retention != time.Hour || m.KeepLast != 0 {
+		t.Errorf("retention %v, keepLast %d, want 1h and 0 from the config", m.Retention, m.KeepLast)
+	}
+
+	// Dropping a setting from the file restores the manager's own value.
+	write(`{}`)
+	if m.Retention != 48*time.Hour || m.KeepLast != 2 {
+		t.Errorf("retention %v, keepLast %d after the settings were removed, want 48h and 2", m.Retention, m.KeepLast)
+	}
+}
@@ -0,0 +1,59 @@
+package manager
+
+import (
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/node"
+	"slices"
+)
+
+// Cordon stops new tasks from being scheduled on worker w, while the tasks
+// it already runs carry on, so it can be looked into without disturbing
+// them. It fails with an error matching cubeerrors.ErrNotFound if the
+// manager does not know the worker.
+func (m *Manager) Cordon(w string) error {
+	return m.setCordoned(w, true)
+}
+
+// Uncordon lets new tasks be scheduled on worker w again.
+func (m *Manager) Uncordon(w string) error {
+	return m.setCordoned(w, false)
+}
+
+func (m *Manager) setCordoned(w string, cordoned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.knows(w) {
+		return fmt.Errorf("worker %s %w", w, cubeerrors.ErrNotFound)
+	}
+	if m.cordoned == nil {
+		m.cordoned = make(map[string]bool)
+	}
+	if cordoned {
+		m.cordoned[w] = true
+		logging.Infof("Cordoned worker %s", w)
+	} else {
+		delete(m.cordoned, w)
+		logging.Infof("Uncordoned worker %s", w)
+	}
+	return nil
+}
+
+// knows reports whether w is one of Workers or WorkerNodes. The caller must
+// hold m.mu.
+func (m *Manager) knows(w string) bool {
+	if slices.Contains(m.Workers, w) {
+		return true
+	}
+	return slices.ContainsFunc(m.WorkerNodes, func(n *node.Node) bool { return n.Name == w })
+}
+
+// isCordoned reports whether worker w is cordoned.
+func (m *Manager) isCordoned(w string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.cordoned[w]
+}
@@ -0,0 +1,237 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"time"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind by before
+// further events are dropped for it.
+const subscriberBuffer = 64
+
+// waitRecheckInterval is how often WaitTask looks at the task regardless of
+// the events it receives.
+const waitRecheckInterval = 5 * time.Second
+
+// Bounds on the number of events returned by a page of TaskEvents.
+const (
+	DefaultEventLimit = 100
+	MaxEventLimit     = 1000
+)
+
+// ErrInvalidCursor is returned when a page of events is asked for after an
+// event that is not in the task's history.
+var ErrInvalidCursor = fmt.Errorf("%w: unknown event cursor", cubeerrors.ErrInvalidRequest)
+
+// EventPage is a page of a task's event history, oldest first. NextCursor
+// is passed as after to fetch the next page, and is empty on the last one.
+type EventPage struct {
+	Events     []task.TaskEvent
+	NextCursor string `json:",omitempty"`
+}
+
+// TaskEvents returns up to limit events from the task's history, starting
+// after the event with ID after, or from the first event when after is
+// uuid.Nil. Events are appended in order, so a cursor keeps its place
+// however many events are recorded between pages. A cursor whose event was
+// since dropped by CompactEvents starts again from the oldest event kept, so
+// a client may see an event twice but never misses one. A limit outside 1
+// to MaxEventLimit is replaced by DefaultEventLimit or MaxEventLimit.
+func (m *Manager) TaskEvents(id uuid.UUID, after uuid.UUID, limit int) (EventPage, error) {
+	if limit <= 0 {
+		limit = DefaultEventLimit
+	}
+	limit = min(limit, MaxEventLimit)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := taskKey(id)
+	if m.task(key) == nil {
+		return EventPage{}, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	events := m.EventDb[key]
+	start := 0
+	if after != uuid.Nil {
+		start = -1
+		for i, te := range events {
+			if te.ID == after {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 && m.compacted[key] {
+			start = 0
+		}
+		if start < 0 {
+			return EventPage{}, fmt.Errorf("%w: no event %v for task %v", ErrInvalidCursor, after, id)
+		}
+	}
+
+	end := min(start+limit, len(events))
+	page := EventPage{Events: make([]task.TaskEvent, 0, end-start)}
+	for _, te := range events[start:end] {
+		page.Events = append(page.Events, *te)
+	}
+	if end < len(events) {
+		page.NextCursor = events[end-1].ID.String()
+	}
+	return page, nil
+}
+
+// appendEvent adds te to its task's event history and hands it to every
+// subscriber, and to the task's webhook when it finishes the task. The
+// caller must hold m.mu.
+func (m *Manager) appendEvent(te *task.TaskEvent) {
+	key := taskKey(te.Task.ID)
+	m.EventDb[key] = append(m.EventDb[key], te)
+	m.publish(*te)
+	if te.State.Terminal() {
+		m.notify(*te)
+	}
+}
+
+// CompactEvents bounds the event history of every task when MaxEvents is
+// set. Consecutive events in the same state collapse into the first of
+// them, and of the rest only the MaxEvents most recent are kept along with
+// every event recording a finished run, so a task's outcomes survive however
+// often it restarted.
+func (m *Manager) CompactEvents() {
+	if m.MaxEvents <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, events := range m.EventDb {
+		kept := compactEvents(events, m.MaxEvents)
+		if len(kept) == len(events) {
+			continue
+		}
+		m.EventDb[key] = kept
+		if m.compacted == nil {
+			m.compacted = make(map[string]bool)
+		}
+		m.compacted[key] = true
+	}
+}
+
+// compactEvents returns the events left once consecutive events in the same
+// state are collapsed into the first and only the last max, and terminal
+// events, are kept.
+func compactEvents(events []*task.TaskEvent, max int) []*task.TaskEvent {
+	var collapsed []*task.TaskEvent
+	for _, te := range events {
+		if n := len(collapsed); n > 0 && collapsed[n-1].State == te.State {
+			continue
+		}
+		collapsed = append(collapsed, te)
+	}
+
+	cut := len(collapsed) - max
+	kept := make([]*task.TaskEvent, 0, min(len(collapsed), max))
+	for i, te := range collapsed {
+		if i >= cut || te.State.Terminal() {
+			kept = append(kept, te)
+		}
+	}
+	return kept
+}
+
+// lastState returns the state of the latest event in the task's history, or
+// Pending when it has none. The caller must hold m.mu.
+func (m *Manager) lastState(key string) task.State {
+	events := m.EventDb[key]
+	if len(events) == 0 {
+		return task.Pending
+	}
+	return events[len(events)-1].State
+}
+
+// Subscribe returns a channel receiving every task event recorded from now
+// on, and a function that ends the subscription and closes the channel. A
+// subscriber that falls too far behind misses events rather than holding up
+// the manager.
+func (m *Manager) Subscribe() (<-chan task.TaskEvent, func()) {
+	ch := make(chan task.TaskEvent, subscriberBuffer)
+
+	m.subMu.Lock()
+	if m.subscribers == nil {
+		m.subscribers = make(map[chan task.TaskEvent]struct{})
+	}
+	m.subscribers[ch] = struct{}{}
+	m.subMu.Unlock()
+
+	cancel := func() {
+		m.subMu.Lock()
+		defer m.subMu.Unlock()
+
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// WaitTask blocks until the task reaches a terminal state or ctx is done,
+// and returns the task as it then stands. It returns ctx's error alongside
+// the task when it gives up waiting.
+func (m *Manager) WaitTask(ctx context.Context, id uuid.UUID) (task.Task, error) {
+	// Subscribe before looking, so an event recorded in between is not missed.
+	events, cancel := m.Subscribe()
+	defer cancel()
+	// Events are dropped for a subscriber that falls behind, so look again
+	// now and then whatever arrives.
+	ticker := time.NewTicker(waitRecheckInterval)
+	defer ticker.Stop()
+
+	for {
+		t, err := m.GetTask(id)
+		if err != nil {
+			return task.Task{}, err
+		}
+		if t.State.Terminal() {
+			return *t, nil
+		}
+		if err := awaitEvent(ctx, events, ticker.C, id); err != nil {
+			return *t, err
+		}
+	}
+}
+
+// awaitEvent waits for an event about the task with the given ID, or a tick.
+func awaitEvent(ctx context.Context, events <-chan task.TaskEvent, tick <-chan time.Time, id uuid.UUID) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+			return nil
+		case te := <-events:
+			if te.Task.ID == id {
+				return nil
+			}
+		}
+	}
+}
+
+// publish hands te to every subscriber with room for it.
+func (m *Manager) publish(te task.TaskEvent) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- te:
+		default:
+			logging.Warnf("Dropping event %v for a slow subscriber", te.ID)
+		}
+	}
+}
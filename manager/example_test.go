@@ -1,6 +1,7 @@
 package manager_test
 
 import (
+	"fmt"
 	"github.com/christinavaneyssen/cube/manager"
 	"github.com/christinavaneyssen/cube/task"
 	"github.com/google/uuid"
@@ -8,33 +9,35 @@ import (
 	"time"
 )
 
-// ExampleManager_BasicUsage demonstrates the basic workflow for creating and using a Manager.
-func ExampleManager_BasicUsage() {
+// ExampleManager_basicUsage demonstrates the basic workflow for creating and using a Manager.
+func ExampleManager_basicUsage() {
 	mgr := &manager.Manager{
 		TaskDb:        make(map[string][]*task.Task),
 		EventDb:       make(map[string][]*task.TaskEvent),
-		Workers:       []string{"worker1", "worker2", "worker3"},
 		WorkerTaskMap: make(map[string][]uuid.UUID),
 		TaskWorkerMap: make(map[uuid.UUID]string),
 	}
 
-	newTask := &task.Task{
+	newTask := task.Task{
 		ID:    uuid.New(),
 		Name:  "data-processing",
 		State: task.Pending,
 	}
 
-	mgr.Pending.Enqueue(newTask)
+	mgr.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: time.Now(),
+		Task:      newTask,
+	})
 
-	// Process the pending task
-	mgr.SelectWorker()
+	// Without any workers the task stays pending
 	mgr.SendWork()
 	mgr.UpdateTasks()
+	fmt.Println("Pending tasks:", mgr.Pending.Len())
 
 	// Output:
-	// I will select an appropriate worker
-	// I send the task to the worker
-	// I keep track of tasks, their states and the machines they run on
+	// Pending tasks: 1
 }
 
 // TestManager_TaskDistribution tests the distribution of tasks across workers.
@@ -93,8 +96,13 @@ func TestManager_TaskStateTransitions(t *testing.T) {
 		State: task.Pending,
 	}
 
-	mgr.Pending.Enqueue(newTask)
-	mgr.SelectWorker()
+	mgr.AddTask(task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: time.Now(),
+		Task:      newTask,
+	})
+	mgr.SelectWorker(newTask)
 	mgr.SendWork()
 
 	mgr.EventDb[taskID.String()] = []*task.TaskEvent{
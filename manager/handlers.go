@@ -0,0 +1,462 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/trace"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryAfter is how long a client turned away by admission control is asked
+// to wait before submitting again.
+const RetryAfter = 5 * time.Second
+
+// DefaultWaitTimeout is how long WaitTaskHandler waits when the client sets
+// no timeout, and MaxWaitTimeout the longest it waits whatever the client
+// asks.
+const (
+	DefaultWaitTimeout = 30 * time.Second
+	MaxWaitTimeout     = 10 * time.Minute
+)
+
+// StartTaskHandler queues the posted task event for scheduling. A retried
+// submission carrying the same Idempotency-Key header, or Task.IdempotencyKey,
+// returns the task created by the first one. A submission naming a task that
+// has not finished is refused unless the replace query parameter is true, in
+// which case the new task replaces it; see Manager.ReplaceTask.
+func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	replace := false
+	if v := r.URL.Query().Get("replace"); v != "" {
+		var err error
+		if replace, err = strconv.ParseBool(v); err != nil {
+			writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid replace flag %q", v))
+			return
+		}
+	}
+
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	te := task.TaskEvent{}
+	if err := d.Decode(&te); err != nil {
+		err = cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err)
+		logging.Warnf("%v", err)
+		writeError(w, err)
+		return
+	}
+
+	if te.Task.TraceID == "" {
+		te.Task.TraceID = trace.FromContext(r.Context())
+	}
+	t, created, err := a.Manager.Submit(te, r.Header.Get("Idempotency-Key"), replace)
+	if errors.Is(err, ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(RetryAfter.Seconds())))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !created {
+		logging.Infof("Submission repeats task %v", t.ID)
+		writeJSON(w, http.StatusOK, t)
+		return
+	}
+
+	logging.Infof("[trace %s] Added task %v", t.TraceID, t.ID)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// GetTasksHandler lists every task the manager knows about.
+func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.GetTasks())
+}
+
+// StreamEventsHandler streams task events to the client as server-sent
+// events, one "task" event of JSON per state change, until the client goes
+// away.
+func (a *Api) StreamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, errors.New("Streaming is not supported"))
+		return
+	}
+
+	events, cancel := a.Manager.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case te, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(te)
+			if err != nil {
+				logging.Errorf("Error marshalling event %v: %v", te.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: task\ndata: %s\n\n", te.ID, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// GetTaskHandler returns the task with the ID in the path.
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	t, err := a.Manager.GetTask(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// GetTaskEventsHandler returns a page of the event history of the task with
+// the ID in the path. The after query parameter takes the NextCursor of the
+// previous page, and limit caps the number of events returned.
+func (a *Api) GetTaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	after := uuid.Nil
+	if v := r.URL.Query().Get("after"); v != "" {
+		if after, err = uuid.Parse(v); err != nil {
+			writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid cursor: %v", err))
+			return
+		}
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid limit %q", v))
+			return
+		}
+	}
+
+	page, err := a.Manager.TaskEvents(taskID, after, limit)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// WaitTaskHandler waits for the task with the ID in the path to finish, for
+// at most the duration in the timeout query parameter, DefaultWaitTimeout
+// when it is absent, and responds with the task as it then stands. Clients
+// tell a finished task from a timeout by its State.
+func (a *Api) WaitTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+	timeout := DefaultWaitTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+			writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid timeout %q", v))
+			return
+		}
+	}
+	timeout = min(timeout, MaxWaitTimeout)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	t, err := a.Manager.WaitTask(ctx, taskID)
+	if err != nil && ctx.Err() == nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// GetTaskUsageHandler returns the resources reserved over the finished runs
+// of the task with the ID in the path.
+func (a *Api) GetTaskUsageHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	u, err := a.Manager.TaskUsage(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+// GetTaskDiffHandler reports how the container of the task with the ID in
+// the path differs from the task's config.
+func (a *Api) GetTaskDiffHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	diffs, err := a.Manager.TaskDiff(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+// GetTaskResultHandler returns the result of the finished task with the ID
+// in the path as plain text, or no content when it reported none.
+func (a *Api) GetTaskResultHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	result, err := a.Manager.TaskResult(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	if result == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, result)
+}
+
+// StopTaskHandler cancels the task with the ID in the path on behalf of the
+// user.
+func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	if err := a.Manager.StopTask(taskID, task.ReasonCancelledByUser); err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	logging.Infof("Cancelled task %v", taskID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestartTaskHandler stops the task with the ID in the path and schedules it
+// again with the same spec, responding with the restarted task.
+func (a *Api) RestartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	t, err := a.Manager.RestartTask(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	logging.Infof("Restarting task %v (restart %d)", t.ID, t.RestartCount)
+	writeJSON(w, http.StatusOK, t)
+}
+
+// GetStatsHandler returns an overview of the manager's workload.
+func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.QueueStats())
+}
+
+// GetNodesHandler lists the workers with their load and reachability.
+func (a *Api) GetNodesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.Nodes())
+}
+
+// GetNodeEventsHandler lists the registered workers joining and going down,
+// oldest first.
+func (a *Api) GetNodeEventsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.NodeEvents())
+}
+
+// CordonNodeHandler stops new tasks from being scheduled on the worker named
+// in the path.
+func (a *Api) CordonNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.Cordon(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UncordonNodeHandler lets new tasks be scheduled on the worker named in the
+// path again.
+func (a *Api) UncordonNodeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := a.Manager.Uncordon(r.PathValue("name")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegisterNodeHandler registers the worker that posted the heartbeat, or
+// refreshes its registration.
+func (a *Api) RegisterNodeHandler(w http.ResponseWriter, r *http.Request) {
+	hb := worker.Heartbeat{}
+	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err))
+		return
+	}
+	if hb.Address == "" {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Heartbeat has no address"))
+		return
+	}
+
+	a.Manager.Register(hb)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StartJobHandler queues the tasks of the posted JobSpec as a job and
+// returns the job.
+func (a *Api) StartJobHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	spec := JobSpec{}
+	if err := d.Decode(&spec); err != nil {
+		err = cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err)
+		logging.Warnf("%v", err)
+		writeError(w, err)
+		return
+	}
+
+	job, err := a.Manager.SubmitJob(spec)
+	if errors.Is(err, ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(RetryAfter.Seconds())))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	logging.Infof("Added job %v with %d tasks", job.ID, len(job.Tasks))
+	writeJSON(w, http.StatusCreated, job)
+}
+
+// GetJobHandler returns the job with the ID in the path, with the states of
+// its tasks and their rollup.
+func (a *Api) GetJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid job ID: %v", err))
+		return
+	}
+
+	job, err := a.Manager.GetJob(jobID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// CancelJobHandler cancels the job with the ID in the path, stopping its
+// unfinished tasks, and returns what was done to each of them.
+func (a *Api) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid job ID: %v", err))
+		return
+	}
+
+	stops, err := a.Manager.CancelJob(jobID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	logging.Infof("Cancelled job %v", jobID)
+	writeJSON(w, http.StatusOK, stops)
+}
+
+// GetAuditHandler returns the placement decisions recorded for the task
+// named by the task query parameter, or for every task without one.
+func (a *Api) GetAuditHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.URL.Query().Get("task")
+	if taskID != "" {
+		if _, err := uuid.Parse(taskID); err != nil {
+			writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+			return
+		}
+	}
+
+	entries, err := a.Manager.AuditLog(taskID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// GetSnapshotHandler returns a copy of the manager's entire state.
+func (a *Api) GetSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Manager.Snapshot())
+}
+
+// RestoreHandler loads a snapshot taken by GetSnapshotHandler into a manager
+// that holds no tasks yet.
+func (a *Api) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	s := Snapshot{}
+	if err := d.Decode(&s); err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err))
+		return
+	}
+
+	err := a.Manager.LoadSnapshot(s)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	logging.Infof("Restored %d tasks from snapshot", len(s.TaskDb))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("Error encoding response: %v", err)
+	}
+}
+
+// writeError answers with the status and error envelope for err.
+func writeError(w http.ResponseWriter, err error) {
+	cubeerrors.WriteHTTP(w, err)
+}
@@ -0,0 +1,944 @@
+package manager_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/queues"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/trace"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApi_GetTaskHandler(t *testing.T) {
+	te := pendingEvent("web")
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// The worker reports the task's container was killed.
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		failed := te.Task
+		failed.State = task.Failed
+		failed.StartTime = started
+		failed.ContainerID = "container-1"
+		failed.ExitCode = 137
+		json.NewEncoder(w).Encode([]task.Task{failed})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	m.AddTask(te)
+	m.UpdateTasks()
+	api := &manager.Api{Manager: m}
+
+	t.Run("found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+te.Task.ID.String(), nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		got := task.Task{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if got.ID != te.Task.ID || got.State != task.Failed || got.ContainerID != "container-1" || !got.StartTime.Equal(started) || got.ExitCode != 137 {
+			t.Errorf("got %+v", got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		id := uuid.NewString()
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+id, nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+		var got map[string]map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding error envelope: %v", err)
+		}
+		body, ok := got["error"]
+		if !ok || len(got) != 1 {
+			t.Fatalf("response = %v, want a single error object", got)
+		}
+		if body["code"] != "not_found" || body["taskId"] != id || body["message"] == "" {
+			t.Errorf("error = %v, want code not_found, taskId %s and a message", body, id)
+		}
+	})
+}
+
+func TestApi_StartTaskHandlerIdempotencyKey(t *testing.T) {
+	m := newManager()
+	api := &manager.Api{Manager: m}
+
+	submit := func() (int, task.Task) {
+		body, err := json.Marshal(pendingEvent("report"))
+		if err != nil {
+			t.Fatalf("marshalling task event: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+		req.Header.Set("Idempotency-Key", "nightly-report-2025-01-02")
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, req)
+
+		got := task.Task{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		return rec.Code, got
+	}
+
+	firstStatus, first := submit()
+	secondStatus, second := submit()
+
+	if firstStatus != http.StatusCreated || secondStatus != http.StatusOK {
+		t.Errorf("statuses = %d, %d, want %d, %d", firstStatus, secondStatus, http.StatusCreated, http.StatusOK)
+	}
+	if second.ID != first.ID {
+		t.Errorf("retry returned task %v, want %v", second.ID, first.ID)
+	}
+	if n := len(m.GetTasks()); n != 1 {
+		t.Errorf("manager has %d tasks, want 1", n)
+	}
+	if m.Pending.Len() != 1 {
+		t.Errorf("pending = %d, want 1", m.Pending.Len())
+	}
+}
+
+func TestApi_StartTaskHandlerDropsSubmittedSecrets(t *testing.T) {
+	m := newManager()
+	api := &manager.Api{Manager: m}
+
+	te := pendingEvent("db")
+	te.Secrets = task.SecretMap{"db-password": []byte("s3cr3t")}
+	body, err := json.Marshal(te)
+	if err != nil {
+		t.Fatalf("marshalling task event: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+te.Task.ID.String()+"/events", nil))
+	if strings.Contains(rec.Body.String(), "db-password") {
+		t.Errorf("events reveal the submitted secrets: %s", rec.Body)
+	}
+	if queued, ok := queues.Peek[task.TaskEvent](&m.Pending); !ok || queued.Secrets != nil {
+		t.Errorf("pending event secrets = %v, want none", queued.Secrets)
+	}
+}
+
+func TestApi_TraceIDPropagates(t *testing.T) {
+	wk := newStubWorker(t, nil)
+	workerApi := &worker.Api{Worker: wk.Worker}
+	var dispatched string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			dispatched = r.Header.Get(trace.Header)
+		}
+		workerApi.Handler().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	api := &manager.Api{Manager: m}
+
+	body, err := json.Marshal(pendingEvent("traced"))
+	if err != nil {
+		t.Fatalf("marshalling task event: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+	req.Header.Set(trace.Header, "trace-42")
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, req)
+	if got := rec.Header().Get(trace.Header); got != "trace-42" {
+		t.Errorf("submission response trace ID = %q, want trace-42", got)
+	}
+	submitted := task.Task{}
+	if err := json.NewDecoder(rec.Body).Decode(&submitted); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	m.SendWork()
+	if dispatched != "trace-42" {
+		t.Errorf("worker received trace ID %q, want trace-42", dispatched)
+	}
+
+	queued := wk.next(t)
+	if queued.TraceID != "trace-42" {
+		t.Errorf("queued task trace ID = %q, want trace-42", queued.TraceID)
+	}
+
+	queued.State = task.Running
+	wk.report(queued)
+	// Forget the manager's copy so the ID has to come back from the worker.
+	m.TaskDb[queued.ID.String()][0].TraceID = ""
+
+	m.UpdateTasks()
+	got, err := m.GetTask(submitted.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.TraceID != "trace-42" {
+		t.Errorf("manager task trace ID = %q, want trace-42", got.TraceID)
+	}
+}
+
+func TestApi_StartTaskHandlerRejectsWhenQueueFull(t *testing.T) {
+	m := newManager()
+	m.MaxPending = 2
+	api := &manager.Api{Manager: m}
+
+	submitted := 0
+	submit := func() *httptest.ResponseRecorder {
+		submitted++
+		body, err := json.Marshal(pendingEvent(fmt.Sprintf("batch-%d", submitted)))
+		if err != nil {
+			t.Fatalf("marshalling task event: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+		return rec
+	}
+
+	for i := range m.MaxPending {
+		if rec := submit(); rec.Code != http.StatusCreated {
+			t.Fatalf("submission %d status = %d, want %d", i, rec.Code, http.StatusCreated)
+		}
+	}
+
+	rec := submit()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("rejected submission has no Retry-After header")
+	}
+	if m.Pending.Len() != m.MaxPending {
+		t.Errorf("pending = %d, want %d", m.Pending.Len(), m.MaxPending)
+	}
+}
+
+func TestApi_StartTaskHandlerEnforcesNamespaceQuota(t *testing.T) {
+	m := newManager()
+	m.Quotas = map[string]manager.Quota{"team-a": {Memory: 1024}}
+	api := &manager.Api{Manager: m}
+
+	submit := func(name, namespace string, memory int) *httptest.ResponseRecorder {
+		te := pendingEvent(name)
+		te.Task.Namespace = namespace
+		te.Task.Memory = memory
+		body, err := json.Marshal(te)
+		if err != nil {
+			t.Fatalf("marshalling task event: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+		return rec
+	}
+
+	if rec := submit("api", "team-a", 768); rec.Code != http.StatusCreated {
+		t.Fatalf("submission within quota status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	rec := submit("worker", "team-a", 512)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("submission over quota status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+	}
+	e := cubeerrors.Envelope{}
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil || e.Error.Code != cubeerrors.CodeQuotaExceeded || !strings.Contains(e.Error.Message, "team-a") {
+		t.Errorf("error = %+v (%v), want %s naming the namespace", e.Error, err, cubeerrors.CodeQuotaExceeded)
+	}
+	if rec := submit("worker", "team-b", 512); rec.Code != http.StatusCreated {
+		t.Errorf("submission to another namespace status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if m.Pending.Len() != 2 {
+		t.Errorf("pending = %d, want 2", m.Pending.Len())
+	}
+}
+
+func TestApi_SnapshotRestoreRoundTrip(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	original := newManager(w)
+	original.AddTask(pendingEvent("dispatched"))
+	original.SendWork()
+	original.AddTask(pendingEvent("waiting"))
+
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: original}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/snapshot", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("snapshot status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	dump := rec.Body.Bytes()
+
+	restored := newManager(w)
+	rec = httptest.NewRecorder()
+	(&manager.Api{Manager: restored}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/restore", bytes.NewReader(dump)))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("restore status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+
+	want, err := json.Marshal(original.Snapshot())
+	if err != nil {
+		t.Fatalf("marshalling original snapshot: %v", err)
+	}
+	got, err := json.Marshal(restored.Snapshot())
+	if err != nil {
+		t.Fatalf("marshalling restored snapshot: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("restored state differs from original\ngot:  %s\nwant: %s", got, want)
+	}
+	if restored.Pending.Len() != 1 || len(restored.TaskWorkerMap) != 1 {
+		t.Errorf("restored %d pending and %d assigned tasks, want 1 each", restored.Pending.Len(), len(restored.TaskWorkerMap))
+	}
+}
+
+func TestApi_RestoreRejectsInconsistentSnapshot(t *testing.T) {
+	s := manager.Snapshot{TaskWorkerMap: map[uuid.UUID]string{uuid.New(): "worker-1"}}
+	body, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("marshalling snapshot: %v", err)
+	}
+
+	m := newManager()
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: m}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/restore", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if len(m.TaskWorkerMap) != 0 {
+		t.Errorf("manager loaded %d assignments from a rejected snapshot", len(m.TaskWorkerMap))
+	}
+}
+
+func TestApi_GetNodesHandler(t *testing.T) {
+	var received int
+	up := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	gone := httptest.NewServer(http.NotFoundHandler())
+	down := strings.TrimPrefix(gone.URL, "http://")
+	gone.Close()
+
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	m := newManager(up, down)
+	m.Clock = clock.NewFake(now)
+	m.WorkerNodes = []*node.Node{
+		{Name: up, Ip: "10.0.0.1", Role: "worker", Cores: 4, Memory: 8192},
+		{Name: down, Ip: "10.0.0.2", Role: "worker", Cores: 2, Memory: 4096},
+	}
+	running := task.Task{ID: uuid.New(), Name: "web", State: task.Running, Memory: 1024}
+	m.TaskDb[running.ID.String()] = []*task.Task{&running}
+	m.WorkerTaskMap[up] = []uuid.UUID{running.ID}
+	m.TaskWorkerMap[running.ID] = up
+
+	m.UpdateTasks()
+
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: m}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nodes", nil))
+	var views []manager.NodeView
+	if err := json.NewDecoder(rec.Body).Decode(&views); err != nil {
+		t.Fatalf("decoding nodes: %v", err)
+	}
+	if len(views) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(views))
+	}
+
+	byName := map[string]manager.NodeView{views[0].Name: views[0], views[1].Name: views[1]}
+	if v := byName[up]; v.Unreachable || !v.LastSeen.Equal(now) || v.TaskCount != 1 || v.Ip != "10.0.0.1" || v.MemoryAllocated != 1024 {
+		t.Errorf("reachable node = %+v, want seen at %v with 1 task", v, now)
+	}
+	if v := byName[down]; !v.Unreachable || v.Error == "" || !v.LastSeen.IsZero() || v.Cores != 2 {
+		t.Errorf("unreachable node = %+v, want it flagged and never seen", v)
+	}
+}
+
+func TestApi_CordonNodeHandler(t *testing.T) {
+	var cordonedReceived, openReceived int
+	cordoned := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &cordonedReceived).URL, "http://")
+	open := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &openReceived).URL, "http://")
+
+	m := newManager(cordoned, open)
+	api := &manager.Api{Manager: m}
+	running := task.Task{ID: uuid.New(), Name: "db", State: task.Running}
+	m.TaskDb[running.ID.String()] = []*task.Task{&running}
+	m.WorkerTaskMap[cordoned] = []uuid.UUID{running.ID}
+	m.TaskWorkerMap[running.ID] = cordoned
+
+	post := func(path string) int {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+	if code := post("/nodes/" + cordoned + "/cordon"); code != http.StatusNoContent {
+		t.Fatalf("cordon status = %d, want %d", code, http.StatusNoContent)
+	}
+	if code := post("/nodes/unknown:1/cordon"); code != http.StatusNotFound {
+		t.Errorf("cordon of an unknown worker status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	for range 3 {
+		m.AddTask(pendingEvent("web"))
+		m.SendWork()
+	}
+	if cordonedReceived != 0 || openReceived != 3 {
+		t.Errorf("cordoned worker received %d tasks and open worker %d, want 0 and 3", cordonedReceived, openReceived)
+	}
+	if got, err := m.GetTask(running.ID); err != nil || got.State != task.Running || m.TaskWorkerMap[running.ID] != cordoned {
+		t.Errorf("task on the cordoned worker was disturbed: %v on %s (%v)", got, m.TaskWorkerMap[running.ID], err)
+	}
+	for _, v := range m.Nodes() {
+		if v.Cordoned != (v.Name == cordoned) {
+			t.Errorf("node %s Cordoned = %v", v.Name, v.Cordoned)
+		}
+	}
+
+	if code := post("/nodes/" + cordoned + "/uncordon"); code != http.StatusNoContent {
+		t.Fatalf("uncordon status = %d, want %d", code, http.StatusNoContent)
+	}
+	for range 2 {
+		m.AddTask(pendingEvent("web"))
+		m.SendWork()
+	}
+	if cordonedReceived != 1 {
+		t.Errorf("uncordoned worker received %d tasks, want 1", cordonedReceived)
+	}
+}
+
+func TestApi_RestartTaskHandler(t *testing.T) {
+	wk := newStubWorker(t, nil)
+	m := newManager(wk.addr)
+	api := &manager.Api{Manager: m}
+	te := pendingEvent("web")
+	te.Task.Image = "strm/helloworld-http"
+	te.Task.Env = []string{"GREETING=hello"}
+	m.AddTask(te)
+	m.SendWork()
+
+	first := wk.next(t)
+	first.State = task.Running
+	first.ContainerID = "container-1"
+	first.StartTime = time.Now()
+	wk.report(first)
+	m.UpdateTasks()
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+te.Task.ID.String()+"/restart", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	m.SendWork()
+
+	if wk.Queue.Len() != 2 {
+		t.Fatalf("worker queue holds %d tasks, want a stop and a start", wk.Queue.Len())
+	}
+	stop := wk.next(t)
+	if stop.State != task.Cancelled || stop.Reason != task.ReasonRestarted {
+		t.Errorf("first queued task is %v (%q), want %v (%q)", stop.State, stop.Reason, task.Cancelled, task.ReasonRestarted)
+	}
+	start := wk.next(t)
+	if start.ID != te.Task.ID || start.State != task.Scheduled || start.RestartCount != 1 {
+		t.Errorf("restarted task = %v in state %v with %d restarts, want %v scheduled with 1", start.ID, start.State, start.RestartCount, te.Task.ID)
+	}
+	if start.Image != te.Task.Image || !slices.Equal(start.Env, te.Task.Env) {
+		t.Errorf("restarted task runs %q with env %v, want %q with %v", start.Image, start.Env, te.Task.Image, te.Task.Env)
+	}
+	if start.ContainerID != "" || !start.StartTime.IsZero() {
+		t.Errorf("restarted task kept container %q started at %v", start.ContainerID, start.StartTime)
+	}
+
+	// A report from the old container must not overwrite the restarted task.
+	m.UpdateTasks()
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.ContainerID != "" || got.RestartCount != 1 {
+		t.Errorf("task = container %q with %d restarts, want no container and 1 restart", got.ContainerID, got.RestartCount)
+	}
+}
+
+func TestApi_RestartTaskHandlerRejectsPendingTask(t *testing.T) {
+	m := newManager()
+	te := pendingEvent("web")
+	m.AddTask(te)
+
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: m}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+te.Task.ID.String()+"/restart", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestApi_WorkerRegistersAndExpires(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	m := newManager()
+	m.Clock = clk
+	m.NodeTTL = time.Minute
+	srv := httptest.NewServer((&manager.Api{Manager: m}).Handler())
+	defer srv.Close()
+	managerAddr := strings.TrimPrefix(srv.URL, "http://")
+
+	wk := &worker.Worker{Name: "joiner", Queue: *queue.New(), Db: make(map[uuid.UUID]*task.Task), MaxConcurrent: 3}
+	if err := wk.SendHeartbeat(managerAddr, "10.0.0.7:5555"); err != nil {
+		t.Fatalf("SendHeartbeat() error = %v", err)
+	}
+	if len(m.Workers) != 1 || m.Workers[0] != "10.0.0.7:5555" {
+		t.Fatalf("workers = %v, want [10.0.0.7:5555]", m.Workers)
+	}
+
+	// A heartbeat within the TTL keeps the worker registered.
+	clk.Advance(45 * time.Second)
+	if err := wk.SendHeartbeat(managerAddr, "10.0.0.7:5555"); err != nil {
+		t.Fatalf("SendHeartbeat() error = %v", err)
+	}
+	clk.Advance(45 * time.Second)
+	if expired := m.ExpireNodes(); len(expired) != 0 {
+		t.Fatalf("expired %v within the TTL", expired)
+	}
+	nodes := m.Nodes()
+	if len(nodes) != 1 || !nodes[0].Registered || nodes[0].Stats == nil || nodes[0].Stats.MaxConcurrent != 3 {
+		t.Fatalf("nodes = %+v, want the registered worker with its stats", nodes)
+	}
+	if want := start.Add(45 * time.Second); !nodes[0].LastSeen.Equal(want) {
+		t.Errorf("last seen = %v, want %v", nodes[0].LastSeen, want)
+	}
+
+	// Gone silent for longer than the TTL.
+	clk.Advance(30 * time.Second)
+	if expired := m.ExpireNodes(); len(expired) != 1 || expired[0] != "10.0.0.7:5555" {
+		t.Fatalf("expired = %v, want [10.0.0.7:5555]", expired)
+	}
+	if len(m.Workers) != 0 {
+		t.Errorf("workers = %v after expiry, want none", m.Workers)
+	}
+	if nodes := m.Nodes(); len(nodes) != 1 || !nodes[0].Down {
+		t.Errorf("nodes = %+v after expiry, want the worker marked down", nodes)
+	}
+}
+
+func TestManager_LapsedHeartbeatReschedulesTasks(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC))
+	var lostReceived, spareReceived int
+	lost := fakeWorker(t, worker.Stats{MaxConcurrent: 2}, &lostReceived)
+	spare := fakeWorker(t, worker.Stats{MaxConcurrent: 2}, &spareReceived)
+	lostAddr := strings.TrimPrefix(lost.URL, "http://")
+	spareAddr := strings.TrimPrefix(spare.URL, "http://")
+
+	m := newManager()
+	m.Clock = clk
+	m.NodeTTL = 30 * time.Second
+	m.Register(worker.Heartbeat{Name: "lost", Address: lostAddr})
+	te := pendingEvent("web")
+	m.AddTask(te)
+	m.SendWork()
+	if lostReceived != 1 {
+		t.Fatalf("worker received %d tasks, want 1", lostReceived)
+	}
+
+	clk.Advance(20 * time.Second)
+	m.Register(worker.Heartbeat{Name: "spare", Address: spareAddr})
+	clk.Advance(20 * time.Second)
+	if expired := m.ExpireNodes(); !slices.Equal(expired, []string{lostAddr}) {
+		t.Fatalf("expired = %v, want [%s]", expired, lostAddr)
+	}
+
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Pending || got.RestartCount != 1 {
+		t.Errorf("task is %v with %d restarts, want %v with 1", got.State, got.RestartCount, task.Pending)
+	}
+	if w, ok := m.TaskWorkerMap[te.Task.ID]; ok {
+		t.Errorf("task still assigned to %s", w)
+	}
+	events := m.NodeEvents()
+	if last := events[len(events)-1]; last.Node != lostAddr || last.State != manager.NodeDown || !slices.Equal(last.Tasks, []uuid.UUID{te.Task.ID}) {
+		t.Errorf("last node event = %+v, want %s down with the task rescheduled", last, lostAddr)
+	}
+
+	m.SendWork()
+	if spareReceived != 1 || m.TaskWorkerMap[te.Task.ID] != spareAddr {
+		t.Errorf("rescheduled task went to %q, want %q", m.TaskWorkerMap[te.Task.ID], spareAddr)
+	}
+
+	// The lost worker rejoins afresh.
+	m.Register(worker.Heartbeat{Name: "lost", Address: lostAddr})
+	for _, n := range m.Nodes() {
+		if n.Name == lostAddr && n.Down {
+			t.Errorf("worker %s still down after registering again", lostAddr)
+		}
+	}
+}
+
+func TestManager_ExpireNodesKeepsStaticWorkers(t *testing.T) {
+	m := newManager("static:5555")
+	m.NodeTTL = time.Nanosecond
+
+	if expired := m.ExpireNodes(); len(expired) != 0 || len(m.Workers) != 1 {
+		t.Errorf("expired %v, leaving %v; want the static worker kept", expired, m.Workers)
+	}
+}
+
+func TestApi_GetTasksHandlerOrder(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC))
+	m := newManager()
+	m.Clock = clk
+	var want []uuid.UUID
+	for i := 0; i < 20; i++ {
+		// Tasks submitted in the same instant fall back to ID order.
+		if i%5 == 0 {
+			clk.Advance(time.Second)
+		}
+		te := pendingEvent("web")
+		m.AddTask(te)
+		want = append(want, te.Task.ID)
+	}
+	for i := 0; i < len(want); i += 5 {
+		slices.SortFunc(want[i:i+5], func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+	}
+	api := &manager.Api{Manager: m}
+
+	for i := 0; i < 5; i++ {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks", nil))
+		var tasks []task.Task
+		if err := json.NewDecoder(rec.Body).Decode(&tasks); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		got := make([]uuid.UUID, len(tasks))
+		for j, tk := range tasks {
+			got[j] = tk.ID
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("call %d listed %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestApi_StreamEventsHandler(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	m := newManager(w)
+	srv := httptest.NewServer((&manager.Api{Manager: m}).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/tasks/events")
+	if err != nil {
+		t.Fatalf("GET /tasks/events: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q, want text/event-stream", ct)
+	}
+
+	events := make(chan task.TaskEvent, 16)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			te := task.TaskEvent{}
+			if err := json.Unmarshal([]byte(data), &te); err != nil {
+				t.Errorf("decoding event: %v", err)
+				return
+			}
+			events <- te
+		}
+	}()
+	next := func() task.TaskEvent {
+		t.Helper()
+		select {
+		case te, ok := <-events:
+			if !ok {
+				t.Fatal("event stream ended")
+			}
+			return te
+		case <-time.After(time.Second):
+			t.Fatal("no event within a second")
+		}
+		return task.TaskEvent{}
+	}
+
+	te := pendingEvent("web")
+	m.AddTask(te)
+	if got := next(); got.Task.ID != te.Task.ID || got.State != task.Pending {
+		t.Errorf("first event = %v for %v, want %v for %v", got.State, got.Task.ID, task.Pending, te.Task.ID)
+	}
+	m.SendWork()
+	if got := next(); got.Task.ID != te.Task.ID || got.State != task.Scheduled {
+		t.Errorf("second event = %v for %v, want %v for %v", got.State, got.Task.ID, task.Scheduled, te.Task.ID)
+	}
+}
+
+func TestManager_SubscribeCancelClosesChannel(t *testing.T) {
+	m := newManager()
+	events, cancel := m.Subscribe()
+	cancel()
+	cancel()
+
+	m.AddTask(pendingEvent("web"))
+	if _, ok := <-events; ok {
+		t.Error("cancelled subscription received an event")
+	}
+}
+
+func TestApi_GetTaskEventsHandlerPages(t *testing.T) {
+	m := newManager()
+	api := &manager.Api{Manager: m}
+	te := pendingEvent("web")
+	m.AddTask(te)
+	key := te.Task.ID.String()
+	for _, state := range []task.State{task.Scheduled, task.Running, task.Completed, task.Running} {
+		m.EventDb[key] = append(m.EventDb[key], &task.TaskEvent{ID: uuid.New(), State: state, Task: te.Task})
+	}
+
+	get := func(query string) manager.EventPage {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+key+"/events"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status = %d, want %d: %s", query, rec.Code, http.StatusOK, rec.Body)
+		}
+		page := manager.EventPage{}
+		if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+			t.Fatalf("decoding page: %v", err)
+		}
+		return page
+	}
+
+	var seen []uuid.UUID
+	query := "?limit=2"
+	for pages := 1; ; pages++ {
+		page := get(query)
+		for _, e := range page.Events {
+			seen = append(seen, e.ID)
+		}
+		if page.NextCursor == "" {
+			if pages != 3 {
+				t.Errorf("read %d pages, want 3", pages)
+			}
+			break
+		}
+		if len(page.Events) != 2 {
+			t.Fatalf("page %d holds %d events, want 2", pages, len(page.Events))
+		}
+		if pages == 1 {
+			// An event recorded between pages comes last, not twice or never.
+			m.EventDb[key] = append(m.EventDb[key], &task.TaskEvent{ID: uuid.New(), State: task.Completed, Task: te.Task})
+		}
+		query = "?limit=2&after=" + page.NextCursor
+	}
+
+	var want []uuid.UUID
+	for _, e := range m.EventDb[key] {
+		want = append(want, e.ID)
+	}
+	if !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+key+"/events?after="+uuid.NewString(), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown cursor: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestApi_WaitTaskHandler(t *testing.T) {
+	wk := newStubWorker(t, nil)
+	m := newManager(wk.addr)
+	msrv := httptest.NewServer((&manager.Api{Manager: m}).Handler())
+	defer msrv.Close()
+	te := pendingEvent("ci")
+	m.AddTask(te)
+	m.SendWork()
+
+	// A task that does not finish in time comes back as it stands.
+	resp, err := http.Get(msrv.URL + "/tasks/" + te.Task.ID.String() + "/wait?timeout=10ms")
+	if err != nil {
+		t.Fatalf("GET wait: %v", err)
+	}
+	got := task.Task{}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || got.State != task.Scheduled {
+		t.Fatalf("timed out wait = %d with task %v, want %d with %v", resp.StatusCode, got.State, http.StatusOK, task.Scheduled)
+	}
+
+	type result struct {
+		status int
+		task   task.Task
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(msrv.URL + "/tasks/" + te.Task.ID.String() + "/wait?timeout=10s")
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		r := result{status: resp.StatusCode}
+		r.err = json.NewDecoder(resp.Body).Decode(&r.task)
+		done <- r
+	}()
+
+	finished := wk.next(t)
+	finished.State = task.Completed
+	finished.ContainerID = "container-1"
+	wk.report(finished)
+	m.UpdateTasks()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("waiting: %v", r.err)
+		}
+		if r.status != http.StatusOK || r.task.State != task.Completed {
+			t.Errorf("wait = %d with task %v, want %d with %v", r.status, r.task.State, http.StatusOK, task.Completed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return once the task completed")
+	}
+}
+
+func TestApi_GetTaskUsageHandler(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	wk := newStubWorker(t, clk)
+	m := newManager(wk.addr)
+	m.Clock = clk
+	te := pendingEvent("batch")
+	te.Task.Cpu = 0.5
+	te.Task.Memory = 256
+	m.AddTask(te)
+	m.SendWork()
+
+	run := wk.next(t)
+	run.State = task.Running
+	run.StartTime = clk.Now()
+	wk.report(run)
+	m.UpdateTasks()
+	clk.Advance(90 * time.Second)
+	run.State = task.Completed
+	run.FinishTime = clk.Now()
+	wk.report(run)
+	m.UpdateTasks()
+	m.UpdateTasks()
+
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: m}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+te.Task.ID.String()+"/usage", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	got := manager.Usage{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding usage: %v", err)
+	}
+	want := manager.Usage{TaskID: te.Task.ID, Runs: 1, Duration: 90 * time.Second, CpuSeconds: 45, MemoryMBSeconds: 23040}
+	if got != want {
+		t.Errorf("usage = %+v, want %+v", got, want)
+	}
+}
+
+func TestApi_GetTaskResultHandler(t *testing.T) {
+	te := pendingEvent("report")
+	var report *task.Task
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*task.Task{report})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	m.AddTask(te)
+	api := &manager.Api{Manager: m}
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+te.Task.ID.String()+"/result", nil))
+		return rec
+	}
+
+	running := te.Task
+	running.State = task.Running
+	report = &running
+	m.UpdateTasks()
+	if rec := get(); rec.Code != http.StatusConflict {
+		t.Errorf("result of a running task status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	completed := te.Task
+	completed.State = task.Completed
+	completed.Result = `{"rows": 30}`
+	report = &completed
+	m.UpdateTasks()
+	rec := get()
+	if rec.Code != http.StatusOK || rec.Body.String() != completed.Result {
+		t.Errorf("result = %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusOK, completed.Result)
+	}
+}
+
+func TestApi_TaskAnnotationsRoundTrip(t *testing.T) {
+	s := store.NewInMemoryStore()
+	m := newManager()
+	m.Store = s
+	api := &manager.Api{Manager: m}
+
+	te := pendingEvent("report")
+	annotations := map[string]string{"owner": "data-team", "ticket": "OPS-1234", "description": "nightly report"}
+	te.Task.Annotations = annotations
+	body, err := json.Marshal(te)
+	if err != nil {
+		t.Fatalf("marshalling task event: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	restored := newManager()
+	restored.Store = s
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	rec = httptest.NewRecorder()
+	(&manager.Api{Manager: restored}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+te.Task.ID.String(), nil))
+	got := task.Task{}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !maps.Equal(got.Annotations, annotations) {
+		t.Errorf("annotations = %v, want %v", got.Annotations, annotations)
+	}
+}
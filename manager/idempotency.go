@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is remembered when
+// Manager.IdempotencyWindow is not set.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// idempotencyPrefix is the store key prefix for submissions made with an
+// idempotency key.
+const idempotencyPrefix = "manager/idempotency/"
+
+// idempotencyRecord remembers the task created by a submission.
+type idempotencyRecord struct {
+	TaskID    uuid.UUID
+	CreatedAt time.Time
+}
+
+// SubmitTask queues a submitted task. When key is not empty and a task was
+// already submitted with the same key within the idempotency window, that
+// task is returned instead and nothing new is queued. The returned bool
+// reports whether a task was created. A new task is rejected with
+// ErrDuplicateName while another task of its name has not finished, with
+// ErrQueueFull while MaxPending tasks are waiting to be scheduled, and with
+// an error matching cubeerrors.ErrQuotaExceeded when it would take its
+// namespace past its quota.
+func (m *Manager) SubmitTask(te task.TaskEvent, key string) (task.Task, bool, error) {
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+
+	if key == "" {
+		key = te.Task.IdempotencyKey
+	}
+	if key == "" {
+		if err := m.admitTask(te.Task); err != nil {
+			return task.Task{}, false, err
+		}
+		return m.AddTask(te), true, nil
+	}
+
+	s := m.recordStore()
+	record := idempotencyRecord{}
+	err := s.Get(idempotencyPrefix+key, &record)
+	switch {
+	case err == nil && m.now().Sub(record.CreatedAt) < m.idempotencyWindow():
+		existing, err := m.GetTask(record.TaskID)
+		if err == nil {
+			return *existing, false, nil
+		}
+	case err != nil && !errors.Is(err, store.ErrNotFound):
+		return task.Task{}, false, fmt.Errorf("looking up idempotency key: %w", err)
+	}
+
+	if err := m.admitTask(te.Task); err != nil {
+		return task.Task{}, false, err
+	}
+	te.Task.IdempotencyKey = key
+	record = idempotencyRecord{TaskID: te.Task.ID, CreatedAt: m.now().UTC()}
+	if err := s.Put(idempotencyPrefix+key, record); err != nil {
+		return task.Task{}, false, fmt.Errorf("recording idempotency key: %w", err)
+	}
+	return m.AddTask(te), true, nil
+}
+
+// admitTask runs the checks a new submission must pass before it is
+// queued: its name, the room in the pending queue, its namespace's quota
+// and whether its completion webhook is allowed.
+func (m *Manager) admitTask(t task.Task) error {
+	if err := m.checkName(t); err != nil {
+		return err
+	}
+	if err := m.admit(1); err != nil {
+		return err
+	}
+	if err := m.checkQuota([]task.Task{t}, nil); err != nil {
+		return err
+	}
+	return m.Webhook.checkWebhook(t)
+}
+
+// admit reports ErrQueueFull when n more tasks would take the pending queue
+// past MaxPending.
+func (m *Manager) admit(n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.MaxPending > 0 && m.Pending.Len()+n > m.MaxPending {
+		return fmt.Errorf("%w (%d tasks waiting)", ErrQueueFull, m.Pending.Len())
+	}
+	return nil
+}
+
+func (m *Manager) idempotencyWindow() time.Duration {
+	if m.IdempotencyWindow > 0 {
+		return m.IdempotencyWindow
+	}
+	return DefaultIdempotencyWindow
+}
+
+// recordStore returns the manager's store, or an in-memory one when the
+// manager has none, so idempotency keys and audit entries are kept either way.
+func (m *Manager) recordStore() store.Store {
+	if m.Store != nil {
+		return m.Store
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.records == nil {
+		m.records = store.NewInMemoryStore()
+	}
+	return m.records
+}
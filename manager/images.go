@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// placeChecked places the task like place and, when CheckImages is set, asks
+// the chosen worker whether it can pull the task's image, placing the task
+// again without each worker that cannot until one can or none is left.
+func (m *Manager) placeChecked(t task.Task) (AuditEntry, error) {
+	skip := make(map[string]bool)
+	for {
+		d, err := m.place(t, skip)
+		if err == nil && m.CheckImages {
+			if checkErr := m.checkImage(d.Chosen, t); checkErr != nil {
+				logging.Warnf("Worker %s cannot pull image %s for task %v: %v", d.Chosen, t.Image, t.ID, checkErr)
+				skip[d.Chosen] = true
+				continue
+			}
+		}
+		if len(skip) > 0 {
+			skipped := slices.Sorted(maps.Keys(skip))
+			d.Reason += fmt.Sprintf("; passed over %s, unable to pull the image", strings.Join(skipped, ", "))
+		}
+		return d, err
+	}
+}
+
+// checkImage asks worker w whether it can pull the task's image, returning
+// an error saying why not if it cannot.
+func (m *Manager) checkImage(w string, t task.Task) error {
+	data, err := json.Marshal(worker.ImageCheck{Image: t.Image, PullPolicy: t.PullPolicy})
+	if err != nil {
+		return err
+	}
+	resp, err := m.client().Post(fmt.Sprintf("http://%s/images/check", w), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return cubeerrors.Wrap(cubeerrors.ErrWorkerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	e := cubeerrors.Envelope{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("%s (%d %s)", e.Error.Message, resp.StatusCode, e.Error.Code)
+}
@@ -0,0 +1,70 @@
+package manager_test
+
+import (
+	"encoding/json"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/worker"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// imageWorker is a worker that can or cannot reach the image registry, and
+// counts the tasks sent to it.
+func imageWorker(t *testing.T, reachable bool, received *int) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.Stats{})
+	})
+	mux.HandleFunc("POST /images/check", func(w http.ResponseWriter, r *http.Request) {
+		if !reachable {
+			cubeerrors.WriteHTTP(w, cubeerrors.Wrapf(cubeerrors.ErrImagePull, "registry unreachable"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		*received++
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestManager_SendWorkSkipsWorkerThatCannotPullImage(t *testing.T) {
+	var isolatedReceived, connectedReceived int
+	connected := imageWorker(t, true, &connectedReceived)
+	isolated := imageWorker(t, false, &isolatedReceived)
+
+	// Round-robin order would pick the isolated worker first.
+	m := newManager(connected, isolated)
+	m.CheckImages = true
+	te := pendingEvent("web")
+	te.Task.Image = "registry.example.com/web:1"
+	m.AddTask(te)
+	m.SendWork()
+
+	if isolatedReceived != 0 || connectedReceived != 1 {
+		t.Fatalf("isolated worker received %d tasks and connected worker %d, want 0 and 1", isolatedReceived, connectedReceived)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != connected {
+		t.Errorf("task assigned to %s, want %s", got, connected)
+	}
+	entries, err := m.AuditLog(te.Task.ID.String())
+	if err != nil || len(entries) != 1 || !strings.Contains(entries[0].Reason, isolated) {
+		t.Errorf("audit = %+v (%v), want the isolated worker noted as passed over", entries, err)
+	}
+
+	// With no worker able to pull the image, the task stays pending.
+	m = newManager(isolated)
+	m.CheckImages = true
+	m.AddTask(pendingEvent("web"))
+	m.SendWork()
+	if isolatedReceived != 0 || m.Pending.Len() != 1 {
+		t.Errorf("isolated worker received %d tasks with %d pending, want none sent and 1 pending", isolatedReceived, m.Pending.Len())
+	}
+}
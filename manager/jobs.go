@@ -0,0 +1,210 @@
+package manager
+
+import (
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"time"
+)
+
+// JobSpec is a named collection of tasks to submit together as a job.
+type JobSpec struct {
+	Name  string
+	Tasks []task.Task
+}
+
+// Job is a group of tasks submitted together, with their states rolled up
+// into one.
+type Job struct {
+	ID        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+
+	// State is the rollup of the tasks' states; see JobState
+	State task.State
+
+	// Tasks lists the job's tasks in the order they were submitted
+	Tasks []JobTask
+}
+
+// JobTask is the state of one of a job's tasks.
+type JobTask struct {
+	ID    uuid.UUID
+	Name  string
+	State task.State
+}
+
+// JobTaskStop is what cancelling a job did to one of its tasks.
+type JobTaskStop struct {
+	ID   uuid.UUID
+	Name string
+
+	// State is the task's state when the job was cancelled
+	State task.State
+
+	// Stopped is set when the task was cancelled; finished tasks are left
+	// as they are
+	Stopped bool
+
+	// Error is why the task could not be stopped
+	Error string `json:",omitempty"`
+}
+
+// JobRecord is what the manager keeps of a submitted job; the states of its
+// tasks are looked up when it is read.
+type JobRecord struct {
+	Name      string
+	CreatedAt time.Time
+	Tasks     []uuid.UUID
+
+	// Cancelled is set once CancelJob has been called
+	Cancelled bool
+}
+
+// JobState rolls the states of a job's tasks up into the job's: Failed once
+// any task has failed, Cancelled once any was cancelled, Completed once all
+// have completed, Pending while none has been scheduled, Scheduled while
+// none has started, and Running otherwise.
+func JobState(states []task.State) task.State {
+	counts := make(map[task.State]int)
+	for _, s := range states {
+		counts[s]++
+	}
+	switch {
+	case counts[task.Failed] > 0:
+		return task.Failed
+	case counts[task.Cancelled] > 0:
+		return task.Cancelled
+	case counts[task.Completed] == len(states):
+		return task.Completed
+	case counts[task.Pending] == len(states):
+		return task.Pending
+	case counts[task.Pending]+counts[task.Scheduled] == len(states):
+		return task.Scheduled
+	default:
+		return task.Running
+	}
+}
+
+// SubmitJob queues the tasks of a job, each filled in from DefaultProfile and
+// tagged with the job's ID, and returns the job. Either every task is queued
+// or none is: the job is rejected with ErrDuplicateName when one of its
+// tasks is named as an unfinished task or another of its tasks is, with
+// ErrQueueFull when its tasks would take the pending queue past MaxPending,
+// and with an error matching cubeerrors.ErrQuotaExceeded when they would
+// take a namespace past its quota. Replicas are exempt from the name check,
+// as they are in SubmitTask.
+func (m *Manager) SubmitJob(spec JobSpec) (Job, error) {
+	if len(spec.Tasks) == 0 {
+		return Job{}, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "job %q has no tasks", spec.Name)
+	}
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+
+	names := make(map[string]bool)
+	for _, t := range spec.Tasks {
+		if err := m.checkName(t); err != nil {
+			return Job{}, err
+		}
+		if t.Name == "" || t.Replicas > 0 {
+			continue
+		}
+		if names[t.Name] {
+			return Job{}, fmt.Errorf("%w: job %q has two tasks named %s", ErrDuplicateName, spec.Name, t.Name)
+		}
+		names[t.Name] = true
+	}
+	if err := m.admit(len(spec.Tasks)); err != nil {
+		return Job{}, err
+	}
+	if err := m.checkQuota(spec.Tasks, nil); err != nil {
+		return Job{}, err
+	}
+	for _, t := range spec.Tasks {
+		if err := m.Webhook.checkWebhook(t); err != nil {
+			return Job{}, err
+		}
+	}
+
+	id := uuid.New()
+	record := &JobRecord{Name: spec.Name, CreatedAt: m.now().UTC()}
+	for _, t := range spec.Tasks {
+		if t.ID == uuid.Nil {
+			t.ID = uuid.New()
+		}
+		t.JobID = id
+		t.State = task.Pending
+		m.AddTask(task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Pending,
+			Timestamp: record.CreatedAt,
+			Task:      t,
+		})
+		record.Tasks = append(record.Tasks, t.ID)
+	}
+
+	m.mu.Lock()
+	if m.jobs == nil {
+		m.jobs = make(map[uuid.UUID]*JobRecord)
+	}
+	m.jobs[id] = record
+	m.mu.Unlock()
+
+	return m.GetJob(id)
+}
+
+// GetJob returns the job with the given ID and the current states of its
+// tasks. Tasks since pruned by Sweep are left out.
+func (m *Manager) GetJob(id uuid.UUID) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("job %v %w", id, cubeerrors.ErrNotFound)
+	}
+	job := Job{ID: id, Name: record.Name, CreatedAt: record.CreatedAt, Tasks: []JobTask{}}
+	var states []task.State
+	for _, taskID := range record.Tasks {
+		t := m.task(taskKey(taskID))
+		if t == nil {
+			continue
+		}
+		job.Tasks = append(job.Tasks, JobTask{ID: t.ID, Name: t.Name, State: t.State})
+		states = append(states, t.State)
+	}
+	job.State = JobState(states)
+	if record.Cancelled {
+		job.State = task.Cancelled
+	}
+	return job, nil
+}
+
+// CancelJob marks the job with the given ID cancelled and stops each of its
+// tasks that has not finished: pending ones are taken off the queue and the
+// rest stopped on their workers. Finished tasks are left as they are. It
+// returns what was done to each task, in the order they were submitted.
+func (m *Manager) CancelJob(id uuid.UUID) ([]JobTaskStop, error) {
+	job, err := m.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	m.jobs[id].Cancelled = true
+	m.mu.Unlock()
+
+	stops := make([]JobTaskStop, 0, len(job.Tasks))
+	for _, jt := range job.Tasks {
+		stop := JobTaskStop{ID: jt.ID, Name: jt.Name, State: jt.State}
+		if !jt.State.Terminal() {
+			if err := m.retire(jt.ID, task.ReasonJobCancelled); err != nil {
+				stop.Error = err.Error()
+			} else {
+				stop.Stopped = true
+			}
+		}
+		stops = append(stops, stop)
+	}
+	return stops, nil
+}
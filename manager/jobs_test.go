@@ -0,0 +1,173 @@
+package manager_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobState(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []task.State
+		want   task.State
+	}{
+		{"all complete", []task.State{task.Completed, task.Completed}, task.Completed},
+		{"one failed", []task.State{task.Completed, task.Failed, task.Running}, task.Failed},
+		{"some running", []task.State{task.Completed, task.Running}, task.Running},
+		{"none started", []task.State{task.Pending, task.Scheduled}, task.Scheduled},
+		{"all pending", []task.State{task.Pending, task.Pending}, task.Pending},
+	}
+	for _, tt := range tests {
+		if got := manager.JobState(tt.states); got != tt.want {
+			t.Errorf("%s: JobState(%v) = %v, want %v", tt.name, tt.states, got, tt.want)
+		}
+	}
+}
+
+func TestApi_JobRollsUpTaskStates(t *testing.T) {
+	var reports []task.Task
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(reports)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	api := &manager.Api{Manager: m}
+
+	body := `{"Name": "nightly", "Tasks": [{"Name": "extract"}, {"Name": "load"}]}`
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	job := manager.Job{}
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("decoding job: %v", err)
+	}
+	if job.Name != "nightly" || len(job.Tasks) != 2 || job.State != task.Pending {
+		t.Fatalf("job = %+v, want nightly with 2 pending tasks", job)
+	}
+	for _, jt := range job.Tasks {
+		if got, err := m.GetTask(jt.ID); err != nil || got.JobID != job.ID {
+			t.Errorf("task %v has job %v (%v), want %v", jt.ID, got.JobID, err, job.ID)
+		}
+	}
+
+	report := func(states ...task.State) manager.Job {
+		t.Helper()
+		reports = nil
+		for i, jt := range job.Tasks {
+			reports = append(reports, task.Task{ID: jt.ID, Name: jt.Name, State: states[i]})
+		}
+		m.UpdateTasks()
+
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/jobs/"+job.ID.String(), nil))
+		got := manager.Job{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding job: %v", err)
+		}
+		return got
+	}
+
+	if got := report(task.Completed, task.Running); got.State != task.Running {
+		t.Errorf("job with a task still running is %v, want %v", got.State, task.Running)
+	}
+	if got := report(task.Completed, task.Completed); got.State != task.Completed || got.Tasks[1].State != task.Completed {
+		t.Errorf("job with every task complete = %+v, want %v", got, task.Completed)
+	}
+	if got := report(task.Completed, task.Failed); got.State != task.Failed {
+		t.Errorf("job with a failed task is %v, want %v", got.State, task.Failed)
+	}
+}
+
+func TestApi_CancelJobStopsUnfinishedTasks(t *testing.T) {
+	var calls []string
+	srv := recordingWorker(t, "worker", task.Task{}, &calls)
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	api := &manager.Api{Manager: m}
+
+	job, err := m.SubmitJob(manager.JobSpec{Name: "nightly", Tasks: []task.Task{{Name: "extract"}, {Name: "transform"}, {Name: "load"}}})
+	if err != nil {
+		t.Fatalf("SubmitJob() error = %v", err)
+	}
+	for range job.Tasks {
+		m.SendWork()
+	}
+	done := job.Tasks[0].ID
+	versions := m.TaskDb[done.String()]
+	versions[len(versions)-1].State = task.Completed
+	calls = nil
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/jobs/"+job.ID.String(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var stops []manager.JobTaskStop
+	if err := json.NewDecoder(rec.Body).Decode(&stops); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(stops) != 3 || stops[0].Stopped || !stops[1].Stopped || !stops[2].Stopped {
+		t.Errorf("stops = %+v, want the last two stopped", stops)
+	}
+
+	var want []string
+	for _, jt := range job.Tasks[1:] {
+		want = append(want, "worker DELETE /tasks/"+jt.ID.String())
+	}
+	if !slices.Equal(calls, want) {
+		t.Errorf("worker calls = %v, want %v", calls, want)
+	}
+	if got, err := m.GetJob(job.ID); err != nil || got.State != task.Cancelled {
+		t.Errorf("GetJob() = %v, %v, want the job cancelled", got.State, err)
+	}
+}
+
+func TestManager_JobsSurviveRestartUntilSwept(t *testing.T) {
+	s := store.NewInMemoryStore()
+	m := newManager()
+	m.Store = s
+	job, err := m.SubmitJob(manager.JobSpec{Name: "nightly", Tasks: []task.Task{{Name: "extract"}}})
+	if err != nil {
+		t.Fatalf("SubmitJob() error = %v", err)
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	restored := newManager()
+	restored.Store = s
+	restored.Clock = clock.NewFake(now)
+	restored.Retention = time.Hour
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	got, err := restored.GetJob(job.ID)
+	if err != nil || got.Name != "nightly" || len(got.Tasks) != 1 {
+		t.Fatalf("GetJob() after restore = %+v, %v; want nightly with its task", got, err)
+	}
+
+	history := restored.TaskDb[job.Tasks[0].ID.String()]
+	history[len(history)-1].State = task.Completed
+	history[len(history)-1].FinishTime = now.Add(-2 * time.Hour)
+	restored.Sweep()
+
+	if _, err := restored.GetJob(job.ID); !cubeerrors.Is(err, cubeerrors.ErrNotFound) {
+		t.Errorf("GetJob() after its tasks were swept error = %v, want ErrNotFound", err)
+	}
+}
@@ -3,10 +3,41 @@
 package manager
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/queues"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/store"
 	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/trace"
+	"github.com/christinavaneyssen/cube/worker"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNoWorkerAvailable is returned when every worker is at capacity or unreachable.
+	ErrNoWorkerAvailable = fmt.Errorf("%w: no worker available", cubeerrors.ErrNoCapacity)
+
+	// ErrTaskNotFound is returned when the manager has no record of a task.
+	ErrTaskNotFound = fmt.Errorf("task %w", cubeerrors.ErrNotFound)
+
+	// ErrQueueFull is returned when a submission would take the pending queue
+	// past MaxPending.
+	ErrQueueFull = fmt.Errorf("%w: pending queue is full", cubeerrors.ErrOverloaded)
 )
 
 type Manager struct {
@@ -29,20 +60,1087 @@ type Manager struct {
 	// TaskWorkerMap maintains the reverse mapping of tasks to workers
 	// Key: task UUID, Value: name of the worker the task is assigned to
 	TaskWorkerMap map[uuid.UUID]string // k = task UUID, v = name of worker
+
+	// LastWorker is the index in Workers of the worker most recently selected
+	LastWorker int
+
+	// Scheduler, when set, places tasks on WorkerNodes instead of taking
+	// Workers in round-robin order. A task's PreferredNodes are honoured on
+	// top of whatever it scores; see scheduler.Prefer.
+	Scheduler scheduler.Scheduler
+
+	// WorkerNodes describes the capacity of each worker, with Node.Name
+	// holding the worker's address
+	WorkerNodes []*node.Node
+
+	// Client is the HTTP client used to talk to workers; http.DefaultClient when nil
+	Client *http.Client
+
+	// Retry sets how calls to workers are retried and when a worker that
+	// keeps failing stops being called for a while
+	Retry RetryPolicy
+
+	// Store, when set, receives the manager's state on Shutdown so Restore
+	// can resume from it
+	Store store.Store
+
+	// Clock tells the time for scheduling and timestamps; the system clock
+	// when nil
+	Clock clock.Clock
+
+	// DefaultProfile supplies the resources and restart policy of tasks
+	// submitted without them
+	DefaultProfile Profile
+
+	// Secrets holds the secrets tasks refer to. Their values are sent to a
+	// worker only with a task it is to start.
+	Secrets task.SecretStore
+
+	// Retention is how long finished tasks are kept before Sweep prunes
+	// them; zero keeps them forever
+	Retention time.Duration
+
+	// KeepLast is the number of most recently finished tasks of each name
+	// Sweep keeps however old they are
+	KeepLast int
+
+	// MaxEvents caps the event history of each task when Sweep compacts it;
+	// see CompactEvents. Zero keeps every event.
+	MaxEvents int
+
+	// MaxAuditEntries caps the placement decisions kept for each task, the
+	// oldest going first; DefaultMaxAuditEntries when zero
+	MaxAuditEntries int
+
+	// MaxPending caps the number of tasks waiting to be scheduled; further
+	// submissions are rejected until the queue drains. Zero means no limit.
+	MaxPending int
+
+	// Quotas caps the resources the unfinished tasks of each namespace may
+	// claim, keyed by namespace; namespaces without one are not limited
+	Quotas map[string]Quota
+
+	// IdempotencyWindow is how long a submission's idempotency key is
+	// remembered; DefaultIdempotencyWindow when zero
+	IdempotencyWindow time.Duration
+
+	// NodeTTL is the grace period a worker that registered itself has to
+	// send its next heartbeat before it is marked down and its tasks
+	// rescheduled; DefaultNodeTTL when zero
+	NodeTTL time.Duration
+
+	// RolloutTimeout is how long a task moved with a rolling update has for
+	// its new container to become healthy; DefaultRolloutTimeout when zero
+	RolloutTimeout time.Duration
+
+	// PollConcurrency is how many workers UpdateTasks polls at once;
+	// DefaultPollConcurrency when zero
+	PollConcurrency int
+
+	// PollTimeout bounds how long UpdateTasks waits for workers to report;
+	// workers that have not answered by then are skipped until the next
+	// poll. DefaultPollTimeout when zero.
+	PollTimeout time.Duration
+
+	// Webhook is told of tasks that finish; see Webhook
+	Webhook Webhook
+
+	// CheckImages has the manager ask the worker it picks for a task
+	// whether it can pull the task's image before sending it the task, and
+	// pick another worker if it cannot
+	CheckImages bool
+
+	// nodeStatus records when each worker was last polled successfully
+	nodeStatus map[string]nodeStatus
+
+	// nodeEvents records registered workers joining and going down
+	nodeEvents []NodeEvent
+
+	// cordoned holds the workers taking no new tasks
+	cordoned map[string]bool
+
+	// previous holds the worker each task was last assigned to, for placing
+	// sticky tasks back on it
+	previous map[uuid.UUID]string
+
+	// jobs holds the jobs submitted with SubmitJob
+	jobs map[uuid.UUID]*JobRecord
+
+	// compacted holds the keys of the tasks whose histories have lost
+	// events to CompactEvents
+	compacted map[string]bool
+
+	// records holds idempotency keys and audit entries when the manager has
+	// no Store
+	records *store.InMemoryStore
+
+	// subscribers receive every task event as it is recorded
+	subscribers map[chan task.TaskEvent]struct{}
+
+	// usage accumulates the resources reserved by each task's finished runs
+	usage map[uuid.UUID]Usage
+
+	// config is the config last applied, and reconfigured is closed when
+	// the next one is, so the loops pick up its intervals
+	config       Config
+	reconfigured chan struct{}
+
+	// base holds the settings a config may replace as they were before
+	// the first config was applied
+	base *baseSettings
+
+	// retrying is Client wrapped to follow Retry, rebuilt when either
+	// changes; retryingFor is the Client and Retry it was built from
+	retrying    *http.Client
+	retryingFor retryConfig
+	breakers    breakers
+	clientMu    sync.Mutex
+
+	mu       sync.Mutex
+	subMu    sync.Mutex
+	submitMu sync.Mutex
+	inflight sync.WaitGroup
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// DefaultPollConcurrency is how many workers UpdateTasks polls at once when
+// Manager.PollConcurrency is zero.
+const DefaultPollConcurrency = 8
+
+// DefaultPollTimeout bounds a round of UpdateTasks when Manager.PollTimeout
+// is zero.
+const DefaultPollTimeout = 10 * time.Second
+
+// Store keys the manager's state is persisted under.
+const (
+	tasksKey       = "manager/tasks"
+	eventsKey      = "manager/events"
+	pendingKey     = "manager/pending"
+	assignmentsKey = "manager/assignments"
+	jobsKey        = "manager/jobs"
+)
+
+// AddTask records a submitted task, filling in what it leaves unset from
+// DefaultProfile, queues it for scheduling and returns it as recorded.
+func (m *Manager) AddTask(te task.TaskEvent) task.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.recordTask(&te)
+	m.Pending.Enqueue(te)
+	return t
+}
+
+// recordTask records a submitted task as AddTask does, filling in te, but
+// leaves queueing it to the caller. Any secret values the submitter sent
+// are dropped: postTask looks them up when the task is sent to a worker, and
+// they must never be stored. The caller must hold m.mu.
+func (m *Manager) recordTask(te *task.TaskEvent) task.Task {
+	te.Secrets = nil
+	if te.Task.TraceID == "" {
+		te.Task.TraceID = trace.NewID()
+	}
+	if te.Task.CreatedAt.IsZero() {
+		te.Task.CreatedAt = m.now().UTC()
+	}
+	te.Task.UpdatedAt = m.now().UTC()
+	m.DefaultProfile.apply(&te.Task)
+	key := taskKey(te.Task.ID)
+	t := te.Task
+	m.TaskDb[key] = []*task.Task{&t}
+	m.appendEvent(te)
+	return t
+}
+
+// requeue puts a task event back on the pending queue after a failed dispatch.
+func (m *Manager) requeue(te task.TaskEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Pending.Enqueue(te)
+}
+
+// SelectWorker chooses a worker with spare capacity, according to its
+// reported stats, to run the task. Workers are taken in round-robin order
+// unless a Scheduler is set, in which case it picks among WorkerNodes.
+func (m *Manager) SelectWorker(t task.Task) (string, error) {
+	d, err := m.place(t, nil)
+	return d.Chosen, err
+}
+
+// place makes a placement decision for the task, leaving out the workers in
+// skip, and describes it as an audit entry, whether or not a worker was
+// found. In round-robin order the entry's candidates are every worker looked
+// at, up to the one chosen.
+func (m *Manager) place(t task.Task, skip map[string]bool) (AuditEntry, error) {
+	d := AuditEntry{TaskID: t.ID, Timestamp: m.now().UTC()}
+	if m.Scheduler != nil {
+		return d, m.scheduleWorker(t, &d, skip)
+	}
+
+	// Heartbeats change Workers while the workers are asked for their stats,
+	// so work on a copy.
+	m.mu.Lock()
+	workers := slices.Clone(m.Workers)
+	last := m.LastWorker
+	m.mu.Unlock()
+
+	if w, ok := m.previousWorker(t); ok && !skip[w] && slices.Contains(workers, w) {
+		d.Candidates = append(d.Candidates, w)
+		if !m.isCordoned(w) && m.hasCapacity(w, t) {
+			d.Chosen = w
+			d.Reason = "worker the sticky task last ran on, with spare capacity"
+			return d, nil
+		}
+	}
+	for i := 1; i <= len(workers); i++ {
+		next := (last + i) % len(workers)
+		w := workers[next]
+		// The sticky task's previous worker, if considered, was found wanting.
+		if skip[w] || slices.Contains(d.Candidates, w) {
+			continue
+		}
+		d.Candidates = append(d.Candidates, w)
+		if m.isCordoned(w) || !m.hasCapacity(w, t) {
+			continue
+		}
+
+		m.mu.Lock()
+		m.LastWorker = next
+		m.mu.Unlock()
+		d.Chosen = w
+		d.Reason = "next worker in round-robin order with spare capacity"
+		return d, nil
+	}
+	d.Reason = "no uncordoned worker has spare capacity"
+	return d, ErrNoWorkerAvailable
+}
+
+// scheduleWorker places the task with Scheduler, wrapped in scheduler.Prefer
+// so its preferred nodes win, and a sticky task's previous worker above them.
+// The bonuses are added to the scores Pick sees, not to any state the
+// scheduler keeps, so a weighted round-robin still charges the node it picks
+// as for any other task.
+func (m *Manager) scheduleWorker(t task.Task, d *AuditEntry, skip map[string]bool) error {
+	s := scheduler.Prefer{Scheduler: m.Scheduler}
+	var nodes []*node.Node
+	for _, n := range m.nodeCopies() {
+		if !skip[n.Name] && m.hasCapacity(n.Name, t) {
+			nodes = append(nodes, n)
+		}
+	}
+
+	candidates := s.SelectCandidateNodes(t, nodes)
+	for _, n := range candidates {
+		d.Candidates = append(d.Candidates, n.Name)
+	}
+	if len(candidates) == 0 {
+		d.Reason = "no worker with spare capacity is a candidate for the task"
+		return ErrNoWorkerAvailable
+	}
+	d.Scores = s.Score(t, candidates)
+	previous, sticky := m.previousWorker(t)
+	if sticky {
+		scheduler.Boost(d.Scores, previous)
+	}
+	picked := s.Pick(d.Scores, candidates)
+	if picked == nil {
+		d.Reason = "scheduler picked none of the candidates"
+		return ErrNoWorkerAvailable
+	}
+	d.Chosen = picked.Name
+	d.Reason = "picked by the scheduler from the scored candidates"
+	if sticky && picked.Name == previous {
+		d.Reason = "picked by the scheduler, favouring the sticky task's previous worker"
+	}
+	return nil
+}
+
+// nodeCopies returns a copy of each of WorkerNodes, refreshed, for the
+// scheduler to read while heartbeats and other placements go on.
+func (m *Manager) nodeCopies() []*node.Node {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodes := make([]*node.Node, 0, len(m.WorkerNodes))
+	for _, n := range m.WorkerNodes {
+		c := *n
+		m.refreshNode(&c)
+		nodes = append(nodes, &c)
+	}
+	return nodes
+}
+
+// refreshNode records on the node whether its worker is cordoned, and the
+// names of the unfinished tasks assigned to it and the CPU, memory, disk and
+// GPUs they claim. The caller must hold m.mu.
+func (m *Manager) refreshNode(n *node.Node) {
+	n.Cordoned = m.cordoned[n.Name]
+	n.Tasks = nil
+	n.CpuAllocated = 0
+	n.MemoryAllocated = 0
+	n.DiskAllocated = 0
+	n.GPUsAllocated = 0
+	for _, id := range m.WorkerTaskMap[n.Name] {
+		t := m.task(taskKey(id))
+		if t == nil || t.State.Terminal() {
+			continue
+		}
+		n.Tasks = append(n.Tasks, t.Name)
+		n.CpuAllocated += t.Cpu
+		n.MemoryAllocated += t.MemoryClaim()
+		n.DiskAllocated += t.Disk
+		n.GPUsAllocated += t.GPUs
+	}
 }
 
-// SelectWorker chooses an appropriate worker from the available pool
-// based on current workload and capacity
-func (m *Manager) SelectWorker() {
-	fmt.Println("I will select an appropriate worker")
+// hasCapacity reports whether the worker is reachable and can take t: it
+// must have a free slot, enough free GPUs for the task's GPUs and, when it
+// reports its free disk, room for the task's Disk.
+func (m *Manager) hasCapacity(w string, t task.Task) bool {
+	if t.GPUs > 0 {
+		if free := m.freeGPUs(w); free < t.GPUs {
+			logging.Debugf("Worker %s has %d GPUs free, task %v needs %d", w, free, t.ID, t.GPUs)
+			return false
+		}
+	}
+	stats, err := m.workerStats(w)
+	if err != nil {
+		logging.Errorf("Error getting stats from worker %s: %v", w, err)
+		return false
+	}
+	if stats.MaxConcurrent > 0 && stats.Running+stats.Queued >= stats.MaxConcurrent {
+		logging.Debugf("Worker %s is at capacity (%d/%d)", w, stats.Running+stats.Queued, stats.MaxConcurrent)
+		return false
+	}
+	if stats.DiskFree != nil && t.Disk > *stats.DiskFree {
+		logging.Debugf("Worker %s has %d MB of disk free, task %v needs %d MB", w, *stats.DiskFree, t.ID, t.Disk)
+		return false
+	}
+	return true
 }
 
-// UpdateTasks maintains the current state of all tasks in the system.
+// freeGPUs returns how many of the worker's GPUs, as its node in WorkerNodes
+// describes them, the unfinished tasks assigned to it leave unclaimed. A
+// worker without a node has none.
+func (m *Manager) freeGPUs(w string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.WorkerNodes, func(n *node.Node) bool { return n.Name == w })
+	if i < 0 {
+		return 0
+	}
+	free := m.WorkerNodes[i].GPUs
+	for _, id := range m.WorkerTaskMap[w] {
+		if t := m.task(taskKey(id)); t != nil && !t.State.Terminal() {
+			free -= t.GPUs
+		}
+	}
+	return max(free, 0)
+}
+
+// GetTasks returns every task the manager knows about, in task.Compare
+// order.
+func (m *Manager) GetTasks() []*task.Task {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tasks := make([]*task.Task, 0, len(m.TaskDb))
+	for key := range m.TaskDb {
+		taskCopy := *m.task(key)
+		tasks = append(tasks, &taskCopy)
+	}
+	slices.SortFunc(tasks, task.Compare)
+	return tasks
+}
+
+// GetTask returns a copy of the task with the given ID.
+func (m *Manager) GetTask(id uuid.UUID) (*task.Task, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t := m.task(taskKey(id))
+	if t == nil {
+		return nil, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	taskCopy := *t
+	return &taskCopy, nil
+}
+
+// TaskResult returns the result a task with CaptureResult reported once it
+// completed, or "" if it reported none. It fails with an error matching
+// cubeerrors.ErrInvalidState while the task has yet to finish.
+func (m *Manager) TaskResult(id uuid.UUID) (string, error) {
+	t, err := m.GetTask(id)
+	if err != nil {
+		return "", err
+	}
+	if !t.State.Terminal() {
+		return "", cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "task %v is %v and has no result yet", id, t.State)
+	}
+	return t.Result, nil
+}
+
+// UpdateTasks polls every worker for the tasks it runs and records their
+// current state, timestamps and container ID, and when each worker was last
+// reachable. A task found in a new state gets an event in its history.
+// Workers are polled PollConcurrency at a time, and the round ends after
+// PollTimeout, so a slow worker delays neither the others nor the next
+// round.
 func (m *Manager) UpdateTasks() {
-	fmt.Println("I keep track of tasks, their states and the machines they run on")
+	timeout := m.PollTimeout
+	if timeout == 0 {
+		timeout = DefaultPollTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	limit := m.PollConcurrency
+	if limit <= 0 {
+		limit = DefaultPollConcurrency
+	}
+	m.mu.Lock()
+	workers := slices.Clone(m.Workers)
+	m.mu.Unlock()
+
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				logging.Errorf("Error getting tasks from worker %s: %v", w, ctx.Err())
+				return
+			}
+			defer func() { <-sem }()
+
+			tasks, err := m.workerTasks(ctx, w)
+			m.markSeen(w, err)
+			if err != nil {
+				logging.Errorf("Error getting tasks from worker %s: %v", w, err)
+				return
+			}
+			m.applyReports(w, tasks)
+		}()
+	}
+	wg.Wait()
+}
+
+// applyReports updates the manager's copies of the tasks worker w reported
+// on, ignoring reports it has since moved on from.
+func (m *Manager) applyReports(w string, tasks []*task.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, wt := range tasks {
+		t := m.task(taskKey(wt.ID))
+		if t == nil {
+			logging.Warnf("Task %v reported by worker %s not found", wt.ID, w)
+			continue
+		}
+		if wt.RestartCount < t.RestartCount {
+			// A report from before the task was restarted
+			continue
+		}
+		if owner, ok := m.TaskWorkerMap[wt.ID]; ok && owner != w {
+			// A report from a worker the task has moved away from
+			continue
+		}
+		changed := t.State != wt.State
+		if changed {
+			t.UpdatedAt = m.now().UTC()
+		}
+		t.State = wt.State
+		t.StartTime = wt.StartTime
+		t.FinishTime = wt.FinishTime
+		t.ContainerID = wt.ContainerID
+		t.ExitCode = wt.ExitCode
+		t.Discrepancies = wt.Discrepancies
+		t.Health = wt.Health
+		t.Result = wt.Result
+		t.Reason = wt.Reason
+		t.FailureReason = wt.FailureReason
+		if t.TraceID == "" {
+			t.TraceID = wt.TraceID
+		}
+		if changed && t.State.Terminal() {
+			m.account(t)
+		}
+		if changed && m.lastState(wt.ID.String()) != t.State {
+			m.appendEvent(&task.TaskEvent{
+				ID:        uuid.New(),
+				State:     t.State,
+				Timestamp: t.UpdatedAt,
+				Task:      *t,
+				Reason:    t.Reason,
+			})
+		}
+		if changed && m.restartsLater(*t) {
+			m.restartLater(*t)
+		}
+	}
 }
 
-// SendWork dispatches a task to its assigned worker for execution.
+// ProcessTasks sends pending work to workers every interval, or every
+// Config.ScheduleInterval once a config sets one, until ctx is cancelled or
+// the manager shuts down.
+func (m *Manager) ProcessTasks(ctx context.Context, interval time.Duration) {
+	m.loop(ctx, m.interval(func(c Config) time.Duration { return c.ScheduleInterval }, interval), m.SendWork)
+}
+
+// RunUpdates expires registered workers that have stopped sending
+// heartbeats and polls the rest for task state every interval, or every
+// Config.UpdateInterval once a config sets one, until ctx is cancelled or the
+// manager shuts down.
+func (m *Manager) RunUpdates(ctx context.Context, interval time.Duration) {
+	m.loop(ctx, m.interval(func(c Config) time.Duration { return c.UpdateInterval }, interval), func() {
+		m.ExpireNodes()
+		m.UpdateTasks()
+	})
+}
+
+// loop calls fn at once and then every interval, switching to the new
+// interval as soon as a config changes it.
+func (m *Manager) loop(ctx context.Context, interval func() time.Duration, fn func()) {
+	current := interval()
+	ticker := time.NewTicker(current)
+	defer ticker.Stop()
+
+	fn()
+	for {
+		changed := m.configChanged()
+		if d := interval(); d != current {
+			current = d
+			ticker.Reset(d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.done():
+			return
+		case <-changed:
+		case <-ticker.C:
+			fn()
+		}
+	}
+}
+
+// Shutdown stops the manager's loops, waits for dispatches already under way
+// to finish and persists the tasks, events, pending queue and worker
+// assignments to the store.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.stopOnce.Do(func() { close(m.stoppedChan()) })
+	m.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for work in flight: %w", ctx.Err())
+	}
+
+	if m.Store == nil {
+		return nil
+	}
+	return m.persist()
+}
+
+// Restore loads the state persisted by Shutdown into the manager.
+func (m *Manager) Restore() error {
+	if m.Store == nil {
+		return nil
+	}
+
+	var (
+		tasks       map[string][]*task.Task
+		events      map[string][]*task.TaskEvent
+		pending     []task.TaskEvent
+		assignments map[string][]uuid.UUID
+		jobs        map[uuid.UUID]*JobRecord
+	)
+	for key, v := range map[string]any{
+		tasksKey:       &tasks,
+		eventsKey:      &events,
+		pendingKey:     &pending,
+		assignmentsKey: &assignments,
+		jobsKey:        &jobs,
+	} {
+		if err := m.Store.Get(key, v); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("restoring %s: %w", key, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	maps.Copy(m.TaskDb, tasks)
+	maps.Copy(m.EventDb, events)
+	for _, te := range pending {
+		m.Pending.Enqueue(te)
+	}
+	for w, ids := range assignments {
+		for _, id := range ids {
+			m.assign(id, w)
+		}
+	}
+	if len(jobs) > 0 {
+		if m.jobs == nil {
+			m.jobs = make(map[uuid.UUID]*JobRecord, len(jobs))
+		}
+		maps.Copy(m.jobs, jobs)
+	}
+	return nil
+}
+
+func (m *Manager) persist() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, v := range map[string]any{
+		tasksKey:       m.TaskDb,
+		eventsKey:      m.EventDb,
+		pendingKey:     m.pendingEvents(),
+		assignmentsKey: m.WorkerTaskMap,
+		jobsKey:        m.jobs,
+	} {
+		if err := m.Store.Put(key, v); err != nil {
+			return fmt.Errorf("persisting %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// nextDue returns the first pending task whose ScheduledAt has passed,
+// leaving it in the queue. The caller must hold m.mu.
+func (m *Manager) nextDue() (task.TaskEvent, bool) {
+	now := m.now()
+	return queues.Find(&m.Pending, func(te task.TaskEvent) bool {
+		return !te.Task.ScheduledAt.After(now)
+	})
+}
+
+// take removes the pending task event with the given ID from the queue, and
+// reports whether it was still there.
+func (m *Manager) take(id uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := queues.Remove(&m.Pending, func(te task.TaskEvent) bool {
+		return te.ID == id
+	})
+	return ok
+}
+
+// now returns the current time according to the manager's clock.
+func (m *Manager) now() time.Time {
+	if m.Clock == nil {
+		return time.Now()
+	}
+	return m.Clock.Now()
+}
+
+// after sends the time on the returned channel once d has passed on the
+// manager's clock.
+func (m *Manager) after(d time.Duration) <-chan time.Time {
+	if m.Clock == nil {
+		return time.After(d)
+	}
+	return m.Clock.After(d)
+}
+
+// pendingEvents returns the pending queue's events in order, leaving the
+// queue as it was. The caller must hold m.mu.
+func (m *Manager) pendingEvents() []task.TaskEvent {
+	return queues.Items[task.TaskEvent](&m.Pending)
+}
+
+// done returns the channel closed when the manager shuts down.
+func (m *Manager) done() chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.stoppedChan()
+}
+
+// isStopped reports whether Shutdown has been called. The caller must hold m.mu.
+func (m *Manager) isStopped() bool {
+	select {
+	case <-m.stoppedChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// stoppedChan returns the channel closed on shutdown, creating it on first
+// use. The caller must hold m.mu.
+func (m *Manager) stoppedChan() chan struct{} {
+	if m.stopped == nil {
+		m.stopped = make(chan struct{})
+	}
+	return m.stopped
+}
+
+// SendWork dispatches the first pending task that is due to a worker with
+// spare capacity. The task leaves the queue only once a worker has been
+// chosen for it; when no worker can take it, it stays pending and moves to
+// the back of the queue.
 func (m *Manager) SendWork() {
-	fmt.Println("I send the task to the worker")
+	m.mu.Lock()
+	if m.isStopped() {
+		m.mu.Unlock()
+		logging.Debugf("Manager is shutting down, not sending work")
+		return
+	}
+	if m.Pending.Len() == 0 {
+		m.mu.Unlock()
+		logging.Debugf("No work in the queue")
+		return
+	}
+	te, ok := m.nextDue()
+	if !ok {
+		m.mu.Unlock()
+		logging.Debugf("No work in the queue is due")
+		return
+	}
+	m.inflight.Add(1)
+	defer m.inflight.Done()
+	m.mu.Unlock()
+
+	d, err := m.placeChecked(te.Task)
+	m.recordDecision(d)
+	w := d.Chosen
+	if err != nil {
+		logging.Infof("Unable to schedule task %v: %v", te.Task.ID, err)
+		// Let the tasks behind it have a turn
+		if m.take(te.ID) {
+			m.requeue(te)
+		}
+		return
+	}
+	if !m.take(te.ID) {
+		logging.Infof("Task %v left the queue while it was being placed", te.Task.ID)
+		return
+	}
+
+	if err := m.dispatch(te, w); err != nil {
+		logging.Errorf("[trace %s] Error sending task %v to worker %s: %v", te.Task.TraceID, te.Task.ID, w, err)
+		if errors.Is(err, cubeerrors.ErrWorkerUnavailable) {
+			m.requeue(te)
+			return
+		}
+		m.failDispatch(te.Task.ID, err)
+	}
+}
+
+// dispatch sends the pending task in te to worker w and, once the worker has
+// taken it, records it as scheduled there. It fails as postTask does.
+func (m *Manager) dispatch(te task.TaskEvent, w string) error {
+	te.State = task.Scheduled
+	te.Task.State = task.Scheduled
+	te.Timestamp = m.now().UTC()
+	te.Task.UpdatedAt = te.Timestamp
+
+	if err := m.postTask(w, te); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := taskKey(te.Task.ID)
+	if t := m.task(key); t != nil {
+		t.State = task.Scheduled
+		t.UpdatedAt = te.Timestamp
+	}
+	m.appendEvent(&te)
+	m.assign(te.Task.ID, w)
+	return nil
+}
+
+// failDispatch records as Failed a task that could not be handed to a
+// worker for a reason other than the worker being unreachable, such as the
+// worker turning it down, which trying again would not change.
+func (m *Manager) failDispatch(id uuid.UUID, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := taskKey(id)
+	current := m.task(key)
+	if current == nil {
+		return
+	}
+	t := *current
+	t.State = task.Failed
+	t.FailureReason = task.FailureRejected
+	t.Reason = err.Error()
+	t.FinishTime = m.now().UTC()
+	t.UpdatedAt = t.FinishTime
+	m.TaskDb[key] = append(m.TaskDb[key], &t)
+	m.appendEvent(&task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Failed,
+		Timestamp: t.UpdatedAt,
+		Task:      t,
+		Reason:    t.Reason,
+	})
+}
+
+// postTask sends a task event to a worker to run. It returns an error
+// matching cubeerrors.ErrWorkerUnavailable when the worker cannot be reached,
+// and one describing the worker's answer when it turns the task down.
+func (m *Manager) postTask(w string, te task.TaskEvent) error {
+	secrets, err := m.taskSecrets(te.Task)
+	if err != nil {
+		return err
+	}
+	te.Secrets = secrets
+	data, err := json.Marshal(te)
+	if err != nil {
+		return fmt.Errorf("marshalling task event %v: %w", te.ID, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s/tasks", w), bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(trace.Header, te.Task.TraceID)
+
+	logging.Infof("[trace %s] Sending task %v to worker %s", te.Task.TraceID, te.Task.ID, w)
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return cubeerrors.Wrap(cubeerrors.ErrWorkerUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		e := cubeerrors.Envelope{}
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return fmt.Errorf("decoding response from worker %s: %w", w, err)
+		}
+		return fmt.Errorf("worker %s rejected task %v (%d %s): %s", w, te.Task.ID, resp.StatusCode, e.Error.Code, e.Error.Message)
+	}
+	return nil
+}
+
+// taskSecrets looks up the values of the secrets the task refers to.
+func (m *Manager) taskSecrets(t task.Task) (task.SecretMap, error) {
+	if len(t.Secrets) == 0 {
+		return nil, nil
+	}
+	if m.Secrets == nil {
+		return nil, fmt.Errorf("%w: task %v has secrets but the manager holds none", task.ErrSecretNotFound, t.ID)
+	}
+	secrets := make(task.SecretMap)
+	for _, ref := range t.Secrets {
+		value, err := m.Secrets.Secret(context.Background(), ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("fetching secret %s of task %v: %w", ref.Name, t.ID, err)
+		}
+		secrets[ref.Name] = value
+	}
+	return secrets, nil
+}
+
+// StopTask asks the worker running a task to cancel it, and records the
+// reason, naming who or what stopped it, in the task's event history.
+func (m *Manager) StopTask(id uuid.UUID, reason string) error {
+	m.mu.Lock()
+	w, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v is not assigned to a worker", ErrTaskNotFound, id)
+	}
+
+	if err := m.stopOn(w, id, reason); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t := m.task(taskKey(id)); t != nil {
+		m.appendEvent(&task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Cancelled,
+			Timestamp: m.now().UTC(),
+			Task:      *t,
+			Reason:    reason,
+		})
+	}
+	return nil
+}
+
+// stopOn asks worker w to cancel the task, giving the reason.
+func (m *Manager) stopOn(w string, id uuid.UUID, reason string) error {
+	u := fmt.Sprintf("http://%s/tasks/%s?reason=%s", w, id, url.QueryEscape(reason))
+	req, err := http.NewRequest(http.MethodDelete, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("connecting to worker %s: %w", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("worker %s failed to stop task %v: status %d", w, id, resp.StatusCode)
+	}
+	return nil
+}
+
+// TaskStats asks the worker running a task for its container's resource usage.
+func (m *Manager) TaskStats(id uuid.UUID) (task.ContainerStats, error) {
+	stats := task.ContainerStats{}
+
+	m.mu.Lock()
+	w, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		return stats, fmt.Errorf("%w: %v is not assigned to a worker", ErrTaskNotFound, id)
+	}
+
+	resp, err := m.client().Get(fmt.Sprintf("http://%s/tasks/%s/stats", w, id))
+	if err != nil {
+		return stats, fmt.Errorf("connecting to worker %s: %w", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("worker %s failed to report stats for task %v: status %d", w, id, resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+// TaskDiff asks the worker running a task how its container differs from
+// the task's config.
+func (m *Manager) TaskDiff(id uuid.UUID) ([]task.FieldDiff, error) {
+	m.mu.Lock()
+	w, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %v is not assigned to a worker", ErrTaskNotFound, id)
+	}
+
+	resp, err := m.client().Get(fmt.Sprintf("http://%s/tasks/%s/diff", w, id))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to worker %s: %w", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("worker %s failed to compare task %v: status %d", w, id, resp.StatusCode)
+	}
+	var diffs []task.FieldDiff
+	err = json.NewDecoder(resp.Body).Decode(&diffs)
+	return diffs, err
+}
+
+// setReplicas records the replica count on every task with the given name.
+func (m *Manager) setReplicas(name string, replicas int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.TaskDb {
+		if t := m.task(key); t.Name == name && t.Replicas > 0 {
+			t.Replicas = replicas
+		}
+	}
+}
+
+func (m *Manager) workerTasks(ctx context.Context, w string) ([]*task.Task, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/tasks", w), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var tasks []*task.Task
+	err = json.NewDecoder(resp.Body).Decode(&tasks)
+	return tasks, err
+}
+
+func (m *Manager) workerTask(w string, id uuid.UUID) (task.Task, error) {
+	t := task.Task{}
+
+	resp, err := m.client().Get(fmt.Sprintf("http://%s/tasks/%s", w, id))
+	if err != nil {
+		return t, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return t, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&t)
+	return t, err
+}
+
+func (m *Manager) workerStats(w string) (worker.Stats, error) {
+	stats := worker.Stats{}
+
+	resp, err := m.client().Get(fmt.Sprintf("http://%s/stats", w))
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	return stats, err
+}
+
+// retryConfig is what the retrying client is built from.
+type retryConfig struct {
+	client *http.Client
+	policy RetryPolicy
+}
+
+// client returns the HTTP client for calls to workers, retrying them as
+// Retry says.
+func (m *Manager) client() *http.Client {
+	m.clientMu.Lock()
+	defer m.clientMu.Unlock()
+
+	cfg := retryConfig{client: m.Client, policy: m.Retry}
+	if m.retrying != nil && cfg == m.retryingFor {
+		return m.retrying
+	}
+	base := http.DefaultClient
+	if cfg.client != nil {
+		base = cfg.client
+	}
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c := *base
+	c.Transport = &retryTransport{base: transport, policy: cfg.policy, now: m.now, after: m.after, breakers: &m.breakers}
+	m.retrying = &c
+	m.retryingFor = cfg
+	return m.retrying
 }
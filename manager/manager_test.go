@@ -0,0 +1,958 @@
+package manager_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWorker serves the parts of the worker API the manager relies on.
+func fakeWorker(t *testing.T, stats worker.Stats, received *int) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(stats)
+	})
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]task.Task{})
+	})
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		*received++
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("DELETE /tasks/{taskID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /tasks/{taskID}/prune", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// stubWorker is a worker served over HTTP whose tasks never reach Docker. A
+// test takes the task the manager sent off its queue with next, and sets
+// what the worker reports for it with report.
+type stubWorker struct {
+	*worker.Worker
+	addr string
+}
+
+func newStubWorker(t *testing.T, clk clock.Clock) *stubWorker {
+	t.Helper()
+
+	wk := &worker.Worker{Name: "stub", Queue: *queue.New(), Db: make(map[uuid.UUID]*task.Task), Clock: clk}
+	srv := httptest.NewServer((&worker.Api{Worker: wk}).Handler())
+	t.Cleanup(srv.Close)
+	return &stubWorker{Worker: wk, addr: strings.TrimPrefix(srv.URL, "http://")}
+}
+
+// next takes the next task off the worker's queue.
+func (s *stubWorker) next(t *testing.T) task.Task {
+	t.Helper()
+
+	if s.Queue.Len() == 0 {
+		t.Fatal("worker queue is empty, want a task from the manager")
+	}
+	return s.Queue.Dequeue().(task.Task)
+}
+
+// report makes the worker report tk as it stands when next polled.
+func (s *stubWorker) report(tk task.Task) {
+	s.Db[tk.ID] = &tk
+}
+
+func newManager(workers ...string) *manager.Manager {
+	return &manager.Manager{
+		TaskDb:        make(map[string][]*task.Task),
+		EventDb:       make(map[string][]*task.TaskEvent),
+		Workers:       workers,
+		WorkerTaskMap: make(map[string][]uuid.UUID),
+		TaskWorkerMap: make(map[uuid.UUID]string),
+	}
+}
+
+func pendingEvent(name string) task.TaskEvent {
+	return task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: time.Now(),
+		Task:      task.Task{ID: uuid.New(), Name: name, State: task.Pending},
+	}
+}
+
+func TestManager_SendWorkRespectsWorkerCapacity(t *testing.T) {
+	var fullReceived, freeReceived int
+	full := fakeWorker(t, worker.Stats{Running: 2, MaxConcurrent: 2}, &fullReceived)
+	free := fakeWorker(t, worker.Stats{Running: 1, MaxConcurrent: 2}, &freeReceived)
+
+	fullAddr := strings.TrimPrefix(full.URL, "http://")
+	freeAddr := strings.TrimPrefix(free.URL, "http://")
+	m := newManager(fullAddr, freeAddr)
+
+	te := pendingEvent("task-1")
+	m.AddTask(te)
+	m.SendWork()
+
+	if fullReceived != 0 {
+		t.Errorf("worker at capacity received %d tasks", fullReceived)
+	}
+	if freeReceived != 1 {
+		t.Errorf("worker with spare capacity received %d tasks, want 1", freeReceived)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != freeAddr {
+		t.Errorf("task assigned to %q, want %q", got, freeAddr)
+	}
+}
+
+func TestManager_SendWorkSkipsWorkerLowOnDisk(t *testing.T) {
+	var fullReceived, lowReceived, roomyReceived int
+	fullFree, lowFree, roomyFree := 0, 100, 10000
+	full := fakeWorker(t, worker.Stats{DiskFree: &fullFree}, &fullReceived)
+	low := fakeWorker(t, worker.Stats{DiskFree: &lowFree}, &lowReceived)
+	roomy := fakeWorker(t, worker.Stats{DiskFree: &roomyFree}, &roomyReceived)
+	m := newManager(strings.TrimPrefix(full.URL, "http://"), strings.TrimPrefix(low.URL, "http://"), strings.TrimPrefix(roomy.URL, "http://"))
+
+	te := pendingEvent("task-1")
+	te.Task.Disk = 500
+	m.AddTask(te)
+	m.SendWork()
+
+	if fullReceived != 0 || lowReceived != 0 || roomyReceived != 1 {
+		t.Errorf("full, low and roomy disk workers received %d, %d and %d tasks, want 0, 0 and 1", fullReceived, lowReceived, roomyReceived)
+	}
+}
+
+func TestManager_SendWorkKeepsTaskPendingWhenWorkersFull(t *testing.T) {
+	var received int
+	full := fakeWorker(t, worker.Stats{Running: 1, Queued: 1, MaxConcurrent: 2}, &received)
+	m := newManager(strings.TrimPrefix(full.URL, "http://"))
+
+	m.AddTask(pendingEvent("task-1"))
+	m.SendWork()
+
+	if received != 0 {
+		t.Errorf("worker at capacity received %d tasks", received)
+	}
+	if m.Pending.Len() != 1 {
+		t.Errorf("pending = %d, want 1", m.Pending.Len())
+	}
+}
+
+func TestManager_ShutdownPersistsInFlightState(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.Stats{})
+	})
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	s := store.NewInMemoryStore()
+	m := newManager(addr)
+	m.Store = s
+
+	dispatched, waiting := pendingEvent("dispatched"), pendingEvent("waiting")
+	m.AddTask(dispatched)
+	m.AddTask(waiting)
+
+	go m.SendWork()
+	<-received
+
+	shutdown := make(chan error)
+	go func() { shutdown <- m.Shutdown(context.Background()) }()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() returned before the dispatch finished: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	// No more work is sent once the manager has shut down.
+	m.SendWork()
+	if m.Pending.Len() != 1 {
+		t.Errorf("pending = %d after shutdown, want 1", m.Pending.Len())
+	}
+
+	restored := newManager(addr)
+	restored.Store = s
+	if err := restored.Restore(); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if got := restored.TaskWorkerMap[dispatched.Task.ID]; got != addr {
+		t.Errorf("dispatched task assigned to %q, want %q", got, addr)
+	}
+	got, err := restored.GetTask(dispatched.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Scheduled {
+		t.Errorf("dispatched task state = %v, want %v", got.State, task.Scheduled)
+	}
+	if restored.Pending.Len() != 1 || restored.Pending.Peek().(task.TaskEvent).Task.ID != waiting.Task.ID {
+		t.Errorf("restored pending queue does not hold the waiting task")
+	}
+	if n := len(restored.EventDb[dispatched.Task.ID.String()]); n != 2 {
+		t.Errorf("dispatched task has %d events, want 2", n)
+	}
+}
+
+func TestManager_SendWorkUsesScheduler(t *testing.T) {
+	var smallReceived, largeReceived int
+	small := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &smallReceived).URL, "http://")
+	large := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &largeReceived).URL, "http://")
+
+	m := newManager(small, large)
+	m.Scheduler = &scheduler.WeightedRoundRobin{}
+	m.WorkerNodes = []*node.Node{
+		{Name: small, Cores: 1, Memory: 1024},
+		{Name: large, Cores: 3, Memory: 3072},
+	}
+
+	for range 4 {
+		m.AddTask(pendingEvent("job"))
+		m.SendWork()
+	}
+
+	if smallReceived != 1 || largeReceived != 3 {
+		t.Errorf("small worker received %d tasks and large %d, want 1 and 3", smallReceived, largeReceived)
+	}
+}
+
+func TestManager_SendWorkRecordsPlacementDecisions(t *testing.T) {
+	var received int
+	open := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 1}, &received).URL, "http://")
+	full := strings.TrimPrefix(fakeWorker(t, worker.Stats{Running: 1, MaxConcurrent: 1}, &received).URL, "http://")
+
+	placed := pendingEvent("placed")
+	m := newManager(open)
+	m.AddTask(placed)
+	m.SendWork()
+
+	waiting := pendingEvent("waiting")
+	m.Workers = []string{full}
+	m.AddTask(waiting)
+	m.SendWork()
+
+	api := &manager.Api{Manager: m}
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/audit?task="+placed.Task.ID.String(), nil))
+	var entries []manager.AuditEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatalf("decoding audit log: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("placed task has %d audit entries, want 1", len(entries))
+	}
+	if e := entries[0]; e.Chosen != open || e.TaskID != placed.Task.ID || e.Reason == "" || e.Timestamp.IsZero() {
+		t.Errorf("audit entry = %+v, want task %v placed on %s with a reason", e, placed.Task.ID, open)
+	}
+
+	all, err := m.AuditLog("")
+	if err != nil {
+		t.Fatalf("AuditLog() error = %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(all))
+	}
+	if e := all[1]; e.TaskID != waiting.Task.ID || e.Chosen != "" || e.Reason == "" {
+		t.Errorf("audit entry = %+v, want unplaced task %v with a reason", e, waiting.Task.ID)
+	}
+}
+
+func TestManager_AuditEntriesListWorkersConsidered(t *testing.T) {
+	var received int
+	open := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 1}, &received).URL, "http://")
+	full := strings.TrimPrefix(fakeWorker(t, worker.Stats{Running: 1, MaxConcurrent: 1}, &received).URL, "http://")
+
+	// Round-robin order starts after LastWorker, so full is looked at first.
+	placed := pendingEvent("placed")
+	m := newManager(open, full)
+	m.AddTask(placed)
+	m.SendWork()
+
+	entries, err := m.AuditLog(placed.Task.ID.String())
+	if err != nil {
+		t.Fatalf("AuditLog() error = %v", err)
+	}
+	if len(entries) != 1 || !slices.Equal(entries[0].Candidates, []string{full, open}) || entries[0].Chosen != open {
+		t.Fatalf("audit log = %+v, want one entry considering %s then %s", entries, full, open)
+	}
+
+	waiting := pendingEvent("waiting")
+	m.Workers = []string{full}
+	m.MaxAuditEntries = 2
+	m.AddTask(waiting)
+	for range 3 {
+		m.SendWork()
+	}
+	entries, err = m.AuditLog(waiting.Task.ID.String())
+	if err != nil {
+		t.Fatalf("AuditLog() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("waiting task has %d audit entries, want the last 2", len(entries))
+	}
+}
+
+func TestManager_SendWorkSpreadsReplicas(t *testing.T) {
+	var smallReceived, largeReceived int
+	small := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &smallReceived).URL, "http://")
+	large := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &largeReceived).URL, "http://")
+
+	m := newManager(small, large)
+	m.Scheduler = scheduler.Spread{Scheduler: &scheduler.WeightedRoundRobin{}}
+	m.WorkerNodes = []*node.Node{
+		{Name: small, Cores: 1, Memory: 1024},
+		{Name: large, Cores: 8, Memory: 8192},
+	}
+
+	for range 2 {
+		te := pendingEvent("web")
+		te.Task.Replicas = 2
+		m.AddTask(te)
+		m.SendWork()
+	}
+
+	if smallReceived != 1 || largeReceived != 1 {
+		t.Errorf("small worker received %d replicas and large %d, want 1 each", smallReceived, largeReceived)
+	}
+}
+
+func TestManager_SendWorkHonoursPreferredNodes(t *testing.T) {
+	var smallReceived, largeReceived int
+	small := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &smallReceived).URL, "http://")
+	large := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &largeReceived).URL, "http://")
+
+	m := newManager(small, large)
+	m.Scheduler = &scheduler.WeightedRoundRobin{}
+	m.WorkerNodes = []*node.Node{
+		{Name: small, Cores: 1, Memory: 1024},
+		{Name: large, Cores: 3, Memory: 3072},
+	}
+
+	preferred := pendingEvent("cache")
+	preferred.Task.PreferredNodes = []string{small}
+	m.AddTask(preferred)
+	m.SendWork()
+	if got := m.TaskWorkerMap[preferred.Task.ID]; got != small {
+		t.Fatalf("preferred task placed on %q, want %q", got, small)
+	}
+
+	// The small node paid for the preferred task, so the next tasks go to
+	// the large one and the split stays in proportion to capacity.
+	for range 3 {
+		m.AddTask(pendingEvent("job"))
+		m.SendWork()
+	}
+	if smallReceived != 1 || largeReceived != 3 {
+		t.Errorf("small worker received %d tasks and large %d, want 1 and 3", smallReceived, largeReceived)
+	}
+}
+
+func TestManager_SendWorkWaitsForScheduledAt(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	m := newManager(w)
+	m.Clock = c
+
+	te := pendingEvent("nightly")
+	te.Task.ScheduledAt = now.Add(time.Hour)
+	m.AddTask(te)
+
+	m.SendWork()
+	if received != 0 {
+		t.Fatalf("worker received %d tasks before the task was due", received)
+	}
+	if m.Pending.Len() != 1 {
+		t.Fatalf("pending = %d, want 1", m.Pending.Len())
+	}
+
+	c.Advance(time.Hour)
+	m.SendWork()
+	if received != 1 {
+		t.Errorf("worker received %d tasks once the task was due, want 1", received)
+	}
+	if m.Pending.Len() != 0 {
+		t.Errorf("pending = %d, want 0", m.Pending.Len())
+	}
+}
+
+func TestManager_SendWorkTimestampsEventWithClock(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	m := newManager(w)
+	m.Clock = clock.NewFake(now)
+
+	te := pendingEvent("job")
+	m.AddTask(te)
+	m.SendWork()
+
+	events := m.EventDb[te.Task.ID.String()]
+	if len(events) != 2 {
+		t.Fatalf("task has %d events, want 2", len(events))
+	}
+	if got := events[1]; got.State != task.Scheduled || !got.Timestamp.Equal(now) {
+		t.Errorf("scheduling event = %v at %v, want %v at %v", got.State, got.Timestamp, task.Scheduled, now)
+	}
+}
+
+func TestManager_StopTaskRecordsReason(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	m := newManager(w)
+	te := pendingEvent("web")
+	m.AddTask(te)
+	m.SendWork()
+
+	if err := m.StopTask(te.Task.ID, task.ReasonCancelledByUser); err != nil {
+		t.Fatalf("StopTask() error = %v", err)
+	}
+
+	events := m.EventDb[te.Task.ID.String()]
+	last := events[len(events)-1]
+	if last.State != task.Cancelled || last.Reason != task.ReasonCancelledByUser {
+		t.Errorf("last event is %v (%q), want %v (%q)", last.State, last.Reason, task.Cancelled, task.ReasonCancelledByUser)
+	}
+}
+
+func TestManager_StateChangesBumpUpdatedAt(t *testing.T) {
+	wk := newStubWorker(t, nil)
+	created := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(created)
+	m := newManager(wk.addr)
+	m.Clock = c
+
+	te := pendingEvent("web")
+	m.AddTask(te)
+	c.Advance(time.Minute)
+	m.SendWork()
+
+	running := wk.next(t)
+	running.State = task.Running
+	wk.report(running)
+	c.Advance(time.Minute)
+	m.UpdateTasks()
+
+	// Reports that do not change the state leave UpdatedAt alone.
+	c.Advance(time.Minute)
+	m.UpdateTasks()
+
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if !got.CreatedAt.Equal(created) {
+		t.Errorf("created at %v, want %v", got.CreatedAt, created)
+	}
+	if want := created.Add(2 * time.Minute); got.State != task.Running || !got.UpdatedAt.Equal(want) {
+		t.Errorf("task %v updated at %v, want %v updated at %v", got.State, got.UpdatedAt, task.Running, want)
+	}
+}
+
+func TestManager_AddTaskAppliesDefaultProfile(t *testing.T) {
+	m := newManager()
+	m.DefaultProfile = manager.Profile{Cpu: 0.5, Memory: 256, Disk: 10, RestartPolicy: "on-failure"}
+
+	bare := pendingEvent("bare")
+	got := m.AddTask(bare)
+	if got.Cpu != 0.5 || got.Memory != 256 || got.Disk != 10 || got.RestartPolicy != "on-failure" {
+		t.Errorf("task without limits = cpu %v, memory %d, disk %d, restart %q; want the default profile",
+			got.Cpu, got.Memory, got.Disk, got.RestartPolicy)
+	}
+	if stored, err := m.GetTask(bare.Task.ID); err != nil || stored.Memory != 256 {
+		t.Errorf("stored task = %+v (%v), want the defaults recorded", stored, err)
+	}
+
+	sized := pendingEvent("sized")
+	sized.Task.Cpu = 2
+	sized.Task.Memory = 1024
+	sized.Task.RestartPolicy = "always"
+	got = m.AddTask(sized)
+	if got.Cpu != 2 || got.Memory != 1024 || got.Disk != 10 || got.RestartPolicy != "always" {
+		t.Errorf("task with limits = cpu %v, memory %d, disk %d, restart %q; want its own values kept",
+			got.Cpu, got.Memory, got.Disk, got.RestartPolicy)
+	}
+}
+
+func TestManager_MoveTask(t *testing.T) {
+	var firstReceived, secondReceived, fullReceived int
+	first := fakeWorker(t, worker.Stats{MaxConcurrent: 2}, &firstReceived)
+	second := fakeWorker(t, worker.Stats{MaxConcurrent: 2}, &secondReceived)
+	full := fakeWorker(t, worker.Stats{Running: 2, MaxConcurrent: 2}, &fullReceived)
+	firstAddr := strings.TrimPrefix(first.URL, "http://")
+	secondAddr := strings.TrimPrefix(second.URL, "http://")
+	fullAddr := strings.TrimPrefix(full.URL, "http://")
+
+	m := newManager(firstAddr)
+	te := pendingEvent("web")
+	m.AddTask(te)
+	m.SendWork()
+	m.Workers = append(m.Workers, secondAddr, fullAddr)
+
+	if err := m.MoveTask(te.Task.ID, fullAddr); !errors.Is(err, cubeerrors.ErrNoCapacity) {
+		t.Fatalf("MoveTask() to a full worker error = %v, want %v", err, cubeerrors.ErrNoCapacity)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != firstAddr {
+		t.Fatalf("after a failed move task is on %q, want %q", got, firstAddr)
+	}
+
+	if err := m.MoveTask(te.Task.ID, secondAddr); err != nil {
+		t.Fatalf("MoveTask() error = %v", err)
+	}
+	if firstReceived != 1 || secondReceived != 1 || fullReceived != 0 {
+		t.Errorf("workers received %d, %d and %d tasks, want 1, 1 and 0", firstReceived, secondReceived, fullReceived)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != secondAddr {
+		t.Errorf("task assigned to %q, want %q", got, secondAddr)
+	}
+	if ids := m.WorkerTaskMap[firstAddr]; slices.Contains(ids, te.Task.ID) {
+		t.Errorf("source worker still lists the task: %v", ids)
+	}
+	if ids := m.WorkerTaskMap[secondAddr]; !slices.Equal(ids, []uuid.UUID{te.Task.ID}) {
+		t.Errorf("target worker lists %v, want [%v]", ids, te.Task.ID)
+	}
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Scheduled {
+		t.Errorf("moved task is %v, want %v", got.State, task.Scheduled)
+	}
+}
+
+// recordingWorker serves the worker API the manager uses to move tasks,
+// reporting every task it is asked about as report does and appending each
+// call it receives, other than for stats, to calls.
+func recordingWorker(t *testing.T, name string, report task.Task, calls *[]string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.Stats{MaxConcurrent: 2})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		*calls = append(*calls, name+" "+r.Method+" "+r.URL.Path)
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			json.NewEncoder(w).Encode(report)
+		}
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestManager_MoveTaskRollingUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		report task.Task
+		moved  bool
+		want   []string
+	}{
+		{
+			name:   "healthy",
+			report: task.Task{State: task.Running, Health: "healthy", ContainerID: "new-container"},
+			moved:  true,
+			want:   []string{"source POST", "target POST", "target GET", "source DELETE"},
+		},
+		{
+			name:   "failed",
+			report: task.Task{State: task.Failed, Health: "unhealthy"},
+			want:   []string{"source POST", "target POST", "target GET", "target DELETE"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			source := recordingWorker(t, "source", task.Task{State: task.Running}, &calls)
+			target := recordingWorker(t, "target", tt.report, &calls)
+			sourceAddr := strings.TrimPrefix(source.URL, "http://")
+			targetAddr := strings.TrimPrefix(target.URL, "http://")
+
+			m := newManager(sourceAddr)
+			te := pendingEvent("web")
+			te.Task.RollingUpdate = true
+			m.AddTask(te)
+			m.SendWork()
+			m.Workers = append(m.Workers, targetAddr)
+
+			err := m.MoveTask(te.Task.ID, targetAddr)
+			if tt.moved && err != nil {
+				t.Fatalf("MoveTask() error = %v", err)
+			}
+			if !tt.moved && err == nil {
+				t.Fatal("MoveTask() succeeded with a container that never became healthy")
+			}
+
+			var got []string
+			for _, call := range calls {
+				name, method, _ := strings.Cut(call, " ")
+				method, _, _ = strings.Cut(method, " ")
+				got = append(got, name+" "+method)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("calls = %v, want %v", got, tt.want)
+			}
+
+			wantWorker := sourceAddr
+			if tt.moved {
+				wantWorker = targetAddr
+			}
+			if w := m.TaskWorkerMap[te.Task.ID]; w != wantWorker {
+				t.Errorf("task assigned to %q, want %q", w, wantWorker)
+			}
+			if ids := m.WorkerTaskMap[wantWorker]; !slices.Equal(ids, []uuid.UUID{te.Task.ID}) {
+				t.Errorf("worker %s lists %v, want [%v]", wantWorker, ids, te.Task.ID)
+			}
+		})
+	}
+}
+
+func TestManager_TaskMapsStayConsistent(t *testing.T) {
+	var firstReceived, secondReceived int
+	first := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &firstReceived).URL, "http://")
+	second := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &secondReceived).URL, "http://")
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	m := newManager(first)
+	m.Clock = clock.NewFake(now)
+	m.Retention = time.Hour
+
+	web, batch := pendingEvent("web"), pendingEvent("batch")
+	m.AddTask(web)
+	m.AddTask(batch)
+	m.SendWork()
+	m.SendWork()
+	check := func(step string) {
+		t.Helper()
+		if err := m.Snapshot().Validate(); err != nil {
+			t.Errorf("after %s: %v", step, err)
+		}
+	}
+	check("assigning")
+
+	m.Workers = append(m.Workers, second)
+	if err := m.MoveTask(web.Task.ID, second); err != nil {
+		t.Fatalf("MoveTask() error = %v", err)
+	}
+	check("moving")
+	if got := m.TaskWorkerMap[web.Task.ID]; got != second || slices.Contains(m.WorkerTaskMap[first], web.Task.ID) {
+		t.Errorf("moved task is mapped to %q and listed on %v", got, m.WorkerTaskMap[first])
+	}
+
+	done := *m.TaskDb[batch.Task.ID.String()][0]
+	done.State = task.Completed
+	done.FinishTime = now.Add(-2 * time.Hour)
+	m.TaskDb[batch.Task.ID.String()] = append(m.TaskDb[batch.Task.ID.String()], &done)
+	m.Sweep()
+	check("pruning")
+	if _, ok := m.TaskWorkerMap[batch.Task.ID]; ok || slices.Contains(m.WorkerTaskMap[first], batch.Task.ID) {
+		t.Errorf("pruned task is still assigned: %v", m.WorkerTaskMap)
+	}
+}
+
+func TestManager_UpdateTasksBoundsSlowWorker(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	running := task.Task{ID: uuid.New(), Name: "web", State: task.Running, ContainerID: "container-1"}
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]task.Task{running})
+	}))
+	t.Cleanup(fast.Close)
+
+	slowAddr := strings.TrimPrefix(slow.URL, "http://")
+	fastAddr := strings.TrimPrefix(fast.URL, "http://")
+	m := newManager(slowAddr, fastAddr)
+	m.PollTimeout = 200 * time.Millisecond
+	scheduled := running
+	scheduled.State = task.Scheduled
+	scheduled.ContainerID = ""
+	m.TaskDb[running.ID.String()] = []*task.Task{&scheduled}
+	m.TaskWorkerMap[running.ID] = fastAddr
+
+	start := time.Now()
+	m.UpdateTasks()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("UpdateTasks() took %v with a slow worker, want about %v", elapsed, m.PollTimeout)
+	}
+
+	got, err := m.GetTask(running.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Running || got.ContainerID != "container-1" {
+		t.Errorf("task is %v in %q, want the fast worker's report", got.State, got.ContainerID)
+	}
+}
+
+func TestManager_StickyTaskReturnsToPreviousWorker(t *testing.T) {
+	var firstReceived, secondReceived int
+	first := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &firstReceived).URL, "http://")
+	second := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &secondReceived).URL, "http://")
+	m := newManager(first, second)
+
+	sticky := pendingEvent("cache")
+	sticky.Task.StickyWorker = true
+	m.AddTask(sticky)
+	m.SendWork()
+	if got := m.TaskWorkerMap[sticky.Task.ID]; got != second {
+		t.Fatalf("task placed on %q, want %q", got, second)
+	}
+
+	for range 2 {
+		if _, err := m.RestartTask(sticky.Task.ID); err != nil {
+			t.Fatalf("RestartTask() error = %v", err)
+		}
+		m.SendWork()
+		if got := m.TaskWorkerMap[sticky.Task.ID]; got != second {
+			t.Errorf("restarted task placed on %q, want its previous worker %q", got, second)
+		}
+	}
+
+	if err := m.Cordon(second); err != nil {
+		t.Fatalf("Cordon() error = %v", err)
+	}
+	if _, err := m.RestartTask(sticky.Task.ID); err != nil {
+		t.Fatalf("RestartTask() error = %v", err)
+	}
+	m.SendWork()
+	if got := m.TaskWorkerMap[sticky.Task.ID]; got != first {
+		t.Errorf("with its previous worker cordoned, task placed on %q, want %q", got, first)
+	}
+}
+
+func TestManager_StickyTaskFavouredByScheduler(t *testing.T) {
+	var smallReceived, largeReceived int
+	small := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &smallReceived).URL, "http://")
+	large := strings.TrimPrefix(fakeWorker(t, worker.Stats{MaxConcurrent: 4}, &largeReceived).URL, "http://")
+
+	m := newManager(small, large)
+	m.Scheduler = &scheduler.WeightedRoundRobin{}
+	m.WorkerNodes = []*node.Node{
+		{Name: small, Cores: 1, Memory: 1024},
+		{Name: large, Cores: 3, Memory: 3072},
+	}
+
+	sticky := pendingEvent("cache")
+	sticky.Task.StickyWorker = true
+	m.AddTask(sticky)
+	m.SendWork()
+	if got := m.TaskWorkerMap[sticky.Task.ID]; got != large {
+		t.Fatalf("task placed on %q, want %q", got, large)
+	}
+
+	// Left to the round-robin the restarted task would go to the small
+	// worker now.
+	if _, err := m.RestartTask(sticky.Task.ID); err != nil {
+		t.Fatalf("RestartTask() error = %v", err)
+	}
+	m.SendWork()
+	if got := m.TaskWorkerMap[sticky.Task.ID]; got != large {
+		t.Errorf("restarted task placed on %q, want its previous worker %q", got, large)
+	}
+
+	// The scheduler charged the large worker for taking the sticky task
+	// back, so the next task goes to the small one.
+	next := pendingEvent("job")
+	m.AddTask(next)
+	m.SendWork()
+	if got := m.TaskWorkerMap[next.Task.ID]; got != small {
+		t.Errorf("next task placed on %q, want %q", got, small)
+	}
+}
+
+func TestManager_RestartDelayHoldsFailedTask(t *testing.T) {
+	var received int
+	var reports []task.Task
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.Stats{})
+	})
+	mux.HandleFunc("GET /tasks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(reports)
+	})
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(http.StatusCreated)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	m.Clock = c
+
+	te := pendingEvent("flaky")
+	te.Task.RestartPolicy = "on-failure"
+	te.Task.RestartDelay = 30 * time.Second
+	m.AddTask(te)
+	m.SendWork()
+
+	failed := te.Task
+	failed.State = task.Failed
+	failed.ExitCode = 1
+	reports = []task.Task{failed}
+	m.UpdateTasks()
+
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Pending || got.RestartCount != 1 || !got.ScheduledAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("after failing task is %v, restart %d, due %v, want pending restart 1 due in 30s", got.State, got.RestartCount, got.ScheduledAt)
+	}
+
+	c.Advance(29 * time.Second)
+	m.SendWork()
+	if received != 1 {
+		t.Fatalf("worker received %d tasks before the restart delay passed, want 1", received)
+	}
+
+	c.Advance(time.Second)
+	m.SendWork()
+	if received != 2 {
+		t.Errorf("worker received %d tasks once the restart delay passed, want 2", received)
+	}
+}
+
+func TestManager_SelectWorkerWhileWorkersRegister(t *testing.T) {
+	var received int
+	m := newManager(strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 20 {
+			m.Register(worker.Heartbeat{Name: fmt.Sprintf("worker-%d", i), Address: fmt.Sprintf("127.0.0.1:%d", i+1)})
+		}
+	}()
+	for range 20 {
+		if _, err := m.SelectWorker(task.Task{ID: uuid.New()}); err != nil && !errors.Is(err, manager.ErrNoWorkerAvailable) {
+			t.Errorf("SelectWorker() error = %v", err)
+		}
+	}
+	<-done
+}
+
+func TestManager_SelectWorkerConcurrentlyWithScheduler(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	m := newManager(w)
+	m.Scheduler = scheduler.BestFit{}
+	m.WorkerNodes = []*node.Node{{Name: w, Cores: 4, Memory: 4096}}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			m.SelectWorker(task.Task{ID: uuid.New(), Memory: 256})
+		}
+	}()
+	for range 20 {
+		if _, err := m.SelectWorker(task.Task{ID: uuid.New(), Memory: 256}); err != nil {
+			t.Errorf("SelectWorker() error = %v", err)
+		}
+		m.Nodes()
+	}
+	<-done
+}
+
+func TestManager_SendWorkFailsTaskWorkerRejects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(worker.Stats{})
+	})
+	mux.HandleFunc("POST /tasks", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(cubeerrors.Envelope{Error: cubeerrors.Body{Code: "invalid_request", Message: "Error unmarshalling body"}})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+
+	te := pendingEvent("task-1")
+	m.AddTask(te)
+	m.SendWork()
+
+	got, err := m.GetTask(te.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed || got.FailureReason != task.FailureRejected {
+		t.Errorf("task is %v (%q), want %v (%q)", got.State, got.FailureReason, task.Failed, task.FailureRejected)
+	}
+	if m.Pending.Len() != 0 {
+		t.Errorf("pending queue holds %d tasks, want the rejected task gone", m.Pending.Len())
+	}
+}
+
+func TestManager_SelectWorkerRoundRobinChecksGPUs(t *testing.T) {
+	var cpuReceived, gpuReceived int
+	cpuOnly := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &cpuReceived).URL, "http://")
+	withGPU := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &gpuReceived).URL, "http://")
+	m := newManager(cpuOnly, withGPU)
+	m.WorkerNodes = []*node.Node{{Name: cpuOnly}, {Name: withGPU, GPUs: 1}}
+
+	first := task.Task{ID: uuid.New(), Name: "train", State: task.Scheduled, GPUs: 1}
+	w, err := m.SelectWorker(first)
+	if err != nil || w != withGPU {
+		t.Fatalf("SelectWorker() = %q, %v; want the worker with a GPU", w, err)
+	}
+
+	// Once its GPU is claimed, no worker can take another GPU task.
+	m.TaskDb[first.ID.String()] = []*task.Task{&first}
+	m.WorkerTaskMap[withGPU] = []uuid.UUID{first.ID}
+	m.TaskWorkerMap[first.ID] = withGPU
+	if w, err := m.SelectWorker(task.Task{ID: uuid.New(), Name: "train-2", GPUs: 1}); !errors.Is(err, manager.ErrNoWorkerAvailable) {
+		t.Errorf("SelectWorker() = %q, %v; want ErrNoWorkerAvailable", w, err)
+	}
+}
@@ -0,0 +1,224 @@
+package manager
+
+import (
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/docker/docker/api/types"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// DefaultRolloutTimeout is how long a task moved with a rolling update has
+// to become healthy when Manager.RolloutTimeout is zero.
+const DefaultRolloutTimeout = 2 * time.Minute
+
+// rolloutPollInterval is how often the target of a rolling update is asked
+// whether the task has become healthy.
+const rolloutPollInterval = time.Second
+
+// MoveTask stops a running task on the worker it was assigned to and starts
+// it on target, leaving the rest of the source worker's tasks alone. It fails
+// without stopping anything if target is unknown or has no room for the task.
+// Once the task is stopped, a target that then turns it down leaves it pending
+// for the manager to place elsewhere.
+//
+// A task with RollingUpdate set is started on target first, and only stopped
+// on the source worker once it runs healthily there; see rollTask.
+func (m *Manager) MoveTask(id uuid.UUID, target string) error {
+	current, err := m.GetTask(id)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	source, ok := m.TaskWorkerMap[id]
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %v is not assigned to a worker", ErrTaskNotFound, id)
+	}
+	if current.State.Terminal() {
+		return cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "task %v is %v", id, current.State)
+	}
+	if source == target {
+		return cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "task %v already runs on worker %s", id, target)
+	}
+	if err := m.canFit(*current, target); err != nil {
+		return err
+	}
+	if current.RollingUpdate {
+		return m.rollTask(*current, source, target)
+	}
+
+	if err := m.StopTask(id, task.ReasonMoved); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	moved := m.rescheduled(*m.task(taskKey(id)))
+	m.unassign(id)
+	m.mu.Unlock()
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: moved.UpdatedAt,
+		Task:      moved,
+		Reason:    task.ReasonMoved,
+	}
+	sendErr := m.postTask(target, te)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := taskKey(id)
+	if sendErr != nil {
+		moved.State = task.Pending
+		te.State = task.Pending
+		te.Task = moved
+		m.TaskDb[key] = append(m.TaskDb[key], &moved)
+		m.appendEvent(&te)
+		m.Pending.Enqueue(te)
+		return fmt.Errorf("moving task %v to worker %s, left pending: %w", id, target, sendErr)
+	}
+
+	m.TaskDb[key] = append(m.TaskDb[key], &moved)
+	m.appendEvent(&te)
+	m.assign(id, target)
+	return nil
+}
+
+// rollTask moves a task from source to target without downtime: it starts
+// the task on target, waits up to RolloutTimeout for it to run and, when it
+// has a healthcheck, to be healthy, and only then stops it on source. If the
+// new container fails or times out it is stopped instead, and the task keeps
+// running on source.
+func (m *Manager) rollTask(current task.Task, source, target string) error {
+	id := current.ID
+	next := m.rescheduled(current)
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Scheduled,
+		Timestamp: next.UpdatedAt,
+		Task:      next,
+		Reason:    task.ReasonMoved,
+	}
+	if err := m.postTask(target, te); err != nil {
+		return fmt.Errorf("starting task %v on worker %s: %w", id, target, err)
+	}
+
+	started, err := m.waitHealthy(target, id)
+	if err != nil {
+		if stopErr := m.stopOn(target, id, task.ReasonRolloutFailed); stopErr != nil {
+			logging.Errorf("Error stopping task %v on worker %s after a failed rollout: %v", id, target, stopErr)
+		}
+		return fmt.Errorf("task %v kept on worker %s: %w", id, source, err)
+	}
+
+	if err := m.stopOn(source, id, task.ReasonMoved); err != nil {
+		// The new container is healthy, so it takes over regardless.
+		logging.Errorf("Error stopping task %v on worker %s after moving it to %s: %v", id, source, target, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := taskKey(id)
+	started.UpdatedAt = m.now().UTC()
+	m.TaskDb[key] = append(m.TaskDb[key], &started)
+	m.appendEvent(&task.TaskEvent{
+		ID:        uuid.New(),
+		State:     started.State,
+		Timestamp: started.UpdatedAt,
+		Task:      started,
+		Reason:    task.ReasonMoved,
+	})
+	m.assign(id, target)
+	return nil
+}
+
+// waitHealthy polls worker w until its copy of the task is running and, if
+// Docker checks its health, healthy. It fails as soon as the task finishes
+// or reports unhealthy, and once RolloutTimeout has passed.
+func (m *Manager) waitHealthy(w string, id uuid.UUID) (task.Task, error) {
+	timeout := m.RolloutTimeout
+	if timeout == 0 {
+		timeout = DefaultRolloutTimeout
+	}
+	expired := m.after(timeout)
+	for {
+		t, err := m.workerTask(w, id)
+		switch {
+		case err != nil:
+			logging.Errorf("Error checking task %v on worker %s: %v", id, w, err)
+		case t.State.Terminal():
+			return t, fmt.Errorf("task %v is %v on worker %s", id, t.State, w)
+		case t.Health == types.Unhealthy:
+			return t, fmt.Errorf("task %v is unhealthy on worker %s", id, w)
+		case t.State == task.Running && (t.Health == "" || t.Health == types.Healthy):
+			return t, nil
+		}
+
+		select {
+		case <-expired:
+			return t, fmt.Errorf("task %v not healthy on worker %s after %v", id, w, timeout)
+		case <-m.after(rolloutPollInterval):
+		}
+	}
+}
+
+// rescheduled returns t ready to be sent to a worker afresh, without the
+// runtime state of its previous container.
+func (m *Manager) rescheduled(t task.Task) task.Task {
+	t.State = task.Scheduled
+	t.StartTime = time.Time{}
+	t.FinishTime = time.Time{}
+	t.ContainerID = ""
+	t.UpdatedAt = m.now().UTC()
+	t.ExitCode = 0
+	t.Discrepancies = nil
+	t.Health = ""
+	t.Result = ""
+	t.Reason = ""
+	t.FailureReason = ""
+	return t
+}
+
+// canFit returns an error matching cubeerrors.ErrNoCapacity unless worker w
+// can take t: it must not be cordoned, must have spare capacity and, when the
+// manager has a Scheduler and knows the worker's node, must be a candidate
+// for the task.
+func (m *Manager) canFit(t task.Task, w string) error {
+	m.mu.Lock()
+	known := slices.Contains(m.Workers, w)
+	var n *node.Node
+	for _, wn := range m.WorkerNodes {
+		if wn.Name == w {
+			c := *wn
+			m.refreshNode(&c)
+			n = &c
+			known = true
+		}
+	}
+	cordoned := m.cordoned[w]
+	m.mu.Unlock()
+	if !known {
+		return fmt.Errorf("worker %s %w", w, cubeerrors.ErrNotFound)
+	}
+	if cordoned {
+		return cubeerrors.Wrapf(cubeerrors.ErrNoCapacity, "worker %s is cordoned", w)
+	}
+
+	if !m.hasCapacity(w, t) {
+		return cubeerrors.Wrapf(cubeerrors.ErrNoCapacity, "worker %s has no spare capacity for task %v", w, t.ID)
+	}
+	if m.Scheduler != nil && n != nil {
+		if len(m.Scheduler.SelectCandidateNodes(t, []*node.Node{n})) == 0 {
+			return cubeerrors.Wrapf(cubeerrors.ErrNoCapacity, "worker %s cannot run task %v", w, t.ID)
+		}
+	}
+	return nil
+}
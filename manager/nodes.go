@@ -0,0 +1,274 @@
+package manager
+
+import (
+	"cmp"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+// DefaultNodeTTL is how long a worker that registered itself is kept without
+// a heartbeat when Manager.NodeTTL is zero.
+const DefaultNodeTTL = time.Minute
+
+// maxNodeEvents is the number of most recent node events the manager keeps.
+const maxNodeEvents = 1000
+
+// States a NodeEvent records a worker entering.
+const (
+	NodeUp   = "up"
+	NodeDown = "down"
+)
+
+// NodeEvent records a registered worker joining the cluster or being marked
+// down for missing its heartbeats, with the tasks that were rescheduled
+// because of it.
+type NodeEvent struct {
+	Node      string
+	State     string
+	Timestamp time.Time
+	Tasks     []uuid.UUID `json:",omitempty"`
+}
+
+// NodeView describes a worker for operators: its capacity, what is
+// allocated on it and whether the manager can reach it.
+type NodeView struct {
+	Name            string
+	Ip              string `json:",omitempty"`
+	Role            string `json:",omitempty"`
+	Cores           int
+	CpuAllocated    float64
+	Memory          int
+	MemoryAllocated int
+	Disk            int
+	DiskAllocated   int
+	GPUs            int
+	GPUsAllocated   int
+
+	// TaskCount is the number of unfinished tasks assigned to the worker
+	TaskCount int
+
+	// LastSeen is when the manager last heard from the worker; zero if it
+	// never has
+	LastSeen time.Time
+
+	// Unreachable is set when the manager's last attempt to poll the worker
+	// failed, with Error saying why
+	Unreachable bool
+	Error       string `json:",omitempty"`
+
+	// Registered is set for workers that joined by sending heartbeats, with
+	// Stats holding the load reported in the latest one
+	Registered bool
+	Stats      *worker.Stats `json:",omitempty"`
+
+	// Down is set for a registered worker whose heartbeats stopped for
+	// longer than the manager's NodeTTL, until it registers again
+	Down bool
+
+	// Cordoned is set for a worker taking no new tasks; see Manager.Cordon
+	Cordoned bool
+}
+
+// nodeStatus is what the manager last learned about reaching a worker.
+type nodeStatus struct {
+	lastSeen time.Time
+	err      error
+
+	// registered is set once the worker has sent a heartbeat, with stats
+	// holding the load it reported
+	registered bool
+	stats      *worker.Stats
+
+	// down is set once the worker's heartbeats have lapsed
+	down bool
+}
+
+// markSeen records the outcome of polling a worker.
+func (m *Manager) markSeen(w string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.nodeStatus == nil {
+		m.nodeStatus = make(map[string]nodeStatus)
+	}
+	status := m.nodeStatus[w]
+	status.err = err
+	if err == nil {
+		status.lastSeen = m.now().UTC()
+	}
+	m.nodeStatus[w] = status
+}
+
+// Register adds the worker that sent hb to Workers, if it is not there
+// already, and records the heartbeat as the latest sign of life. A worker
+// that was marked down joins afresh.
+func (m *Manager) Register(hb worker.Heartbeat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.Contains(m.Workers, hb.Address) {
+		logging.Infof("Registering worker %s (%s)", hb.Address, hb.Name)
+		m.Workers = append(m.Workers, hb.Address)
+		m.appendNodeEvent(NodeEvent{Node: hb.Address, State: NodeUp, Timestamp: m.now().UTC()})
+	}
+	if m.nodeStatus == nil {
+		m.nodeStatus = make(map[string]nodeStatus)
+	}
+	m.nodeStatus[hb.Address] = nodeStatus{
+		lastSeen:   m.now().UTC(),
+		registered: true,
+		stats:      &hb.Stats,
+	}
+}
+
+// ExpireNodes marks down every registered worker that has not sent a
+// heartbeat for the NodeTTL grace period, and returns their addresses. A
+// down worker leaves Workers, and its unfinished tasks go back to Pending to
+// be scheduled elsewhere, each counting a restart so reports from its old
+// container are ignored should the worker return. Workers listed statically
+// are never expired.
+func (m *Manager) ExpireNodes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ttl := m.NodeTTL
+	if ttl == 0 {
+		ttl = DefaultNodeTTL
+	}
+	cutoff := m.now().Add(-ttl)
+
+	var expired []string
+	m.Workers = slices.DeleteFunc(m.Workers, func(w string) bool {
+		status, ok := m.nodeStatus[w]
+		if !ok || !status.registered || !status.lastSeen.Before(cutoff) {
+			return false
+		}
+		expired = append(expired, w)
+		status.down = true
+		m.nodeStatus[w] = status
+		return true
+	})
+	for _, w := range expired {
+		logging.Warnf("Worker %s is down, last seen more than %v ago", w, ttl)
+		m.appendNodeEvent(NodeEvent{
+			Node:      w,
+			State:     NodeDown,
+			Timestamp: m.now().UTC(),
+			Tasks:     m.rescheduleFrom(w),
+		})
+	}
+	return expired
+}
+
+// rescheduleFrom puts the unfinished tasks assigned to worker w back on the
+// pending queue, and returns their IDs. The caller must hold m.mu.
+func (m *Manager) rescheduleFrom(w string) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, id := range slices.Clone(m.WorkerTaskMap[w]) {
+		key := taskKey(id)
+		t := m.task(key)
+		if t == nil || t.State.Terminal() {
+			continue
+		}
+
+		pending := m.rescheduled(*t)
+		pending.State = task.Pending
+		pending.RestartCount++
+		m.TaskDb[key] = append(m.TaskDb[key], &pending)
+		m.unassign(id)
+
+		te := task.TaskEvent{
+			ID:        uuid.New(),
+			State:     task.Pending,
+			Timestamp: pending.UpdatedAt,
+			Task:      pending,
+			Reason:    task.ReasonWorkerLost,
+		}
+		m.appendEvent(&te)
+		m.Pending.Enqueue(te)
+		ids = append(ids, id)
+	}
+	if len(ids) > 0 {
+		logging.Infof("Rescheduling %d tasks from worker %s", len(ids), w)
+	}
+	return ids
+}
+
+// appendNodeEvent records e, dropping the oldest event once more than
+// maxNodeEvents are kept. The caller must hold m.mu.
+func (m *Manager) appendNodeEvent(e NodeEvent) {
+	m.nodeEvents = append(m.nodeEvents, e)
+	if over := len(m.nodeEvents) - maxNodeEvents; over > 0 {
+		m.nodeEvents = slices.Delete(m.nodeEvents, 0, over)
+	}
+}
+
+// NodeEvents returns the workers joining and going down, oldest first.
+func (m *Manager) NodeEvents() []NodeEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.nodeEvents)
+}
+
+// Nodes describes every worker the manager knows of, from Workers and
+// WorkerNodes along with registered workers that are down, sorted by name.
+// What is allocated on each is worked out from its unfinished tasks.
+func (m *Manager) Nodes() []NodeView {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byName := make(map[string]*node.Node)
+	for _, w := range m.Workers {
+		byName[w] = &node.Node{Name: w}
+	}
+	for w, status := range m.nodeStatus {
+		if status.down {
+			byName[w] = &node.Node{Name: w}
+		}
+	}
+	for _, n := range m.WorkerNodes {
+		c := *n
+		byName[n.Name] = &c
+	}
+
+	views := make([]NodeView, 0, len(byName))
+	for name, n := range byName {
+		m.refreshNode(n)
+		view := NodeView{
+			Name:            name,
+			Ip:              n.Ip,
+			Role:            n.Role,
+			Cores:           n.Cores,
+			CpuAllocated:    n.CpuAllocated,
+			Memory:          n.Memory,
+			MemoryAllocated: n.MemoryAllocated,
+			Disk:            n.Disk,
+			DiskAllocated:   n.DiskAllocated,
+			GPUs:            n.GPUs,
+			GPUsAllocated:   n.GPUsAllocated,
+			TaskCount:       len(n.Tasks),
+			Cordoned:        n.Cordoned,
+		}
+		if status, ok := m.nodeStatus[name]; ok {
+			view.LastSeen = status.lastSeen
+			view.Registered = status.registered
+			view.Stats = status.stats
+			view.Down = status.down
+			if status.err != nil {
+				view.Unreachable = true
+				view.Error = status.err.Error()
+			}
+		}
+		views = append(views, view)
+	}
+	slices.SortFunc(views, func(a, b NodeView) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return views
+}
@@ -0,0 +1,29 @@
+package manager
+
+import "github.com/christinavaneyssen/cube/task"
+
+// Profile holds the resources and restart policy given to tasks submitted
+// without them.
+type Profile struct {
+	Cpu           float64
+	Memory        int
+	Disk          int
+	RestartPolicy string
+}
+
+// apply fills the fields of t left at their zero value from the profile.
+// Fields the task sets keep their value.
+func (p Profile) apply(t *task.Task) {
+	if t.Cpu == 0 {
+		t.Cpu = p.Cpu
+	}
+	if t.Memory == 0 {
+		t.Memory = p.Memory
+	}
+	if t.Disk == 0 {
+		t.Disk = p.Disk
+	}
+	if t.RestartPolicy == "" {
+		t.RestartPolicy = p.RestartPolicy
+	}
+}
@@ -0,0 +1,79 @@
+package manager
+
+import (
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// Quota caps what the unfinished tasks of a namespace, pending ones
+// included, may claim between them. A zero field sets no limit.
+type Quota struct {
+	// Cpu is the total CPUs the tasks may claim
+	Cpu float64
+
+	// Memory is the total memory, in MB, the tasks may claim
+	Memory int
+
+	// Tasks is the number of tasks that may be unfinished at once
+	Tasks int
+}
+
+// checkQuota returns an error matching cubeerrors.ErrQuotaExceeded if
+// submitting tasks, filled in from DefaultProfile, would take a namespace
+// past its quota. The tasks in replacing are about to be cancelled and do
+// not count.
+func (m *Manager) checkQuota(tasks []task.Task, replacing []task.Task) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.Quotas) == 0 {
+		return nil
+	}
+	excluded := make(map[uuid.UUID]bool, len(replacing))
+	for _, t := range replacing {
+		excluded[t.ID] = true
+	}
+
+	usage := make(map[string]Quota)
+	add := func(t task.Task) {
+		u := usage[t.Namespace]
+		u.Cpu += t.Cpu
+		u.Memory += t.Memory
+		u.Tasks++
+		usage[t.Namespace] = u
+	}
+	for key := range m.TaskDb {
+		t := m.task(key)
+		if _, limited := m.Quotas[t.Namespace]; !limited || t.State.Terminal() || excluded[t.ID] {
+			continue
+		}
+		add(*t)
+	}
+
+	var namespaces []string
+	for _, t := range tasks {
+		m.DefaultProfile.apply(&t)
+		add(t)
+		if !slices.Contains(namespaces, t.Namespace) {
+			namespaces = append(namespaces, t.Namespace)
+		}
+	}
+	for _, ns := range namespaces {
+		q, ok := m.Quotas[ns]
+		if !ok {
+			continue
+		}
+		u := usage[ns]
+		switch {
+		case q.Memory > 0 && u.Memory > q.Memory:
+			return cubeerrors.Wrapf(cubeerrors.ErrQuotaExceeded, "namespace %q would claim %d MB of memory, over its quota of %d MB", ns, u.Memory, q.Memory)
+		case q.Cpu > 0 && u.Cpu > q.Cpu:
+			return cubeerrors.Wrapf(cubeerrors.ErrQuotaExceeded, "namespace %q would claim %g CPUs, over its quota of %g", ns, u.Cpu, q.Cpu)
+		case q.Tasks > 0 && u.Tasks > q.Tasks:
+			return cubeerrors.Wrapf(cubeerrors.ErrQuotaExceeded, "namespace %q would have %d unfinished tasks, over its quota of %d", ns, u.Tasks, q.Tasks)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/queues"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// ErrDuplicateName is returned when a task is submitted under the name of a
+// task that has not finished, without asking to replace it.
+var ErrDuplicateName = fmt.Errorf("%w: a task of that name has not finished", cubeerrors.ErrInvalidState)
+
+// Submit queues te through ReplaceTask when replace is set and through
+// SubmitTask with the idempotency key otherwise.
+func (m *Manager) Submit(te task.TaskEvent, key string, replace bool) (task.Task, bool, error) {
+	if replace {
+		return m.ReplaceTask(te)
+	}
+	return m.SubmitTask(te, key)
+}
+
+// ReplaceTask submits te as the one task running under its name: the
+// unfinished tasks of that name are cancelled, whether still pending or
+// already on a worker, and te is queued in their place. Submitting the spec
+// the single task of that name already runs changes nothing, so applying
+// the same spec twice is harmless; that task is returned and the returned
+// bool, which reports whether a task was created, is false.
+//
+// When te has RollingUpdate set and tasks it replaces are on workers, those
+// keep running until te has been placed and runs healthily; see
+// rollReplace.
+func (m *Manager) ReplaceTask(te task.TaskEvent) (task.Task, bool, error) {
+	t, created, running, err := m.replace(te)
+	if err != nil || len(running) == 0 {
+		return t, created, err
+	}
+	if err := m.rollReplace(t, running); err != nil {
+		return task.Task{}, false, err
+	}
+	return t, true, nil
+}
+
+// replace does the work of ReplaceTask up to a rolling update. For one, it
+// retires only the tasks still waiting for a worker, records te without
+// queueing it, and returns the tasks left running for the caller to roll.
+func (m *Manager) replace(te task.TaskEvent) (task.Task, bool, []task.Task, error) {
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+
+	m.mu.Lock()
+	current := m.unfinished(te.Task.Name)
+	// Compare the spec as AddTask would record it.
+	spec := te.Task
+	m.DefaultProfile.apply(&spec)
+	m.mu.Unlock()
+	if len(current) == 1 && current[0].SpecHash() == spec.SpecHash() {
+		return current[0], false, nil, nil
+	}
+
+	if err := m.admit(1); err != nil {
+		return task.Task{}, false, nil, err
+	}
+	if err := m.checkQuota([]task.Task{te.Task}, current); err != nil {
+		return task.Task{}, false, nil, err
+	}
+	if err := m.Webhook.checkWebhook(te.Task); err != nil {
+		return task.Task{}, false, nil, err
+	}
+	var running []task.Task
+	for _, t := range current {
+		m.mu.Lock()
+		_, assigned := m.TaskWorkerMap[t.ID]
+		m.mu.Unlock()
+		if te.Task.RollingUpdate && assigned {
+			running = append(running, t)
+			continue
+		}
+		if err := m.retire(t.ID, task.ReasonReplaced); err != nil {
+			return task.Task{}, false, nil, fmt.Errorf("replacing task %v: %w", t.ID, err)
+		}
+	}
+	if len(running) == 0 {
+		return m.AddTask(te), true, nil, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.recordTask(&te), true, running, nil
+}
+
+// rollReplace replaces the running tasks with t, recorded but not yet
+// queued, without downtime: it places t and sends it to its worker, waits
+// up to RolloutTimeout for it to run and, when it has a healthcheck, to be
+// healthy, and only then stops the tasks it replaces. If t cannot be placed,
+// fails or times out it is cancelled instead, and the old tasks keep running.
+func (m *Manager) rollReplace(t task.Task, running []task.Task) error {
+	d, err := m.placeChecked(t)
+	m.recordDecision(d)
+	if err == nil {
+		te := task.TaskEvent{ID: uuid.New(), State: task.Pending, Timestamp: m.now().UTC(), Task: t}
+		err = m.dispatch(te, d.Chosen)
+	}
+	if err != nil {
+		m.mu.Lock()
+		m.cancel(t.ID, task.ReasonRolloutFailed)
+		m.mu.Unlock()
+		return fmt.Errorf("starting task %v to replace %s: %w", t.ID, t.Name, err)
+	}
+
+	if _, err := m.waitHealthy(d.Chosen, t.ID); err != nil {
+		if stopErr := m.StopTask(t.ID, task.ReasonRolloutFailed); stopErr != nil {
+			logging.Errorf("Error stopping task %v on worker %s after a failed rollout: %v", t.ID, d.Chosen, stopErr)
+		}
+		return fmt.Errorf("tasks named %s kept running: %w", t.Name, err)
+	}
+
+	for _, old := range running {
+		// The new task is healthy, so it takes over regardless.
+		if err := m.StopTask(old.ID, task.ReasonReplaced); err != nil {
+			logging.Errorf("Error stopping task %v replaced by %v: %v", old.ID, t.ID, err)
+		}
+	}
+	return nil
+}
+
+// checkName returns ErrDuplicateName if a task named as t is has not
+// finished. Tasks without a name never clash, and neither do replicas,
+// tasks with Replicas set, which share their name by design.
+func (m *Manager) checkName(t task.Task) error {
+	if t.Name == "" || t.Replicas > 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current := m.unfinished(t.Name); len(current) > 0 {
+		return fmt.Errorf("%w: task %s is %v as %v", ErrDuplicateName, t.Name, m.lastState(current[0].ID.String()), current[0].ID)
+	}
+	return nil
+}
+
+// unfinished returns the tasks named name whose latest event does not finish
+// them, in task.Compare order. A task being stopped counts as finished once
+// its cancellation is recorded, before its worker confirms it. The caller
+// must hold m.mu.
+func (m *Manager) unfinished(name string) []task.Task {
+	if name == "" {
+		return nil
+	}
+
+	var tasks []task.Task
+	for key := range m.TaskDb {
+		t := m.task(key)
+		if t.Name == name && !m.lastState(key).Terminal() {
+			tasks = append(tasks, *t)
+		}
+	}
+	slices.SortFunc(tasks, func(a, b task.Task) int { return task.Compare(&a, &b) })
+	return tasks
+}
+
+// retire cancels a task for the reason given: a pending task is taken off
+// the queue, and one already sent to a worker is stopped there.
+func (m *Manager) retire(id uuid.UUID, reason string) error {
+	m.mu.Lock()
+	_, queued := queues.Remove(&m.Pending, func(te task.TaskEvent) bool {
+		return te.Task.ID == id
+	})
+	if queued {
+		m.cancel(id, reason)
+	}
+	m.mu.Unlock()
+
+	if queued {
+		return nil
+	}
+	return m.StopTask(id, reason)
+}
+
+// cancel records a task no worker has as cancelled for the reason given.
+// The caller must hold m.mu.
+func (m *Manager) cancel(id uuid.UUID, reason string) {
+	key := taskKey(id)
+	t := *m.task(key)
+	t.State = task.Cancelled
+	t.Reason = reason
+	t.UpdatedAt = m.now().UTC()
+	m.TaskDb[key] = append(m.TaskDb[key], &t)
+	m.appendEvent(&task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Cancelled,
+		Timestamp: t.UpdatedAt,
+		Task:      t,
+		Reason:    reason,
+	})
+}
@@ -0,0 +1,168 @@
+package manager_test
+
+import (
+	"errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestManager_ReplaceTaskByName(t *testing.T) {
+	var received int
+	m := newManager(strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://"))
+
+	old := pendingEvent("web")
+	old.Task.Image = "nginx:1.26"
+	if _, _, err := m.SubmitTask(old, ""); err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	m.SendWork()
+
+	next := pendingEvent("web")
+	next.Task.Image = "nginx:1.27"
+	if _, _, err := m.SubmitTask(next, ""); !errors.Is(err, manager.ErrDuplicateName) {
+		t.Fatalf("SubmitTask() of a duplicate name error = %v, want %v", err, manager.ErrDuplicateName)
+	}
+
+	got, created, err := m.ReplaceTask(next)
+	if err != nil || !created {
+		t.Fatalf("ReplaceTask() = %v, %v, want a new task", created, err)
+	}
+	if got.ID != next.Task.ID {
+		t.Errorf("ReplaceTask() returned task %v, want %v", got.ID, next.Task.ID)
+	}
+	events := m.EventDb[old.Task.ID.String()]
+	if last := events[len(events)-1]; last.State != task.Cancelled || last.Reason != task.ReasonReplaced {
+		t.Errorf("old task's last event is %v (%q), want %v (%q)", last.State, last.Reason, task.Cancelled, task.ReasonReplaced)
+	}
+
+	m.SendWork()
+	if received != 2 {
+		t.Errorf("worker received %d tasks, want the old and the new", received)
+	}
+	if _, ok := m.TaskWorkerMap[next.Task.ID]; !ok {
+		t.Error("replacement task was not scheduled")
+	}
+
+	// Applying the same spec again leaves the running task alone.
+	again := pendingEvent("web")
+	again.Task.Image = "nginx:1.27"
+	got, created, err = m.ReplaceTask(again)
+	if err != nil || created || got.ID != next.Task.ID {
+		t.Errorf("ReplaceTask() with an unchanged spec = %v, %v, %v, want task %v unchanged", got.ID, created, err, next.Task.ID)
+	}
+}
+
+func TestManager_ReplaceTaskRollingUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		report   task.Task
+		replaced bool
+	}{
+		{name: "healthy", report: task.Task{State: task.Running, Health: "healthy"}, replaced: true},
+		{name: "failed", report: task.Task{State: task.Failed, Health: "unhealthy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			w := recordingWorker(t, "worker", tt.report, &calls)
+			m := newManager(strings.TrimPrefix(w.URL, "http://"))
+
+			old := pendingEvent("web")
+			old.Task.Image = "nginx:1.26"
+			m.AddTask(old)
+			m.SendWork()
+
+			next := pendingEvent("web")
+			next.Task.Image = "nginx:1.27"
+			next.Task.RollingUpdate = true
+			_, created, err := m.ReplaceTask(next)
+			if tt.replaced && (err != nil || !created) {
+				t.Fatalf("ReplaceTask() = %v, %v, want a new task", created, err)
+			}
+			if !tt.replaced && err == nil {
+				t.Fatal("ReplaceTask() succeeded with a task that never became healthy")
+			}
+
+			// The new task starts before anything is stopped, and then the
+			// old one is stopped if the new one is healthy and the new one
+			// if not.
+			stopped, kept := old.Task.ID, next.Task.ID
+			if !tt.replaced {
+				stopped, kept = kept, stopped
+			}
+			want := []string{
+				"worker POST /tasks",
+				"worker POST /tasks",
+				"worker GET /tasks/" + next.Task.ID.String(),
+				"worker DELETE /tasks/" + stopped.String(),
+			}
+			if !slices.Equal(calls, want) {
+				t.Errorf("calls = %v, want %v", calls, want)
+			}
+			for id, want := range map[uuid.UUID]bool{stopped: true, kept: false} {
+				events := m.EventDb[id.String()]
+				if got := events[len(events)-1].State == task.Cancelled; got != want {
+					t.Errorf("task %v last event is %v, want cancelled %v", id, events[len(events)-1].State, want)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_ReplaceTaskCancelsPendingTask(t *testing.T) {
+	m := newManager()
+
+	old := pendingEvent("report")
+	old.Task.Image = "report:1"
+	m.AddTask(old)
+	next := pendingEvent("report")
+	next.Task.Image = "report:2"
+	if _, _, err := m.ReplaceTask(next); err != nil {
+		t.Fatalf("ReplaceTask() error = %v", err)
+	}
+
+	if m.Pending.Len() != 1 {
+		t.Fatalf("pending = %d, want only the replacement", m.Pending.Len())
+	}
+	replaced, err := m.GetTask(old.Task.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if replaced.State != task.Cancelled || replaced.Reason != task.ReasonReplaced {
+		t.Errorf("old task is %v (%q), want %v (%q)", replaced.State, replaced.Reason, task.Cancelled, task.ReasonReplaced)
+	}
+}
+
+func TestManager_SubmitJobChecksNames(t *testing.T) {
+	m := newManager()
+	if _, _, err := m.SubmitTask(pendingEvent("web"), ""); err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+
+	specs := map[string]manager.JobSpec{
+		"unfinished task": {Name: "deploy", Tasks: []task.Task{{Name: "web"}}},
+		"within the job":  {Name: "deploy", Tasks: []task.Task{{Name: "api"}, {Name: "api"}}},
+	}
+	for name, spec := range specs {
+		if _, err := m.SubmitJob(spec); !errors.Is(err, manager.ErrDuplicateName) {
+			t.Errorf("%s: SubmitJob() error = %v, want %v", name, err, manager.ErrDuplicateName)
+		}
+	}
+
+	// Replicas share their name, with each other and with tasks already
+	// running.
+	replicas := manager.JobSpec{Name: "scale", Tasks: []task.Task{{Name: "web", Replicas: 2}, {Name: "web", Replicas: 2}}}
+	if _, err := m.SubmitJob(replicas); err != nil {
+		t.Errorf("SubmitJob() of replicas error = %v", err)
+	}
+	replica := pendingEvent("web")
+	replica.Task.Replicas = 2
+	if _, _, err := m.SubmitTask(replica, ""); err != nil {
+		t.Errorf("SubmitTask() of a replica error = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+)
+
+// RestartTask stops the task's container, if it has one running, and queues
+// the task to be scheduled again with the same spec. The restarted task
+// keeps its ID, counts the restart in RestartCount and starts from fresh
+// timestamps, appearing as a new version in the task's TaskDb history.
+func (m *Manager) RestartTask(id uuid.UUID) (task.Task, error) {
+	current, err := m.GetTask(id)
+	if err != nil {
+		return task.Task{}, err
+	}
+	if current.State == task.Pending {
+		return task.Task{}, cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "task %v has not been scheduled yet", id)
+	}
+
+	if !current.State.Terminal() {
+		if err := m.StopTask(id, task.ReasonRestarted); err != nil {
+			return task.Task{}, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restarted := m.rescheduled(*m.task(taskKey(id)))
+	restarted.State = task.Pending
+	restarted.RestartCount++
+
+	key := taskKey(id)
+	m.TaskDb[key] = append(m.TaskDb[key], &restarted)
+	m.unassign(id)
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: m.now().UTC(),
+		Task:      restarted,
+		Reason:    task.ReasonRestarted,
+	}
+	m.appendEvent(&te)
+	m.Pending.Enqueue(te)
+	return restarted, nil
+}
+
+// restartsLater reports whether the manager, rather than Docker, restarts
+// the finished task t: it has a RestartDelay, its restart policy asks for a
+// restart, and it has retries left.
+func (m *Manager) restartsLater(t task.Task) bool {
+	if t.RestartDelay <= 0 || !t.ShouldRestart() {
+		return false
+	}
+	return t.RestartMaxRetries == 0 || t.RestartCount < t.RestartMaxRetries
+}
+
+// restartLater queues the finished task t again as a new version, held in
+// Pending until its restart delay has passed. The caller must hold m.mu.
+func (m *Manager) restartLater(t task.Task) {
+	restarted := m.rescheduled(t)
+	restarted.State = task.Pending
+	restarted.ScheduledAt = restarted.UpdatedAt.Add(t.NextRestartDelay())
+	restarted.RestartCount++
+
+	key := taskKey(t.ID)
+	m.TaskDb[key] = append(m.TaskDb[key], &restarted)
+	m.unassign(t.ID)
+
+	te := task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: restarted.UpdatedAt,
+		Task:      restarted,
+		Reason:    task.ReasonRestartPolicy,
+	}
+	m.appendEvent(&te)
+	m.Pending.Enqueue(te)
+	logging.Infof("Restarting task %v at %v", t.ID, restarted.ScheduledAt)
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// RunSweeper prunes expired task records every interval, or every
+// Config.SweepInterval once a config sets one, until ctx is cancelled or the
+// manager shuts down.
+func (m *Manager) RunSweeper(ctx context.Context, interval time.Duration) {
+	m.loop(ctx, m.interval(func(c Config) time.Duration { return c.SweepInterval }, interval), m.Sweep)
+}
+
+// Sweep prunes the finished tasks that finished longer than Retention ago,
+// along with their events, and asks their workers to remove their
+// containers. The KeepLast most recently finished tasks of each name are
+// kept regardless, and a job is dropped once all its tasks have been. No
+// task is pruned when Retention is zero. The audit entries of the pruned
+// tasks go with them, as do idempotency keys naming a pruned task or older
+// than the idempotency window. Sweep then compacts the event histories of
+// the tasks that remain.
+func (m *Manager) Sweep() {
+	defer m.CompactEvents()
+
+	expired := m.expire()
+	for id, w := range expired {
+		if w == "" {
+			continue
+		}
+		if err := m.pruneOnWorker(w, id); err != nil {
+			logging.Errorf("Error pruning task %v on worker %s: %v", id, w, err)
+		}
+	}
+	m.pruneRecords(expired)
+}
+
+// expire drops the expired task records and returns the IDs of the tasks
+// dropped, mapped to the workers they ran on.
+func (m *Manager) expire() map[uuid.UUID]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Retention <= 0 {
+		return nil
+	}
+	cutoff := m.now().Add(-m.Retention)
+	finished := make(map[string][]*task.Task)
+	for key := range m.TaskDb {
+		if t := m.task(key); t.State.Terminal() {
+			finished[t.Name] = append(finished[t.Name], t)
+		}
+	}
+
+	expired := make(map[uuid.UUID]string)
+	for _, tasks := range finished {
+		slices.SortFunc(tasks, func(a, b *task.Task) int {
+			return m.finishedAt(b).Compare(m.finishedAt(a))
+		})
+		for _, t := range tasks[min(m.KeepLast, len(tasks)):] {
+			if m.finishedAt(t).Before(cutoff) {
+				expired[t.ID] = m.TaskWorkerMap[t.ID]
+			}
+		}
+	}
+
+	for id := range expired {
+		m.forget(id)
+		logging.Infof("Pruned task %v", id)
+	}
+	for id, record := range m.jobs {
+		if !slices.ContainsFunc(record.Tasks, func(taskID uuid.UUID) bool { return m.TaskDb[taskKey(taskID)] != nil }) {
+			delete(m.jobs, id)
+			logging.Infof("Pruned job %v", id)
+		}
+	}
+	return expired
+}
+
+// finishedAt returns when a finished task finished: its FinishTime, or the
+// time of its last event when the worker did not report one. The caller
+// must hold m.mu.
+func (m *Manager) finishedAt(t *task.Task) time.Time {
+	if !t.FinishTime.IsZero() {
+		return t.FinishTime
+	}
+	events := m.EventDb[taskKey(t.ID)]
+	if len(events) == 0 {
+		return time.Time{}
+	}
+	return events[len(events)-1].Timestamp
+}
+
+// pruneRecords deletes the audit entries of the expired tasks, and the
+// idempotency keys that name one of them or have outlived the idempotency
+// window. Failing to delete one is logged and left for the next sweep.
+func (m *Manager) pruneRecords(expired map[uuid.UUID]string) {
+	s := m.recordStore()
+	for id := range expired {
+		keys, err := s.List(fmt.Sprintf("%s%s/", auditPrefix, id))
+		if err != nil {
+			logging.Errorf("Error listing audit entries of task %v: %v", id, err)
+			continue
+		}
+		for _, key := range keys {
+			if err := s.Delete(key); err != nil {
+				logging.Errorf("Error pruning audit entry %s: %v", key, err)
+			}
+		}
+	}
+
+	// Hold submissions off so a key is not deleted just as it is reused.
+	m.submitMu.Lock()
+	defer m.submitMu.Unlock()
+
+	keys, err := s.List(idempotencyPrefix)
+	if err != nil {
+		logging.Errorf("Error listing idempotency keys: %v", err)
+		return
+	}
+	for _, key := range keys {
+		record := idempotencyRecord{}
+		if err := s.Get(key, &record); err != nil {
+			logging.Errorf("Error reading idempotency key %s: %v", key, err)
+			continue
+		}
+		if _, ok := expired[record.TaskID]; !ok && m.now().Sub(record.CreatedAt) < m.idempotencyWindow() {
+			continue
+		}
+		if err := s.Delete(key); err != nil {
+			logging.Errorf("Error pruning idempotency key %s: %v", key, err)
+		}
+	}
+}
+
+func (m *Manager) pruneOnWorker(w string, id uuid.UUID) error {
+	resp, err := m.client().Post(fmt.Sprintf("http://%s/tasks/%s/prune", w, id), "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("connecting to worker %s: %w", w, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("worker %s failed to prune task %v: status %d", w, id, resp.StatusCode)
+	}
+	return nil
+}
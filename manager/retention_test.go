@@ -0,0 +1,150 @@
+package manager_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_SweepPrunesExpiredTasks(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	m := newManager(w)
+	m.Clock = clock.NewFake(now)
+	m.Retention = 24 * time.Hour
+	m.KeepLast = 1
+
+	add := func(name string, state task.State, finished time.Time) uuid.UUID {
+		tk := task.Task{ID: uuid.New(), Name: name, State: state, FinishTime: finished}
+		m.TaskDb[tk.ID.String()] = []*task.Task{&tk}
+		m.EventDb[tk.ID.String()] = []*task.TaskEvent{{ID: uuid.New(), State: state, Task: tk}}
+		m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], tk.ID)
+		m.TaskWorkerMap[tk.ID] = w
+		return tk.ID
+	}
+	oldest := add("report", task.Completed, now.Add(-72*time.Hour))
+	latestOld := add("report", task.Failed, now.Add(-48*time.Hour))
+	recent := add("backup", task.Completed, now.Add(-time.Hour))
+	expiredBackup := add("backup", task.Completed, now.Add(-30*time.Hour))
+	running := add("web", task.Running, time.Time{})
+
+	m.Sweep()
+
+	for _, id := range []uuid.UUID{oldest, expiredBackup} {
+		if _, ok := m.TaskDb[id.String()]; ok {
+			t.Errorf("expired task %v was kept", id)
+		}
+		if _, ok := m.EventDb[id.String()]; ok {
+			t.Errorf("events of expired task %v were kept", id)
+		}
+		if _, ok := m.TaskWorkerMap[id]; ok {
+			t.Errorf("expired task %v is still assigned", id)
+		}
+	}
+	for _, id := range []uuid.UUID{latestOld, recent, running} {
+		if _, ok := m.TaskDb[id.String()]; !ok {
+			t.Errorf("task %v was pruned", id)
+		}
+	}
+	if n := len(m.WorkerTaskMap[w]); n != 3 {
+		t.Errorf("worker has %d assigned tasks, want 3", n)
+	}
+}
+
+func TestManager_SweepPrunesRecordsOfExpiredTasks(t *testing.T) {
+	var received int
+	w := strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://")
+	clk := clock.NewFake(time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC))
+	m := newManager(w)
+	m.Clock = clk
+	m.Store = store.NewInMemoryStore()
+	m.Retention = time.Hour
+
+	finished, _, err := m.SubmitTask(pendingEvent("report"), "report-key")
+	if err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	if _, _, err := m.SubmitTask(pendingEvent("web"), "web-key"); err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	m.SendWork()
+	m.SendWork()
+	done := m.TaskDb[finished.ID.String()]
+	done[len(done)-1].State = task.Completed
+	done[len(done)-1].FinishTime = clk.Now()
+
+	keys := func(prefix string) []string {
+		keys, err := m.Store.List(prefix)
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", prefix, err)
+		}
+		return keys
+	}
+
+	clk.Advance(2 * time.Hour)
+	m.Sweep()
+	if got := keys("manager/audit/" + finished.ID.String() + "/"); len(got) != 0 {
+		t.Errorf("pruned task kept audit entries %v", got)
+	}
+	if got := keys("manager/audit/"); len(got) != 1 {
+		t.Errorf("audit log holds %v, want the running task's entry", got)
+	}
+	if got := keys("manager/idempotency/"); !slices.Equal(got, []string{"manager/idempotency/web-key"}) {
+		t.Errorf("idempotency keys = %v, want only the running task's", got)
+	}
+
+	clk.Advance(manager.DefaultIdempotencyWindow)
+	m.Sweep()
+	if got := keys("manager/idempotency/"); len(got) != 0 {
+		t.Errorf("idempotency keys = %v, want none once the window passed", got)
+	}
+}
+
+func TestManager_CompactEventsTrimsHistory(t *testing.T) {
+	m := newManager()
+	m.MaxEvents = 3
+
+	id := uuid.New()
+	states := []task.State{
+		task.Pending, task.Scheduled, task.Running, task.Failed,
+		task.Pending, task.Pending, task.Scheduled, task.Running, task.Running, task.Completed,
+		task.Scheduled, task.Running,
+	}
+	var events []*task.TaskEvent
+	for _, s := range states {
+		events = append(events, &task.TaskEvent{ID: uuid.New(), State: s, Task: task.Task{ID: id, State: s}})
+	}
+	m.TaskDb[id.String()] = []*task.Task{&events[len(events)-1].Task}
+	m.EventDb[id.String()] = events
+
+	m.Sweep()
+
+	got := m.EventDb[id.String()]
+	var gotStates []task.State
+	for _, te := range got {
+		gotStates = append(gotStates, te.State)
+	}
+	want := []task.State{task.Failed, task.Completed, task.Scheduled, task.Running}
+	if !slices.Equal(gotStates, want) {
+		t.Fatalf("compacted states = %v, want %v", gotStates, want)
+	}
+	if got[0] != events[3] || got[1] != events[9] {
+		t.Errorf("terminal events were not preserved as recorded")
+	}
+
+	page, err := m.TaskEvents(id, events[0].ID, 10)
+	if err != nil {
+		t.Fatalf("paging from a compacted cursor: %v", err)
+	}
+	if len(page.Events) != len(want) {
+		t.Errorf("page from a compacted cursor has %d events, want %d", len(page.Events), len(want))
+	}
+}
@@ -0,0 +1,222 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling a worker while its circuit
+// breaker is open.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker open", cubeerrors.ErrWorkerUnavailable)
+
+// RetryPolicy controls how the manager retries calls to workers that fail
+// transiently, and when it stops calling a worker that keeps failing. The
+// zero value makes every call once and never stops calling.
+type RetryPolicy struct {
+	// Retries is the number of times a failed call is repeated
+	Retries int
+
+	// Backoff is the delay before the first retry, doubled before each
+	// retry after it up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// BreakerThreshold is the number of calls in a row that must fail,
+	// after their retries, for the worker's circuit breaker to open; zero
+	// disables the breaker
+	BreakerThreshold int
+
+	// BreakerCooldown is how long an open breaker turns calls away before
+	// letting one through to see whether the worker has recovered
+	BreakerCooldown time.Duration
+}
+
+// delay returns how long to wait before the given retry, counting from 1.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// breaker tracks the calls in a row that failed for one worker.
+type breaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// breakers holds the circuit breaker of each worker host. It outlives the
+// transports built on it, so a breaker stays open when Retry changes.
+type breakers struct {
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+// retryTransport repeats requests that fail transiently, and keeps a circuit
+// breaker for each worker host. A GET or HEAD is repeated when the worker
+// cannot be reached or answers that it is briefly unavailable; any other
+// request only when it never reached the worker, since the worker may have
+// acted on it. A request the worker turned away with 429 Too Many Requests
+// was not acted on, so it is repeated whatever its method, once the worker's
+// Retry-After has passed.
+type retryTransport struct {
+	base     http.RoundTripper
+	policy   RetryPolicy
+	now      func() time.Time
+	after    func(time.Duration) <-chan time.Time
+	breakers *breakers
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := rt.allow(host); err != nil {
+		return nil, err
+	}
+
+	attempt := req
+	var wait time.Duration
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			var err error
+			if attempt, err = rewind(req); err != nil {
+				return nil, err
+			}
+			select {
+			case <-rt.after(wait):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		}
+
+		resp, err := rt.base.RoundTrip(attempt)
+		throttled := err == nil && resp.StatusCode == http.StatusTooManyRequests
+		if !throttled && (!transient(resp, err) || !idempotent(req) && !unsent(err)) {
+			rt.record(host, true)
+			return resp, err
+		}
+		// A worker that throttles the call is up, so it does not count
+		// towards opening its breaker.
+		if retry == rt.policy.Retries {
+			rt.record(host, throttled)
+			return resp, err
+		}
+		wait = rt.policy.delay(retry + 1)
+		if d, ok := retryAfter(resp, rt.now()); throttled && ok {
+			wait = d
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+// allow returns ErrCircuitOpen while the host's breaker is open.
+func (rt *retryTransport) allow(host string) error {
+	rt.breakers.mu.Lock()
+	defer rt.breakers.mu.Unlock()
+
+	if b, ok := rt.breakers.hosts[host]; ok && rt.now().Before(b.openUntil) {
+		return fmt.Errorf("%w: worker %s", ErrCircuitOpen, host)
+	}
+	return nil
+}
+
+// record notes whether a call to the host succeeded, opening its breaker
+// once BreakerThreshold calls in a row have failed.
+func (rt *retryTransport) record(host string, ok bool) {
+	if rt.policy.BreakerThreshold == 0 {
+		return
+	}
+
+	rt.breakers.mu.Lock()
+	defer rt.breakers.mu.Unlock()
+
+	if ok {
+		delete(rt.breakers.hosts, host)
+		return
+	}
+	if rt.breakers.hosts == nil {
+		rt.breakers.hosts = make(map[string]*breaker)
+	}
+	b, found := rt.breakers.hosts[host]
+	if !found {
+		b = &breaker{}
+		rt.breakers.hosts[host] = b
+	}
+	b.failures++
+	if b.failures >= rt.policy.BreakerThreshold {
+		b.openUntil = rt.now().Add(rt.policy.BreakerCooldown)
+	}
+}
+
+// transient reports whether a call failed in a way worth retrying: the
+// worker could not be reached, or answered that it is briefly unavailable.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns how long the response's Retry-After header, given in
+// seconds or as a date, asks the caller to wait, and whether it has one.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// idempotent reports whether sending req twice has the same effect as
+// sending it once.
+func idempotent(req *http.Request) bool {
+	return req.Method == http.MethodGet || req.Method == http.MethodHead
+}
+
+// unsent reports whether a call failed before the request was sent, because
+// no connection to the worker could be made.
+func unsent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// rewind returns a copy of the request, with a fresh body, to send again.
+func rewind(req *http.Request) (*http.Request, error) {
+	again := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return again, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry %s %s: request body cannot be rewound", req.Method, req.URL)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	again.Body = body
+	return again, nil
+}
@@ -0,0 +1,159 @@
+package manager_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/manager"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyWorker answers every call with 503 Service Unavailable until it has
+// failed the given number of times, then serves its stats and tasks.
+func flakyWorker(t *testing.T, failures int, calls *int) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		if *calls <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/stats":
+			w.Write([]byte(`{"MaxConcurrent":2}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/tasks":
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestManager_RetriesFlakyWorker(t *testing.T) {
+	var calls int
+	srv := flakyWorker(t, 2, &calls)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	m := newManager(addr)
+	m.Retry = manager.RetryPolicy{Retries: 3, Backoff: time.Millisecond}
+	te := pendingEvent("web")
+	m.AddTask(te)
+	m.SendWork()
+
+	if got := m.TaskWorkerMap[te.Task.ID]; got != addr {
+		t.Fatalf("task assigned to %q, want %q", got, addr)
+	}
+	// Two failed calls for stats, one that succeeds, then the task itself.
+	if calls != 4 {
+		t.Errorf("worker called %d times, want 4", calls)
+	}
+}
+
+func TestManager_CircuitBreakerStopsCallingFailingWorker(t *testing.T) {
+	var calls int
+	srv := flakyWorker(t, 100, &calls)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	m := newManager(addr)
+	m.Retry = manager.RetryPolicy{Retries: 1, Backoff: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Hour}
+	for range 3 {
+		m.UpdateTasks()
+	}
+
+	if calls != 4 {
+		t.Errorf("worker called %d times, want 4 before the breaker opened", calls)
+	}
+	nodes := m.Nodes()
+	if len(nodes) != 1 || !strings.Contains(nodes[0].Error, manager.ErrCircuitOpen.Error()) {
+		t.Errorf("nodes = %+v, want the worker unreachable with an open breaker", nodes)
+	}
+}
+
+func TestManager_DoesNotRetrySubmittedTask(t *testing.T) {
+	var posts int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/stats":
+			w.Write([]byte(`{"MaxConcurrent":2}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/tasks":
+			// The worker may have queued the task before failing.
+			posts++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	m := newManager(strings.TrimPrefix(srv.URL, "http://"))
+	m.Retry = manager.RetryPolicy{Retries: 3, Backoff: time.Millisecond}
+	m.AddTask(pendingEvent("web"))
+	m.SendWork()
+
+	if posts != 1 {
+		t.Errorf("task sent %d times, want 1", posts)
+	}
+}
+
+func TestManager_RetriesThrottledTaskAfterRetryAfter(t *testing.T) {
+	var posts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/stats":
+			w.Write([]byte(`{"MaxConcurrent":2}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/tasks":
+			// A throttled request was turned away before the worker acted.
+			if posts.Add(1) == 1 {
+				w.Header().Set("Retry-After", "2")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	clk := clock.NewFake(time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC))
+	m := newManager(addr)
+	m.Clock = clk
+	m.Retry = manager.RetryPolicy{Retries: 1, Backoff: time.Hour}
+	te := pendingEvent("web")
+	m.AddTask(te)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.SendWork()
+	}()
+	// The retry waits the two seconds the worker asked for, not the hour
+	// of backoff.
+	var waited time.Duration
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(5 * time.Millisecond):
+			if waited >= time.Minute {
+				t.Fatalf("SendWork() still waiting after %v", waited)
+			}
+			clk.Advance(2 * time.Second)
+			waited += 2 * time.Second
+		}
+	}
+
+	if n := posts.Load(); n != 2 {
+		t.Errorf("task sent %d times, want 2", n)
+	}
+	if got := m.TaskWorkerMap[te.Task.ID]; got != addr {
+		t.Errorf("task assigned to %q, want %q", got, addr)
+	}
+}
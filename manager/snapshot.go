@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"maps"
+	"slices"
+)
+
+// ErrInvalidSnapshot is returned when a snapshot's records contradict each
+// other, such as a worker assignment for a task it does not hold.
+var ErrInvalidSnapshot = fmt.Errorf("%w: inconsistent snapshot", cubeerrors.ErrInvalidRequest)
+
+// Snapshot is a copy of the manager's entire state, for backups and for
+// moving state between manager instances.
+type Snapshot struct {
+	TaskDb        map[string][]*task.Task
+	EventDb       map[string][]*task.TaskEvent
+	WorkerTaskMap map[string][]uuid.UUID
+	TaskWorkerMap map[uuid.UUID]string
+	Pending       []task.TaskEvent
+	Jobs          map[uuid.UUID]*JobRecord
+}
+
+// Snapshot returns a copy of the manager's state that later changes to the
+// manager do not affect.
+func (m *Manager) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := Snapshot{
+		TaskDb:        make(map[string][]*task.Task, len(m.TaskDb)),
+		EventDb:       make(map[string][]*task.TaskEvent, len(m.EventDb)),
+		WorkerTaskMap: make(map[string][]uuid.UUID, len(m.WorkerTaskMap)),
+		TaskWorkerMap: maps.Clone(m.TaskWorkerMap),
+		Pending:       m.pendingEvents(),
+		Jobs:          make(map[uuid.UUID]*JobRecord, len(m.jobs)),
+	}
+	for key, history := range m.TaskDb {
+		for _, t := range history {
+			taskCopy := *t
+			s.TaskDb[key] = append(s.TaskDb[key], &taskCopy)
+		}
+	}
+	for key, events := range m.EventDb {
+		for _, te := range events {
+			eventCopy := *te
+			s.EventDb[key] = append(s.EventDb[key], &eventCopy)
+		}
+	}
+	for w, ids := range m.WorkerTaskMap {
+		s.WorkerTaskMap[w] = append([]uuid.UUID(nil), ids...)
+	}
+	for id, record := range m.jobs {
+		recordCopy := *record
+		recordCopy.Tasks = slices.Clone(record.Tasks)
+		s.Jobs[id] = &recordCopy
+	}
+	if s.TaskWorkerMap == nil {
+		s.TaskWorkerMap = make(map[uuid.UUID]string)
+	}
+	return s
+}
+
+// Validate checks that every task the snapshot assigns to a worker or holds
+// pending is in its TaskDb, and that its two worker maps agree.
+func (s Snapshot) Validate() error {
+	var errs []error
+	for w, ids := range s.WorkerTaskMap {
+		for _, id := range ids {
+			if _, ok := s.TaskDb[taskKey(id)]; !ok {
+				errs = append(errs, fmt.Errorf("task %v assigned to worker %s is not in TaskDb", id, w))
+			}
+			if got := s.TaskWorkerMap[id]; got != w {
+				errs = append(errs, fmt.Errorf("task %v is assigned to worker %s but mapped to %q", id, w, got))
+			}
+		}
+	}
+	for id, w := range s.TaskWorkerMap {
+		if _, ok := s.TaskDb[taskKey(id)]; !ok {
+			errs = append(errs, fmt.Errorf("task %v mapped to worker %s is not in TaskDb", id, w))
+		}
+	}
+	for _, te := range s.Pending {
+		if _, ok := s.TaskDb[taskKey(te.Task.ID)]; !ok {
+			errs = append(errs, fmt.Errorf("pending task %v is not in TaskDb", te.Task.ID))
+		}
+	}
+	for key, history := range s.TaskDb {
+		if len(history) == 0 {
+			errs = append(errs, fmt.Errorf("task %s has no history", key))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	return nil
+}
+
+// LoadSnapshot validates the snapshot and loads it into the manager, which
+// must not hold any tasks yet. Any jobs the manager holds are replaced.
+func (m *Manager) LoadSnapshot(s Snapshot) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.TaskDb) > 0 || m.Pending.Len() > 0 {
+		return cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "manager already holds %d tasks", len(m.TaskDb))
+	}
+
+	m.TaskDb = make(map[string][]*task.Task, len(s.TaskDb))
+	maps.Copy(m.TaskDb, s.TaskDb)
+	m.EventDb = make(map[string][]*task.TaskEvent, len(s.EventDb))
+	maps.Copy(m.EventDb, s.EventDb)
+	m.WorkerTaskMap = make(map[string][]uuid.UUID, len(s.WorkerTaskMap))
+	maps.Copy(m.WorkerTaskMap, s.WorkerTaskMap)
+	m.TaskWorkerMap = make(map[uuid.UUID]string, len(s.TaskWorkerMap))
+	maps.Copy(m.TaskWorkerMap, s.TaskWorkerMap)
+	for _, te := range s.Pending {
+		m.Pending.Enqueue(te)
+	}
+	m.jobs = make(map[uuid.UUID]*JobRecord, len(s.Jobs))
+	maps.Copy(m.jobs, s.Jobs)
+	return nil
+}
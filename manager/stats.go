@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"time"
+)
+
+// QueueStats is an overview of the manager's workload.
+type QueueStats struct {
+	// Pending is the number of tasks waiting to be scheduled
+	Pending int
+
+	// OldestPendingAge is how long the longest-waiting pending task has been
+	// queued; zero when nothing is pending
+	OldestPendingAge time.Duration
+
+	// States counts the manager's tasks by state name
+	States map[string]int
+
+	// Workers counts the unfinished tasks assigned to each worker
+	Workers map[string]int
+}
+
+// QueueStats returns an overview of the pending queue and of the tasks the
+// manager knows about.
+func (m *Manager) QueueStats() QueueStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := QueueStats{
+		Pending: m.Pending.Len(),
+		States:  make(map[string]int),
+		Workers: make(map[string]int, len(m.Workers)),
+	}
+
+	now := m.now()
+	for _, te := range m.pendingEvents() {
+		if age := now.Sub(te.Timestamp); age > stats.OldestPendingAge {
+			stats.OldestPendingAge = age
+		}
+	}
+	for key := range m.TaskDb {
+		stats.States[m.task(key).State.String()]++
+	}
+	for _, w := range m.Workers {
+		stats.Workers[w] = 0
+	}
+	for w, ids := range m.WorkerTaskMap {
+		for _, id := range ids {
+			if t := m.task(taskKey(id)); t != nil && !t.State.Terminal() {
+				stats.Workers[w]++
+			}
+		}
+	}
+	return stats
+}
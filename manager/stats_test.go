@@ -0,0 +1,42 @@
+package manager_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManager_QueueStats(t *testing.T) {
+	now := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	m := newManager("worker-1", "worker-2")
+	m.Clock = clock.NewFake(now)
+
+	for _, age := range []time.Duration{time.Minute, 10 * time.Minute} {
+		te := pendingEvent("queued")
+		te.Timestamp = now.Add(-age)
+		m.AddTask(te)
+	}
+	for _, state := range []task.State{task.Running, task.Running, task.Completed, task.Failed} {
+		tk := task.Task{ID: uuid.New(), Name: "assigned", State: state}
+		m.TaskDb[tk.ID.String()] = []*task.Task{&tk}
+		m.WorkerTaskMap["worker-1"] = append(m.WorkerTaskMap["worker-1"], tk.ID)
+		m.TaskWorkerMap[tk.ID] = "worker-1"
+	}
+
+	got := m.QueueStats()
+
+	if got.Pending != 2 || got.OldestPendingAge != 10*time.Minute {
+		t.Errorf("pending %d, oldest %v, want 2 and 10m", got.Pending, got.OldestPendingAge)
+	}
+	wantStates := map[string]int{"Pending": 2, "Running": 2, "Completed": 1, "Failed": 1}
+	if !reflect.DeepEqual(got.States, wantStates) {
+		t.Errorf("states = %v, want %v", got.States, wantStates)
+	}
+	wantWorkers := map[string]int{"worker-1": 2, "worker-2": 0}
+	if !reflect.DeepEqual(got.Workers, wantWorkers) {
+		t.Errorf("workers = %v, want %v", got.Workers, wantWorkers)
+	}
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// taskKey returns the key the task with the given ID is stored under in
+// TaskDb and EventDb.
+func taskKey(id uuid.UUID) string {
+	return id.String()
+}
+
+// normalizeKey returns key in the form taskKey gives it, so that a task ID
+// written in upper case or braces finds the same task. A key that is not a
+// UUID is returned as it is.
+func normalizeKey(key string) string {
+	id, err := uuid.Parse(key)
+	if err != nil {
+		return key
+	}
+	return taskKey(id)
+}
+
+// task returns the current version of the task stored under key, or nil.
+// The caller must hold m.mu.
+func (m *Manager) task(key string) *task.Task {
+	versions := m.TaskDb[normalizeKey(key)]
+	if len(versions) == 0 {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// assign records the task as running on worker w in both WorkerTaskMap and
+// TaskWorkerMap, first taking it off any worker it was assigned to. The
+// caller must hold m.mu.
+func (m *Manager) assign(id uuid.UUID, w string) {
+	m.unassign(id)
+	m.WorkerTaskMap[w] = append(m.WorkerTaskMap[w], id)
+	m.TaskWorkerMap[id] = w
+}
+
+// unassign removes the task from the worker it is assigned to in both
+// WorkerTaskMap and TaskWorkerMap, remembering the worker as the task's
+// previous one. The caller must hold m.mu.
+func (m *Manager) unassign(id uuid.UUID) {
+	w, ok := m.TaskWorkerMap[id]
+	if !ok {
+		return
+	}
+	m.WorkerTaskMap[w] = slices.DeleteFunc(m.WorkerTaskMap[w], func(other uuid.UUID) bool {
+		return other == id
+	})
+	delete(m.TaskWorkerMap, id)
+	if m.previous == nil {
+		m.previous = make(map[uuid.UUID]string)
+	}
+	m.previous[id] = w
+}
+
+// previousWorker returns the worker a sticky task was last assigned to.
+func (m *Manager) previousWorker(t task.Task) (string, bool) {
+	if !t.StickyWorker {
+		return "", false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w, ok := m.previous[t.ID]
+	return w, ok
+}
+
+// forget removes every record of the task: its versions, its events and
+// its assignment. The caller must hold m.mu.
+func (m *Manager) forget(id uuid.UUID) {
+	key := taskKey(id)
+	delete(m.TaskDb, key)
+	delete(m.EventDb, key)
+	delete(m.compacted, key)
+	m.unassign(id)
+	delete(m.previous, id)
+}
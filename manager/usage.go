@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Usage is the resources a task reserved, multiplied by how long it held
+// them, summed over every run of the task that has finished. It measures
+// what the task was allocated rather than what it used, for chargeback.
+type Usage struct {
+	TaskID uuid.UUID
+
+	// Runs is the number of finished runs counted, one more than the
+	// restarts for a task that finished after being restarted
+	Runs int
+
+	// Duration is the time the task's containers ran
+	Duration time.Duration
+
+	// CpuSeconds is the reserved CPUs times the seconds they were held
+	CpuSeconds float64
+
+	// MemoryMBSeconds is the reserved memory, in MB, times the seconds it
+	// was held
+	MemoryMBSeconds float64
+}
+
+// TaskUsage returns the resources the task has reserved over its finished
+// runs.
+func (m *Manager) TaskUsage(id uuid.UUID) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.task(taskKey(id)) == nil {
+		return Usage{}, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	u := m.usage[id]
+	u.TaskID = id
+	return u, nil
+}
+
+// account adds the finished run of t to its usage. Runs that never started
+// reserved nothing and are not counted. The caller must hold m.mu.
+func (m *Manager) account(t *task.Task) {
+	if t.StartTime.IsZero() || t.FinishTime.Before(t.StartTime) {
+		return
+	}
+	if m.usage == nil {
+		m.usage = make(map[uuid.UUID]Usage)
+	}
+
+	d := t.FinishTime.Sub(t.StartTime)
+	u := m.usage[t.ID]
+	u.Runs++
+	u.Duration += d
+	u.CpuSeconds += t.Cpu * d.Seconds()
+	u.MemoryMBSeconds += float64(t.Memory) * d.Seconds()
+	m.usage[t.ID] = u
+}
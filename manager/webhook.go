@@ -0,0 +1,169 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// webhookTimeout bounds each attempt to deliver a webhook.
+const webhookTimeout = 10 * time.Second
+
+// Webhook is an endpoint told of tasks that finish. A task's own
+// CompletionWebhook, when set, replaces URL and States for that task.
+type Webhook struct {
+	// URL receives a POST of each finished task as JSON; empty sends none
+	URL string
+
+	// AllowedHosts lists the hosts, as host or host:port, that a task's
+	// CompletionWebhook may post to. Tasks cannot set their own webhook
+	// while it is empty.
+	AllowedHosts []string
+
+	// States are the finished states that trigger the webhook; every
+	// finished state when empty
+	States []task.State
+
+	// Retry sets how often and how soon a failed delivery is repeated. Its
+	// breaker settings do not apply.
+	Retry RetryPolicy
+}
+
+// ErrWebhookNotAllowed is returned for a task whose CompletionWebhook is not
+// on the manager's Webhook.AllowedHosts.
+var ErrWebhookNotAllowed = fmt.Errorf("%w: webhook not allowed", cubeerrors.ErrInvalidRequest)
+
+// checkWebhook returns ErrWebhookNotAllowed unless the task's own webhook,
+// if it has one, is an http or https URL on one of the allowed hosts.
+func (h Webhook) checkWebhook(t task.Task) error {
+	if t.CompletionWebhook == "" {
+		return nil
+	}
+	u, err := url.Parse(t.CompletionWebhook)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return fmt.Errorf("%w: %q is not an http or https URL", ErrWebhookNotAllowed, t.CompletionWebhook)
+	}
+	if !slices.Contains(h.AllowedHosts, u.Host) && !slices.Contains(h.AllowedHosts, u.Hostname()) {
+		return fmt.Errorf("%w: host %s is not allowed", ErrWebhookNotAllowed, u.Host)
+	}
+	return nil
+}
+
+// webhookPayload is the task as posted to a webhook, without its
+// environment, secrets or init steps, which may hold credentials.
+func webhookPayload(t task.Task) task.Task {
+	t.Env = nil
+	t.EnvFiles = nil
+	t.Secrets = nil
+	t.InitTasks = nil
+	return t
+}
+
+// notify posts the task te finished to its webhook in the background, when
+// it has one for te's state. Deliveries are abandoned once the manager shuts
+// down. The caller must hold m.mu.
+func (m *Manager) notify(te task.TaskEvent) {
+	t := te.Task
+	t.State = te.State
+	t.Reason = te.Reason
+
+	hook := m.Webhook
+	if t.CompletionWebhook != "" {
+		// The allowed hosts may have changed since the task was submitted.
+		if err := hook.checkWebhook(t); err != nil {
+			logging.Warnf("Not notifying the webhook of task %v: %v", t.ID, err)
+			return
+		}
+		hook.URL = t.CompletionWebhook
+		hook.States = t.CompletionWebhookStates
+	}
+	if hook.URL == "" || (len(hook.States) > 0 && !slices.Contains(hook.States, t.State)) {
+		return
+	}
+	if m.isStopped() {
+		logging.Warnf("Not notifying %s of task %v: the manager is shutting down", hook.URL, t.ID)
+		return
+	}
+
+	body, err := json.Marshal(webhookPayload(t))
+	if err != nil {
+		logging.Errorf("Error encoding task %v for webhook %s: %v", t.ID, hook.URL, err)
+		return
+	}
+	stop := m.stoppedChan()
+	m.inflight.Add(1)
+	go func() {
+		defer m.inflight.Done()
+		if err := m.deliver(hook, body, stop); err != nil {
+			logging.Errorf("Error notifying %s of task %v: %v", hook.URL, t.ID, err)
+		}
+	}()
+}
+
+// deliver posts body to the webhook, retrying as its Retry says, until it is
+// accepted, the retries run out or stop is closed.
+func (m *Manager) deliver(h Webhook, body []byte, stop <-chan struct{}) error {
+	c := m.webhookClient()
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			select {
+			case <-m.after(h.Retry.delay(retry)):
+			case <-stop:
+				return fmt.Errorf("abandoned after %d attempts: the manager is shutting down", retry)
+			}
+		}
+
+		err := h.post(c, body)
+		if err == nil {
+			return nil
+		}
+		if retry == h.Retry.Retries {
+			return fmt.Errorf("giving up after %d attempts: %w", retry+1, err)
+		}
+	}
+}
+
+// webhookClient returns the manager's Client, or one limited to
+// webhookTimeout, set not to follow redirects, which could lead a delivery
+// off the allowed hosts.
+func (m *Manager) webhookClient() *http.Client {
+	c := http.Client{Timeout: webhookTimeout}
+	if m.Client != nil {
+		c = *m.Client
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return errors.New("webhooks are not redirected")
+	}
+	return &c
+}
+
+// post makes one attempt at delivering body to the webhook.
+func (h Webhook) post(c *http.Client, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package manager_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_WebhookNotifiesFinishedTasks(t *testing.T) {
+	delivered := make(chan task.Task, 1)
+	attempts := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var tk task.Task
+		if err := json.NewDecoder(r.Body).Decode(&tk); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		delivered <- tk
+	}))
+	t.Cleanup(hook.Close)
+
+	var received int
+	m := newManager(strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://"))
+	m.Webhook = manager.Webhook{
+		URL:   hook.URL,
+		Retry: manager.RetryPolicy{Retries: 2, Backoff: time.Millisecond},
+	}
+
+	te := pendingEvent("web")
+	te.Task.Env = []string{"DB_PASSWORD=hunter2"}
+	m.AddTask(te)
+	m.SendWork()
+	if err := m.StopTask(te.Task.ID, task.ReasonCancelledByUser); err != nil {
+		t.Fatalf("StopTask() error = %v", err)
+	}
+
+	select {
+	case tk := <-delivered:
+		if tk.ID != te.Task.ID || tk.State != task.Cancelled || tk.Reason != task.ReasonCancelledByUser {
+			t.Errorf("webhook got task %v %v (%q), want %v %v (%q)",
+				tk.ID, tk.State, tk.Reason, te.Task.ID, task.Cancelled, task.ReasonCancelledByUser)
+		}
+		if len(tk.Env) != 0 {
+			t.Errorf("webhook got the task's env %v, want it left out", tk.Env)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("webhook called %d times, want a failure and a retry", attempts)
+	}
+}
+
+func TestManager_TaskWebhookFiltersStates(t *testing.T) {
+	calls := make(chan struct{}, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+	}))
+	t.Cleanup(hook.Close)
+
+	var received int
+	m := newManager(strings.TrimPrefix(fakeWorker(t, worker.Stats{}, &received).URL, "http://"))
+	m.Webhook = manager.Webhook{URL: hook.URL, AllowedHosts: []string{strings.TrimPrefix(hook.URL, "http://")}}
+
+	te := pendingEvent("web")
+	te.Task.CompletionWebhook = hook.URL
+	te.Task.CompletionWebhookStates = []task.State{task.Completed, task.Failed}
+	m.AddTask(te)
+	m.SendWork()
+	if err := m.StopTask(te.Task.ID, task.ReasonCancelledByUser); err != nil {
+		t.Fatalf("StopTask() error = %v", err)
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	select {
+	case <-calls:
+		t.Error("webhook called for a cancelled task it only wants completions and failures of")
+	default:
+	}
+}
+
+func TestManager_SubmitTaskRejectsUnlistedWebhook(t *testing.T) {
+	m := newManager()
+	m.Webhook = manager.Webhook{AllowedHosts: []string{"hooks.example.com"}}
+
+	for _, hook := range []string{
+		"http://169.254.169.254/latest/meta-data",
+		"http://hooks.example.com.evil.example/",
+		"file:///etc/passwd",
+	} {
+		te := pendingEvent("web")
+		te.Task.CompletionWebhook = hook
+		if _, _, err := m.SubmitTask(te, ""); !errors.Is(err, manager.ErrWebhookNotAllowed) {
+			t.Errorf("SubmitTask() with webhook %s error = %v, want ErrWebhookNotAllowed", hook, err)
+		}
+	}
+
+	te := pendingEvent("web")
+	te.Task.CompletionWebhook = "https://hooks.example.com/cube"
+	if _, _, err := m.SubmitTask(te, ""); err != nil {
+		t.Errorf("SubmitTask() with an allowed webhook error = %v", err)
+	}
+}
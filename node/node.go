@@ -1,13 +1,36 @@
 package node
 
+// Node describes a worker machine and the resources it has to run tasks.
 type Node struct {
 	Name            string
 	Ip              string
 	Cores           int
+	CpuAllocated    float64
 	Memory          int
 	MemoryAllocated int
 	Disk            int
 	DiskAllocated   int
 	Role            string
 	TaskCount       int
+
+	// GPUs is the number of GPUs the node has, and GPUsAllocated how many of
+	// them its unfinished tasks have claimed
+	GPUs          int
+	GPUsAllocated int
+
+	// Tasks holds the names of the tasks placed on the node and not yet
+	// finished, for placement constraints such as Spread
+	Tasks []string
+
+	// Cordoned marks a node that takes no new tasks; those it runs stay
+	Cordoned bool
+
+	// MaintenanceWindow, when set, is the time of day the node takes new
+	// tasks, such as the night for a batch node; see scheduler.Windowed
+	MaintenanceWindow *Window
+}
+
+// FreeGPUs returns the number of the node's GPUs not yet claimed by a task.
+func (n *Node) FreeGPUs() int {
+	return max(n.GPUs-n.GPUsAllocated, 0)
 }
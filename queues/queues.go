@@ -0,0 +1,100 @@
+// Package queues adds typed helpers to the golang-collections queue the
+// manager and worker hold their tasks in. Its Dequeue is the only way to reach
+// items past the head, so each helper walks the queue by dequeuing every item
+// and enqueuing it again, leaving the queue in its original order unless it
+// says otherwise. An item that is not of the type asked for, such as a nil
+// enqueued by mistake, is logged and dropped rather than left to panic a type
+// assertion. None of them is safe for concurrent use; callers guard the queue
+// with their own lock.
+package queues
+
+import (
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/golang-collections/collections/queue"
+)
+
+// Dequeue removes and returns the first T in q, dropping any other items
+// ahead of it, or returns false when q holds no T.
+func Dequeue[T any](q *queue.Queue) (T, bool) {
+	for q.Len() > 0 {
+		if item, ok := take[T](q); ok {
+			return item, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// Peek returns the first T in q without removing it, dropping any other
+// items ahead of it, or returns false when q holds no T.
+func Peek[T any](q *queue.Queue) (T, bool) {
+	for q.Len() > 0 {
+		if item, ok := q.Peek().(T); ok {
+			return item, true
+		}
+		take[T](q)
+	}
+	var zero T
+	return zero, false
+}
+
+// take removes the item at the head of q, which must not be empty, and
+// returns it when it is a T. Any other item is logged and dropped.
+func take[T any](q *queue.Queue) (T, bool) {
+	v := q.Dequeue()
+	item, ok := v.(T)
+	if !ok {
+		logging.Warnf("Dropping queued item of unexpected type %T: %v", v, v)
+	}
+	return item, ok
+}
+
+// Find returns the first item in q that match accepts, without removing it.
+func Find[T any](q *queue.Queue, match func(T) bool) (T, bool) {
+	var found T
+	ok := false
+	for n := q.Len(); n > 0; n-- {
+		item, valid := take[T](q)
+		if !valid {
+			continue
+		}
+		if !ok && match(item) {
+			found, ok = item, true
+		}
+		q.Enqueue(item)
+	}
+	return found, ok
+}
+
+// Remove takes the first item in q that match accepts out of q. The other
+// items keep their order.
+func Remove[T any](q *queue.Queue, match func(T) bool) (T, bool) {
+	var removed T
+	ok := false
+	for n := q.Len(); n > 0; n-- {
+		item, valid := take[T](q)
+		if !valid {
+			continue
+		}
+		if !ok && match(item) {
+			removed, ok = item, true
+			continue
+		}
+		q.Enqueue(item)
+	}
+	return removed, ok
+}
+
+// Items returns the items in q, head first.
+func Items[T any](q *queue.Queue) []T {
+	items := make([]T, 0, q.Len())
+	for n := q.Len(); n > 0; n-- {
+		item, valid := take[T](q)
+		if !valid {
+			continue
+		}
+		items = append(items, item)
+		q.Enqueue(item)
+	}
+	return items
+}
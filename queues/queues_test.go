@@ -0,0 +1,100 @@
+package queues_test
+
+import (
+	"github.com/christinavaneyssen/cube/queues"
+	"github.com/golang-collections/collections/queue"
+	"slices"
+	"testing"
+)
+
+func newQueue(items ...int) *queue.Queue {
+	q := queue.New()
+	for _, item := range items {
+		q.Enqueue(item)
+	}
+	return q
+}
+
+func TestPeek(t *testing.T) {
+	q := newQueue(1, 2, 3)
+	for range 2 {
+		if got, ok := queues.Peek[int](q); !ok || got != 1 {
+			t.Errorf("Peek() = %d, %v, want 1, true", got, ok)
+		}
+	}
+	if q.Len() != 3 {
+		t.Errorf("queue holds %d items after peeking, want 3", q.Len())
+	}
+
+	if _, ok := queues.Peek[int](queue.New()); ok {
+		t.Error("Peek() on an empty queue found an item")
+	}
+}
+
+func TestFind(t *testing.T) {
+	q := newQueue(1, 2, 3, 4)
+	got, ok := queues.Find(q, func(i int) bool { return i%2 == 0 })
+	if !ok || got != 2 {
+		t.Errorf("Find() = %d, %v, want 2, true", got, ok)
+	}
+	if items := queues.Items[int](q); !slices.Equal(items, []int{1, 2, 3, 4}) {
+		t.Errorf("queue holds %v after Find, want it unchanged", items)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	q := newQueue(1, 2, 3, 4)
+	got, ok := queues.Remove(q, func(i int) bool { return i%2 == 0 })
+	if !ok || got != 2 {
+		t.Errorf("Remove() = %d, %v, want 2, true", got, ok)
+	}
+	if items := queues.Items[int](q); !slices.Equal(items, []int{1, 3, 4}) {
+		t.Errorf("queue holds %v after Remove, want [1 3 4]", items)
+	}
+
+	if _, ok := queues.Remove(q, func(i int) bool { return i > 10 }); ok || q.Len() != 3 {
+		t.Errorf("Remove() without a match = %v, leaving %d items; want false and 3", ok, q.Len())
+	}
+}
+
+func TestDequeue(t *testing.T) {
+	q := newQueue(1, 2)
+	if got, ok := queues.Dequeue[int](q); !ok || got != 1 || q.Len() != 1 {
+		t.Errorf("Dequeue() = %d, %v leaving %d items, want 1, true leaving 1", got, ok, q.Len())
+	}
+
+	if _, ok := queues.Dequeue[int](queue.New()); ok {
+		t.Error("Dequeue() on an empty queue found an item")
+	}
+
+	q = queue.New()
+	q.Enqueue(nil)
+	q.Enqueue("not an int")
+	q.Enqueue(3)
+	q.Enqueue(4)
+	if got, ok := queues.Dequeue[int](q); !ok || got != 3 {
+		t.Errorf("Dequeue() past a nil head = %d, %v, want 3, true", got, ok)
+	}
+	if items := queues.Items[int](q); !slices.Equal(items, []int{4}) {
+		t.Errorf("queue holds %v after dropping the nil head, want [4]", items)
+	}
+
+	q = queue.New()
+	q.Enqueue(nil)
+	if _, ok := queues.Dequeue[int](q); ok || q.Len() != 0 {
+		t.Errorf("Dequeue() of only a nil = %v leaving %d items, want false leaving 0", ok, q.Len())
+	}
+}
+
+func TestPeekSkipsOtherTypes(t *testing.T) {
+	q := queue.New()
+	q.Enqueue(nil)
+	q.Enqueue(3)
+	q.Enqueue(4)
+	if got, ok := queues.Peek[int](q); !ok || got != 3 {
+		t.Errorf("Peek() past a nil head = %d, %v, want 3, true", got, ok)
+	}
+	if items := queues.Items[int](q); !slices.Equal(items, []int{3, 4}) {
+		t.Errorf("queue holds %v after Peek, want [3 4]", items)
+	}
+}
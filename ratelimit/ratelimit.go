@@ -0,0 +1,114 @@
+// Package ratelimit limits how often each client may call an API, so an
+// accidental flood of requests from one client cannot swamp a server.
+package ratelimit
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxClients is the number of clients tracked before the buckets of those
+// that have gone quiet are dropped.
+const maxClients = 10000
+
+// Limiter is a token bucket per client. Each client may make Burst requests
+// at once, and gets back Rate requests a second after that. It is safe for
+// concurrent use.
+type Limiter struct {
+	// Rate is the number of requests a second each client is allowed
+	Rate float64
+
+	// Burst is the number of requests a client may make at once
+	Burst int
+
+	// Clock tells the time tokens are refilled by; the system clock when nil
+	Clock clock.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds a client's unspent requests as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Allow spends one of the client's requests and reports whether it had one
+// to spend.
+func (l *Limiter) Allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	if len(l.buckets) >= maxClients {
+		l.dropFull(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Middleware rejects requests from clients, told apart by remote IP, that
+// have run out of requests with 429 Too Many Requests, and passes the rest
+// to next.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if !l.Allow(client) {
+			logging.Warnf("Rate limit exceeded by %s for %s %s", client, r.Method, r.URL.Path)
+			if l.Rate > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/l.Rate))))
+			}
+			cubeerrors.WriteHTTP(w, cubeerrors.Newf(cubeerrors.ErrOverloaded, "Rate limit exceeded"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refill returns the bucket's tokens topped up for the time since it was
+// last used, up to Burst.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return min(b.tokens+now.Sub(b.last).Seconds()*l.Rate, float64(l.Burst))
+}
+
+// dropFull forgets the clients whose buckets have refilled completely,
+// which are treated the same as clients never seen. The caller must hold
+// l.mu.
+func (l *Limiter) dropFull(now time.Time) {
+	for client, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Clock == nil {
+		return time.Now()
+	}
+	return l.Clock.Now()
+}
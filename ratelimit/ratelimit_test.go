@@ -0,0 +1,51 @@
+package ratelimit_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddleware_RejectsRequestsOverLimit(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := &ratelimit.Limiter{Rate: 1, Burst: 3, Clock: clk}
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(remote string) int {
+		req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	var allowed, rejected int
+	for range 5 {
+		switch code := serve("10.0.0.1:4000"); code {
+		case http.StatusOK:
+			allowed++
+		case http.StatusTooManyRequests:
+			rejected++
+		default:
+			t.Fatalf("status = %d", code)
+		}
+	}
+	if allowed != 3 || rejected != 2 {
+		t.Errorf("allowed %d and rejected %d requests, want 3 and 2", allowed, rejected)
+	}
+
+	if code := serve("10.0.0.2:4000"); code != http.StatusOK {
+		t.Errorf("another client got status %d, want %d", code, http.StatusOK)
+	}
+	if code := serve("10.0.0.1:4001"); code != http.StatusTooManyRequests {
+		t.Errorf("same client from another port got status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	clk.Advance(time.Second)
+	if code := serve("10.0.0.1:4000"); code != http.StatusOK {
+		t.Errorf("after a second status = %d, want %d", code, http.StatusOK)
+	}
+}
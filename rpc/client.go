@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Client calls cube.Tasks on a manager or worker, exchanging the task types
+// the REST APIs use.
+type Client struct {
+	tc TasksClient
+}
+
+// NewClient returns a client making its calls over cc.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{tc: NewTasksClient(cc)}
+}
+
+// SubmitTask queues the task in te, replacing an unfinished task of the same
+// name when replace is set. It reports whether a task was created, which it
+// was not when key repeats an earlier submission.
+func (c *Client) SubmitTask(ctx context.Context, te task.TaskEvent, key string, replace bool) (task.Task, bool, error) {
+	var t task.Task
+	event, err := json.Marshal(te)
+	if err != nil {
+		return t, false, err
+	}
+	var trailer metadata.MD
+	resp, err := c.tc.SubmitTask(ctx, &SubmitTaskRequest{Event: event, IdempotencyKey: key, Replace: replace}, grpc.Trailer(&trailer))
+	if err != nil {
+		return t, false, fromStatus(err, trailer)
+	}
+	err = json.Unmarshal(resp.Task, &t)
+	return t, resp.Created, err
+}
+
+// GetTask returns the task with the given ID.
+func (c *Client) GetTask(ctx context.Context, id uuid.UUID) (task.Task, error) {
+	var t task.Task
+	var trailer metadata.MD
+	resp, err := c.tc.GetTask(ctx, &TaskRequest{Id: id.String()}, grpc.Trailer(&trailer))
+	if err != nil {
+		return t, fromStatus(err, trailer)
+	}
+	err = json.Unmarshal(resp.Task, &t)
+	return t, err
+}
+
+// ListTasks returns every task the server knows about.
+func (c *Client) ListTasks(ctx context.Context) ([]*task.Task, error) {
+	var trailer metadata.MD
+	resp, err := c.tc.ListTasks(ctx, &ListTasksRequest{}, grpc.Trailer(&trailer))
+	if err != nil {
+		return nil, fromStatus(err, trailer)
+	}
+	tasks := make([]*task.Task, 0, len(resp.Tasks))
+	for _, data := range resp.Tasks {
+		var t task.Task
+		if err := json.Unmarshal(data, &t); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, &t)
+	}
+	return tasks, nil
+}
+
+// StopTask stops the task with the given ID, recording reason as why.
+func (c *Client) StopTask(ctx context.Context, id uuid.UUID, reason string) error {
+	var trailer metadata.MD
+	_, err := c.tc.StopTask(ctx, &TaskRequest{Id: id.String(), Reason: reason}, grpc.Trailer(&trailer))
+	return fromStatus(err, trailer)
+}
+
+// Stats decodes the server's stats into v, which should point to a
+// manager.QueueStats or a worker.Stats to match the server.
+func (c *Client) Stats(ctx context.Context, v any) error {
+	var trailer metadata.MD
+	resp, err := c.tc.Stats(ctx, &StatsRequest{}, grpc.Trailer(&trailer))
+	if err != nil {
+		return fromStatus(err, trailer)
+	}
+	return json.Unmarshal(resp.Stats, v)
+}
+
+// fromStatus returns an error matching the kind the server reported err
+// with in trailer, or err itself when it reported none.
+func fromStatus(err error, trailer metadata.MD) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	codes := trailer.Get(errorCodeKey)
+	if len(codes) == 0 {
+		return err
+	}
+	kind := cubeerrors.Kind(codes[0])
+	if kind == nil {
+		return err
+	}
+	return cubeerrors.Newf(kind, "%s", s.Message())
+}
@@ -0,0 +1,75 @@
+// Package rpc serves the task operations of the manager and worker APIs over
+// gRPC, for callers inside the cluster that would rather hold a connection
+// open than make an HTTP request per call.
+//
+// The cube.Tasks service is described in cube.proto. Its messages carry the
+// same task documents the REST APIs use, as JSON, so both APIs accept and
+// return exactly the same tasks.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cube.proto
+
+import (
+	"context"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodeKey is the trailer carrying the errors.Code of a failed call, so
+// the client can report the same kind of error.
+const errorCodeKey = "cube-error-code"
+
+// NewServer returns a gRPC server serving cube.Tasks from srv, reporting
+// each error to the client with the kind it matches.
+func NewServer(srv TasksServer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append(opts, grpc.ChainUnaryInterceptor(statusInterceptor))...)
+	RegisterTasksServer(s, srv)
+	return s
+}
+
+// statusInterceptor turns the error a call fails with into a status.
+func statusInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return resp, nil
+}
+
+// toStatus returns the gRPC status for err, recording its errors.Code in the
+// trailer.
+func toStatus(ctx context.Context, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs(errorCodeKey, cubeerrors.Code(err)))
+	return status.Error(grpcCode(err), err.Error())
+}
+
+// grpcCode returns the gRPC code closest to the HTTP status the REST APIs
+// answer err with.
+func grpcCode(err error) codes.Code {
+	switch {
+	case cubeerrors.Is(err, cubeerrors.ErrInvalidRequest):
+		return codes.InvalidArgument
+	case cubeerrors.Is(err, cubeerrors.ErrNotFound):
+		return codes.NotFound
+	case cubeerrors.Is(err, cubeerrors.ErrInvalidState):
+		return codes.FailedPrecondition
+	case cubeerrors.Is(err, cubeerrors.ErrNoCapacity),
+		cubeerrors.Is(err, cubeerrors.ErrWorkerUnavailable),
+		cubeerrors.Is(err, cubeerrors.ErrImagePull):
+		return codes.Unavailable
+	case cubeerrors.Is(err, cubeerrors.ErrOverloaded):
+		return codes.ResourceExhausted
+	case cubeerrors.Is(err, cubeerrors.ErrQuotaExceeded):
+		return codes.PermissionDenied
+	case cubeerrors.Is(err, cubeerrors.ErrAPIVersionTooOld):
+		return codes.Unimplemented
+	default:
+		return codes.Internal
+	}
+}
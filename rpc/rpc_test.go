@@ -0,0 +1,118 @@
+package rpc_test
+
+import (
+	"context"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/rpc"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+	"time"
+)
+
+// dial serves srv over an in-memory listener and returns a client for it.
+func dial(t *testing.T, srv rpc.TasksServer) *rpc.Client {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := rpc.NewServer(srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return rpc.NewClient(cc)
+}
+
+func newEvent(name string) task.TaskEvent {
+	return task.TaskEvent{
+		ID:        uuid.New(),
+		State:     task.Pending,
+		Timestamp: time.Now(),
+		Task:      task.Task{ID: uuid.New(), Name: name, State: task.Pending, Image: "strm/helloworld-http"},
+	}
+}
+
+func TestManagerServer_SubmitTaskRoundTrip(t *testing.T) {
+	m := &manager.Manager{
+		TaskDb:        make(map[string][]*task.Task),
+		EventDb:       make(map[string][]*task.TaskEvent),
+		WorkerTaskMap: make(map[string][]uuid.UUID),
+		TaskWorkerMap: make(map[uuid.UUID]string),
+	}
+	c := dial(t, &rpc.ManagerServer{Manager: m})
+	ctx := context.Background()
+
+	te := newEvent("web")
+	got, created, err := c.SubmitTask(ctx, te, "key-1", false)
+	if err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	if !created || got.ID != te.Task.ID || got.State != task.Pending {
+		t.Errorf("SubmitTask() = %+v, created %v, want pending task %v created", got, created, te.Task.ID)
+	}
+
+	// Repeating the key returns the first task rather than queueing another.
+	again, created, err := c.SubmitTask(ctx, newEvent("web"), "key-1", false)
+	if err != nil || created || again.ID != te.Task.ID {
+		t.Errorf("repeated SubmitTask() = %v, created %v, error %v; want task %v, not created", again.ID, created, err, te.Task.ID)
+	}
+
+	if got, err := c.GetTask(ctx, te.Task.ID); err != nil || got.Name != "web" {
+		t.Errorf("GetTask() = %+v, %v; want task web", got, err)
+	}
+	if tasks, err := c.ListTasks(ctx); err != nil || len(tasks) != 1 {
+		t.Errorf("ListTasks() = %d tasks, %v; want 1", len(tasks), err)
+	}
+
+	var stats manager.QueueStats
+	if err := c.Stats(ctx, &stats); err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Pending != 1 {
+		t.Errorf("Stats().Pending = %d, want 1", stats.Pending)
+	}
+
+	// The task was never placed, so stopping it fails with the same kind of
+	// error the REST API reports.
+	if err := c.StopTask(ctx, te.Task.ID, ""); !cubeerrors.Is(err, cubeerrors.ErrNotFound) {
+		t.Errorf("StopTask() error = %v, want ErrNotFound", err)
+	}
+	if _, err := c.GetTask(ctx, uuid.New()); !cubeerrors.Is(err, cubeerrors.ErrNotFound) {
+		t.Errorf("GetTask(unknown) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWorkerServer_SubmitAndStopTask(t *testing.T) {
+	w := &worker.Worker{
+		Name:  "test-worker",
+		Queue: *queue.New(),
+		Db:    make(map[uuid.UUID]*task.Task),
+	}
+	c := dial(t, &rpc.WorkerServer{Worker: w})
+	ctx := context.Background()
+
+	te := newEvent("web")
+	te.Task.State = task.Scheduled
+	if _, _, err := c.SubmitTask(ctx, te, "", false); err != nil {
+		t.Fatalf("SubmitTask() error = %v", err)
+	}
+	if w.Queue.Len() != 1 {
+		t.Fatalf("worker queue holds %d tasks, want 1", w.Queue.Len())
+	}
+
+	if err := c.StopTask(ctx, uuid.New(), ""); !cubeerrors.Is(err, cubeerrors.ErrNotFound) {
+		t.Errorf("StopTask(unknown) error = %v, want ErrNotFound", err)
+	}
+}
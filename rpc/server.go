@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+)
+
+// ManagerServer serves cube.Tasks from a manager, as its REST API does.
+type ManagerServer struct {
+	UnimplementedTasksServer
+
+	Manager *manager.Manager
+}
+
+// SubmitTask queues the task for scheduling; see Manager.Submit.
+func (s *ManagerServer) SubmitTask(ctx context.Context, req *SubmitTaskRequest) (*TaskResponse, error) {
+	te, err := decodeEvent(req.Event)
+	if err != nil {
+		return nil, err
+	}
+	t, created, err := s.Manager.Submit(te, req.IdempotencyKey, req.Replace)
+	if err != nil {
+		return nil, err
+	}
+	return taskResponse(&t, created)
+}
+
+// GetTask returns the task with the requested ID.
+func (s *ManagerServer) GetTask(ctx context.Context, req *TaskRequest) (*TaskResponse, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.Manager.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	return taskResponse(t, false)
+}
+
+// ListTasks lists every task the manager knows about.
+func (s *ManagerServer) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTasksResponse, error) {
+	return listTasksResponse(s.Manager.GetTasks())
+}
+
+// StopTask cancels the task with the requested ID, on behalf of the user
+// unless the request gives a reason.
+func (s *ManagerServer) StopTask(ctx context.Context, req *TaskRequest) (*StopTaskResponse, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = task.ReasonCancelledByUser
+	}
+	if err := s.Manager.StopTask(id, reason); err != nil {
+		return nil, err
+	}
+	return &StopTaskResponse{}, nil
+}
+
+// Stats returns the manager's QueueStats.
+func (s *ManagerServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	return statsResponse(s.Manager.QueueStats())
+}
+
+// WorkerServer serves cube.Tasks from a worker, as its REST API does.
+type WorkerServer struct {
+	UnimplementedTasksServer
+
+	Worker *worker.Worker
+}
+
+// SubmitTask queues the task to run or stop; see Worker.SubmitTask.
+func (s *WorkerServer) SubmitTask(ctx context.Context, req *SubmitTaskRequest) (*TaskResponse, error) {
+	te, err := decodeEvent(req.Event)
+	if err != nil {
+		return nil, err
+	}
+	t := s.Worker.SubmitTask(te)
+	return taskResponse(&t, true)
+}
+
+// GetTask returns the task with the requested ID.
+func (s *WorkerServer) GetTask(ctx context.Context, req *TaskRequest) (*TaskResponse, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.Worker.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	return taskResponse(t, false)
+}
+
+// ListTasks lists every task on the worker.
+func (s *WorkerServer) ListTasks(ctx context.Context, req *ListTasksRequest) (*ListTasksResponse, error) {
+	return listTasksResponse(s.Worker.GetTasks())
+}
+
+// StopTask queues the task with the requested ID to be stopped; see
+// Worker.CancelTask.
+func (s *WorkerServer) StopTask(ctx context.Context, req *TaskRequest) (*StopTaskResponse, error) {
+	id, err := parseID(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Worker.CancelTask(id, req.Reason); err != nil {
+		return nil, err
+	}
+	return &StopTaskResponse{}, nil
+}
+
+// Stats returns the worker's current load.
+func (s *WorkerServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	return statsResponse(s.Worker.CollectStats())
+}
+
+// decodeEvent decodes the task event a SubmitTaskRequest carries.
+func decodeEvent(data []byte) (task.TaskEvent, error) {
+	var te task.TaskEvent
+	if err := json.Unmarshal(data, &te); err != nil {
+		return te, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err)
+	}
+	return te, nil
+}
+
+// parseID parses the task ID a TaskRequest carries.
+func parseID(id string) (uuid.UUID, error) {
+	taskID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err)
+	}
+	return taskID, nil
+}
+
+func taskResponse(t *task.Task, created bool) (*TaskResponse, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskResponse{Task: data, Created: created}, nil
+}
+
+func listTasksResponse(tasks []*task.Task) (*ListTasksResponse, error) {
+	resp := &ListTasksResponse{Tasks: make([][]byte, 0, len(tasks))}
+	for _, t := range tasks {
+		data, err := json.Marshal(t)
+		if err != nil {
+			return nil, err
+		}
+		resp.Tasks = append(resp.Tasks, data)
+	}
+	return resp, nil
+}
+
+func statsResponse(stats any) (*StatsResponse, error) {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsResponse{Stats: data}, nil
+}
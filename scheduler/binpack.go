@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+)
+
+// FirstFit places each task on the first node, in the order given, that has
+// room for it. Filling nodes in order leaves those at the end idle, so they
+// can be scaled away.
+type FirstFit struct {
+	// Overcommit lets each node fill up to its stretched capacity before
+	// tasks move on to the next
+	Overcommit Overcommit
+}
+
+// SelectCandidateNodes returns the nodes that fit the task, in the order
+// given, which is the order Score ranks them in.
+func (f FirstFit) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return fits(t, nodes, f.Overcommit)
+}
+
+// Score ranks the candidates by position, the first scoring highest.
+func (f FirstFit) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64, len(nodes))
+	for i, n := range nodes {
+		scores[n.Name] = float64(len(nodes) - i)
+	}
+	return scores
+}
+
+// Pick returns the candidate with the highest score.
+func (f FirstFit) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return highest(scores, candidates)
+}
+
+// BestFit places each task on the node it leaves with the least spare
+// capacity, packing tasks tightly so large tasks still find a node with
+// room.
+type BestFit struct {
+	// Overcommit stretches node capacity both for the room check and for
+	// the spare capacity Score measures a fit by
+	Overcommit Overcommit
+}
+
+// SelectCandidateNodes returns the nodes that fit the task, among which
+// Score finds the tightest fit.
+func (b BestFit) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return fits(t, nodes, b.Overcommit)
+}
+
+// Score rates each candidate by the capacity it would have left once the
+// task is placed, as the sum of the fractions of its CPU, memory and disk
+// left free, negated so the tightest fit scores highest. Resources a node
+// does not report are left out.
+func (b BestFit) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := make(map[string]float64, len(nodes))
+	for _, n := range nodes {
+		var left float64
+		if n.Cores > 0 {
+			capacity := float64(n.Cores) * ratio(b.Overcommit.Cpu)
+			left += (capacity - n.CpuAllocated - t.Cpu) / capacity
+		}
+		if n.Memory > 0 {
+			capacity := float64(n.Memory) * ratio(b.Overcommit.Memory)
+			left += (capacity - float64(n.MemoryAllocated+t.MemoryClaim())) / capacity
+		}
+		if n.Disk > 0 {
+			left += float64(n.Disk-n.DiskAllocated-t.Disk) / float64(n.Disk)
+		}
+		scores[n.Name] = -left
+	}
+	return scores
+}
+
+// Pick returns the candidate with the highest score.
+func (b BestFit) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	return highest(scores, candidates)
+}
+
+// highest returns the candidate with the highest score, the earliest of
+// those tied, or nil when there are none.
+func highest(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	for _, n := range candidates {
+		if best == nil || scores[n.Name] > scores[best.Name] {
+			best = n
+		}
+	}
+	return best
+}
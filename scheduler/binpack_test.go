@@ -0,0 +1,39 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+)
+
+func TestBinPacking_PlacementChoices(t *testing.T) {
+	cluster := func() []*node.Node {
+		return []*node.Node{
+			{Name: "roomy", Cores: 8, Memory: 8192, MemoryAllocated: 1024},
+			{Name: "full", Cores: 2, Memory: 2048, MemoryAllocated: 2048},
+			{Name: "snug", Cores: 4, Memory: 4096, CpuAllocated: 3, MemoryAllocated: 3072},
+		}
+	}
+	web := task.Task{Name: "web", Cpu: 1, Memory: 512}
+
+	tests := []struct {
+		name string
+		s    scheduler.Scheduler
+		want string
+	}{
+		{"first fit", scheduler.FirstFit{}, "roomy"},
+		{"best fit", scheduler.BestFit{}, "snug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := tt.s.SelectCandidateNodes(web, cluster())
+			if len(candidates) != 2 {
+				t.Fatalf("got %d candidates, want the 2 nodes with room", len(candidates))
+			}
+			if picked := tt.s.Pick(tt.s.Score(web, candidates), candidates); picked.Name != tt.want {
+				t.Errorf("picked %s, want %s", picked.Name, tt.want)
+			}
+		})
+	}
+}
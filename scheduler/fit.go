@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+)
+
+// Overcommit sets how far a node's CPU and memory may be allocated beyond
+// what it has, for clusters whose tasks rarely use all they ask for. A node
+// with 4 cores and a Cpu ratio of 2 takes tasks asking for up to 8 cores in
+// total. Ratios of zero count as 1, which allows no overcommit.
+type Overcommit struct {
+	Cpu    float64
+	Memory float64
+}
+
+// CanFit reports whether the node has room for the task's CPU, memory and
+// disk on top of what its unfinished tasks already claim, counting CPU and
+// memory at their overcommitted capacity. Memory is counted by the task's
+// MemoryClaim, so a task with a reservation fits where its hard limit would
+// not. A resource whose capacity the node does not report is not checked.
+func (o Overcommit) CanFit(t task.Task, n *node.Node) bool {
+	if n.Cores > 0 && n.CpuAllocated+t.Cpu > float64(n.Cores)*ratio(o.Cpu) {
+		return false
+	}
+	if n.Memory > 0 && float64(n.MemoryAllocated+t.MemoryClaim()) > float64(n.Memory)*ratio(o.Memory) {
+		return false
+	}
+	if n.Disk > 0 && n.DiskAllocated+t.Disk > n.Disk {
+		return false
+	}
+	return true
+}
+
+func ratio(r float64) float64 {
+	if r <= 0 {
+		return 1
+	}
+	return r
+}
+
+// fits returns the nodes any scheduler here may place the task on: those
+// uncordoned, with enough free GPUs for it and with room for it under the
+// overcommit ratios.
+func fits(t task.Task, nodes []*node.Node, o Overcommit) []*node.Node {
+	return withRoom(t, withFreeGPUs(t, uncordoned(nodes)), o)
+}
+
+// withRoom returns the nodes that can fit the task under the overcommit
+// ratios.
+func withRoom(t task.Task, nodes []*node.Node, o Overcommit) []*node.Node {
+	var fit []*node.Node
+	for _, n := range nodes {
+		if o.CanFit(t, n) {
+			fit = append(fit, n)
+		}
+	}
+	return fit
+}
+
+// uncordoned returns the nodes that are not cordoned.
+func uncordoned(nodes []*node.Node) []*node.Node {
+	var open []*node.Node
+	for _, n := range nodes {
+		if !n.Cordoned {
+			open = append(open, n)
+		}
+	}
+	return open
+}
@@ -0,0 +1,58 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+)
+
+func TestOvercommit_CanFit(t *testing.T) {
+	n := &node.Node{Name: "busy", Cores: 2, CpuAllocated: 1.5, Memory: 1024, MemoryAllocated: 900}
+	web := task.Task{Name: "web", Cpu: 1, Memory: 200}
+
+	if (scheduler.Overcommit{}).CanFit(web, n) {
+		t.Error("task fits a full node without overcommit")
+	}
+	if (scheduler.Overcommit{Cpu: 2}).CanFit(web, n) {
+		t.Error("task fits with only CPU overcommitted, though memory is short")
+	}
+	over := scheduler.Overcommit{Cpu: 2, Memory: 1.2}
+	if !over.CanFit(web, n) {
+		t.Error("task does not fit with CPU and memory overcommitted")
+	}
+
+	s := &scheduler.WeightedRoundRobin{}
+	if got := s.SelectCandidateNodes(web, []*node.Node{n}); len(got) != 0 {
+		t.Errorf("candidates without overcommit = %v, want none", got)
+	}
+	s.Overcommit = over
+	if got := s.SelectCandidateNodes(web, []*node.Node{n}); len(got) != 1 {
+		t.Errorf("candidates with overcommit = %v, want the busy node", got)
+	}
+}
+
+func TestOvercommit_CanFitByReservation(t *testing.T) {
+	n := &node.Node{Name: "small", Memory: 1024}
+	worker := task.Task{Name: "worker", Memory: 512, MemoryReservation: 256}
+
+	var placed int
+	for (scheduler.Overcommit{}).CanFit(worker, n) {
+		n.MemoryAllocated += worker.MemoryClaim()
+		placed++
+	}
+	if placed != 4 {
+		t.Errorf("placed %d tasks reserving 256 MB on a 1024 MB node, want 4", placed)
+	}
+
+	n.MemoryAllocated = 0
+	worker.MemoryReservation = 0
+	placed = 0
+	for (scheduler.Overcommit{}).CanFit(worker, n) {
+		n.MemoryAllocated += worker.MemoryClaim()
+		placed++
+	}
+	if placed != 2 {
+		t.Errorf("placed %d tasks limited to 512 MB on a 1024 MB node, want 2", placed)
+	}
+}
@@ -0,0 +1,22 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+)
+
+// withFreeGPUs returns the nodes with at least as many free GPUs as the task
+// requests. Every node qualifies for a task that requests none.
+func withFreeGPUs(t task.Task, nodes []*node.Node) []*node.Node {
+	if t.GPUs == 0 {
+		return nodes
+	}
+
+	var fit []*node.Node
+	for _, n := range nodes {
+		if n.FreeGPUs() >= t.GPUs {
+			fit = append(fit, n)
+		}
+	}
+	return fit
+}
@@ -0,0 +1,30 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+)
+
+func TestWeightedRoundRobin_GPUTask(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "cpu-only", Cores: 32, Memory: 65536},
+		{Name: "busy-gpu", Cores: 8, Memory: 16384, GPUs: 2, GPUsAllocated: 1},
+		{Name: "gpu", Cores: 8, Memory: 16384, GPUs: 4, GPUsAllocated: 1},
+	}
+	s := &scheduler.WeightedRoundRobin{}
+	training := task.Task{Name: "train", GPUs: 2}
+
+	candidates := s.SelectCandidateNodes(training, nodes)
+	if len(candidates) != 1 || candidates[0].Name != "gpu" {
+		t.Fatalf("candidates = %v, want only the gpu node", candidates)
+	}
+	if picked := s.Pick(s.Score(training, candidates), candidates); picked.Name != "gpu" {
+		t.Errorf("picked %s, want gpu", picked.Name)
+	}
+
+	if got := s.SelectCandidateNodes(task.Task{Name: "web"}, nodes); len(got) != len(nodes) {
+		t.Errorf("task without GPUs has %d candidates, want %d", len(got), len(nodes))
+	}
+}
@@ -0,0 +1,45 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"math"
+	"slices"
+)
+
+// Prefer adds soft placement preferences to another Scheduler: candidates
+// named in the task's PreferredNodes score above every other candidate, so
+// one of them is picked whenever one can run the task. The preference never
+// removes a node from the candidates, so a task whose preferred nodes are
+// full, or gone, is still placed on another.
+type Prefer struct {
+	Scheduler
+}
+
+// Score raises the wrapped scheduler's score of each preferred candidate
+// above every other candidate's; see Boost.
+func (p Prefer) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	scores := p.Scheduler.Score(t, nodes)
+	Boost(scores, t.PreferredNodes...)
+	return scores
+}
+
+// Boost raises the scores of the named nodes by more than the spread of all
+// the scores, so any of them outscores every node not named while the named
+// nodes keep their order among themselves.
+func Boost(scores map[string]float64, names ...string) {
+	if len(names) == 0 || len(scores) == 0 {
+		return
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range scores {
+		lo, hi = min(lo, s), max(hi, s)
+	}
+	bonus := hi - lo + 1
+	for name := range scores {
+		if slices.Contains(names, name) {
+			scores[name] += bonus
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+)
+
+func TestPrefer_PicksPreferredNode(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "large", Cores: 8, Memory: 8192},
+		{Name: "small", Cores: 1, Memory: 1024},
+	}
+	s := scheduler.Prefer{Scheduler: &scheduler.WeightedRoundRobin{}}
+	tk := task.Task{Name: "web", PreferredNodes: []string{"small"}}
+
+	for range 3 {
+		candidates := s.SelectCandidateNodes(tk, nodes)
+		if picked := s.Pick(s.Score(tk, candidates), candidates); picked.Name != "small" {
+			t.Fatalf("picked %s, want the preferred node small", picked.Name)
+		}
+	}
+}
+
+func TestPrefer_FallsBackWhenPreferredNodeIsFull(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "full", Cores: 1, Memory: 1024, MemoryAllocated: 1024},
+		{Name: "free", Cores: 1, Memory: 1024},
+	}
+	s := scheduler.Prefer{Scheduler: &scheduler.WeightedRoundRobin{}}
+	tk := task.Task{Name: "web", Memory: 512, PreferredNodes: []string{"full"}}
+
+	candidates := s.SelectCandidateNodes(tk, nodes)
+	picked := s.Pick(s.Score(tk, candidates), candidates)
+	if picked == nil || picked.Name != "free" {
+		t.Fatalf("picked %v, want free", picked)
+	}
+}
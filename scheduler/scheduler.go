@@ -1,7 +1,20 @@
+// Package scheduler decides which node in the cluster a task runs on.
 package scheduler
 
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+)
+
+// Scheduler places tasks on nodes in three steps: filter the nodes that can
+// run the task, score each candidate, and pick one from the scores.
 type Scheduler interface {
-	SelectCandidateNodes()
-	Score()
-	Pick()
+	// SelectCandidateNodes returns the nodes able to run the task
+	SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node
+
+	// Score rates each candidate, keyed by node name; higher is better
+	Score(t task.Task, nodes []*node.Node) map[string]float64
+
+	// Pick chooses the node to run the task from the scored candidates
+	Pick(scores map[string]float64, candidates []*node.Node) *node.Node
 }
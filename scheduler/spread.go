@@ -0,0 +1,32 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"slices"
+)
+
+// Spread adds an anti-affinity constraint to another Scheduler: a task is
+// kept off nodes already running a task with the same Name, so replicas land
+// on different nodes. When every candidate runs one, for instance in a
+// single-node cluster, the task is co-located rather than left unplaced.
+type Spread struct {
+	Scheduler
+}
+
+// SelectCandidateNodes narrows the wrapped scheduler's candidates to the
+// nodes not yet running a task with the same Name, if there are any.
+func (s Spread) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	candidates := s.Scheduler.SelectCandidateNodes(t, nodes)
+
+	var spread []*node.Node
+	for _, n := range candidates {
+		if !slices.Contains(n.Tasks, t.Name) {
+			spread = append(spread, n)
+		}
+	}
+	if len(spread) == 0 {
+		return candidates
+	}
+	return spread
+}
@@ -0,0 +1,38 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+)
+
+func TestSpread_SeparatesReplicas(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "small", Cores: 1, Memory: 1024},
+		{Name: "large", Cores: 8, Memory: 8192},
+	}
+	s := scheduler.Spread{Scheduler: &scheduler.WeightedRoundRobin{}}
+
+	var placed []string
+	for range 2 {
+		replica := task.Task{Name: "web", Replicas: 2}
+		candidates := s.SelectCandidateNodes(replica, nodes)
+		picked := s.Pick(s.Score(replica, candidates), candidates)
+		picked.Tasks = append(picked.Tasks, replica.Name)
+		placed = append(placed, picked.Name)
+	}
+
+	if placed[0] == placed[1] {
+		t.Errorf("both replicas placed on %s, want them on different nodes", placed[0])
+	}
+}
+
+func TestSpread_FallsBackToColocating(t *testing.T) {
+	nodes := []*node.Node{{Name: "only", Cores: 1, Memory: 1024, Tasks: []string{"web"}}}
+	s := scheduler.Spread{Scheduler: &scheduler.WeightedRoundRobin{}}
+
+	if got := s.SelectCandidateNodes(task.Task{Name: "web"}, nodes); len(got) != 1 {
+		t.Errorf("SelectCandidateNodes() returned %d nodes, want the single node", len(got))
+	}
+}
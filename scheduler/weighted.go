@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"math"
+)
+
+// WeightedRoundRobin spreads tasks across nodes in proportion to their
+// capacity, so a node twice the size of another receives about twice as many
+// tasks. It uses smooth weighted round-robin: every round each candidate earns
+// credit equal to its weight, the node with the most credit is picked, and the
+// winner pays back the total weight of the round.
+type WeightedRoundRobin struct {
+	// Overcommit stretches the capacity a node is checked for room against;
+	// weights are always taken from its real capacity
+	Overcommit Overcommit
+
+	credits map[string]float64
+	total   float64
+}
+
+// SelectCandidateNodes returns the nodes that fit the task. A node without
+// room is left out of the round, so it earns no credit for a task it could
+// not take.
+func (w *WeightedRoundRobin) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	return fits(t, nodes, w.Overcommit)
+}
+
+// Score adds each candidate's weight to its credit and returns the credits.
+func (w *WeightedRoundRobin) Score(t task.Task, nodes []*node.Node) map[string]float64 {
+	if w.credits == nil {
+		w.credits = make(map[string]float64)
+	}
+
+	w.total = 0
+	scores := make(map[string]float64, len(nodes))
+	for _, n := range nodes {
+		weight := Weight(n)
+		w.credits[n.Name] += weight
+		w.total += weight
+		scores[n.Name] = w.credits[n.Name]
+	}
+	return scores
+}
+
+// Pick returns the candidate with the most credit and charges it the total
+// weight of the round.
+func (w *WeightedRoundRobin) Pick(scores map[string]float64, candidates []*node.Node) *node.Node {
+	var best *node.Node
+	for _, n := range candidates {
+		if best == nil || scores[n.Name] > scores[best.Name] {
+			best = n
+		}
+	}
+	if best != nil {
+		w.credits[best.Name] -= w.total
+	}
+	return best
+}
+
+// Weight is a node's capacity as the geometric mean of its cores and memory,
+// so doubling both doubles the weight. Missing values count as one.
+func Weight(n *node.Node) float64 {
+	return math.Sqrt(float64(max(n.Cores, 1)) * float64(max(n.Memory, 1)))
+}
@@ -0,0 +1,46 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"math"
+	"testing"
+)
+
+func TestWeightedRoundRobin_Distribution(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "small", Cores: 1, Memory: 1024},
+		{Name: "medium", Cores: 2, Memory: 2048},
+		{Name: "large", Cores: 4, Memory: 4096},
+	}
+	var s scheduler.Scheduler = &scheduler.WeightedRoundRobin{}
+
+	const rounds = 7000
+	counts := map[string]int{}
+	for range rounds {
+		tk := task.Task{Name: "job"}
+		candidates := s.SelectCandidateNodes(tk, nodes)
+		picked := s.Pick(s.Score(tk, candidates), candidates)
+		counts[picked.Name]++
+	}
+
+	want := map[string]float64{"small": 1.0 / 7, "medium": 2.0 / 7, "large": 4.0 / 7}
+	for name, share := range want {
+		got := float64(counts[name]) / rounds
+		if math.Abs(got-share) > 0.01 {
+			t.Errorf("%s received %.3f of tasks, want %.3f", name, got, share)
+		}
+	}
+}
+
+func TestWeightedRoundRobin_SkipsCordonedNodes(t *testing.T) {
+	nodes := []*node.Node{
+		{Name: "cordoned", Cores: 8, Memory: 8192, Cordoned: true},
+		{Name: "open", Cores: 1, Memory: 1024},
+	}
+	got := (&scheduler.WeightedRoundRobin{}).SelectCandidateNodes(task.Task{Name: "web"}, nodes)
+	if len(got) != 1 || got[0].Name != "open" {
+		t.Errorf("SelectCandidateNodes() = %v, want only the open node", got)
+	}
+}
@@ -0,0 +1,36 @@
+package scheduler
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/task"
+	"time"
+)
+
+// Windowed keeps another Scheduler from placing tasks on nodes outside their
+// MaintenanceWindow. Tasks already running on a node are left alone when
+// its window closes.
+type Windowed struct {
+	Scheduler
+
+	// Clock tells the time windows are checked against; the system clock
+	// when nil
+	Clock clock.Clock
+}
+
+// SelectCandidateNodes returns the candidates the wrapped scheduler selects
+// from the nodes that have no window or are within it.
+func (s Windowed) SelectCandidateNodes(t task.Task, nodes []*node.Node) []*node.Node {
+	now := time.Now()
+	if s.Clock != nil {
+		now = s.Clock.Now()
+	}
+
+	var open []*node.Node
+	for _, n := range nodes {
+		if n.MaintenanceWindow == nil || n.MaintenanceWindow.Contains(now) {
+			open = append(open, n)
+		}
+	}
+	return s.Scheduler.SelectCandidateNodes(t, open)
+}
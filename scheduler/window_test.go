@@ -0,0 +1,61 @@
+package scheduler_test
+
+import (
+	"github.com/christinavaneyssen/cube/clock"
+	"github.com/christinavaneyssen/cube/node"
+	"github.com/christinavaneyssen/cube/scheduler"
+	"github.com/christinavaneyssen/cube/task"
+	"testing"
+	"time"
+)
+
+func TestWindowed_SkipsNodesOutsideTheirWindow(t *testing.T) {
+	night, err := node.ParseWindow("22:00-06:00")
+	if err != nil {
+		t.Fatalf("ParseWindow() error = %v", err)
+	}
+	nodes := []*node.Node{
+		{Name: "batch", Cores: 8, Memory: 8192, MaintenanceWindow: &night},
+		{Name: "general", Cores: 2, Memory: 2048},
+	}
+	fake := clock.NewFake(time.Date(2025, 1, 2, 14, 0, 0, 0, time.UTC))
+	s := scheduler.Windowed{Scheduler: &scheduler.WeightedRoundRobin{}, Clock: fake}
+	tk := task.Task{Name: "etl", Memory: 512}
+
+	names := func() []string {
+		var got []string
+		for _, n := range s.SelectCandidateNodes(tk, nodes) {
+			got = append(got, n.Name)
+		}
+		return got
+	}
+
+	if got := names(); len(got) != 1 || got[0] != "general" {
+		t.Errorf("candidates at 14:00 = %v, want only general", got)
+	}
+	for _, at := range []time.Time{
+		time.Date(2025, 1, 2, 23, 30, 0, 0, time.UTC),
+		time.Date(2025, 1, 3, 5, 59, 0, 0, time.UTC),
+	} {
+		fake.Set(at)
+		if got := names(); len(got) != 2 {
+			t.Errorf("candidates at %v = %v, want both nodes", at.Format("15:04"), got)
+		}
+	}
+	fake.Set(time.Date(2025, 1, 3, 6, 0, 0, 0, time.UTC))
+	if got := names(); len(got) != 1 || got[0] != "general" {
+		t.Errorf("candidates at 06:00 = %v, want only general once the window closes", got)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	for _, s := range []string{"22:00", "25:00-06:00", "22:00-6pm"} {
+		if _, err := node.ParseWindow(s); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded, want an error", s)
+		}
+	}
+	w, err := node.ParseWindow("09:30-17:00")
+	if err != nil || w.Start != 9*time.Hour+30*time.Minute || w.End != 17*time.Hour || w.String() != "09:30-17:00" {
+		t.Errorf("ParseWindow(\"09:30-17:00\") = %v (%v, %v), %v", w, w.Start, w.End, err)
+	}
+}
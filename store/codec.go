@@ -0,0 +1,58 @@
+package store
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+// Codec encodes the values a store keeps.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes values as JSON. It is the default, and the easiest to
+	// read when inspecting a store file by hand.
+	JSON Codec = jsonCodec{}
+
+	// Gob encodes values with encoding/gob, which takes less space than
+	// JSON for large clusters. Types encode as their Go values rather than
+	// through MarshalJSON, so task.State is kept as its number.
+	Gob Codec = gobCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Option configures a store when it is constructed.
+type Option func(*InMemoryStore)
+
+// WithCodec has the store encode values with c instead of JSON.
+func WithCodec(c Codec) Option {
+	return func(s *InMemoryStore) {
+		s.codec = c
+	}
+}
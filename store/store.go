@@ -0,0 +1,206 @@
+// Package store persists orchestrator state so it survives process restarts.
+// Values are encoded with a Codec, JSON unless another is chosen when the
+// store is constructed, and kept under string keys.
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when no value is stored under a key.
+var ErrNotFound = errors.New("key not found")
+
+// Store persists values under string keys.
+type Store interface {
+	// Put stores value under key, replacing any existing value
+	Put(key string, value any) error
+
+	// Get decodes the value stored under key into value
+	Get(key string, value any) error
+
+	// Delete removes the value stored under key, if any
+	Delete(key string) error
+
+	// List returns the sorted keys that start with prefix
+	List(prefix string) ([]string, error)
+}
+
+// InMemoryStore keeps values in memory. Values are encoded on Put so callers
+// never share state with the store.
+type InMemoryStore struct {
+	mu    sync.Mutex
+	data  map[string][]byte
+	codec Codec
+}
+
+// NewInMemoryStore returns an empty in-memory store.
+func NewInMemoryStore(opts ...Option) *InMemoryStore {
+	s := &InMemoryStore{data: make(map[string][]byte), codec: JSON}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *InMemoryStore) Put(key string, value any) error {
+	raw, err := s.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = raw
+	return nil
+}
+
+func (s *InMemoryStore) Get(key string, value any) error {
+	s.mu.Lock()
+	raw, ok := s.data[key]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return s.codec.Unmarshal(raw, value)
+}
+
+func (s *InMemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data, key)
+	return nil
+}
+
+func (s *InMemoryStore) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// FileStore keeps values in memory and writes them all to a single file on
+// every change, so the contents survive a restart. With the JSON codec the
+// file is a JSON object of the values by key; with any other codec it is the
+// codec's encoding of the encoded values by key.
+type FileStore struct {
+	InMemoryStore
+	path string
+
+	// writeMu is held from encoding the store to renaming the file into
+	// place, so a flush never replaces the file with an older snapshot
+	writeMu sync.Mutex
+}
+
+// NewFileStore opens the store kept in the file at path, creating it on the
+// first write if it does not exist. The file must have been written with
+// the same codec.
+func NewFileStore(path string, opts ...Option) (*FileStore, error) {
+	s := &FileStore{
+		InMemoryStore: InMemoryStore{data: make(map[string][]byte), codec: JSON},
+		path:          path,
+	}
+	for _, opt := range opts {
+		opt(&s.InMemoryStore)
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading store: %w", err)
+	}
+	if err := s.decode(raw); err != nil {
+		return nil, fmt.Errorf("decoding store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Put(key string, value any) error {
+	if err := s.InMemoryStore.Put(key, value); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *FileStore) Delete(key string) error {
+	if err := s.InMemoryStore.Delete(key); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+// flush writes the whole store to a temporary file and renames it over the
+// store file so a crash never leaves a partial write behind.
+func (s *FileStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	raw, err := s.encode()
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encoding store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// encode returns the contents of the store file. JSON values are embedded
+// as they are rather than as strings, so the file reads naturally. The
+// caller must hold s.mu.
+func (s *FileStore) encode() ([]byte, error) {
+	if s.codec != JSON {
+		return s.codec.Marshal(s.data)
+	}
+	values := make(map[string]json.RawMessage, len(s.data))
+	for key, raw := range s.data {
+		values[key] = raw
+	}
+	return json.Marshal(values)
+}
+
+// decode loads the contents of a store file written by encode.
+func (s *FileStore) decode(raw []byte) error {
+	if s.codec != JSON {
+		return s.codec.Unmarshal(raw, &s.data)
+	}
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return err
+	}
+	for key, value := range values {
+		s.data[key] = value
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cube.json")
+
+	s, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if err := s.Put("tasks/b", []string{"two"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("tasks/a", []string{"one"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("events/a", []string{"ignored"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Delete("tasks/b"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	reopened, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	keys, err := reopened.List("tasks/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"tasks/a"}) {
+		t.Errorf("List() = %v, want [tasks/a]", keys)
+	}
+
+	var got []string
+	if err := reopened.Get("tasks/a", &got); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"one"}) {
+		t.Errorf("Get() = %v, want [one]", got)
+	}
+
+	if err := reopened.Get("tasks/b", &got); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Get() of deleted key error = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func TestFileStore_ConcurrentPutsAllSurvive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cube.json")
+	s, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.Put(fmt.Sprintf("tasks/%02d", i), i); err != nil {
+				t.Errorf("Put() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := store.NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	keys, err := reopened.List("tasks/")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(keys) != 20 {
+		t.Errorf("reopened store holds %d keys, want 20", len(keys))
+	}
+}
+
+func TestCodecs_RoundTripTask(t *testing.T) {
+	want := task.Task{
+		ID:        uuid.New(),
+		Name:      "web",
+		State:     task.Running,
+		Image:     "strm/helloworld-http",
+		Memory:    256,
+		Env:       []string{"GREETING=hello"},
+		CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC),
+		StartTime: time.Date(2025, 1, 2, 3, 5, 0, 0, time.UTC),
+	}
+
+	for name, codec := range map[string]store.Codec{"json": store.JSON, "gob": store.Gob} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cube.db")
+			s, err := store.NewFileStore(path, store.WithCodec(codec))
+			if err != nil {
+				t.Fatalf("NewFileStore() error = %v", err)
+			}
+			if err := s.Put("tasks/web", map[string][]*task.Task{want.ID.String(): {&want}}); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			reopened, err := store.NewFileStore(path, store.WithCodec(codec))
+			if err != nil {
+				t.Fatalf("NewFileStore() error = %v", err)
+			}
+			var got map[string][]*task.Task
+			if err := reopened.Get("tasks/web", &got); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			versions := got[want.ID.String()]
+			if len(versions) != 1 || !reflect.DeepEqual(*versions[0], want) {
+				t.Errorf("Get() = %+v, want %+v", versions, want)
+			}
+		})
+	}
+}
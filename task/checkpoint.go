@@ -0,0 +1,42 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/docker/docker/api/types/checkpoint"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Checkpoint saves the running state of the container with the given ID
+// under name, using the daemon's CRIU support, and stops the container. The
+// container can later be resumed from the checkpoint with Restore. It fails
+// with cubeerrors.ErrInvalidState unless the config is Checkpointable.
+func (d *Docker) Checkpoint(ctx context.Context, containerID, name string) error {
+	if !d.Config.Checkpointable {
+		return cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "container %s is not checkpointable", containerID)
+	}
+	d.Logger.Printf("Checkpointing container %s as %s", containerID, name)
+	err := d.Client.CheckpointCreate(ctx, containerID, checkpoint.CreateOptions{CheckpointID: name, Exit: true})
+	if err != nil {
+		return fmt.Errorf("checkpoint container failed: %w", err)
+	}
+	return nil
+}
+
+// Restore starts the stopped container with the given ID from the
+// checkpoint saved under name. Like Checkpoint, it needs a Checkpointable
+// config.
+func (d *Docker) Restore(ctx context.Context, containerID, name string) error {
+	if !d.Config.Checkpointable {
+		return cubeerrors.Wrapf(cubeerrors.ErrInvalidState, "container %s is not checkpointable", containerID)
+	}
+	d.Logger.Printf("Restoring container %s from checkpoint %s", containerID, name)
+	err := d.Client.ContainerStart(ctx, containerID, container.StartOptions{CheckpointID: name})
+	if err != nil {
+		return fmt.Errorf("restore container failed: %w", err)
+	}
+
+	d.ContainerID = containerID
+	return nil
+}
@@ -0,0 +1,84 @@
+package task
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// FieldDiff is a setting in which a running container differs from its
+// task's desired config.
+type FieldDiff struct {
+	// Field names the setting, such as "memory" or "env FOO"
+	Field string
+
+	// Desired is the value the config asks for and Actual the container's;
+	// empty when the setting is missing on that side
+	Desired string
+	Actual  string
+}
+
+// Diff inspects the container with the given ID and compares it, field by
+// field, with the config it should run: its image, environment, resource
+// limits and exposed ports. The list is empty when they agree. Variables
+// and ports the container has on top of the config, such as those its image
+// declares, are not reported.
+func (d *Docker) Diff(containerID string) ([]FieldDiff, error) {
+	resp := d.Inspect(containerID)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	desired := d.Config
+	if err := desired.mergeEnvFiles(); err != nil {
+		return nil, err
+	}
+	actual := resp.Container
+
+	diffs := []FieldDiff{}
+	compare := func(field, want, got string) {
+		if want != got {
+			diffs = append(diffs, FieldDiff{Field: field, Desired: want, Actual: got})
+		}
+	}
+	if actual.Config != nil {
+		compare("image", d.image(), actual.Config.Image)
+
+		env := make(map[string]string)
+		for _, kv := range actual.Config.Env {
+			k, v, _ := strings.Cut(kv, "=")
+			env[k] = v
+		}
+		for _, kv := range desired.Env {
+			k, v, _ := strings.Cut(kv, "=")
+			compare("env "+k, v, env[k])
+		}
+
+		var missing []string
+		for port := range desired.ExposedPorts {
+			if _, ok := actual.Config.ExposedPorts[port]; !ok {
+				missing = append(missing, string(port))
+			}
+		}
+		slices.Sort(missing)
+		for _, port := range missing {
+			compare("port "+port, "exposed", "")
+		}
+	}
+	if actual.ContainerJSONBase != nil && actual.HostConfig != nil {
+		applied := actual.HostConfig.Resources
+		compare("memory", bytesString(desired.Memory), bytesString(applied.Memory))
+		compare("memory reservation", bytesString(desired.MemoryReservation), bytesString(applied.MemoryReservation))
+		compare("nano CPUs", strconv.FormatInt(desired.nanoCPUs(), 10), strconv.FormatInt(applied.NanoCPUs, 10))
+		compare("CPU shares", strconv.FormatInt(desired.cpuShares(), 10), strconv.FormatInt(applied.CPUShares, 10))
+	}
+	return diffs, nil
+}
+
+// bytesString formats a limit in bytes, with zero meaning none.
+func bytesString(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
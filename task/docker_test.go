@@ -0,0 +1,812 @@
+package task_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/checkpoint"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClient records the configuration containers are created with. Methods
+// a test does not override panic through the embedded nil interface.
+type fakeClient struct {
+	client.APIClient
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networking *network.NetworkingConfig
+
+	// images are the images present on the host, and pulls the images pulled
+	images []string
+	pulls  []string
+
+	// version is the API version the client speaks; the latest when empty
+	version string
+
+	// createErr fails ContainerCreate
+	createErr error
+
+	// names records the name of each container create attempt, the first
+	// conflicts of which fail with the name already in use
+	names     []string
+	conflicts int
+
+	// checkpoints records the checkpoints created, and starts the options
+	// containers were started with
+	checkpoints []checkpoint.CreateOptions
+	starts      []container.StartOptions
+
+	// hangStart makes ContainerStart wait until its context is done
+	hangStart bool
+}
+
+func (f *fakeClient) CheckpointCreate(ctx context.Context, containerID string, options checkpoint.CreateOptions) error {
+	f.checkpoints = append(f.checkpoints, options)
+	return nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	f.starts = append(f.starts, options)
+	if f.hangStart {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (f *fakeClient) ClientVersion() string {
+	if f.version == "" {
+		return api.DefaultVersion
+	}
+	return f.version
+}
+
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, ref string) (types.ImageInspect, []byte, error) {
+	if !slices.Contains(f.images, ref) {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", ref))
+	}
+	return types.ImageInspect{ID: ref}, nil, nil
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	f.pulls = append(f.pulls, ref)
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	if f.createErr != nil {
+		return container.CreateResponse{}, f.createErr
+	}
+	f.names = append(f.names, containerName)
+	if len(f.names) <= f.conflicts {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("container name %q is already in use", containerName))
+	}
+	f.config = config
+	f.hostConfig = hostConfig
+	f.networking = networkingConfig
+	return container.CreateResponse{ID: "container-1"}, nil
+}
+
+func newDocker(c client.APIClient, cfg task.Config) *task.Docker {
+	return &task.Docker{
+		Client: c,
+		Config: cfg,
+		Logger: log.New(io.Discard, "", 0),
+		Writer: io.Discard,
+		StdErr: io.Discard,
+	}
+}
+
+func TestDocker_ContainerCreateMounts(t *testing.T) {
+	dataDir := t.TempDir()
+	fc := &fakeClient{}
+	d := newDocker(fc, task.Config{
+		Name:  "db",
+		Image: "postgres:16",
+		Mounts: []task.Mount{
+			{Source: dataDir, Target: "/var/lib/postgresql/data"},
+			{Source: "pg-config", Target: "/etc/postgresql", ReadOnly: true},
+		},
+	})
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: dataDir, Target: "/var/lib/postgresql/data"},
+		{Type: mount.TypeVolume, Source: "pg-config", Target: "/etc/postgresql", ReadOnly: true},
+	}
+	if !reflect.DeepEqual(fc.hostConfig.Mounts, want) {
+		t.Errorf("host config mounts = %+v, want %+v", fc.hostConfig.Mounts, want)
+	}
+}
+
+func TestDocker_ContainerCreateRejectsMissingBindSource(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, task.Config{
+		Name:   "db",
+		Image:  "postgres:16",
+		Mounts: []task.Mount{{Source: filepath.Join(t.TempDir(), "missing"), Target: "/data"}},
+	})
+
+	_, err := d.ContainerCreate(context.Background())
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ContainerCreate() error = %v, want %v", err, os.ErrNotExist)
+	}
+	if fc.hostConfig != nil {
+		t.Error("container created despite missing bind source")
+	}
+}
+
+func TestDocker_ContainerCreateDevices(t *testing.T) {
+	// Validation only needs the host path to exist, so a plain file stands
+	// in for a device node.
+	fuse := filepath.Join(t.TempDir(), "fuse")
+	if err := os.WriteFile(fuse, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:  "mounter",
+		Image: "rclone/rclone",
+		Devices: []task.DeviceMapping{
+			{HostPath: fuse},
+			{HostPath: fuse, ContainerPath: "/dev/fuse-ro", Permissions: "r"},
+		},
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	want := []container.DeviceMapping{
+		{PathOnHost: fuse, PathInContainer: fuse, CgroupPermissions: "rwm"},
+		{PathOnHost: fuse, PathInContainer: "/dev/fuse-ro", CgroupPermissions: "r"},
+	}
+	if !reflect.DeepEqual(fc.hostConfig.Devices, want) {
+		t.Errorf("host config devices = %+v, want %+v", fc.hostConfig.Devices, want)
+	}
+
+	t.Run("missing device", func(t *testing.T) {
+		fc := &fakeClient{}
+		d := newDocker(fc, task.Config{
+			Name:    "mounter",
+			Image:   "rclone/rclone",
+			Devices: []task.DeviceMapping{{HostPath: filepath.Join(t.TempDir(), "fuse")}},
+		})
+		if _, err := d.ContainerCreate(context.Background()); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("ContainerCreate() error = %v, want %v", err, os.ErrNotExist)
+		}
+		if fc.hostConfig != nil {
+			t.Error("container created despite missing device")
+		}
+	})
+}
+
+func TestDocker_ContainerCreateHealthcheck(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:           "web",
+		Image:          "nginx",
+		HealthCmd:      []string{"curl", "-f", "http://localhost/"},
+		HealthInterval: 10 * time.Second,
+		HealthRetries:  3,
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	want := &container.HealthConfig{
+		Test:     []string{"CMD", "curl", "-f", "http://localhost/"},
+		Interval: 10 * time.Second,
+		Retries:  3,
+	}
+	if !reflect.DeepEqual(fc.config.Healthcheck, want) {
+		t.Errorf("healthcheck = %+v, want %+v", fc.config.Healthcheck, want)
+	}
+}
+
+func TestNewDockerClient_RespectsDockerHost(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://10.0.0.5:2375")
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+	t.Setenv("DOCKER_CERT_PATH", "")
+
+	d, err := task.NewDocker(task.Config{Name: "web", Image: "nginx"})
+	if err != nil {
+		t.Fatalf("NewDocker() error = %v", err)
+	}
+	if got := d.Client.DaemonHost(); got != "tcp://10.0.0.5:2375" {
+		t.Errorf("daemon host = %q, want tcp://10.0.0.5:2375", got)
+	}
+	if d.Config.Name != "web" || d.Logger == nil || d.Writer == nil || d.StdErr == nil {
+		t.Errorf("NewDocker() = %+v, want the config wired with a logger and writers", d)
+	}
+}
+
+func TestDocker_ContainerCreateCpuModel(t *testing.T) {
+	tests := []struct {
+		model      task.CpuModel
+		wantNano   int64
+		wantShares int64
+	}{
+		{model: "", wantNano: 500_000_000},
+		{model: task.CpuQuota, wantNano: 500_000_000},
+		{model: task.CpuShares, wantShares: 512},
+	}
+	for _, tt := range tests {
+		fc := &fakeClient{}
+		d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx", Cpu: 0.5, CpuModel: tt.model}))
+
+		if _, err := d.ContainerCreate(context.Background()); err != nil {
+			t.Fatalf("ContainerCreate() with model %q error = %v", tt.model, err)
+		}
+		got := fc.hostConfig.Resources
+		if got.NanoCPUs != tt.wantNano || got.CPUShares != tt.wantShares {
+			t.Errorf("model %q: nano CPUs %d and shares %d, want %d and %d",
+				tt.model, got.NanoCPUs, got.CPUShares, tt.wantNano, tt.wantShares)
+		}
+	}
+}
+
+func TestConfig_ValidateCpu(t *testing.T) {
+	tests := []struct {
+		cfg     task.Config
+		wantErr bool
+	}{
+		{cfg: task.Config{Cpu: 1}},
+		{cfg: task.Config{Cpu: -1}, wantErr: true},
+		{cfg: task.Config{Cpu: 1_000_000, CpuModel: task.CpuQuota}, wantErr: true},
+		{cfg: task.Config{Cpu: 64, CpuModel: task.CpuShares}},
+		{cfg: task.Config{Cpu: 0.001, CpuModel: task.CpuShares}, wantErr: true},
+		{cfg: task.Config{Cpu: 1000, CpuModel: task.CpuShares}, wantErr: true},
+		{cfg: task.Config{Cpu: 1, CpuModel: "burst"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate() of cpu %v with model %q error = %v, want error %v",
+				tt.cfg.Cpu, tt.cfg.CpuModel, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDocker_ContainerCreateGPUs(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "train", Image: "pytorch/pytorch", GPUs: 2}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	want := []container.DeviceRequest{{Driver: "nvidia", Count: 2, Capabilities: [][]string{{"gpu"}}}}
+	if got := fc.hostConfig.DeviceRequests; !reflect.DeepEqual(got, want) {
+		t.Errorf("device requests = %+v, want %+v", got, want)
+	}
+}
+
+func TestDocker_ContainerCreateUserAndWorkingDir(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:       "app",
+		Image:      "node:22",
+		User:       "1000:1000",
+		WorkingDir: "/srv/app",
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if fc.config.User != "1000:1000" || fc.config.WorkingDir != "/srv/app" {
+		t.Errorf("user %q and working dir %q, want 1000:1000 and /srv/app", fc.config.User, fc.config.WorkingDir)
+	}
+
+	for _, cfg := range []task.Config{
+		{User: "app user"},
+		{User: ":1000"},
+		{User: "app:"},
+		{User: "a:b:c"},
+		{WorkingDir: "srv/app"},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() of user %q, working dir %q succeeded", cfg.User, cfg.WorkingDir)
+		}
+	}
+}
+
+func TestDocker_ContainerCreateSecurityOptions(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:           "web",
+		Image:          "nginx",
+		ReadonlyRootfs: true,
+		CapAdd:         []string{"NET_BIND_SERVICE"},
+		CapDrop:        []string{"ALL"},
+		SecurityOpt:    []string{"no-new-privileges"},
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	hc := fc.hostConfig
+	if !hc.ReadonlyRootfs {
+		t.Error("root filesystem is writable, want read-only")
+	}
+	if !reflect.DeepEqual([]string(hc.CapAdd), []string{"NET_BIND_SERVICE"}) || !reflect.DeepEqual([]string(hc.CapDrop), []string{"ALL"}) {
+		t.Errorf("capabilities added %v and dropped %v, want [NET_BIND_SERVICE] and [ALL]", hc.CapAdd, hc.CapDrop)
+	}
+	if !reflect.DeepEqual(hc.SecurityOpt, []string{"no-new-privileges"}) {
+		t.Errorf("security options = %v, want [no-new-privileges]", hc.SecurityOpt)
+	}
+
+	if err := (&task.Config{CapAdd: []string{"net bind"}}).Validate(); err == nil {
+		t.Error("Validate() of a malformed capability succeeded")
+	}
+}
+
+func TestDocker_ContainerCreateDefaultSecurity(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, task.Config{Name: "web", Image: "nginx"})
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	hc := fc.hostConfig
+	if hc.ReadonlyRootfs || hc.CapAdd != nil || hc.CapDrop != nil || hc.SecurityOpt != nil {
+		t.Errorf("host config = %+v, want Docker's default security settings", hc)
+	}
+}
+
+func TestDocker_ImagePullPolicy(t *testing.T) {
+	const img = "postgres:16"
+	tests := []struct {
+		policy    task.PullPolicy
+		present   bool
+		wantPulls int
+		wantErr   bool
+	}{
+		{policy: "", present: true, wantPulls: 1},
+		{policy: task.PullAlways, present: true, wantPulls: 1},
+		{policy: task.PullIfNotPresent, present: true, wantPulls: 0},
+		{policy: task.PullIfNotPresent, present: false, wantPulls: 1},
+		{policy: task.PullNever, present: true, wantPulls: 0},
+		{policy: task.PullNever, present: false, wantPulls: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/present=%v", tt.policy, tt.present), func(t *testing.T) {
+			fc := &fakeClient{}
+			if tt.present {
+				fc.images = []string{img}
+			}
+			d := newDocker(fc, task.Config{Name: "db", Image: img, PullPolicy: tt.policy})
+
+			err := d.ImagePull(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImagePull() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, cubeerrors.ErrImagePull) {
+				t.Errorf("ImagePull() error = %v, want ErrImagePull", err)
+			}
+			if len(fc.pulls) != tt.wantPulls {
+				t.Errorf("pulled %v, want %d pulls", fc.pulls, tt.wantPulls)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidatePullPolicy(t *testing.T) {
+	cfg := task.Config{PullPolicy: "Sometimes"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted an unknown pull policy")
+	}
+}
+
+func TestNewDocker_PinsAPIVersion(t *testing.T) {
+	t.Setenv("DOCKER_API_VERSION", "")
+
+	d, err := task.NewDocker(task.Config{Name: "web", Image: "nginx", DockerAPIVersion: "1.41"})
+	if err != nil {
+		t.Fatalf("NewDocker() error = %v", err)
+	}
+	if got := d.Client.ClientVersion(); got != "1.41" {
+		t.Errorf("client version = %q, want 1.41", got)
+	}
+}
+
+func TestDocker_ContainerCreateChecksAPIVersion(t *testing.T) {
+	fc := &fakeClient{version: "1.39"}
+	d := newDocker(fc, task.Config{Name: "train", Image: "pytorch/pytorch", GPUs: 1})
+
+	_, err := d.ContainerCreate(context.Background())
+	if !errors.Is(err, cubeerrors.ErrAPIVersionTooOld) {
+		t.Fatalf("ContainerCreate() error = %v, want ErrAPIVersionTooOld", err)
+	}
+	if fc.config != nil {
+		t.Error("container created despite the API version being too old")
+	}
+
+	d.Config.GPUs = 0
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Errorf("ContainerCreate() without GPUs error = %v", err)
+	}
+}
+
+func TestDocker_ContainerCreateTmpfs(t *testing.T) {
+	fc := &fakeClient{}
+	tmpfs := map[string]string{
+		"/tmp":       "size=64m,mode=1777",
+		"/var/cache": "",
+	}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:           "web",
+		Image:          "nginx",
+		ReadonlyRootfs: true,
+		Tmpfs:          tmpfs,
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if !reflect.DeepEqual(fc.hostConfig.Tmpfs, tmpfs) {
+		t.Errorf("tmpfs = %v, want %v", fc.hostConfig.Tmpfs, tmpfs)
+	}
+
+	cfg := task.Config{Tmpfs: map[string]string{"tmp": ""}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a relative tmpfs path")
+	}
+}
+
+func TestDocker_ContainerCreateDNS(t *testing.T) {
+	fc := &fakeClient{}
+	tsk := &task.Task{
+		Name:       "web",
+		Image:      "nginx",
+		DNS:        []string{"10.0.0.53"},
+		DNSSearch:  []string{"svc.internal"},
+		ExtraHosts: []string{"db.internal:10.0.0.7", "gateway:host-gateway", "v6.internal:fd00::1"},
+	}
+	d := newDocker(fc, *task.NewConfig(tsk))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if !slices.Equal(fc.hostConfig.DNS, tsk.DNS) || !slices.Equal(fc.hostConfig.DNSSearch, tsk.DNSSearch) {
+		t.Errorf("dns = %v searching %v, want %v searching %v", fc.hostConfig.DNS, fc.hostConfig.DNSSearch, tsk.DNS, tsk.DNSSearch)
+	}
+	if !slices.Equal(fc.hostConfig.ExtraHosts, tsk.ExtraHosts) {
+		t.Errorf("extra hosts = %v, want %v", fc.hostConfig.ExtraHosts, tsk.ExtraHosts)
+	}
+
+	for _, entry := range []string{"db.internal", "db.internal:", ":10.0.0.7", "db.internal:not-an-ip"} {
+		cfg := task.Config{ExtraHosts: []string{entry}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() accepted extra host %q", entry)
+		}
+	}
+	cfg := task.Config{DNS: []string{"dns.internal"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a DNS server that is not an IP address")
+	}
+}
+
+func TestDocker_ContainerCreateUlimits(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:    "db",
+		Image:   "postgres",
+		Ulimits: []task.Ulimit{{Name: "nofile", Soft: 65536, Hard: 65536}, {Name: "nproc", Soft: 1024, Hard: 2048}},
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	want := []*container.Ulimit{{Name: "nofile", Soft: 65536, Hard: 65536}, {Name: "nproc", Soft: 1024, Hard: 2048}}
+	if !reflect.DeepEqual(fc.hostConfig.Ulimits, want) {
+		t.Errorf("ulimits = %v, want %v", fc.hostConfig.Ulimits, want)
+	}
+
+	cfg := task.Config{Ulimits: []task.Ulimit{{Name: "nofile", Soft: 4096, Hard: 1024}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a soft limit above the hard limit")
+	}
+}
+
+func TestDocker_ContainerCreateStopSignal(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx", StopSignal: "SIGQUIT"}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if fc.config.StopSignal != "SIGQUIT" {
+		t.Errorf("stop signal = %q, want SIGQUIT", fc.config.StopSignal)
+	}
+
+	for signal, valid := range map[string]bool{"SIGQUIT": true, "quit": true, "SIGTERM": true, "SIGNOPE": false, "15": false} {
+		cfg := task.Config{StopSignal: signal}
+		if err := cfg.Validate(); (err == nil) != valid {
+			t.Errorf("Validate() of stop signal %q error = %v, want valid %v", signal, err, valid)
+		}
+	}
+}
+
+func TestDocker_ContainerCreateEntrypoint(t *testing.T) {
+	fc := &fakeClient{}
+	entrypoint := []string{"/bin/sh", "-c"}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx", Entrypoint: entrypoint}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if !slices.Equal(fc.config.Entrypoint, entrypoint) {
+		t.Errorf("entrypoint = %q, want %q", fc.config.Entrypoint, entrypoint)
+	}
+
+	fc = &fakeClient{}
+	d = newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx"}))
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if fc.config.Entrypoint != nil {
+		t.Errorf("entrypoint = %q, want nil to keep the image's", fc.config.Entrypoint)
+	}
+}
+
+func TestDocker_ContainerCreateNetworkAliases(t *testing.T) {
+	fc := &fakeClient{}
+	aliases := map[string][]string{"backend": {"api", "api.internal"}}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "api-1", Image: "nginx", NetworkAliases: aliases}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if fc.networking == nil || len(fc.networking.EndpointsConfig) != 1 {
+		t.Fatalf("networking config = %+v, want one endpoint", fc.networking)
+	}
+	endpoint := fc.networking.EndpointsConfig["backend"]
+	if endpoint == nil || !slices.Equal(endpoint.Aliases, aliases["backend"]) {
+		t.Errorf("backend endpoint = %+v, want aliases %q", endpoint, aliases["backend"])
+	}
+
+	cfg := task.Config{NetworkAliases: map[string][]string{"backend": {""}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() of an empty alias succeeded, want an error")
+	}
+}
+
+func TestDocker_ContainerCreateSeccompProfile(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(profile, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile string
+		want    string
+	}{
+		{profile, `seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`},
+		{task.SeccompUnconfined, "seccomp=unconfined"},
+	}
+	for _, tt := range tests {
+		fc := &fakeClient{}
+		d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx", SecurityOpt: []string{"no-new-privileges"}, SeccompProfile: tt.profile}))
+		if _, err := d.ContainerCreate(context.Background()); err != nil {
+			t.Fatalf("ContainerCreate() with profile %s error = %v", tt.profile, err)
+		}
+		if want := []string{"no-new-privileges", tt.want}; !slices.Equal(fc.hostConfig.SecurityOpt, want) {
+			t.Errorf("security options = %q, want %q", fc.hostConfig.SecurityOpt, want)
+		}
+	}
+
+	cfg := task.Config{SeccompProfile: filepath.Join(t.TempDir(), "missing.json")}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() of a missing seccomp profile succeeded, want an error")
+	}
+}
+
+func TestDocker_ContainerCreateRestartMaxRetries(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "job", Image: "busybox", RestartPolicy: "on-failure", RestartMaxRetries: 3}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if got := fc.hostConfig.RestartPolicy; got.Name != container.RestartPolicyOnFailure || got.MaximumRetryCount != 3 {
+		t.Errorf("restart policy = %+v, want on-failure with 3 retries", got)
+	}
+
+	for _, policy := range []string{"", "always", "unless-stopped", "no"} {
+		fc = &fakeClient{}
+		d = newDocker(fc, *task.NewConfig(&task.Task{Name: "job", Image: "busybox", RestartPolicy: policy, RestartMaxRetries: 3}))
+		if _, err := d.ContainerCreate(context.Background()); err == nil {
+			t.Errorf("ContainerCreate() with %d retries under policy %q succeeded, want an error", 3, policy)
+		}
+		if fc.hostConfig != nil {
+			t.Errorf("policy %q: container created with restart policy %+v", policy, fc.hostConfig.RestartPolicy)
+		}
+	}
+
+	if err := task.NewConfig(&task.Task{RestartPolicy: "on-failure", RestartMaxRetries: -1}).Validate(); err == nil {
+		t.Error("Validate() of negative restart max retries succeeded")
+	}
+}
+
+func TestDocker_ContainerCreateRetriesNameCollision(t *testing.T) {
+	fc := &fakeClient{conflicts: 1}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx"}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if len(fc.names) != 2 {
+		t.Fatalf("create attempted %d times, want 2: %q", len(fc.names), fc.names)
+	}
+	for _, name := range fc.names {
+		if !strings.HasPrefix(name, "web-") || len(name) <= len("web-") {
+			t.Errorf("container name %q does not keep the task name as its prefix", name)
+		}
+	}
+	if fc.names[0] == fc.names[1] {
+		t.Errorf("retry reused the name %q", fc.names[0])
+	}
+
+	fc = &fakeClient{conflicts: 10}
+	d = newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx"}))
+	if _, err := d.ContainerCreate(context.Background()); !errdefs.IsConflict(err) {
+		t.Errorf("ContainerCreate() with every name taken error = %v, want a conflict", err)
+	}
+	if len(fc.names) >= fc.conflicts {
+		t.Errorf("create attempted %d times, want it to give up", len(fc.names))
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	for name, prefix := range map[string]string{
+		"web":          "web-",
+		"my app/v2":    "my-app-v2-",
+		"_hidden":      "hidden-",
+		"":             "cube-",
+		"db.primary_1": "db.primary_1-",
+	} {
+		got, err := task.ContainerName(name)
+		if err != nil {
+			t.Fatalf("ContainerName(%q) error = %v", name, err)
+		}
+		if !strings.HasPrefix(got, prefix) || len(got) != len(prefix)+8 {
+			t.Errorf("ContainerName(%q) = %q, want %q and an 8 digit suffix", name, got, prefix)
+		}
+	}
+}
+
+func TestDocker_ContainerCreateMemoryReservation(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx", Memory: 512, MemoryReservation: 256}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if got := fc.hostConfig.Resources; got.Memory != 512<<20 || got.MemoryReservation != 256<<20 {
+		t.Errorf("memory = %d, reservation = %d, want %d and %d", got.Memory, got.MemoryReservation, 512<<20, 256<<20)
+	}
+
+	if err := task.NewConfig(&task.Task{Memory: 256, MemoryReservation: 512}).Validate(); err == nil {
+		t.Error("Validate() of a reservation above the memory limit succeeded")
+	}
+}
+
+func TestDocker_CheckpointAndRestore(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{Name: "job", Image: "busybox", Checkpointable: true}))
+
+	if err := d.Checkpoint(context.Background(), "container-1", "drain"); err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+	want := []checkpoint.CreateOptions{{CheckpointID: "drain", Exit: true}}
+	if !reflect.DeepEqual(fc.checkpoints, want) {
+		t.Errorf("checkpoints = %+v, want %+v", fc.checkpoints, want)
+	}
+
+	if err := d.Restore(context.Background(), "container-1", "drain"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if len(fc.starts) != 1 || fc.starts[0].CheckpointID != "drain" {
+		t.Errorf("starts = %+v, want one from checkpoint drain", fc.starts)
+	}
+
+	plain := newDocker(fc, *task.NewConfig(&task.Task{Name: "web", Image: "nginx"}))
+	if err := plain.Checkpoint(context.Background(), "container-2", "drain"); !errors.Is(err, cubeerrors.ErrInvalidState) {
+		t.Errorf("Checkpoint() of a task that is not checkpointable error = %v, want ErrInvalidState", err)
+	}
+	if len(fc.checkpoints) != 1 {
+		t.Errorf("checkpoints = %d, want the call refused", len(fc.checkpoints))
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		ref, mirror, want string
+	}{
+		{"nginx", "mirror.local:5000", "mirror.local:5000/library/nginx"},
+		{"nginx:1.27", "mirror.local:5000", "mirror.local:5000/library/nginx:1.27"},
+		{"docker.io/bitnami/redis:7", "https://mirror.local/", "mirror.local/bitnami/redis:7"},
+		{"ghcr.io/acme/api@" + digest, "mirror.local/hub", "mirror.local/hub/acme/api@" + digest},
+		{"acme/api:v2@" + digest, "mirror.local", "mirror.local/acme/api:v2@" + digest},
+		{"nginx:1.27", "", "nginx:1.27"},
+	}
+	for _, tt := range tests {
+		got, err := task.MirrorImage(tt.ref, tt.mirror)
+		if err != nil || got != tt.want {
+			t.Errorf("MirrorImage(%q, %q) = %q, %v, want %q", tt.ref, tt.mirror, got, err, tt.want)
+		}
+	}
+	if _, err := task.MirrorImage("Not A Reference", "mirror.local"); err == nil {
+		t.Error("MirrorImage() of an invalid reference succeeded")
+	}
+}
+
+func TestDocker_ImagePullUsesRegistryMirror(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, task.Config{Name: "db", Image: "postgres:16", RegistryMirror: "mirror.local:5000"})
+
+	if err := d.ImagePull(context.Background()); err != nil {
+		t.Fatalf("ImagePull() error = %v", err)
+	}
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	const want = "mirror.local:5000/library/postgres:16"
+	if !slices.Equal(fc.pulls, []string{want}) {
+		t.Errorf("pulled %v, want [%s]", fc.pulls, want)
+	}
+	if fc.config.Image != want {
+		t.Errorf("container image = %q, want %q", fc.config.Image, want)
+	}
+}
+
+func TestDocker_RunClassifiesFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		fc   *fakeClient
+		cfg  task.Config
+		want string
+	}{
+		{"start times out", &fakeClient{hangStart: true}, task.Config{Image: "alpine"}, task.FailureTimeout},
+		{"missing image", &fakeClient{}, task.Config{Image: "alpine", PullPolicy: task.PullNever}, task.FailureImagePull},
+		{"invalid config", &fakeClient{}, task.Config{Image: "alpine", GPUs: -1}, task.FailureInvalidConfig},
+		{"daemon refuses", &fakeClient{createErr: errors.New("no space left on device")}, task.Config{Image: "alpine"}, task.FailureDaemon},
+	}
+	for _, tt := range tests {
+		d := newDocker(tt.fc, tt.cfg)
+		d.StartTimeout = 10 * time.Millisecond
+		result := d.Run()
+		if result.Error == nil {
+			t.Errorf("%s: Run() error = nil, want one", tt.name)
+			continue
+		}
+		if got := task.StartFailure(result.Error); got != tt.want {
+			t.Errorf("%s: StartFailure(%v) = %q, want %q", tt.name, result.Error, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package task
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseEnvFile reads KEY=VALUE pairs, one per line. Blank lines and lines
+// starting with # are skipped, an "export " prefix is allowed, and a value
+// wrapped in matching single or double quotes is unwrapped.
+func ParseEnvFile(r io.Reader) ([]string, error) {
+	var env []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE, got %q", n, line)
+		}
+		env = append(env, key+"="+unquote(strings.TrimSpace(value)))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// mergeEnvFiles reads the configured env files and merges their variables
+// into Env. A later file overrides an earlier one, and variables set in Env
+// itself override them all.
+func (c *Config) mergeEnvFiles() error {
+	if len(c.EnvFiles) == 0 {
+		return nil
+	}
+
+	var layers [][]string
+	for _, path := range c.EnvFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("env file: %w", err)
+		}
+		env, err := ParseEnvFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("env file %s: %w", path, err)
+		}
+		layers = append(layers, env)
+	}
+	c.Env = mergeEnv(append(layers, c.Env)...)
+	return nil
+}
+
+// mergeEnv combines KEY=VALUE lists, later lists winning on conflicts. Keys
+// keep the position where they first appear.
+func mergeEnv(layers ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+	for _, env := range layers {
+		for _, kv := range env {
+			key, _, _ := strings.Cut(kv, "=")
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,48 @@
+package task_test
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/task"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	got, err := task.ParseEnvFile(strings.NewReader("# comment\n\nA=1\nexport B=\"two words\"\nC='x=y'\n"))
+	if err != nil {
+		t.Fatalf("ParseEnvFile() error = %v", err)
+	}
+	if want := []string{"A=1", "B=two words", "C=x=y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseEnvFile() = %q, want %q", got, want)
+	}
+
+	if _, err := task.ParseEnvFile(strings.NewReader("A=1\nNOT_A_PAIR\n")); err == nil {
+		t.Error("ParseEnvFile() of a line without = succeeded")
+	}
+}
+
+func TestDocker_ContainerCreateMergesEnvFiles(t *testing.T) {
+	fc := &fakeClient{}
+	d := newDocker(fc, *task.NewConfig(&task.Task{
+		Name:     "web",
+		Image:    "nginx",
+		Env:      []string{"PORT=9090", "EXTRA=1"},
+		EnvFiles: []string{"testdata/app.env"},
+	}))
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+
+	want := []string{
+		"LOG_LEVEL=info",
+		"DATABASE_URL=postgres://db:5432/app",
+		"GREETING=hello = world",
+		"PORT=9090",
+		"EXTRA=1",
+	}
+	if !reflect.DeepEqual(fc.config.Env, want) {
+		t.Errorf("env = %q, want %q", fc.config.Env, want)
+	}
+}
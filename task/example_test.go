@@ -20,7 +20,7 @@ func Example_newTask() {
 	}
 
 	fmt.Printf("Created task: %s with state: %v\n\n", task.Name, task.State)
-	// Output: Created task: nginx-server with state: 0
+	// Output: Created task: nginx-server with state: Pending
 }
 
 // Example_configureTaskPorts shows how to configure port mappings for a task
@@ -57,7 +57,7 @@ func Example_taskEventLifecycle() {
 	}
 
 	fmt.Printf("Task %s transitioned to state: %v\n", event.Task.Name, event.State)
-	// Output: Task background-job transitioned to state: 1
+	// Output: Task background-job transitioned to state: Scheduled
 }
 
 // Example_fullTaskConfig shows how to create a complete task configuration
@@ -0,0 +1,55 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Exec runs cmd inside the running container with the given ID, copying
+// its output to Writer and StdErr as it is produced, and returns the
+// command's exit code. It stops waiting for the command, which may still
+// be running, and returns ctx's error once ctx is done.
+func (d *Docker) Exec(ctx context.Context, containerID string, cmd []string) (int, error) {
+	d.Logger.Printf("Running %q in container %s", cmd, containerID)
+	created, err := d.Client.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("exec create failed: %w", err)
+	}
+
+	// Attaching starts the command.
+	resp, err := d.Client.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return -1, fmt.Errorf("exec attach failed: %w", err)
+	}
+	defer resp.Close()
+
+	copied := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(d.Writer, d.StdErr, resp.Reader)
+		copied <- err
+	}()
+	select {
+	case err := <-copied:
+		if err != nil {
+			return -1, fmt.Errorf("reading exec output: %w", err)
+		}
+	case <-ctx.Done():
+		// Closing the connection ends the copy, which must not write once
+		// Exec has returned.
+		resp.Close()
+		<-copied
+		return -1, ctx.Err()
+	}
+
+	inspect, err := d.Client.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return -1, fmt.Errorf("exec inspect failed: %w", err)
+	}
+	return inspect.ExitCode, nil
+}
@@ -0,0 +1,83 @@
+package task
+
+import (
+	"context"
+	"errors"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/docker/docker/api/types"
+)
+
+// Failure reasons classify why a task failed; see Task.FailureReason.
+const (
+	// FailureOOMKilled is recorded when the kernel killed the container for
+	// going over its memory limit
+	FailureOOMKilled = "oom-killed"
+
+	// FailureExitCode is recorded when the container exited with a non-zero
+	// code of its own accord
+	FailureExitCode = "non-zero exit"
+
+	// FailureDaemon is recorded when Docker could not create, start or run
+	// the container
+	FailureDaemon = "daemon error"
+
+	// FailureImagePull is recorded when the task's image could not be pulled
+	FailureImagePull = "image pull"
+
+	// FailureInvalidConfig is recorded when the task's config was refused
+	// before the container was created
+	FailureInvalidConfig = "invalid config"
+
+	// FailureTimeout is recorded when Docker did not start the container
+	// within Docker.StartTimeout
+	FailureTimeout = "timeout"
+
+	// FailureUnhealthy is recorded when the container's healthcheck reported
+	// it unhealthy
+	FailureUnhealthy = "unhealthy"
+
+	// FailureContainerGone is recorded when the container disappeared from
+	// under the worker
+	FailureContainerGone = "container gone"
+
+	// FailureRejected is recorded when the manager could not hand the task
+	// to a worker, which turned it down
+	FailureRejected = "rejected"
+
+	// FailureSecretsLost is recorded when the worker restarted before
+	// starting the task and no longer holds its secrets, which it keeps in
+	// memory only
+	FailureSecretsLost = "secrets lost"
+)
+
+// ExitFailure classifies why a stopped container failed from the state Docker
+// reports for it: running out of memory takes precedence over an error from
+// Docker, which takes precedence over the exit code. It returns "" for a
+// container that exited cleanly.
+func ExitFailure(state *types.ContainerState) string {
+	switch {
+	case state == nil:
+		return ""
+	case state.OOMKilled:
+		return FailureOOMKilled
+	case state.Error != "":
+		return FailureDaemon
+	case state.ExitCode != 0:
+		return FailureExitCode
+	}
+	return ""
+}
+
+// StartFailure classifies an error starting a task's container.
+func StartFailure(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case errors.Is(err, cubeerrors.ErrImagePull):
+		return FailureImagePull
+	case errors.Is(err, cubeerrors.ErrInvalidRequest), errors.Is(err, cubeerrors.ErrAPIVersionTooOld):
+		return FailureInvalidConfig
+	default:
+		return FailureDaemon
+	}
+}
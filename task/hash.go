@@ -0,0 +1,51 @@
+package task
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/docker/go-connections/nat"
+	"slices"
+)
+
+// spec holds the fields of a task that decide how its container runs, in a
+// canonical form for SpecHash.
+type spec struct {
+	Image         string
+	Memory        int
+	Disk          int
+	ExposedPorts  nat.PortMap
+	PortBindings  map[string]string
+	Env           []string
+	RestartPolicy string
+
+	// RestartMaxRetries and MemoryReservation are left out when zero so
+	// hashes taken before they were added still match.
+	RestartMaxRetries int `json:",omitempty"`
+	MemoryReservation int `json:",omitempty"`
+}
+
+// SpecHash returns a hash of the task's image, memory limit and reservation,
+// disk, ports, environment and restart policy, with its retry cap. Tasks
+// whose containers would run the same way hash alike whatever order their
+// environment variables were listed in, so comparing a running task's hash
+// with its desired spec's reveals drift. State, timestamps and other runtime
+// fields do not affect the hash.
+func (t Task) SpecHash() string {
+	s := spec{
+		Image:             t.Image,
+		Memory:            t.Memory,
+		Disk:              t.Disk,
+		ExposedPorts:      t.ExposedPorts,
+		PortBindings:      t.PortBindings,
+		Env:               slices.Sorted(slices.Values(t.Env)),
+		RestartPolicy:     t.RestartPolicy,
+		RestartMaxRetries: t.RestartMaxRetries,
+		MemoryReservation: t.MemoryReservation,
+	}
+	// Maps marshal with sorted keys, so the encoding is stable, and none of
+	// the fields can fail to marshal.
+	data, _ := json.Marshal(s)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
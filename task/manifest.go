@@ -0,0 +1,43 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+)
+
+// ParseManifest reads a task manifest: the task as POST /tasks takes it, in
+// JSON or YAML. The format is told from the content, JSON being a document
+// that starts with "{". YAML keys are matched to fields as JSON keys are,
+// and in either format an unknown key is an error.
+func ParseManifest(r io.Reader) (Task, error) {
+	var t Task
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return t, fmt.Errorf("reading manifest: %w", err)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return t, errors.New("manifest is empty")
+	}
+
+	if data[0] != '{' {
+		var doc map[string]any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return t, fmt.Errorf("parsing YAML manifest: %w", err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return t, fmt.Errorf("parsing YAML manifest: %w", err)
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&t); err != nil {
+		return t, fmt.Errorf("parsing manifest: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"github.com/distribution/reference"
+	"strings"
+)
+
+// MirrorImage rewrites the image reference ref to be pulled from mirror, a
+// registry host such as "mirror.example.com:5000" optionally followed by a
+// path prefix. The repository path is kept, including the library/ prefix
+// of official Docker Hub images, as are the tag and digest, so "nginx:1.27"
+// becomes "mirror.example.com:5000/library/nginx:1.27". An empty mirror
+// leaves ref as it is.
+func MirrorImage(ref, mirror string) (string, error) {
+	mirror = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(mirror, "https://"), "http://"), "/")
+	if mirror == "" {
+		return ref, nil
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", err
+	}
+
+	mirrored := mirror + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		mirrored += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		mirrored += "@" + digested.Digest().String()
+	}
+	return mirrored, nil
+}
+
+// image returns the reference the container's image is pulled and run from:
+// Image rewritten for the RegistryMirror when one is set. A reference that
+// cannot be parsed is returned unchanged for the daemon to reject.
+func (d *Docker) image() string {
+	mirrored, err := MirrorImage(d.Config.Image, d.Config.RegistryMirror)
+	if err != nil {
+		return d.Config.Image
+	}
+	return mirrored
+}
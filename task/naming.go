@@ -0,0 +1,41 @@
+package task
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// nameAttempts is how many freshly suffixed names ContainerCreate tries
+// before giving up on a name Docker keeps reporting as in use.
+const nameAttempts = 3
+
+// nameSuffixLen is the number of random bytes, written as twice as many hex
+// digits, that ContainerName appends to a task's name.
+const nameSuffixLen = 4
+
+// ContainerName returns a Docker container name for a task called name: the
+// name, with any character Docker does not allow replaced by a dash, and a
+// short random suffix so tasks sharing a name get containers of their own.
+// A task without a name gets containers named "cube" and a suffix. It fails
+// only when no random bytes can be read for the suffix.
+func ContainerName(name string) (string, error) {
+	prefix := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, name)
+	// Docker names must start with a letter or digit.
+	prefix = strings.TrimLeft(prefix, "_.-")
+	if prefix == "" {
+		prefix = "cube"
+	}
+
+	suffix := make([]byte, nameSuffixLen)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("generating container name suffix: %w", err)
+	}
+	return prefix + "-" + hex.EncodeToString(suffix), nil
+}
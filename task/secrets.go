@@ -0,0 +1,241 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/docker/docker/api/types/mount"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrSecretNotFound is returned when a SecretStore has no secret by a name.
+var ErrSecretNotFound = fmt.Errorf("secret %w", cubeerrors.ErrNotFound)
+
+// SecretRef names a secret held by the manager and says how the task's
+// container receives its value: in an environment variable, in a file, or
+// both. The value itself never travels with the task.
+type SecretRef struct {
+	// Name is the secret's name in the SecretStore
+	Name string
+
+	// Env, when set, is the environment variable the value is passed in
+	Env string `json:",omitempty"`
+
+	// File, when set, is the absolute path in the container of a read-only
+	// file, readable only by the container's user, holding the value. The
+	// file lives on a tmpfs on the host and is bind-mounted into the
+	// container, so the task's User must be a uid, uid:gid or root.
+	File string `json:",omitempty"`
+}
+
+// SecretStore looks up the values of secrets by name.
+type SecretStore interface {
+	// Secret returns the value of the named secret, or an error wrapping
+	// ErrSecretNotFound when there is none
+	Secret(ctx context.Context, name string) ([]byte, error)
+}
+
+// SecretMap is a SecretStore holding secrets in memory.
+type SecretMap map[string][]byte
+
+// Secret returns the value of the named secret.
+func (m SecretMap) Secret(ctx context.Context, name string) ([]byte, error) {
+	value, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+func (r SecretRef) validate() error {
+	if r.Name == "" {
+		return errors.New("secret has no name")
+	}
+	if r.Env == "" && r.File == "" {
+		return fmt.Errorf("secret %s is neither passed in an environment variable nor a file", r.Name)
+	}
+	if strings.Contains(r.Env, "=") {
+		return fmt.Errorf("secret %s environment variable %q must not contain =", r.Name, r.Env)
+	}
+	if r.File != "" && !path.IsAbs(r.File) {
+		return fmt.Errorf("secret %s file %q must be an absolute path", r.Name, r.File)
+	}
+	return nil
+}
+
+// resolvedSecrets are the values of a config's secrets, ready to hand to its
+// container.
+type resolvedSecrets struct {
+	env    []string
+	files  map[string][]byte
+	values [][]byte
+}
+
+// resolveSecrets fetches the values of the config's secrets from the store.
+func (d *Docker) resolveSecrets(ctx context.Context) (resolvedSecrets, error) {
+	resolved := resolvedSecrets{files: make(map[string][]byte)}
+	if len(d.Config.Secrets) == 0 {
+		return resolved, nil
+	}
+	if d.Secrets == nil {
+		return resolved, errors.New("task has secrets but there is no secret store")
+	}
+
+	for _, ref := range d.Config.Secrets {
+		value, err := d.Secrets.Secret(ctx, ref.Name)
+		if err != nil {
+			return resolved, fmt.Errorf("fetching secret %s: %w", ref.Name, err)
+		}
+		resolved.values = append(resolved.values, value)
+		if ref.Env != "" {
+			resolved.env = append(resolved.env, ref.Env+"="+string(value))
+		}
+		if ref.File != "" {
+			resolved.files[ref.File] = value
+		}
+	}
+	return resolved, nil
+}
+
+// DefaultSecretsDir is the host directory secret files are written under
+// when the Docker has no SecretsDir. /dev/shm is a tmpfs, so the values
+// never reach the host's disk.
+const DefaultSecretsDir = "/dev/shm/cube-secrets"
+
+// LabelSecretsDir is the container label holding the host directory its
+// secret files were written to, which goes when the container is removed.
+const LabelSecretsDir = "io.cube.secrets-dir"
+
+// writeSecretFiles writes the secret files to a fresh directory under
+// SecretsDir, each readable only by the container's user, and returns the
+// directory with the read-only bind mounts that place the files in the
+// container. Bind mounts work whatever the container's user and even with a
+// read-only root filesystem.
+func (d *Docker) writeSecretFiles(files map[string][]byte) (string, []mount.Mount, error) {
+	if len(files) == 0 {
+		return "", nil, nil
+	}
+	uid, gid, err := secretOwner(d.Config.User)
+	if err != nil {
+		return "", nil, err
+	}
+
+	base := d.SecretsDir
+	if base == "" {
+		base = DefaultSecretsDir
+	}
+	if err := os.MkdirAll(base, 0o700); err != nil {
+		return "", nil, err
+	}
+	dir, err := os.MkdirTemp(base, "secrets-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	var mounts []mount.Mount
+	for i, file := range slices.Sorted(maps.Keys(files)) {
+		source := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.WriteFile(source, files[file], 0o400); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		if err := os.Chown(source, uid, gid); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		mounts = append(mounts, mount.Mount{Type: mount.TypeBind, Source: source, Target: file, ReadOnly: true})
+	}
+	return dir, mounts, nil
+}
+
+// secretOwner returns the uid and gid secret files are owned by for a
+// container running as user. Names other than root would need the image's
+// /etc/passwd to resolve, so they are refused.
+func secretOwner(user string) (int, int, error) {
+	name, group, _ := strings.Cut(user, ":")
+	uid, err := secretID(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("secret files need the user as a uid, not %q", user)
+	}
+	gid, err := secretID(group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("secret files need the group as a gid, not %q", user)
+	}
+	return uid, gid, nil
+}
+
+func secretID(id string) (int, error) {
+	if id == "" || id == "root" {
+		return 0, nil
+	}
+	return strconv.Atoi(id)
+}
+
+// removeSecretFiles deletes the host directory holding the secret files of
+// the container with the given ID. It must run before the container is
+// removed, while its label can still be read.
+func (d *Docker) removeSecretFiles(ctx context.Context, containerID string) {
+	if !slices.ContainsFunc(d.Config.Secrets, func(r SecretRef) bool { return r.File != "" }) {
+		return
+	}
+	resp, err := d.Client.ContainerInspect(ctx, containerID)
+	if err != nil || resp.Config == nil {
+		return
+	}
+	if dir := resp.Config.Labels[LabelSecretsDir]; dir != "" {
+		if err := os.RemoveAll(dir); err != nil {
+			d.Logger.Printf("Error removing secret files of container %s: %v", containerID, err)
+		}
+	}
+}
+
+// redactor replaces secret values with a placeholder in text bound for logs.
+type redactor struct {
+	values [][]byte
+}
+
+const redacted = "[REDACTED]"
+
+func (r redactor) redact(s string) string {
+	for _, value := range r.values {
+		if len(value) > 0 {
+			s = strings.ReplaceAll(s, string(value), redacted)
+		}
+	}
+	return s
+}
+
+// redactingLogger is a Logger that redacts secret values before passing
+// lines on.
+type redactingLogger struct {
+	Logger
+	redactor
+}
+
+func (l redactingLogger) Printf(format string, args ...interface{}) {
+	l.Logger.Printf("%s", l.redact(fmt.Sprintf(format, args...)))
+}
+
+// redactedError hides secret values in an error's message while keeping the
+// error chain for errors.Is and errors.As.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+func (r redactor) redactError(err error) error {
+	if err == nil || len(r.values) == 0 {
+		return err
+	}
+	return &redactedError{err: err, msg: r.redact(err.Error())}
+}
@@ -0,0 +1,119 @@
+package task_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/docker/docker/api/types/mount"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDocker_ContainerCreateSecrets(t *testing.T) {
+	const password = "s3cr3t-hunter2"
+	logs := bytes.Buffer{}
+	fc := &fakeClient{}
+	d := newDocker(fc, task.Config{
+		Name:  "db",
+		Image: "postgres:16",
+		Env:   []string{"PGUSER=app"},
+		User:  fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+		Secrets: []task.SecretRef{
+			{Name: "db-password", Env: "PGPASSWORD", File: "/run/secrets/db-password"},
+		},
+		ReadonlyRootfs: true,
+	})
+	d.Logger = log.New(&logs, "", 0)
+	d.Secrets = task.SecretMap{"db-password": []byte(password)}
+	d.SecretsDir = t.TempDir()
+
+	if _, err := d.ContainerCreate(context.Background()); err != nil {
+		t.Fatalf("ContainerCreate() error = %v", err)
+	}
+	if !slices.Contains(fc.config.Env, "PGPASSWORD="+password) {
+		t.Errorf("container env = %v, want the secret in PGPASSWORD", fc.config.Env)
+	}
+	i := slices.IndexFunc(fc.hostConfig.Mounts, func(m mount.Mount) bool { return m.Target == "/run/secrets/db-password" })
+	if i < 0 || !fc.hostConfig.Mounts[i].ReadOnly || fc.hostConfig.Mounts[i].Type != mount.TypeBind {
+		t.Fatalf("host config mounts = %+v, want the secret file bound read-only", fc.hostConfig.Mounts)
+	}
+	source := fc.hostConfig.Mounts[i].Source
+	if data, err := os.ReadFile(source); err != nil || string(data) != password {
+		t.Errorf("secret file holds %q (error %v), want the secret", data, err)
+	}
+	if info, err := os.Stat(source); err != nil || info.Mode().Perm() != 0o400 {
+		t.Errorf("secret file mode = %v (error %v), want 0400", info.Mode().Perm(), err)
+	}
+	if dir := fc.config.Labels[task.LabelSecretsDir]; dir != filepath.Dir(source) {
+		t.Errorf("secrets dir label = %q, want %q", dir, filepath.Dir(source))
+	}
+	if slices.ContainsFunc(d.Config.Env, func(e string) bool { return strings.Contains(e, password) }) {
+		t.Errorf("config env = %v, want the secret kept out of it", d.Config.Env)
+	}
+
+	d.Logger.Printf("Creating container with env %v", fc.config.Env)
+	if strings.Contains(logs.String(), password) || !strings.Contains(logs.String(), "[REDACTED]") {
+		t.Errorf("logs = %q, want the secret redacted", logs.String())
+	}
+}
+
+func TestDocker_ContainerCreateRedactsSecretsFromErrors(t *testing.T) {
+	const password = "s3cr3t-hunter2"
+	daemonErr := fmt.Errorf("invalid environment PGPASSWORD=%s", password)
+	fc := &fakeClient{createErr: daemonErr}
+	d := newDocker(fc, task.Config{
+		Name:    "db",
+		Image:   "postgres:16",
+		Secrets: []task.SecretRef{{Name: "db-password", Env: "PGPASSWORD"}},
+	})
+	d.Secrets = task.SecretMap{"db-password": []byte(password)}
+
+	_, err := d.ContainerCreate(context.Background())
+	if err == nil {
+		t.Fatal("ContainerCreate() succeeded, want the daemon's error")
+	}
+	if strings.Contains(err.Error(), password) {
+		t.Errorf("error %q reveals the secret", err)
+	}
+	if !errors.Is(err, daemonErr) {
+		t.Errorf("error %v does not wrap the daemon's error", err)
+	}
+}
+
+func TestDocker_ContainerCreateMissingSecret(t *testing.T) {
+	d := newDocker(&fakeClient{}, task.Config{
+		Name:    "db",
+		Image:   "postgres:16",
+		Secrets: []task.SecretRef{{Name: "db-password", Env: "PGPASSWORD"}},
+	})
+	d.Secrets = task.SecretMap{}
+
+	if _, err := d.ContainerCreate(context.Background()); !errors.Is(err, task.ErrSecretNotFound) {
+		t.Errorf("ContainerCreate() error = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestConfig_ValidateSecrets(t *testing.T) {
+	for _, ref := range []task.SecretRef{
+		{Env: "PGPASSWORD"},
+		{Name: "db-password"},
+		{Name: "db-password", Env: "A=B"},
+		{Name: "db-password", File: "secrets/db"},
+	} {
+		cfg := task.Config{Secrets: []task.SecretRef{ref}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() accepted secret %+v", ref)
+		}
+	}
+
+	cfg := task.Config{User: "postgres", Secrets: []task.SecretRef{{Name: "db-password", File: "/run/secrets/db"}}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted a secret file for a named user")
+	}
+}
@@ -0,0 +1,122 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// stateNames maps each state to the name used in logs and JSON.
+var stateNames = map[State]string{
+	Pending:   "Pending",
+	Scheduled: "Scheduled",
+	Running:   "Running",
+	Completed: "Completed",
+	Failed:    "Failed",
+	Paused:    "Paused",
+	Cancelled: "Cancelled",
+}
+
+// stateTransitionMap lists the states a task may move to from each state.
+var stateTransitionMap = map[State][]State{
+	Pending:   {Scheduled},
+	Scheduled: {Scheduled, Running, Failed, Cancelled},
+	Running:   {Running, Completed, Failed, Paused, Cancelled},
+	Completed: {},
+	Failed:    {},
+	Paused:    {Paused, Running, Completed, Failed, Cancelled},
+	Cancelled: {},
+}
+
+// ValidStateTransition reports whether a task may move from the src state to the dst state.
+func ValidStateTransition(src State, dst State) bool {
+	return slices.Contains(stateTransitionMap[src], dst)
+}
+
+// Terminal reports whether a task in the state has finished for good.
+func (s State) Terminal() bool {
+	return s == Completed || s == Failed || s == Cancelled
+}
+
+// ShouldRestart reports whether the task's restart policy asks for it to be
+// started again now that it has finished: "always" and "unless-stopped"
+// restart a completed or failed task and "on-failure" only a failed one. A
+// cancelled task was stopped on purpose and is never restarted.
+func (t Task) ShouldRestart() bool {
+	switch t.RestartPolicy {
+	case "always", "unless-stopped":
+		return t.State == Completed || t.State == Failed
+	case "on-failure":
+		return t.State == Failed
+	default:
+		return false
+	}
+}
+
+// MaxRestartDelay caps the delay of a task restarted with RestartBackoff.
+const MaxRestartDelay = 10 * time.Minute
+
+// NextRestartDelay returns how long the manager waits before restarting the
+// task: its RestartDelay, doubled for each restart so far with
+// RestartBackoff, up to MaxRestartDelay.
+func (t Task) NextRestartDelay() time.Duration {
+	delay := t.RestartDelay
+	if !t.RestartBackoff {
+		return delay
+	}
+	for range t.RestartCount {
+		if delay >= MaxRestartDelay {
+			break
+		}
+		delay *= 2
+	}
+	return min(delay, MaxRestartDelay)
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// MarshalJSON encodes the state by name.
+func (s State) MarshalJSON() ([]byte, error) {
+	name, ok := stateNames[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown task state %d", int(s))
+	}
+	return json.Marshal(name)
+}
+
+// UnmarshalJSON decodes a state from its name, or from its number as
+// written before states were encoded by name.
+func (s *State) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		*s = State(number)
+		return nil
+	}
+
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("task state must be a name or number: %w", err)
+	}
+	state, err := ParseState(name)
+	if err != nil {
+		return err
+	}
+	*s = state
+	return nil
+}
+
+// ParseState returns the state with the given name, such as "Running".
+func ParseState(name string) (State, error) {
+	for state, stateName := range stateNames {
+		if stateName == name {
+			return state, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown task state %q", name)
+}
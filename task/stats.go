@@ -0,0 +1,108 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"io"
+	"strings"
+)
+
+// ContainerStats is a point-in-time view of a container's resource usage.
+type ContainerStats struct {
+	// CPUPercent is the share of the host's CPU the container used since the
+	// previous sample, where 100 is one full core
+	CPUPercent float64
+
+	// MemoryUsage is the memory the container is using, in bytes
+	MemoryUsage uint64
+
+	// MemoryLimit is the memory the container may use, in bytes
+	MemoryLimit uint64
+
+	// Networks holds the traffic of each of the container's network
+	// interfaces, keyed by interface name
+	Networks map[string]NetworkStats `json:",omitempty"`
+
+	// NetworkRxBytes and NetworkTxBytes total the bytes received and sent
+	// across all interfaces
+	NetworkRxBytes uint64
+	NetworkTxBytes uint64
+
+	// BlockReadBytes and BlockWriteBytes total the bytes the container read
+	// from and wrote to block devices
+	BlockReadBytes  uint64
+	BlockWriteBytes uint64
+}
+
+// NetworkStats is the traffic of one network interface, in bytes.
+type NetworkStats struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// ParseContainerStats decodes a stats sample as returned by the Docker API.
+func ParseContainerStats(r io.Reader) (ContainerStats, error) {
+	var s container.StatsResponse
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return ContainerStats{}, fmt.Errorf("decoding container stats: %w", err)
+	}
+
+	stats := ContainerStats{
+		CPUPercent:  cpuPercent(s.Stats),
+		MemoryUsage: s.MemoryStats.Usage,
+		MemoryLimit: s.MemoryStats.Limit,
+	}
+	for name, n := range s.Networks {
+		if stats.Networks == nil {
+			stats.Networks = make(map[string]NetworkStats, len(s.Networks))
+		}
+		stats.Networks[name] = NetworkStats{RxBytes: n.RxBytes, TxBytes: n.TxBytes}
+		stats.NetworkRxBytes += n.RxBytes
+		stats.NetworkTxBytes += n.TxBytes
+	}
+	stats.BlockReadBytes, stats.BlockWriteBytes = blockIO(s.BlkioStats)
+	return stats, nil
+}
+
+// blockIO totals the bytes read and written across block devices. cgroup v1
+// reports the operations capitalised and cgroup v2 in lower case.
+func blockIO(s container.BlkioStats) (read, write uint64) {
+	for _, e := range s.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			read += e.Value
+		case "write":
+			write += e.Value
+		}
+	}
+	return read, write
+}
+
+// cpuPercent computes CPU usage the same way `docker stats` does: the
+// container's share of the CPU time that elapsed between the two samples.
+func cpuPercent(s container.Stats) float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// Stats samples the resource usage of the container with the given ID.
+func (d *Docker) Stats(containerID string) (ContainerStats, error) {
+	resp, err := d.Client.ContainerStats(context.Background(), containerID, false)
+	if err != nil {
+		return ContainerStats{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return ParseContainerStats(resp.Body)
+}
@@ -0,0 +1,37 @@
+package task_test
+
+import (
+	"github.com/christinavaneyssen/cube/task"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestParseContainerStats(t *testing.T) {
+	f, err := os.Open("testdata/stats.json")
+	if err != nil {
+		t.Fatalf("opening fixture: %v", err)
+	}
+	defer f.Close()
+
+	got, err := task.ParseContainerStats(f)
+	if err != nil {
+		t.Fatalf("ParseContainerStats() error = %v", err)
+	}
+
+	if math.Abs(got.CPUPercent-20) > 1e-9 {
+		t.Errorf("CPUPercent = %v, want 20", got.CPUPercent)
+	}
+	if got.MemoryUsage != 52428800 || got.MemoryLimit != 1073741824 {
+		t.Errorf("memory = %d/%d, want 52428800/1073741824", got.MemoryUsage, got.MemoryLimit)
+	}
+	if got.NetworkRxBytes != 2000 || got.NetworkTxBytes != 1000 {
+		t.Errorf("network rx/tx = %d/%d, want 2000/1000", got.NetworkRxBytes, got.NetworkTxBytes)
+	}
+	if eth1 := got.Networks["eth1"]; eth1 != (task.NetworkStats{RxBytes: 500, TxBytes: 300}) {
+		t.Errorf("eth1 = %+v, want 500 received and 300 sent", eth1)
+	}
+	if got.BlockReadBytes != 5120 || got.BlockWriteBytes != 10240 {
+		t.Errorf("block read/write = %d/%d, want 5120/10240", got.BlockReadBytes, got.BlockWriteBytes)
+	}
+}
@@ -4,17 +4,28 @@
 package task
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 	"github.com/google/uuid"
 	"io"
-	"log"
+	"maps"
 	"math"
+	"os"
+	"slices"
+	"strconv"
 	"time"
 )
 
@@ -37,6 +48,49 @@ const (
 
 	// Failed indicates the task terminated abnormally due to error or crash
 	Failed
+
+	// Paused indicates the task's container is frozen but has not stopped
+	Paused
+
+	// Cancelled indicates the task was stopped on request, by a user or by
+	// the orchestrator, rather than finishing or crashing on its own
+	Cancelled
+)
+
+// Reasons recorded for state changes made on request.
+const (
+	// ReasonCancelledByUser is recorded when a user stops a task
+	ReasonCancelledByUser = "cancelled by user"
+
+	// ReasonRestarted is recorded when a task is stopped to be restarted
+	ReasonRestarted = "restarted by user"
+
+	// ReasonMoved is recorded when a task is stopped to run on another worker
+	ReasonMoved = "moved to another worker"
+
+	// ReasonRolloutFailed is recorded when a task's new container is stopped
+	// for not becoming healthy during a rolling update
+	ReasonRolloutFailed = "new container did not become healthy"
+
+	// ReasonWorkerLost is recorded when a task is rescheduled because its
+	// worker stopped sending heartbeats
+	ReasonWorkerLost = "worker stopped sending heartbeats"
+
+	// ReasonReplaced is recorded when a task is stopped for a submission
+	// replacing it by name
+	ReasonReplaced = "replaced by a new submission"
+
+	// ReasonWorkerShutdown is recorded when a task is stopped because its
+	// worker shut down before the task finished
+	ReasonWorkerShutdown = "worker shut down"
+
+	// ReasonJobCancelled is recorded when a task is stopped because the job
+	// it belongs to was cancelled
+	ReasonJobCancelled = "job cancelled"
+
+	// ReasonRestartPolicy is recorded when the manager queues a finished
+	// task again as its restart policy asks
+	ReasonRestartPolicy = "restarted by its restart policy"
 )
 
 // Task represents a containerized workload with its configuration and runtime state.
@@ -48,18 +102,69 @@ type Task struct {
 	// Name is a human-readable identifier for the task
 	Name string
 
+	// Namespace is the tenant the task belongs to, whose quota it counts
+	// against; empty for the default namespace
+	Namespace string `json:",omitempty"`
+
+	// Annotations hold free-form metadata about the task, such as its owner
+	// or the ticket it was run for. They are stored and returned with the
+	// task but never affect how or where it runs.
+	Annotations map[string]string `json:",omitempty"`
+
 	// State indicates the current lifecycle stage of the task
 	State State
 
 	// Image specifies the container image to be used
 	Image string
 
+	// PullPolicy decides whether the image is pulled before the task runs;
+	// see Config
+	PullPolicy PullPolicy
+
+	// Cpu specifies the number of CPUs to allocate to the container
+	Cpu float64
+
+	// CpuModel selects whether Cpu is a hard limit or a relative weight; see
+	// Config
+	CpuModel CpuModel
+
+	// GPUs is the number of NVIDIA GPUs the task needs. Only nodes with that
+	// many free GPUs are candidates to run it.
+	GPUs int
+
 	// Memory specifies the amount of memory in MB to allocate to the container
 	Memory int
 
+	// MemoryReservation is the memory, in MB, the task needs in the usual
+	// run of things, a soft limit below the hard Memory limit. Schedulers
+	// place the task by its reservation when it has one; see MemoryClaim.
+	MemoryReservation int
+
 	// Disk specifies the amount of disk space in MB to allocate to the container
 	Disk int
 
+	// Env lists KEY=VALUE environment variables for the container
+	Env []string
+
+	// EnvFiles are files on the worker of KEY=VALUE lines added to the
+	// container's environment; variables in Env take precedence
+	EnvFiles []string
+
+	// User and WorkingDir override the image's user and working directory;
+	// see Config
+	User       string
+	WorkingDir string
+
+	// ReadonlyRootfs, CapAdd, CapDrop and SecurityOpt harden the container;
+	// see Config
+	ReadonlyRootfs bool
+	CapAdd         []string
+	CapDrop        []string
+	SecurityOpt    []string
+
+	// SeccompProfile confines the container's system calls; see Config
+	SeccompProfile string
+
 	// ExposedPorts defines which ports are exposed by the container
 	ExposedPorts nat.PortMap
 
@@ -75,11 +180,178 @@ type Task struct {
 	// 	- "on-failure": restart the container only on non-zero exit code
 	RestartPolicy string
 
+	// RestartMaxRetries caps how many times Docker restarts the container
+	// under the "on-failure" policy; see Config
+	RestartMaxRetries int
+
+	// RestartDelay, when set, has the manager rather than Docker restart
+	// the task under its RestartPolicy, queueing it again only once the
+	// delay has passed. With RestartBackoff the delay doubles with every
+	// restart, up to MaxRestartDelay.
+	RestartDelay   time.Duration `json:",omitempty"`
+	RestartBackoff bool          `json:",omitempty"`
+
+	// CreatedAt records when the task was submitted to the manager, and
+	// UpdatedAt when its state last changed
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
 	// StartTime records when the task began execution
 	StartTime time.Time
 
 	// FinishTime records when the task completed execution
 	FinishTime time.Time
+
+	// ContainerID identifies the container running the task once it has started
+	ContainerID string
+
+	// AutoRemove has Docker remove the container as soon as it exits
+	AutoRemove bool
+
+	// Checkpointable marks a task whose running state can be saved with
+	// CRIU and restored later, so that it can survive being moved off a
+	// draining node
+	Checkpointable bool `json:",omitempty"`
+
+	// ExitCode is the exit code of the task's container once it has exited
+	ExitCode int
+
+	// Mounts attaches host paths or named volumes to the container
+	Mounts []Mount
+
+	// Devices passes host devices, such as /dev/fuse, through to the
+	// container; see DeviceMapping
+	Devices []DeviceMapping `json:",omitempty"`
+
+	// Secrets names secrets held by the manager to pass to the container;
+	// see SecretRef
+	Secrets []SecretRef
+
+	// Tmpfs mounts in-memory filesystems in the container; see Config
+	Tmpfs map[string]string
+
+	// DNS, DNSSearch and ExtraHosts customise name resolution in the
+	// container; see Config
+	DNS        []string
+	DNSSearch  []string
+	ExtraHosts []string
+
+	// Ulimits raises or lowers the container's resource limits; see Config
+	Ulimits []Ulimit
+
+	// StopSignal is the signal sent to stop the container; see Config
+	StopSignal string
+
+	// Entrypoint overrides the image's entrypoint; see Config
+	Entrypoint []string
+
+	// NetworkAliases joins the container to networks under the names peers
+	// reach it by; see Config
+	NetworkAliases map[string][]string
+
+	// Replicas is the number of copies of the task, sharing its Name, the
+	// manager keeps running. Zero opts the task out of autoscaling.
+	Replicas int
+
+	// PreferredNodes names the nodes the task should run on when one of them
+	// has room for it. Unlike a constraint, it never keeps the task from
+	// running elsewhere; see scheduler.Prefer.
+	PreferredNodes []string
+
+	// IdempotencyKey identifies a submission so a retried submission returns
+	// the task it already created instead of creating another
+	IdempotencyKey string
+
+	// JobID is the job the task was submitted as part of; uuid.Nil for a
+	// task submitted on its own
+	JobID uuid.UUID
+
+	// CompletionWebhook, when set, is the URL the manager posts the task to
+	// once it finishes in one of CompletionWebhookStates, or in any finished
+	// state when that is empty, in place of the manager's own webhook
+	CompletionWebhook       string  `json:",omitempty"`
+	CompletionWebhookStates []State `json:",omitempty"`
+
+	// Discrepancies lists the resource limits the Docker daemon applied
+	// differently from those requested, such as a memory limit it clamped
+	Discrepancies []string
+
+	// HealthCmd, HealthInterval and HealthRetries configure a Docker
+	// healthcheck for the container; see Config. The task fails once Docker
+	// reports its container unhealthy.
+	HealthCmd      []string
+	HealthInterval time.Duration
+	HealthRetries  int
+
+	// Health is the status Docker's healthcheck last reported for the
+	// running container: "starting", "healthy" or "unhealthy". It is empty
+	// when the container has no healthcheck.
+	Health string `json:",omitempty"`
+
+	// CaptureResult has the worker keep the last line a one-shot task
+	// writes to standard output, once its container exits successfully, as
+	// its Result
+	CaptureResult bool
+
+	// Result is the payload a task with CaptureResult reported, cut short
+	// at the worker's MaxResultSize; empty if it wrote nothing
+	Result string `json:",omitempty"`
+
+	// RollingUpdate has the manager start the task's new container and wait
+	// for it to be healthy before stopping the old one when it moves the
+	// task, or the tasks it replaces when it is submitted to replace them,
+	// instead of stopping the old ones first
+	RollingUpdate bool
+
+	// StickyWorker has the manager place the task, when it is rescheduled,
+	// back on the worker it last ran on if that worker can take it, so it
+	// finds its image and volumes there
+	StickyWorker bool `json:",omitempty"`
+
+	// RestartCount is the number of times the task has been restarted. Each
+	// restart starts a new container lifecycle under the same task ID.
+	RestartCount int
+
+	// Reason explains the task's last state change when it was not the task's
+	// own doing, such as "cancelled by user"
+	Reason string `json:",omitempty"`
+
+	// FailureReason classifies why a failed task failed, such as
+	// FailureOOMKilled, telling memory pressure apart from a bug in the task;
+	// empty unless the task failed
+	FailureReason string `json:",omitempty"`
+
+	// ScheduledAt holds the task in Pending until the given time, after which
+	// the manager may schedule it. The zero time schedules it right away.
+	ScheduledAt time.Time
+
+	// TraceID correlates the log lines of the task's journey from submission
+	// through dispatch to the worker running it
+	TraceID string
+
+	// InitTasks are setup steps run to completion, one after another, before
+	// the task's own container starts. The task fails if any of them exits
+	// with a non-zero code.
+	InitTasks []Config
+}
+
+// MemoryClaim returns the memory, in MB, the task claims on the node it is
+// placed on: its MemoryReservation when it has one, and its Memory
+// otherwise.
+func (t Task) MemoryClaim() int {
+	if t.MemoryReservation > 0 {
+		return t.MemoryReservation
+	}
+	return t.Memory
+}
+
+// Compare orders tasks by CreatedAt and then by ID, so lists of tasks read
+// the same every time they are built from a map.
+func Compare(a, b *Task) int {
+	if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
 }
 
 // TaskEvent represents a point-in-time state change of a task in the orchestration.
@@ -96,11 +368,20 @@ type TaskEvent struct {
 
 	// Task contains the complete task information at the time of the event
 	Task Task
+
+	// Reason records who or what triggered the transition, when it was not
+	// the task's own doing
+	Reason string `json:",omitempty"`
+
+	// Secrets holds the values of the task's secrets, by name, when the
+	// manager sends the task to a worker to start. They are never stored.
+	Secrets SecretMap `json:",omitempty"`
 }
 
 // Config defines the configuration parameters for an orchestration task.
 type Config struct {
-	// Name specifies both the task name and container name
+	// Name is the task name, which prefixes the container name; see
+	// ContainerName
 	Name string
 
 	// AttachStdin indicates whether to attach to the container's standard input
@@ -118,16 +399,47 @@ type Config struct {
 	// Cmd specifies the command to run in the container
 	Cmd []string
 
+	// Entrypoint replaces the entrypoint of the image, for images whose own
+	// entrypoint gets in the way. Docker then ignores the image's default
+	// command too. Empty keeps the image's entrypoint.
+	Entrypoint []string
+
+	// NetworkAliases maps the name of each user-defined network the
+	// container joins to the DNS names, such as a service name, other
+	// containers on that network resolve it by. A network with no aliases is
+	// still joined.
+	NetworkAliases map[string][]string
+
 	// Image represents the name of the container image to run
 	Image string
 
-	// Cpu defines the amount of CPU resources to allocate to the container in CPU shares
+	// PullPolicy decides whether Image is pulled before the container is
+	// created; PullAlways when empty
+	PullPolicy PullPolicy
+
+	// RegistryMirror is the registry Image is pulled from instead of its
+	// own, keeping its repository path; see MirrorImage
+	RegistryMirror string
+
+	// Cpu defines the amount of CPU resources to allocate to the container,
+	// in CPUs; CpuModel decides whether it is a hard limit or a relative weight
 	Cpu float64
 
+	// CpuModel selects how Cpu is applied; CpuQuota when empty
+	CpuModel CpuModel
+
+	// GPUs is the number of NVIDIA GPUs exposed to the container
+	GPUs int
+
 	// Memory specifies the memory limit in bytes for the container
 	// The scheduler uses this value to find a suitable node in the cluster
 	Memory int64
 
+	// MemoryReservation is the soft memory limit in bytes: Docker lets the
+	// container use up to Memory, but reclaims memory above the reservation
+	// first when the host runs short. Zero sets no reservation.
+	MemoryReservation int64
+
 	// Disk specifies the disk space limit in bytes for the container
 	// The scheduler uses this value to find a suitable node in the cluster
 	Disk int64
@@ -135,8 +447,175 @@ type Config struct {
 	// Env specifies environment variables to pass to the container
 	Env []string
 
+	// EnvFiles are files of KEY=VALUE lines merged into Env before the
+	// container is created. Variables set in Env take precedence.
+	EnvFiles []string
+
+	// User runs the container's processes as a user, and optionally group,
+	// given by name or ID: "nobody", "1000" or "1000:1000". Empty keeps the
+	// image's user.
+	User string
+
+	// WorkingDir is the absolute path the container's command starts in.
+	// Empty keeps the image's working directory.
+	WorkingDir string
+
+	// ReadonlyRootfs mounts the container's root filesystem read-only
+	ReadonlyRootfs bool
+
+	// CapAdd and CapDrop add and drop Linux capabilities, such as
+	// "NET_BIND_SERVICE" or "ALL", to and from Docker's default set
+	CapAdd  []string
+	CapDrop []string
+
+	// SecurityOpt passes security options such as "no-new-privileges" or
+	// "seccomp=unconfined" to Docker
+	SecurityOpt []string
+
+	// SeccompProfile is the path, on the worker, of a JSON seccomp profile
+	// limiting the system calls the container may make, or
+	// SeccompUnconfined to lift Docker's default profile. Empty keeps the
+	// default profile.
+	SeccompProfile string
+
 	// RestartPolicy defines the container's restart behaviour on exit
 	RestartPolicy container.RestartPolicyMode
+
+	// RestartMaxRetries is the most times Docker restarts a failed container
+	// before giving up on it. It only applies to the "on-failure" policy;
+	// zero restarts it without limit.
+	RestartMaxRetries int
+
+	// AutoRemove has Docker remove the container as soon as it exits
+	AutoRemove bool
+
+	// Checkpointable allows Checkpoint and Restore on the container
+	Checkpointable bool
+
+	// Mounts attaches host paths or named volumes to the container
+	Mounts []Mount
+
+	// Devices passes host devices through to the container
+	Devices []DeviceMapping
+
+	// HealthCmd is the command Docker runs inside the container to check its
+	// health, such as ["curl", "-f", "http://localhost/"]. It runs directly
+	// unless it starts with "CMD" or "CMD-SHELL", as in a Dockerfile's
+	// HEALTHCHECK. Empty keeps the image's own healthcheck.
+	HealthCmd []string
+
+	// HealthInterval is the time between health checks; Docker's default
+	// when zero
+	HealthInterval time.Duration
+
+	// HealthRetries is the number of consecutive failed checks after which
+	// the container is unhealthy; Docker's default when zero
+	HealthRetries int
+
+	// Secrets are passed to the container from the Docker's SecretStore
+	// when it is created
+	Secrets []SecretRef
+
+	// Tmpfs mounts in-memory filesystems at absolute paths in the container,
+	// each with mount options such as "size=64m,mode=1777"; empty options
+	// take Docker's defaults. Their contents go when the container stops.
+	Tmpfs map[string]string
+
+	// DNS lists the IP addresses of the nameservers the container uses
+	// instead of the host's, and DNSSearch the domains it searches
+	DNS       []string
+	DNSSearch []string
+
+	// ExtraHosts adds "host:ip" entries to the container's /etc/hosts
+	ExtraHosts []string
+
+	// Ulimits sets resource limits such as the number of open files,
+	// "nofile", in place of the Docker daemon's defaults
+	Ulimits []Ulimit
+
+	// StopSignal is the signal, such as "SIGQUIT", Docker sends the
+	// container's main process to stop it, before killing it once the stop
+	// timeout passes. Empty keeps the image's stop signal, SIGTERM unless
+	// the image sets one.
+	StopSignal string
+
+	// Labels are attached to the container. NewConfig labels a task's
+	// container with LabelTaskID.
+	Labels map[string]string
+
+	// DockerAPIVersion pins the Docker API version NewDocker's client speaks,
+	// such as "1.41"; empty negotiates it with the daemon
+	DockerAPIVersion string
+}
+
+// LabelTaskID is the container label holding the ID of the task a container
+// runs, marking the containers cube manages.
+const LabelTaskID = "io.cube.task-id"
+
+// CpuModel selects how a container's CPU allocation is enforced.
+type CpuModel string
+
+const (
+	// CpuQuota caps the container at Cpu CPUs, however idle the host is
+	CpuQuota CpuModel = "quota"
+
+	// CpuShares weights the container's claim on contended CPU time, with 1
+	// CPU worth Docker's default of 1024 shares; it is not capped when the
+	// host has CPU to spare
+	CpuShares CpuModel = "shares"
+)
+
+// PullPolicy selects when a task's image is pulled from its registry.
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image before every run, picking up changes to
+	// mutable tags such as latest
+	PullAlways PullPolicy = "Always"
+
+	// PullIfNotPresent pulls the image only when the host does not have it,
+	// which suits pinned tags and digests
+	PullIfNotPresent PullPolicy = "IfNotPresent"
+
+	// PullNever uses the image on the host and fails the run when it is
+	// missing
+	PullNever PullPolicy = "Never"
+)
+
+// Mount attaches a host path or a named volume to a container.
+type Mount struct {
+	// Source is an absolute host path for a bind mount, or the name of a volume
+	Source string
+
+	// Target is the absolute path the source is mounted at inside the container
+	Target string
+
+	// ReadOnly mounts the source read-only
+	ReadOnly bool
+}
+
+// DeviceMapping passes a host device through to a container, like docker
+// run's --device flag.
+type DeviceMapping struct {
+	// HostPath is the absolute path of the device on the host, such as /dev/fuse
+	HostPath string
+
+	// ContainerPath is where the device appears in the container; HostPath
+	// when empty
+	ContainerPath string `json:",omitempty"`
+
+	// Permissions is the cgroup access the container has to the device, some
+	// of "r" (read), "w" (write) and "m" (mknod); "rwm" when empty
+	Permissions string `json:",omitempty"`
+}
+
+// Ulimit is a resource limit for the processes in a container, named as in
+// ulimit(1) without the RLIMIT_ prefix, such as "nofile" or "nproc". Soft is
+// the limit enforced, which a process may raise as far as Hard.
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
 }
 
 type DockerRunner interface {
@@ -154,7 +633,7 @@ type Logger interface {
 // Docker provides an interface to interact with the Docker daemon through the Docker API.
 type Docker struct {
 	// Client is the Docker client used to communicate with the Docker daemon
-	Client *client.Client
+	Client client.APIClient
 
 	// Config holds both the initial task configuration and runtime information
 	// such as ContainerID once the task is running
@@ -165,6 +644,59 @@ type Docker struct {
 	Logger Logger
 	Writer io.Writer
 	StdErr io.Writer
+
+	// Secrets supplies the values of the config's secrets when the container
+	// is created. Once they are known, they are redacted from the Logger's
+	// output and from errors.
+	Secrets SecretStore
+
+	// SecretsDir is the host directory secret files are written under;
+	// DefaultSecretsDir when empty. It should be on a tmpfs.
+	SecretsDir string
+
+	// StartTimeout bounds pulling the image, creating the container and
+	// starting it; DefaultStartTimeout when zero
+	StartTimeout time.Duration
+
+	// secretsDir is the directory the secret files of the container created
+	// last were written to
+	secretsDir string
+
+	redactor redactor
+}
+
+// NewDockerClient connects to the Docker daemon described by the environment:
+// DOCKER_HOST, DOCKER_API_VERSION, DOCKER_CERT_PATH and DOCKER_TLS_VERIFY.
+// A non-empty apiVersion, such as "1.41", pins the API version over
+// DOCKER_API_VERSION. Without either the client negotiates the API version
+// with the daemon.
+func NewDockerClient(apiVersion string) (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if apiVersion != "" {
+		opts = append(opts, client.WithVersion(apiVersion))
+	}
+	c, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("creating Docker client: %w", err)
+	}
+	return c, nil
+}
+
+// NewDocker returns a Docker for the configuration, with a client from
+// NewDockerClient speaking the config's DockerAPIVersion, the standard
+// logger, and output going to stdout and stderr.
+func NewDocker(cfg Config) (*Docker, error) {
+	c, err := NewDockerClient(cfg.DockerAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &Docker{
+		Client: c,
+		Config: cfg,
+		Logger: logging.Printer(logging.Info),
+		Writer: os.Stdout,
+		StdErr: os.Stderr,
+	}, nil
 }
 
 // DockerResult encapsulates the outcome of Docker operations
@@ -181,13 +713,87 @@ type DockerResult struct {
 
 	// Result contains additional operation-specific output
 	Result string
+
+	// Exited receives the container's exit code once Docker has removed it.
+	// It is only set when starting a container with AutoRemove.
+	Exited <-chan int64
 }
 
+// DockerInspectResponse holds the outcome of inspecting a container.
+type DockerInspectResponse struct {
+	// Error holds any error that occurred while inspecting the container
+	Error error
+
+	// Container holds the container details reported by Docker
+	Container *types.ContainerJSON
+}
+
+// NewConfig builds the container configuration for a task. Task memory and
+// disk are expressed in MB and converted to the bytes Docker expects.
+func NewConfig(t *Task) *Config {
+	exposedPorts := nat.PortSet{}
+	for port := range t.ExposedPorts {
+		exposedPorts[port] = struct{}{}
+	}
+	// The manager restarts tasks with a delay itself.
+	restartPolicy, restartMaxRetries := t.RestartPolicy, t.RestartMaxRetries
+	if t.RestartDelay > 0 {
+		restartPolicy, restartMaxRetries = "", 0
+	}
+
+	return &Config{
+		Name:              t.Name,
+		Labels:            map[string]string{LabelTaskID: t.ID.String()},
+		ExposedPorts:      exposedPorts,
+		Image:             t.Image,
+		Cpu:               t.Cpu,
+		PullPolicy:        t.PullPolicy,
+		CpuModel:          t.CpuModel,
+		GPUs:              t.GPUs,
+		Memory:            int64(t.Memory) * 1024 * 1024,
+		MemoryReservation: int64(t.MemoryReservation) * 1024 * 1024,
+		Env:               t.Env,
+		EnvFiles:          t.EnvFiles,
+		User:              t.User,
+		WorkingDir:        t.WorkingDir,
+		ReadonlyRootfs:    t.ReadonlyRootfs,
+		CapAdd:            t.CapAdd,
+		CapDrop:           t.CapDrop,
+		SecurityOpt:       t.SecurityOpt,
+		SeccompProfile:    t.SeccompProfile,
+		Disk:              int64(t.Disk) * 1024 * 1024,
+		RestartPolicy:     container.RestartPolicyMode(restartPolicy),
+		RestartMaxRetries: restartMaxRetries,
+		AutoRemove:        t.AutoRemove,
+		Checkpointable:    t.Checkpointable,
+		Mounts:            t.Mounts,
+		Devices:           t.Devices,
+		Secrets:           t.Secrets,
+		Tmpfs:             t.Tmpfs,
+		DNS:               t.DNS,
+		DNSSearch:         t.DNSSearch,
+		ExtraHosts:        t.ExtraHosts,
+		Ulimits:           t.Ulimits,
+		StopSignal:        t.StopSignal,
+		Entrypoint:        t.Entrypoint,
+		NetworkAliases:    t.NetworkAliases,
+		HealthCmd:         t.HealthCmd,
+		HealthInterval:    t.HealthInterval,
+		HealthRetries:     t.HealthRetries,
+	}
+}
+
+// ImagePull makes sure the task's image is on the host, pulling it as the
+// pull policy directs.
 func (d *Docker) ImagePull(ctx context.Context) error {
-	d.Logger.Printf("Pulling image %s", d.Config.Image)
-	reader, err := d.Client.ImagePull(ctx, d.Config.Image, image.PullOptions{})
+	if present, err := d.imagePresent(ctx); present || err != nil {
+		return err
+	}
+
+	d.Logger.Printf("Pulling image %s", d.image())
+	reader, err := d.Client.ImagePull(ctx, d.image(), image.PullOptions{})
 	if err != nil {
-		return fmt.Errorf("image pull failed: %w", err)
+		return cubeerrors.Wrap(cubeerrors.ErrImagePull, err)
 	}
 	defer reader.Close()
 
@@ -195,39 +801,295 @@ func (d *Docker) ImagePull(ctx context.Context) error {
 	return err
 }
 
+// ImageCheck returns an error matching cubeerrors.ErrImagePull unless the
+// task's image can be had as the pull policy directs: from the host when the
+// policy allows and the image is there, and otherwise from its registry,
+// whose manifest for the image is looked up without pulling it.
+func (d *Docker) ImageCheck(ctx context.Context) error {
+	if present, err := d.imagePresent(ctx); present || err != nil {
+		return err
+	}
+	if _, err := d.Client.DistributionInspect(ctx, d.image(), ""); err != nil {
+		return cubeerrors.Wrap(cubeerrors.ErrImagePull, err)
+	}
+	return nil
+}
+
+// imagePresent reports whether the pull policy lets the task use the image
+// already on the host and it is there. It fails when the policy forbids
+// pulling an image that is not.
+func (d *Docker) imagePresent(ctx context.Context) (bool, error) {
+	if d.Config.PullPolicy != PullIfNotPresent && d.Config.PullPolicy != PullNever {
+		return false, nil
+	}
+	_, _, err := d.Client.ImageInspectWithRaw(ctx, d.image())
+	switch {
+	case err == nil:
+		return true, nil
+	case !errdefs.IsNotFound(err):
+		return false, cubeerrors.Wrap(cubeerrors.ErrImagePull, err)
+	case d.Config.PullPolicy == PullNever:
+		return false, cubeerrors.Wrapf(cubeerrors.ErrImagePull, "image %s is not present and the pull policy is %s", d.image(), PullNever)
+	}
+	return false, nil
+}
+
 func (d *Docker) buildContainerConfig() *container.Config {
 	return &container.Config{
-		Image:        d.Config.Image,
+		Image:        d.image(),
 		Tty:          false,
 		Env:          d.Config.Env,
 		ExposedPorts: d.Config.ExposedPorts,
+		Healthcheck:  d.Config.healthcheck(),
+		User:         d.Config.User,
+		WorkingDir:   d.Config.WorkingDir,
+		Labels:       d.Config.Labels,
+		StopSignal:   d.Config.StopSignal,
+		Entrypoint:   d.Config.Entrypoint,
+	}
+}
+
+// buildNetworkingConfig returns the endpoints that join the container to the
+// networks in NetworkAliases under their aliases, or nil to leave it on the
+// default network.
+func (d *Docker) buildNetworkingConfig() *network.NetworkingConfig {
+	if len(d.Config.NetworkAliases) == 0 {
+		return nil
+	}
+	endpoints := make(map[string]*network.EndpointSettings, len(d.Config.NetworkAliases))
+	for name, aliases := range d.Config.NetworkAliases {
+		endpoints[name] = &network.EndpointSettings{Aliases: aliases}
+	}
+	return &network.NetworkingConfig{EndpointsConfig: endpoints}
+}
+
+// healthcheck translates the configured health command into Docker's form,
+// or returns nil to keep the image's healthcheck.
+func (c *Config) healthcheck() *container.HealthConfig {
+	if len(c.HealthCmd) == 0 {
+		return nil
+	}
+
+	test := c.HealthCmd
+	switch test[0] {
+	case "CMD", "CMD-SHELL", "NONE":
+	default:
+		test = append([]string{"CMD"}, test...)
+	}
+	return &container.HealthConfig{
+		Test:     test,
+		Interval: c.HealthInterval,
+		Retries:  c.HealthRetries,
 	}
 }
 
 func (d *Docker) buildHostConfig() *container.HostConfig {
 	return &container.HostConfig{
 		RestartPolicy: container.RestartPolicy{
-			Name: d.Config.RestartPolicy,
+			Name:              d.Config.RestartPolicy,
+			MaximumRetryCount: d.Config.RestartMaxRetries,
 		},
 		Resources: container.Resources{
-			Memory:   d.Config.Memory,
-			NanoCPUs: int64(d.Config.Cpu * math.Pow(10, 9)),
+			Memory:            d.Config.Memory,
+			MemoryReservation: d.Config.MemoryReservation,
+			NanoCPUs:          d.Config.nanoCPUs(),
+			CPUShares:         d.Config.cpuShares(),
+			DeviceRequests:    d.Config.deviceRequests(),
+			Devices:           d.Config.devices(),
+			Ulimits:           d.Config.ulimits(),
 		},
 		PublishAllPorts: true,
+		AutoRemove:      d.Config.AutoRemove,
+		Mounts:          d.buildMounts(),
+		Tmpfs:           d.Config.Tmpfs,
+		DNS:             d.Config.DNS,
+		DNSSearch:       d.Config.DNSSearch,
+		ExtraHosts:      d.Config.ExtraHosts,
+		ReadonlyRootfs:  d.Config.ReadonlyRootfs,
+		CapAdd:          d.Config.CapAdd,
+		CapDrop:         d.Config.CapDrop,
+		SecurityOpt:     d.Config.SecurityOpt,
+	}
+}
+
+// SeccompUnconfined is the SeccompProfile that runs a container without a
+// seccomp profile.
+const SeccompUnconfined = "unconfined"
+
+// seccompOpt returns the security option applying SeccompProfile, or "" when
+// none is set. Docker expects the profile itself rather than its path, so a
+// profile file is read and checked to be JSON.
+func (c *Config) seccompOpt() (string, error) {
+	switch c.SeccompProfile {
+	case "":
+		return "", nil
+	case SeccompUnconfined:
+		return "seccomp=" + SeccompUnconfined, nil
+	}
+	data, err := os.ReadFile(c.SeccompProfile)
+	if err != nil {
+		return "", fmt.Errorf("seccomp profile: %w", err)
+	}
+	profile := bytes.Buffer{}
+	if err := json.Compact(&profile, data); err != nil {
+		return "", fmt.Errorf("seccomp profile %s: %w", c.SeccompProfile, err)
+	}
+	return "seccomp=" + profile.String(), nil
+}
+
+// ulimits translates the configured limits into Docker's form.
+func (c *Config) ulimits() []*container.Ulimit {
+	var ulimits []*container.Ulimit
+	for _, u := range c.Ulimits {
+		ulimits = append(ulimits, &container.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+	}
+	return ulimits
+}
+
+// nanoCPUs converts the requested CPUs to the billionths of a CPU Docker
+// expects, or returns zero when the CPU model is shares.
+func (c *Config) nanoCPUs() int64 {
+	if c.CpuModel == CpuShares {
+		return 0
+	}
+	return int64(c.Cpu * math.Pow(10, 9))
+}
+
+// cpuShares converts the requested CPUs to CPU shares, or returns zero when
+// the CPU model is a quota.
+func (c *Config) cpuShares() int64 {
+	if c.CpuModel != CpuShares {
+		return 0
+	}
+	return int64(c.Cpu * defaultCPUShares)
+}
+
+// deviceRequests asks Docker for the configured number of NVIDIA GPUs.
+func (c *Config) deviceRequests() []container.DeviceRequest {
+	if c.GPUs == 0 {
+		return nil
+	}
+	return []container.DeviceRequest{{
+		Driver:       "nvidia",
+		Count:        c.GPUs,
+		Capabilities: [][]string{{"gpu"}},
+	}}
+}
+
+// devices translates the configured device mappings into Docker's form,
+// filling in the defaults Docker applies to --device.
+func (c *Config) devices() []container.DeviceMapping {
+	var devices []container.DeviceMapping
+	for _, m := range c.Devices {
+		containerPath := m.ContainerPath
+		if containerPath == "" {
+			containerPath = m.HostPath
+		}
+		permissions := m.Permissions
+		if permissions == "" {
+			permissions = "rwm"
+		}
+		devices = append(devices, container.DeviceMapping{
+			PathOnHost:        m.HostPath,
+			PathInContainer:   containerPath,
+			CgroupPermissions: permissions,
+		})
 	}
+	return devices
+}
+
+func (d *Docker) buildMounts() []mount.Mount {
+	var mounts []mount.Mount
+	for _, m := range d.Config.Mounts {
+		mountType := mount.TypeVolume
+		if m.isBind() {
+			mountType = mount.TypeBind
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:     mountType,
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return mounts
 }
 
 func (d *Docker) ContainerCreate(ctx context.Context) (string, error) {
+	if err := d.Config.Validate(); err != nil {
+		return "", cubeerrors.Wrap(cubeerrors.ErrInvalidRequest, fmt.Errorf("invalid config: %w", err))
+	}
+	if err := d.Config.mergeEnvFiles(); err != nil {
+		return "", cubeerrors.Wrap(cubeerrors.ErrInvalidRequest, fmt.Errorf("invalid config: %w", err))
+	}
+	seccomp, err := d.Config.seccompOpt()
+	if err != nil {
+		return "", fmt.Errorf("invalid config: %w", err)
+	}
+	if err := d.checkAPIVersion(); err != nil {
+		return "", err
+	}
+
+	secrets, err := d.resolveSecrets(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(secrets.values) > 0 {
+		r := redactor{values: secrets.values}
+		d.Logger = redactingLogger{Logger: d.Logger, redactor: r}
+		d.redactor = r
+	}
+
 	config := d.buildContainerConfig()
+	config.Env = append(slices.Clone(config.Env), secrets.env...)
 	hostConfig := d.buildHostConfig()
+	if seccomp != "" {
+		hostConfig.SecurityOpt = append(slices.Clone(hostConfig.SecurityOpt), seccomp)
+	}
+
+	dir, mounts, err := d.writeSecretFiles(secrets.files)
+	if err != nil {
+		return "", d.redactor.redactError(fmt.Errorf("writing secret files: %w", err))
+	}
+	if dir != "" {
+		config.Labels = maps.Clone(config.Labels)
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[LabelSecretsDir] = dir
+		hostConfig.Mounts = append(hostConfig.Mounts, mounts...)
+	}
+	d.secretsDir = dir
 
-	resp, err := d.Client.ContainerCreate(ctx, config, hostConfig, nil, nil, d.Config.Name)
+	resp, err := d.create(ctx, config, hostConfig)
 	if err != nil {
-		return "", fmt.Errorf("create container failed: %w", err)
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+		return "", d.redactor.redactError(fmt.Errorf("create container failed: %w", err))
 	}
 	return resp.ID, nil
 }
 
+// create creates the container under a name from ContainerName, trying a
+// fresh suffix whenever Docker reports the name is already in use.
+func (d *Docker) create(ctx context.Context, config *container.Config, hostConfig *container.HostConfig) (container.CreateResponse, error) {
+	var resp container.CreateResponse
+	var name string
+	var err error
+	for range nameAttempts {
+		if name, err = ContainerName(d.Config.Name); err != nil {
+			return resp, err
+		}
+		resp, err = d.Client.ContainerCreate(ctx, config, hostConfig, d.buildNetworkingConfig(), nil, name)
+		if !errdefs.IsConflict(err) {
+			return resp, err
+		}
+		d.Logger.Printf("Container name %s is in use, trying another", name)
+	}
+	return resp, err
+}
+
 func (d *Docker) ContainerStart(ctx context.Context, containerID string) error {
 	d.Logger.Printf("Starting container %s", containerID)
 	err := d.Client.ContainerStart(ctx, containerID, container.StartOptions{})
@@ -239,10 +1101,39 @@ func (d *Docker) ContainerStart(ctx context.Context, containerID string) error {
 	return nil
 }
 
+// ContainerLogs copies what the container has logged so far to Writer and
+// StdErr.
 func (d *Docker) ContainerLogs(ctx context.Context, containerID string) error {
+	return d.copyLogs(ctx, containerID, false)
+}
+
+// FollowLogs copies the container's logs to Writer and StdErr as they are
+// written, returning once the container stops.
+func (d *Docker) FollowLogs(ctx context.Context, containerID string) error {
+	return d.copyLogs(ctx, containerID, true)
+}
+
+// StdoutTail copies the last lines the container wrote to standard output to
+// Writer, leaving out what it wrote to standard error.
+func (d *Docker) StdoutTail(ctx context.Context, containerID string, lines int) error {
+	logs, err := d.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
+		ShowStdout: true,
+		Tail:       strconv.Itoa(lines),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get container logs: %w", err)
+	}
+	defer logs.Close()
+
+	_, err = stdcopy.StdCopy(d.Writer, io.Discard, logs)
+	return err
+}
+
+func (d *Docker) copyLogs(ctx context.Context, containerID string, follow bool) error {
 	logs, err := d.Client.ContainerLogs(ctx, containerID, container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Follow:     follow,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to get container logs: %w", err)
@@ -253,9 +1144,24 @@ func (d *Docker) ContainerLogs(ctx context.Context, containerID string) error {
 	return err
 }
 
+// DefaultStartTimeout bounds starting a container when Docker.StartTimeout
+// is zero.
+const DefaultStartTimeout = 5 * time.Minute
+
+// startContext returns the context pulling, creating and starting a
+// container run under, which expires after StartTimeout.
+func (d *Docker) startContext() (context.Context, context.CancelFunc) {
+	timeout := d.StartTimeout
+	if timeout <= 0 {
+		timeout = DefaultStartTimeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
 func (d *Docker) Run() DockerResult {
-	log.Printf("Attempting to start container")
-	ctx := context.Background()
+	logging.Debugf("Attempting to start container")
+	ctx, cancel := d.startContext()
+	defer cancel()
 
 	if err := d.ImagePull(ctx); err != nil {
 		return DockerResult{Error: fmt.Errorf("failed to pull image: %w", err)}
@@ -266,6 +1172,14 @@ func (d *Docker) Run() DockerResult {
 		return DockerResult{Error: fmt.Errorf("failed to create container: %w", err)}
 	}
 
+	// An auto-removed container is gone by the time anyone inspects it, so
+	// wait for its removal before starting it to capture the exit code.
+	// The wait outlives the start, so it gets a context of its own.
+	var exited <-chan int64
+	if d.Config.AutoRemove {
+		exited = d.waitRemoved(context.Background(), containerID)
+	}
+
 	if err := d.ContainerStart(ctx, containerID); err != nil {
 		return DockerResult{Error: fmt.Errorf("failed to start container: %w", err)}
 	}
@@ -274,5 +1188,168 @@ func (d *Docker) Run() DockerResult {
 		Action:      "start",
 		ContainerID: containerID,
 		Result:      "success",
+		Exited:      exited,
+	}
+}
+
+func (d *Docker) waitRemoved(ctx context.Context, containerID string) <-chan int64 {
+	statusCh, errCh := d.Client.ContainerWait(ctx, containerID, container.WaitConditionRemoved)
+
+	// Docker removes the container itself, so its secret files go here.
+	secretsDir := d.secretsDir
+	exited := make(chan int64, 1)
+	go func() {
+		defer close(exited)
+		if secretsDir != "" {
+			defer os.RemoveAll(secretsDir)
+		}
+		select {
+		case status := <-statusCh:
+			exited <- status.StatusCode
+		case err := <-errCh:
+			d.Logger.Printf("Error waiting for container %s: %v", containerID, err)
+		}
+	}()
+	return exited
+}
+
+// RunToCompletion runs the container, waits for it to exit and removes it,
+// returning its exit code. It suits short-lived steps such as init tasks.
+func (d *Docker) RunToCompletion() (int64, error) {
+	ctx := context.Background()
+	startCtx, cancel := d.startContext()
+	defer cancel()
+
+	if err := d.ImagePull(startCtx); err != nil {
+		return 0, fmt.Errorf("failed to pull image: %w", err)
+	}
+
+	containerID, err := d.ContainerCreate(startCtx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create container: %w", err)
+	}
+	if d.secretsDir != "" {
+		defer os.RemoveAll(d.secretsDir)
+	}
+
+	condition := container.WaitConditionNextExit
+	if d.Config.AutoRemove {
+		condition = container.WaitConditionRemoved
+	}
+	statusCh, errCh := d.Client.ContainerWait(ctx, containerID, condition)
+
+	if err := d.ContainerStart(startCtx, containerID); err != nil {
+		return 0, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	var code int64
+	select {
+	case status := <-statusCh:
+		if status.Error != nil {
+			return 0, fmt.Errorf("failed to wait for container: %s", status.Error.Message)
+		}
+		code = status.StatusCode
+	case err := <-errCh:
+		return 0, fmt.Errorf("failed to wait for container: %w", err)
+	}
+	if !d.Config.AutoRemove {
+		err := d.Client.ContainerRemove(ctx, containerID, container.RemoveOptions{RemoveVolumes: true})
+		if err != nil {
+			d.Logger.Printf("Error removing container %s: %v", containerID, err)
+		}
+	}
+	return code, nil
+}
+
+// Inspect returns Docker's view of the container with the given ID.
+func (d *Docker) Inspect(containerID string) DockerInspectResponse {
+	resp, err := d.Client.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return DockerInspectResponse{Error: fmt.Errorf("failed to inspect container: %w", err)}
+	}
+	return DockerInspectResponse{Container: &resp}
+}
+
+// Stop stops and removes the container with the given ID. A container
+// started with AutoRemove is removed by Docker once it stops, and one
+// already gone is not an error.
+func (d *Docker) Stop(containerID string) DockerResult {
+	d.Logger.Printf("Attempting to stop container %s", containerID)
+	ctx := context.Background()
+
+	if err := d.Client.ContainerStop(ctx, containerID, container.StopOptions{}); err != nil {
+		return DockerResult{Error: fmt.Errorf("failed to stop container: %w", err)}
+	}
+
+	d.removeSecretFiles(ctx, containerID)
+	if !d.Config.AutoRemove {
+		err := d.Client.ContainerRemove(ctx, containerID, container.RemoveOptions{RemoveVolumes: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return DockerResult{Error: fmt.Errorf("failed to remove container: %w", err)}
+		}
+	}
+
+	return DockerResult{
+		Action:      "stop",
+		ContainerID: containerID,
+		Result:      "success",
+	}
+}
+
+// Remove deletes the stopped container with the given ID and its anonymous
+// volumes. A container that no longer exists is not an error.
+func (d *Docker) Remove(containerID string) error {
+	d.removeSecretFiles(context.Background(), containerID)
+	err := d.Client.ContainerRemove(context.Background(), containerID, container.RemoveOptions{RemoveVolumes: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// Pause freezes the processes of the container with the given ID.
+func (d *Docker) Pause(ctx context.Context, containerID string) error {
+	d.Logger.Printf("Pausing container %s", containerID)
+	if err := d.Client.ContainerPause(ctx, containerID); err != nil {
+		return fmt.Errorf("pause container failed: %w", err)
+	}
+	return nil
+}
+
+// Unpause resumes the processes of a paused container.
+func (d *Docker) Unpause(ctx context.Context, containerID string) error {
+	d.Logger.Printf("Unpausing container %s", containerID)
+	if err := d.Client.ContainerUnpause(ctx, containerID); err != nil {
+		return fmt.Errorf("unpause container failed: %w", err)
+	}
+	return nil
+}
+
+// VerifyLimits inspects a started container and reports every resource limit
+// the daemon applied differently from the configuration, for example a memory
+// limit raised to the kernel minimum.
+func (d *Docker) VerifyLimits(containerID string) ([]string, error) {
+	resp := d.Inspect(containerID)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if resp.Container.ContainerJSONBase == nil || resp.Container.HostConfig == nil {
+		return nil, nil
+	}
+
+	var discrepancies []string
+	applied := resp.Container.HostConfig.Resources
+	if applied.Memory != d.Config.Memory {
+		discrepancies = append(discrepancies,
+			fmt.Sprintf("memory limit is %d bytes, requested %d", applied.Memory, d.Config.Memory))
+	}
+	if applied.NanoCPUs != d.Config.nanoCPUs() {
+		discrepancies = append(discrepancies,
+			fmt.Sprintf("CPU limit is %d nano CPUs, requested %d", applied.NanoCPUs, d.Config.nanoCPUs()))
+	}
+	if applied.CPUShares != d.Config.cpuShares() {
+		discrepancies = append(discrepancies,
+			fmt.Sprintf("CPU shares are %d, requested %d", applied.CPUShares, d.Config.cpuShares()))
 	}
+	return discrepancies, nil
 }
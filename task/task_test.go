@@ -1 +1,128 @@
 package task
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestValidStateTransition_Paused(t *testing.T) {
+	tests := []struct {
+		src, dst State
+		want     bool
+	}{
+		{Running, Paused, true},
+		{Paused, Running, true},
+		{Paused, Completed, true},
+		{Paused, Failed, true},
+		{Pending, Paused, false},
+		{Scheduled, Paused, false},
+		{Completed, Paused, false},
+	}
+	for _, tt := range tests {
+		if got := ValidStateTransition(tt.src, tt.dst); got != tt.want {
+			t.Errorf("ValidStateTransition(%v, %v) = %v, want %v", tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestState_JSON(t *testing.T) {
+	data, err := json.Marshal(Paused)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != `"Paused"` {
+		t.Errorf("Marshal() = %s, want \"Paused\"", data)
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil || s != Paused {
+		t.Errorf("Unmarshal(%s) = %v, %v, want %v", data, s, err, Paused)
+	}
+	if err := json.Unmarshal([]byte("2"), &s); err != nil || s != Running {
+		t.Errorf("Unmarshal(2) = %v, %v, want %v", s, err, Running)
+	}
+	if err := json.Unmarshal([]byte(`"Sleeping"`), &s); err == nil {
+		t.Error("Unmarshal() of an unknown state succeeded")
+	}
+}
+
+func TestTask_ShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy string
+		state  State
+		want   bool
+	}{
+		{"always", Failed, true},
+		{"always", Completed, true},
+		{"always", Cancelled, false},
+		{"unless-stopped", Completed, true},
+		{"unless-stopped", Cancelled, false},
+		{"on-failure", Failed, true},
+		{"on-failure", Completed, false},
+		{"on-failure", Cancelled, false},
+		{"", Failed, false},
+		{"always", Running, false},
+	}
+	for _, tt := range tests {
+		tk := Task{RestartPolicy: tt.policy, State: tt.state}
+		if got := tk.ShouldRestart(); got != tt.want {
+			t.Errorf("ShouldRestart() with policy %q in state %v = %v, want %v", tt.policy, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestTask_SpecHash(t *testing.T) {
+	base := Task{
+		Image:         "strm/helloworld-http",
+		Memory:        256,
+		Disk:          1,
+		Env:           []string{"A=1", "B=2"},
+		PortBindings:  map[string]string{"80/tcp": "8080", "443/tcp": "8443"},
+		RestartPolicy: "always",
+	}
+	same := base
+	same.ID = uuid.New()
+	same.State = Running
+	same.Env = []string{"B=2", "A=1"}
+	same.PortBindings = map[string]string{"443/tcp": "8443", "80/tcp": "8080"}
+	if base.SpecHash() != same.SpecHash() {
+		t.Errorf("equal specs hash differently")
+	}
+
+	changes := map[string]func(*Task){
+		"image":   func(t *Task) { t.Image = "strm/helloworld-http:2" },
+		"memory":  func(t *Task) { t.Memory = 512 },
+		"disk":    func(t *Task) { t.Disk = 2 },
+		"env":     func(t *Task) { t.Env = []string{"A=1", "B=3"} },
+		"ports":   func(t *Task) { t.PortBindings = map[string]string{"80/tcp": "9090"} },
+		"restart": func(t *Task) { t.RestartPolicy = "on-failure" },
+	}
+	for field, change := range changes {
+		changed := base
+		change(&changed)
+		if changed.SpecHash() == base.SpecHash() {
+			t.Errorf("changing %s left the hash unchanged", field)
+		}
+	}
+}
+
+func TestTask_NextRestartDelay(t *testing.T) {
+	tests := []struct {
+		backoff  bool
+		restarts int
+		want     time.Duration
+	}{
+		{false, 3, 10 * time.Second},
+		{true, 0, 10 * time.Second},
+		{true, 3, 80 * time.Second},
+		{true, 20, MaxRestartDelay},
+	}
+	for _, tt := range tests {
+		tk := Task{RestartDelay: 10 * time.Second, RestartBackoff: tt.backoff, RestartCount: tt.restarts}
+		if got := tk.NextRestartDelay(); got != tt.want {
+			t.Errorf("NextRestartDelay() with backoff %v after %d restarts = %v, want %v", tt.backoff, tt.restarts, got, tt.want)
+		}
+	}
+}
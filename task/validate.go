@@ -0,0 +1,235 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"github.com/docker/docker/api/types/container"
+	"maps"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Validate checks the configuration for values Docker would reject or that
+// refer to resources missing on this host.
+func (c *Config) Validate() error {
+	errs := []error{c.validateCpu()}
+	switch c.PullPolicy {
+	case "", PullAlways, PullIfNotPresent, PullNever:
+	default:
+		errs = append(errs, fmt.Errorf("unknown pull policy %q", c.PullPolicy))
+	}
+	if c.GPUs < 0 {
+		errs = append(errs, fmt.Errorf("gpus %d must not be negative", c.GPUs))
+	}
+	errs = append(errs, validateUser(c.User))
+	for _, capability := range slices.Concat(c.CapAdd, c.CapDrop) {
+		errs = append(errs, validateCapability(capability))
+	}
+	for _, opt := range c.SecurityOpt {
+		if strings.TrimSpace(opt) == "" {
+			errs = append(errs, errors.New("security option must not be empty"))
+		}
+		if c.SeccompProfile != "" && strings.HasPrefix(opt, "seccomp") {
+			errs = append(errs, fmt.Errorf("security option %q conflicts with the seccomp profile", opt))
+		}
+	}
+	if c.SeccompProfile != "" && c.SeccompProfile != SeccompUnconfined {
+		if info, err := os.Stat(c.SeccompProfile); err != nil {
+			errs = append(errs, fmt.Errorf("seccomp profile: %w", err))
+		} else if info.IsDir() {
+			errs = append(errs, fmt.Errorf("seccomp profile %s is a directory", c.SeccompProfile))
+		}
+	}
+	if c.MemoryReservation < 0 {
+		errs = append(errs, fmt.Errorf("memory reservation %d must not be negative", c.MemoryReservation))
+	}
+	if c.Memory > 0 && c.MemoryReservation > c.Memory {
+		errs = append(errs, fmt.Errorf("memory reservation %d exceeds the memory limit %d", c.MemoryReservation, c.Memory))
+	}
+	if c.RestartMaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("restart max retries %d must not be negative", c.RestartMaxRetries))
+	}
+	if c.Checkpointable && c.AutoRemove {
+		errs = append(errs, fmt.Errorf("a checkpointable container cannot be auto-removed"))
+	}
+	if c.RestartMaxRetries > 0 && c.RestartPolicy != container.RestartPolicyOnFailure {
+		errs = append(errs, fmt.Errorf("restart max retries needs the %q restart policy, not %q", container.RestartPolicyOnFailure, c.RestartPolicy))
+	}
+	if c.WorkingDir != "" && !path.IsAbs(c.WorkingDir) {
+		errs = append(errs, fmt.Errorf("working directory %q must be an absolute path", c.WorkingDir))
+	}
+	for _, m := range c.Mounts {
+		errs = append(errs, m.validate())
+	}
+	for _, m := range c.Devices {
+		errs = append(errs, m.validate())
+	}
+	for _, s := range c.Secrets {
+		errs = append(errs, s.validate())
+	}
+	if slices.ContainsFunc(c.Secrets, func(r SecretRef) bool { return r.File != "" }) {
+		if _, _, err := secretOwner(c.User); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, target := range slices.Sorted(maps.Keys(c.Tmpfs)) {
+		if !path.IsAbs(target) {
+			errs = append(errs, fmt.Errorf("tmpfs path %q must be an absolute path", target))
+		}
+	}
+	for _, server := range c.DNS {
+		if net.ParseIP(server) == nil {
+			errs = append(errs, fmt.Errorf("dns server %q must be an IP address", server))
+		}
+	}
+	for _, entry := range c.ExtraHosts {
+		errs = append(errs, validateExtraHost(entry))
+	}
+	for _, u := range c.Ulimits {
+		errs = append(errs, u.validate())
+	}
+	if c.StopSignal != "" && !isSignal(c.StopSignal) {
+		errs = append(errs, fmt.Errorf("unknown stop signal %q", c.StopSignal))
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.NetworkAliases)) {
+		if name == "" {
+			errs = append(errs, errors.New("network aliases must name their network"))
+		}
+		if slices.Contains(c.NetworkAliases[name], "") {
+			errs = append(errs, fmt.Errorf("network %q has an empty alias", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Docker's bounds on CPU shares, and the shares it gives a container by default.
+const (
+	minCPUShares     = 2
+	maxCPUShares     = 262144
+	defaultCPUShares = 1024
+)
+
+// validateCpu checks Cpu against the bounds of its model: a quota cannot
+// exceed the host's CPUs, and shares must fall within Docker's range.
+func (c *Config) validateCpu() error {
+	if c.Cpu < 0 {
+		return fmt.Errorf("cpu %v must not be negative", c.Cpu)
+	}
+
+	switch c.CpuModel {
+	case "", CpuQuota:
+		if c.Cpu > float64(runtime.NumCPU()) {
+			return fmt.Errorf("cpu quota %v exceeds the %d CPUs on this host", c.Cpu, runtime.NumCPU())
+		}
+	case CpuShares:
+		if shares := c.cpuShares(); c.Cpu != 0 && (shares < minCPUShares || shares > maxCPUShares) {
+			return fmt.Errorf("cpu %v gives %d shares, outside %d to %d", c.Cpu, shares, minCPUShares, maxCPUShares)
+		}
+	default:
+		return fmt.Errorf("unknown cpu model %q", c.CpuModel)
+	}
+	return nil
+}
+
+// validateUser loosely checks a user is a name or ID, optionally followed by
+// a colon and a group name or ID.
+func validateUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	name, group, hasGroup := strings.Cut(user, ":")
+	if name == "" || (hasGroup && group == "") || strings.Contains(group, ":") ||
+		strings.ContainsFunc(user, unicode.IsSpace) {
+		return fmt.Errorf("user %q must be user, uid, user:group or uid:gid", user)
+	}
+	return nil
+}
+
+// validateExtraHost checks an extra hosts entry is a host name and an IP
+// address, or Docker's "host-gateway", separated by a colon.
+func validateExtraHost(entry string) error {
+	host, ip, ok := strings.Cut(entry, ":")
+	if !ok || host == "" || strings.ContainsFunc(host, unicode.IsSpace) ||
+		(ip != "host-gateway" && net.ParseIP(ip) == nil) {
+		return fmt.Errorf("extra host %q must be host:ip", entry)
+	}
+	return nil
+}
+
+// validateCapability loosely checks a Linux capability name: "ALL", or
+// letters, digits and underscores with an optional CAP_ prefix, in either case.
+func validateCapability(capability string) error {
+	name := strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+		return !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_')
+	}) {
+		return fmt.Errorf("capability %q is not a capability name", capability)
+	}
+	return nil
+}
+
+// signals names the Linux signals a container can be stopped with.
+var signals = []string{
+	"ABRT", "ALRM", "BUS", "CHLD", "CONT", "FPE", "HUP", "ILL", "INT", "IO",
+	"KILL", "PIPE", "PROF", "PWR", "QUIT", "SEGV", "STKFLT", "STOP", "SYS",
+	"TERM", "TRAP", "TSTP", "TTIN", "TTOU", "URG", "USR1", "USR2", "VTALRM",
+	"WINCH", "XCPU", "XFSZ",
+}
+
+// isSignal reports whether name is a Linux signal name, with or without the
+// SIG prefix, as Docker accepts them.
+func isSignal(name string) bool {
+	return slices.Contains(signals, strings.TrimPrefix(strings.ToUpper(name), "SIG"))
+}
+
+func (u Ulimit) validate() error {
+	if u.Name == "" {
+		return errors.New("ulimit has no name")
+	}
+	if u.Soft > u.Hard {
+		return fmt.Errorf("ulimit %s soft limit %d exceeds hard limit %d", u.Name, u.Soft, u.Hard)
+	}
+	return nil
+}
+
+// isBind reports whether the mount binds a host path rather than a named volume.
+func (m Mount) isBind() bool {
+	return filepath.IsAbs(m.Source)
+}
+
+func (m Mount) validate() error {
+	if !filepath.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+	if m.Source == "" {
+		return fmt.Errorf("mount for %q has no source", m.Target)
+	}
+	if m.isBind() {
+		if _, err := os.Stat(m.Source); err != nil {
+			return fmt.Errorf("bind mount source %q: %w", m.Source, err)
+		}
+	}
+	return nil
+}
+
+func (m DeviceMapping) validate() error {
+	if !filepath.IsAbs(m.HostPath) {
+		return fmt.Errorf("device host path %q must be an absolute path", m.HostPath)
+	}
+	if m.ContainerPath != "" && !path.IsAbs(m.ContainerPath) {
+		return fmt.Errorf("device container path %q must be an absolute path", m.ContainerPath)
+	}
+	if strings.Trim(m.Permissions, "rwm") != "" {
+		return fmt.Errorf("device %s permissions %q must be some of \"rwm\"", m.HostPath, m.Permissions)
+	}
+	if _, err := os.Stat(m.HostPath); err != nil {
+		return fmt.Errorf("device %w", err)
+	}
+	return nil
+}
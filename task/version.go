@@ -0,0 +1,29 @@
+package task
+
+import (
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/docker/docker/api/types/versions"
+)
+
+// checkAPIVersion reports the first feature of the config that needs a newer
+// Docker API than the client speaks. The client's version is the negotiated
+// one once it has made a request to the daemon.
+func (d *Docker) checkAPIVersion() error {
+	features := []struct {
+		name       string
+		used       bool
+		minVersion string
+	}{
+		{name: "GPUs", used: d.Config.GPUs > 0, minVersion: "1.40"},
+	}
+
+	for _, f := range features {
+		if !f.used {
+			continue
+		}
+		if version := d.Client.ClientVersion(); versions.LessThan(version, f.minVersion) {
+			return cubeerrors.Wrapf(cubeerrors.ErrAPIVersionTooOld, "%s need API %s or later, but the daemon speaks %s", f.name, f.minVersion, version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+// Package trace carries a correlation ID through a task's journey from
+// submission to the manager, through dispatch, to the worker running it, so
+// the log lines of every hop can be tied together.
+package trace
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+// Header is the HTTP header carrying the trace ID between manager and worker.
+const Header = "X-Cube-Trace-Id"
+
+type contextKey struct{}
+
+// NewID returns a fresh trace ID.
+func NewID() string {
+	return uuid.NewString()
+}
+
+// NewContext returns a copy of ctx carrying the trace ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the trace ID carried by ctx, or "" if there is none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware takes the trace ID from the request's header, or generates one
+// when the caller sent none, makes it available to the handler through the
+// request context, echoes it in the response header and logs the request
+// under it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if id == "" {
+			id = NewID()
+		}
+		w.Header().Set(Header, id)
+
+		logging.Debugf("[trace %s] %s %s", id, r.Method, r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
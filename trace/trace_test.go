@@ -0,0 +1,29 @@
+package trace_test
+
+import (
+	"github.com/christinavaneyssen/cube/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	var seen string
+	h := trace.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = trace.FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+	req.Header.Set(trace.Header, "trace-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if seen != "trace-1" || rec.Header().Get(trace.Header) != "trace-1" {
+		t.Errorf("handler saw %q and response carried %q, want trace-1", seen, rec.Header().Get(trace.Header))
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks", nil))
+	if seen == "" || rec.Header().Get(trace.Header) != seen {
+		t.Errorf("generated trace ID %q, response carried %q", seen, rec.Header().Get(trace.Header))
+	}
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/christinavaneyssen/cube/ratelimit"
+	"github.com/christinavaneyssen/cube/trace"
+	"net/http"
+)
+
+// Api exposes a worker over HTTP.
+type Api struct {
+	Address string
+	Port    int
+	Worker  *Worker
+	Router  *http.ServeMux
+
+	// Limiter, when set, rejects clients that call the API too often
+	Limiter *ratelimit.Limiter
+}
+
+func (a *Api) initRouter() {
+	a.Router = http.NewServeMux()
+	a.Router.HandleFunc("POST /tasks", a.StartTaskHandler)
+	a.Router.HandleFunc("GET /tasks", a.GetTasksHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}", a.GetTaskHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/stats", a.GetTaskStatsHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/logs", a.GetTaskLogsHandler)
+	a.Router.HandleFunc("GET /tasks/{taskID}/diff", a.GetTaskDiffHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/exec", a.ExecTaskHandler)
+	a.Router.HandleFunc("DELETE /tasks", a.StopTasksHandler)
+	a.Router.HandleFunc("DELETE /tasks/{taskID}", a.StopTaskHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/pause", a.PauseTaskHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/unpause", a.UnpauseTaskHandler)
+	a.Router.HandleFunc("POST /tasks/{taskID}/prune", a.PruneTaskHandler)
+	a.Router.HandleFunc("GET /containers", a.GetContainersHandler)
+	a.Router.HandleFunc("GET /images", a.GetImagesHandler)
+	a.Router.HandleFunc("POST /images/check", a.CheckImageHandler)
+	a.Router.HandleFunc("GET /stats", a.GetStatsHandler)
+}
+
+// Handler returns the HTTP handler serving the worker API.
+func (a *Api) Handler() http.Handler {
+	if a.Router == nil {
+		a.initRouter()
+	}
+	h := trace.Middleware(a.Router)
+	if a.Limiter != nil {
+		h = a.Limiter.Middleware(h)
+	}
+	return h
+}
+
+// Start serves the worker API on the configured address and port.
+func (a *Api) Start() error {
+	return http.ListenAndServe(fmt.Sprintf("%s:%d", a.Address, a.Port), a.Handler())
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+)
+
+// StopResult reports what StopTasks did to one task.
+type StopResult struct {
+	TaskID uuid.UUID
+
+	// Action is "stopped" for a task that was running and "pruned" for a
+	// finished one
+	Action string
+
+	// State is the state the task was left in
+	State task.State
+
+	// Error says why the task could not be stopped or pruned
+	Error string `json:",omitempty"`
+}
+
+// StopTasks acts at once on every task in the given state: unfinished tasks
+// are cancelled for reason and their containers stopped, while finished ones
+// are pruned along with their containers. It returns a result for each task
+// in task.Compare order.
+func (w *Worker) StopTasks(state task.State, reason string) []StopResult {
+	results := []StopResult{}
+	for _, t := range w.GetTasks() {
+		if t.State != state {
+			continue
+		}
+
+		res := StopResult{TaskID: t.ID, State: t.State}
+		if t.State.Terminal() {
+			res.Action = "pruned"
+			if err := w.PruneTask(t.ID); err != nil {
+				res.Error = err.Error()
+			}
+			results = append(results, res)
+			continue
+		}
+
+		res.Action = "stopped"
+		t.Reason = reason
+		if t.ContainerID == "" {
+			// Nothing was started, so there is nothing to stop
+			w.finish(*t, task.Cancelled)
+			res.State = task.Cancelled
+		} else if result := w.StopTask(*t, task.Cancelled); result.Error != nil {
+			res.Error = result.Error.Error()
+		} else {
+			res.State = task.Cancelled
+		}
+		results = append(results, res)
+	}
+	logging.Infof("Stopped %d %v tasks", len(results), state)
+	return results
+}
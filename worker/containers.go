@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// ContainerView joins a container the worker manages with the task the
+// worker believes it runs, to show where the two have drifted apart.
+type ContainerView struct {
+	ContainerID string `json:",omitempty"`
+
+	// Status is Docker's view of the container, such as "running" or
+	// "exited", or "missing" when the container no longer exists
+	Status string
+
+	// TaskID, TaskName and TaskState describe the task the container runs,
+	// as recorded by the worker
+	TaskID    uuid.UUID
+	TaskName  string `json:",omitempty"`
+	TaskState string `json:",omitempty"`
+
+	// Orphaned is set for a container labelled as cube's that no task on the
+	// worker accounts for
+	Orphaned bool
+}
+
+// Containers lists the containers of the worker's tasks with their live
+// status, followed by any other container labelled as running a cube task,
+// which the worker has lost track of.
+func (w *Worker) Containers(ctx context.Context) ([]ContainerView, error) {
+	views := []ContainerView{}
+	known := make(map[string]bool)
+	for _, t := range w.GetTasks() {
+		if t.ContainerID == "" {
+			continue
+		}
+		known[t.ContainerID] = true
+
+		view := ContainerView{
+			ContainerID: t.ContainerID,
+			TaskID:      t.ID,
+			TaskName:    t.Name,
+			TaskState:   t.State.String(),
+		}
+		info, err := w.Client.ContainerInspect(ctx, t.ContainerID)
+		switch {
+		case errdefs.IsNotFound(err):
+			view.Status = "missing"
+		case err != nil:
+			return nil, fmt.Errorf("inspecting container %s: %w", t.ContainerID, err)
+		default:
+			view.Status = info.State.Status
+		}
+		views = append(views, view)
+	}
+
+	listed, err := w.Client.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", task.LabelTaskID)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	var orphans []ContainerView
+	for _, c := range listed {
+		if known[c.ID] {
+			continue
+		}
+		id, _ := uuid.Parse(c.Labels[task.LabelTaskID])
+		orphans = append(orphans, ContainerView{
+			ContainerID: c.ID,
+			Status:      c.State,
+			TaskID:      id,
+			Orphaned:    true,
+		})
+	}
+	slices.SortFunc(orphans, func(a, b ContainerView) int {
+		return cmp.Compare(a.ContainerID, b.ContainerID)
+	})
+	return append(views, orphans...), nil
+}
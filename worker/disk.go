@@ -0,0 +1,20 @@
+package worker
+
+import (
+	"github.com/christinavaneyssen/cube/logging"
+)
+
+// diskFree returns the free space, in MB, on the filesystem holding
+// DiskPath, and whether it is known: it is not when DiskPath is unset or
+// cannot be inspected.
+func (w *Worker) diskFree() (int, bool) {
+	if w.DiskPath == "" {
+		return 0, false
+	}
+	free, err := freeSpace(w.DiskPath)
+	if err != nil {
+		logging.Errorf("Error checking free disk space at %s: %v", w.DiskPath, err)
+		return 0, false
+	}
+	return free, true
+}
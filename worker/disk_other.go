@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+)
+
+// freeSpace is not implemented on this platform, so the worker never holds
+// tasks back for lack of disk.
+func freeSpace(path string) (int, error) {
+	return 0, fmt.Errorf("free space of %s: %w", path, errors.ErrUnsupported)
+}
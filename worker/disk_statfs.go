@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package worker
+
+import "syscall"
+
+// freeSpace returns the space, in MB, available to unprivileged users on the
+// filesystem holding path.
+func freeSpace(path string) (int, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, err
+	}
+	return int(uint64(fs.Bavail) * uint64(fs.Bsize) / (1024 * 1024)), nil
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"time"
+)
+
+// drainPollInterval is how often Shutdown checks whether the running tasks
+// have finished.
+const drainPollInterval = time.Second
+
+// Shutdown drains the worker: it stops starting queued tasks, waits up to
+// DrainTimeout for the running tasks to finish on their own, checking on
+// their containers as UpdateTasks does, and then stops those still running,
+// or paused, with ReasonWorkerShutdown. Cancelling ctx cuts the wait short.
+// Tasks left in the queue stay there, persisted when the worker has a Store.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	w.draining = true
+	w.mu.Unlock()
+
+	if w.DrainTimeout > 0 {
+		w.drain(ctx)
+	}
+
+	var errs []error
+	for _, t := range w.unfinished() {
+		t.Reason = task.ReasonWorkerShutdown
+		if result := w.StopTask(t, task.Cancelled); result.Error != nil {
+			errs = append(errs, fmt.Errorf("stopping task %v: %w", t.ID, result.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// drain waits for the running tasks to finish until DrainTimeout passes or
+// ctx is cancelled.
+func (w *Worker) drain(ctx context.Context) {
+	expired := time.After(w.DrainTimeout)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		w.UpdateTasks()
+		if len(w.unfinished()) == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			logging.Warnf("Drain cut short: %v", ctx.Err())
+			return
+		case <-expired:
+			logging.Warnf("Tasks still running after %v, stopping them", w.DrainTimeout)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// unfinished returns the tasks whose containers are running or paused.
+func (w *Worker) unfinished() []task.Task {
+	var tasks []task.Task
+	for _, t := range w.GetTasks() {
+		if t.State == task.Running || t.State == task.Paused {
+			tasks = append(tasks, *t)
+		}
+	}
+	return tasks
+}
@@ -0,0 +1,56 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"io"
+	"time"
+)
+
+// DefaultExecTimeout bounds a command run with Exec whose request sets no
+// timeout.
+const DefaultExecTimeout = 30 * time.Second
+
+// Trailers the /tasks/{taskID}/exec endpoint ends its streamed output with:
+// the command's exit code, or why it could not be had.
+const (
+	ExitCodeTrailer  = "X-Exit-Code"
+	ExecErrorTrailer = "X-Exec-Error"
+)
+
+// ExecRequest is what is posted to a worker's /tasks/{taskID}/exec endpoint
+// to run a command in the task's container.
+type ExecRequest struct {
+	// Cmd is the command and its arguments
+	Cmd []string
+
+	// Timeout is how long the command may run; DefaultExecTimeout when zero
+	Timeout time.Duration
+}
+
+// Exec runs the requested command in the container of a running task,
+// copying its output to out as it is produced, and returns its exit code.
+// Nothing is written to out unless the task is running.
+func (w *Worker) Exec(ctx context.Context, id uuid.UUID, req ExecRequest, out io.Writer) (int, error) {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return -1, err
+	}
+	if t.State != task.Running {
+		return -1, fmt.Errorf("%w: cannot exec in a %v task", ErrInvalidTransition, t.State)
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	d := w.newDocker(task.NewConfig(t))
+	d.Writer = out
+	d.StdErr = out
+	return d.Exec(ctx, t.ContainerID, req.Cmd)
+}
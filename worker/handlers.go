@@ -0,0 +1,313 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/trace"
+	"github.com/google/uuid"
+	"net/http"
+	"strconv"
+)
+
+// StartTaskHandler queues the task carried by the posted task event.
+func (a *Api) StartTaskHandler(w http.ResponseWriter, r *http.Request) {
+	d := json.NewDecoder(r.Body)
+	d.DisallowUnknownFields()
+
+	te := task.TaskEvent{}
+	if err := d.Decode(&te); err != nil {
+		err = cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err)
+		logging.Warnf("%v", err)
+		writeError(w, err)
+		return
+	}
+
+	if te.Task.TraceID == "" {
+		te.Task.TraceID = trace.FromContext(r.Context())
+	}
+	t := a.Worker.SubmitTask(te)
+	logging.Infof("[trace %s] Added task %v", t.TraceID, t.ID)
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// GetTasksHandler lists every task the worker knows about.
+func (a *Api) GetTasksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Worker.GetTasks())
+}
+
+// GetTaskHandler returns the task with the ID in the path.
+func (a *Api) GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	t, err := a.Worker.GetTask(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// GetTaskStatsHandler samples the resource usage of the task with the ID in the path.
+func (a *Api) GetTaskStatsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	stats, err := a.Worker.TaskStats(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// GetTaskDiffHandler reports how the container of the task with the ID in
+// the path differs from the task's config.
+func (a *Api) GetTaskDiffHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	diffs, err := a.Worker.TaskDiff(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, diffs)
+}
+
+// GetTaskLogsHandler returns the output of the task with the ID in the path
+// as plain text.
+func (a *Api) GetTaskLogsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := a.Worker.TaskLogs(taskID, &buf); err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// ExecTaskHandler runs the command in the posted ExecRequest in the
+// container of the task with the ID in the path, streaming its output as
+// plain text. The exit code follows in the ExitCodeTrailer trailer or, when
+// the command failed or timed out after its output began, the reason in the
+// ExecErrorTrailer trailer.
+func (a *Api) ExecTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+	req := ExecRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err))
+		return
+	}
+	if len(req.Cmd) == 0 {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "The command is required"))
+		return
+	}
+
+	out := &streamWriter{w: w}
+	code, err := a.Worker.Exec(r.Context(), taskID, req, out)
+	if err != nil && !out.started {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	out.start()
+	if err != nil {
+		logging.Warnf("Exec in task %v failed: %v", taskID, err)
+		w.Header().Set(ExecErrorTrailer, err.Error())
+		return
+	}
+	w.Header().Set(ExitCodeTrailer, strconv.Itoa(code))
+}
+
+// streamWriter writes plain text to the client, flushing each write so it
+// arrives as it is produced. The response begins on the first write, with
+// the exec trailers declared.
+type streamWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (s *streamWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.w.Header().Set("Trailer", ExitCodeTrailer+", "+ExecErrorTrailer)
+	s.w.WriteHeader(http.StatusOK)
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.start()
+	n, err := s.w.Write(p)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// GetContainersHandler lists the containers the worker manages alongside the
+// tasks it believes they run.
+func (a *Api) GetContainersHandler(w http.ResponseWriter, r *http.Request) {
+	views, err := a.Worker.Containers(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+// GetImagesHandler lists the images in the worker's warm pool.
+func (a *Api) GetImagesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Worker.WarmImages())
+}
+
+// CheckImageHandler answers no content when the worker can pull the image
+// named in the posted ImageCheck, and an error saying why it cannot
+// otherwise.
+func (a *Api) CheckImageHandler(w http.ResponseWriter, r *http.Request) {
+	c := ImageCheck{}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Error unmarshalling body: %v", err))
+		return
+	}
+	if c.Image == "" {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "The image is required"))
+		return
+	}
+
+	if err := a.Worker.CheckImage(r.Context(), c); err != nil {
+		logging.Warnf("Image %s cannot be pulled: %v", c.Image, err)
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StopTaskHandler queues the task with the ID in the path to be cancelled.
+// The reason query parameter records why; "cancelled by user" when absent.
+func (a *Api) StopTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	if err := a.Worker.CancelTask(taskID, r.URL.Query().Get("reason")); err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// StopTasksHandler stops, or prunes if they have finished, every task in the
+// state named by the required state query parameter, and returns what
+// happened to each. The reason query parameter records why tasks are
+// stopped; "cancelled by user" when absent.
+func (a *Api) StopTasksHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("state")
+	if name == "" {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "The state query parameter is required"))
+		return
+	}
+	state, err := task.ParseState(name)
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "%v", err))
+		return
+	}
+
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = task.ReasonCancelledByUser
+	}
+	writeJSON(w, http.StatusOK, a.Worker.StopTasks(state, reason))
+}
+
+// PauseTaskHandler freezes the container of the task with the ID in the path.
+func (a *Api) PauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTask(w, r, a.Worker.PauseTask)
+}
+
+// UnpauseTaskHandler resumes the container of the task with the ID in the path.
+func (a *Api) UnpauseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeTask(w, r, a.Worker.UnpauseTask)
+}
+
+// PruneTaskHandler forgets the finished task with the ID in the path and
+// removes its container.
+func (a *Api) PruneTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	if err := a.Worker.PruneTask(taskID); err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	logging.Infof("Pruned task %v", taskID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changeTask applies change to the task with the ID in the path and responds
+// with the updated task.
+func (a *Api) changeTask(w http.ResponseWriter, r *http.Request, change func(uuid.UUID) error) {
+	taskID, err := uuid.Parse(r.PathValue("taskID"))
+	if err != nil {
+		writeError(w, cubeerrors.Newf(cubeerrors.ErrInvalidRequest, "Invalid task ID: %v", err))
+		return
+	}
+
+	if err := change(taskID); err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+
+	t, err := a.Worker.GetTask(taskID)
+	if err != nil {
+		writeError(w, cubeerrors.WithTask(err, taskID.String()))
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+// GetStatsHandler reports the worker's current load.
+func (a *Api) GetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Worker.CollectStats())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Errorf("Error encoding response: %v", err)
+	}
+}
+
+// writeError answers with the status and error envelope for err.
+func writeError(w http.ResponseWriter, err error) {
+	cubeerrors.WriteHTTP(w, err)
+}
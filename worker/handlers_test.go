@@ -0,0 +1,315 @@
+package worker_test
+
+import (
+	"encoding/json"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/google/uuid"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApi_GetTaskHandler(t *testing.T) {
+	w := newWorker(&fakeClient{})
+	started := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	running := &task.Task{
+		ID:          uuid.New(),
+		Name:        "web",
+		State:       task.Running,
+		StartTime:   started,
+		ContainerID: "container-1",
+	}
+	w.Db[running.ID] = running
+	api := &worker.Api{Worker: w}
+
+	t.Run("found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+running.ID.String(), nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		got := task.Task{}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+		if got.ID != running.ID || got.State != task.Running || got.ContainerID != "container-1" || !got.StartTime.Equal(started) {
+			t.Errorf("got %+v, want %+v", got, *running)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+uuid.NewString(), nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestApi_PauseAndUnpauseTask(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	running := &task.Task{ID: uuid.New(), Name: "web", State: task.Running, ContainerID: "container-1"}
+	w.Db[running.ID] = running
+	api := &worker.Api{Worker: w}
+
+	post := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+	state := func() task.State {
+		got, err := w.GetTask(running.ID)
+		if err != nil {
+			t.Fatalf("GetTask() error = %v", err)
+		}
+		return got.State
+	}
+
+	if rec := post("/tasks/" + running.ID.String() + "/pause"); rec.Code != http.StatusOK {
+		t.Fatalf("pause status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := state(); got != task.Paused {
+		t.Errorf("state after pause = %v, want %v", got, task.Paused)
+	}
+
+	if rec := post("/tasks/" + running.ID.String() + "/pause"); rec.Code != http.StatusConflict {
+		t.Errorf("pausing a paused task status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec := post("/tasks/" + running.ID.String() + "/unpause"); rec.Code != http.StatusOK {
+		t.Fatalf("unpause status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := state(); got != task.Running {
+		t.Errorf("state after unpause = %v, want %v", got, task.Running)
+	}
+	if want := []string{"pause:container-1", "unpause:container-1"}; !slices.Equal(fc.calls, want) {
+		t.Errorf("calls = %v, want %v", fc.calls, want)
+	}
+}
+
+func TestApi_StopTaskCancelsTask(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	running := &task.Task{
+		ID:            uuid.New(),
+		Name:          "web",
+		State:         task.Running,
+		ContainerID:   "container-1",
+		RestartPolicy: "always",
+	}
+	w.Db[running.ID] = running
+	api := &worker.Api{Worker: w}
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/tasks/"+running.ID.String(), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	got, err := w.GetTask(running.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Cancelled || got.Reason != task.ReasonCancelledByUser {
+		t.Errorf("task is %v (%q), want %v (%q)", got.State, got.Reason, task.Cancelled, task.ReasonCancelledByUser)
+	}
+	if got.ShouldRestart() {
+		t.Error("cancelled task asks to be restarted")
+	}
+	if !slices.Equal(fc.stopped, []string{"container-1"}) {
+		t.Errorf("stopped containers = %v, want [container-1]", fc.stopped)
+	}
+}
+
+func TestApi_StopTasksHandler(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	running := []*task.Task{
+		{ID: uuid.New(), Name: "web", State: task.Running, ContainerID: "container-1"},
+		{ID: uuid.New(), Name: "api", State: task.Running, ContainerID: "container-2"},
+	}
+	done := &task.Task{ID: uuid.New(), Name: "batch", State: task.Completed, ContainerID: "container-3"}
+	for _, tk := range append(running, done) {
+		w.Db[tk.ID] = tk
+	}
+	api := &worker.Api{Worker: w}
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/tasks?state=Running", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var results []worker.StopResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2: %+v", len(results), results)
+	}
+	for _, res := range results {
+		if res.Action != "stopped" || res.State != task.Cancelled || res.Error != "" {
+			t.Errorf("result = %+v, want the task stopped and cancelled", res)
+		}
+	}
+
+	for _, tk := range running {
+		got, err := w.GetTask(tk.ID)
+		if err != nil {
+			t.Fatalf("GetTask() error = %v", err)
+		}
+		if got.State != task.Cancelled || got.Reason != task.ReasonCancelledByUser {
+			t.Errorf("task %s is %v (%q), want %v (%q)", got.Name, got.State, got.Reason, task.Cancelled, task.ReasonCancelledByUser)
+		}
+	}
+	slices.Sort(fc.stopped)
+	if !slices.Equal(fc.stopped, []string{"container-1", "container-2"}) {
+		t.Errorf("stopped = %v, want both running containers", fc.stopped)
+	}
+	if got, err := w.GetTask(done.ID); err != nil || got.State != task.Completed {
+		t.Errorf("completed task = %+v (%v), want it left alone", got, err)
+	}
+
+	for _, query := range []string{"", "?state=Sleeping"} {
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/tasks"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("DELETE /tasks%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestApi_GetContainersHandler(t *testing.T) {
+	orphanTask := uuid.New()
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	tsk := scheduledTask("web")
+	w.AddTask(tsk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if got := fc.configs[0].Labels[task.LabelTaskID]; got != tsk.ID.String() {
+		t.Fatalf("container labelled with task %q, want %v", got, tsk.ID)
+	}
+	fc.containers = []types.Container{
+		{ID: "container-1", State: "running", Labels: map[string]string{task.LabelTaskID: tsk.ID.String()}},
+		{ID: "container-9", State: "exited", Labels: map[string]string{task.LabelTaskID: orphanTask.String()}},
+	}
+
+	rec := httptest.NewRecorder()
+	(&worker.Api{Worker: w}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/containers", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var views []worker.ContainerView
+	if err := json.NewDecoder(rec.Body).Decode(&views); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+
+	want := []worker.ContainerView{
+		{ContainerID: "container-1", Status: "running", TaskID: tsk.ID, TaskName: "web", TaskState: "Running"},
+		{ContainerID: "container-9", Status: "exited", TaskID: orphanTask, Orphaned: true},
+	}
+	if !slices.Equal(views, want) {
+		t.Errorf("containers = %+v, want %+v", views, want)
+	}
+}
+
+func TestApi_CheckImageHandler(t *testing.T) {
+	fc := &fakeClient{unreachable: []string{"registry.internal/app:1"}}
+	api := &worker.Api{Worker: newWorker(fc)}
+
+	tests := []struct {
+		image string
+		want  int
+	}{
+		{"nginx:1.27", http.StatusNoContent},
+		{"registry.internal/app:1", http.StatusBadGateway},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body := strings.NewReader(`{"Image": "` + tt.image + `"}`)
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/images/check", body))
+		if rec.Code != tt.want {
+			t.Errorf("check of %q status = %d, want %d: %s", tt.image, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestApi_GetTaskDiffHandler(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	running := &task.Task{ID: uuid.New(), Name: "web", State: task.Running, Image: "nginx:1.27", Memory: 512, Env: []string{"MODE=prod"}, ContainerID: "container-1"}
+	w.Db[running.ID] = running
+	fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:         containerID,
+				HostConfig: &container.HostConfig{Resources: container.Resources{Memory: 256 << 20}},
+			},
+			Config: &container.Config{Image: "nginx:1.27", Env: []string{"PATH=/usr/bin", "MODE=prod"}},
+		}, nil
+	}
+	api := &worker.Api{Worker: w}
+
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+running.ID.String()+"/diff", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var diffs []task.FieldDiff
+	if err := json.NewDecoder(rec.Body).Decode(&diffs); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	want := []task.FieldDiff{{Field: "memory", Desired: "536870912 bytes", Actual: "268435456 bytes"}}
+	if !slices.Equal(diffs, want) {
+		t.Errorf("diffs = %+v, want %+v", diffs, want)
+	}
+}
+
+func TestApi_ExecTaskHandler(t *testing.T) {
+	fc := &fakeClient{stdout: "hello\n", stderr: "oops\n", exitCode: 3}
+	w := newWorker(fc)
+	running := &task.Task{ID: uuid.New(), Name: "web", State: task.Running, Image: "nginx:1.27", ContainerID: "container-1"}
+	w.Db[running.ID] = running
+	api := &worker.Api{Worker: w}
+
+	body := strings.NewReader(`{"Cmd": ["sh", "-c", "echo hello; echo oops >&2; exit 3"]}`)
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+running.ID.String()+"/exec", body))
+	res := rec.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", res.StatusCode, http.StatusOK, rec.Body)
+	}
+	if got := rec.Body.String(); got != "hello\noops\n" {
+		t.Errorf("output = %q, want %q", got, "hello\noops\n")
+	}
+	if got := res.Trailer.Get(worker.ExitCodeTrailer); got != "3" {
+		t.Errorf("exit code trailer = %q, want %q", got, "3")
+	}
+	if len(fc.execs) != 1 || !slices.Equal(fc.execs[0], []string{"sh", "-c", "echo hello; echo oops >&2; exit 3"}) {
+		t.Errorf("execs = %q, want the posted command", fc.execs)
+	}
+
+	t.Run("task not running", func(t *testing.T) {
+		running.State = task.Completed
+		defer func() { running.State = task.Running }()
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tasks/"+running.ID.String()+"/exec", strings.NewReader(`{"Cmd": ["true"]}`)))
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+		}
+	})
+}
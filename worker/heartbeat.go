@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/christinavaneyssen/cube/logging"
+	"net/http"
+	"time"
+)
+
+// heartbeatTimeout bounds each heartbeat, so a manager that stops answering
+// cannot hold up the next one.
+const heartbeatTimeout = 5 * time.Second
+
+var heartbeatClient = &http.Client{Timeout: heartbeatTimeout}
+
+// Heartbeat is what a worker posts to the manager's /nodes endpoint to
+// register itself and to report, periodically after that, that it is alive.
+type Heartbeat struct {
+	// Name is the worker's name
+	Name string
+
+	// Address is the host:port the manager reaches the worker's API on; the
+	// manager knows the worker by it
+	Address string
+
+	// Stats is the worker's load when the heartbeat was sent
+	Stats Stats
+
+	// Timestamp is when the heartbeat was sent
+	Timestamp time.Time
+}
+
+// Heartbeat describes the worker, reachable at address, as of now.
+func (w *Worker) Heartbeat(address string) Heartbeat {
+	return Heartbeat{
+		Name:      w.Name,
+		Address:   address,
+		Stats:     w.CollectStats(),
+		Timestamp: w.now().UTC(),
+	}
+}
+
+// SendHeartbeat posts a heartbeat for the worker, reachable at address, to
+// the manager at the host:port manager.
+func (w *Worker) SendHeartbeat(manager, address string) error {
+	data, err := json.Marshal(w.Heartbeat(address))
+	if err != nil {
+		return fmt.Errorf("marshalling heartbeat: %w", err)
+	}
+
+	resp, err := heartbeatClient.Post(fmt.Sprintf("http://%s/nodes", manager), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("sending heartbeat to %s: %w", manager, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("sending heartbeat to %s: unexpected status %d", manager, resp.StatusCode)
+	}
+	return nil
+}
+
+// RunHeartbeats registers the worker with the manager and then sends a
+// heartbeat every interval until ctx is cancelled. A failed heartbeat is
+// logged and retried on the next tick, so a worker started before its
+// manager joins the cluster once the manager is up.
+func (w *Worker) RunHeartbeats(ctx context.Context, manager, address string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.SendHeartbeat(manager, address); err != nil {
+			logging.Errorf("Error sending heartbeat: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
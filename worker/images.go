@@ -0,0 +1,20 @@
+package worker
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/task"
+)
+
+// ImageCheck is what the manager posts to a worker's /images/check endpoint
+// to ask whether the worker can pull an image before sending it a task.
+type ImageCheck struct {
+	Image      string
+	PullPolicy task.PullPolicy
+}
+
+// CheckImage returns an error matching cubeerrors.ErrImagePull unless the
+// worker can have the image as the pull policy directs, looking its manifest
+// up in the registry rather than pulling it.
+func (w *Worker) CheckImage(ctx context.Context, c ImageCheck) error {
+	return w.newDocker(&task.Config{Image: c.Image, PullPolicy: c.PullPolicy}).ImageCheck(ctx)
+}
@@ -0,0 +1,188 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/google/uuid"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultLogMaxSize is the size, in bytes, a task's log file grows to before
+// it is rotated when Worker.LogMaxSize is zero.
+const DefaultLogMaxSize = 10 << 20
+
+// LogPath returns the file the output of the task with the given ID is
+// captured to, or "" when the worker has no LogDir. When the file is rotated
+// its previous contents move to the same path with a ".1" suffix.
+func (w *Worker) LogPath(id uuid.UUID) string {
+	if w.LogDir == "" {
+		return ""
+	}
+	return filepath.Join(w.LogDir, id.String()+".log")
+}
+
+// captureLogs follows the output of the task's container into its log file
+// until the container stops.
+func (w *Worker) captureLogs(t task.Task) {
+	path := w.LogPath(t.ID)
+	if path == "" {
+		return
+	}
+	if err := os.MkdirAll(w.LogDir, 0o755); err != nil {
+		logging.Errorf("Error creating log directory %s: %v", w.LogDir, err)
+		return
+	}
+	maxSize := w.LogMaxSize
+	if maxSize == 0 {
+		maxSize = DefaultLogMaxSize
+	}
+	f, err := openRotatingFile(path, maxSize)
+	if err != nil {
+		logging.Errorf("Error opening log file of task %v: %v", t.ID, err)
+		return
+	}
+
+	d := w.newDocker(task.NewConfig(&t))
+	d.Writer = f
+	d.StdErr = f
+	go func() {
+		defer f.Close()
+		if err := d.FollowLogs(context.Background(), t.ContainerID); err != nil {
+			logging.Errorf("Error capturing logs of task %v: %v", t.ID, err)
+		}
+	}()
+}
+
+// TaskLogs writes the output of the task with the given ID to out: the
+// captured log file when there is one, including its rotated part, and
+// otherwise whatever Docker still holds for the task's container.
+func (w *Worker) TaskLogs(id uuid.UUID, out io.Writer) error {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return err
+	}
+
+	if path := w.LogPath(id); path != "" {
+		copied, err := copyFiles(out, path+".1", path)
+		if copied || err != nil {
+			return err
+		}
+	}
+
+	if t.ContainerID == "" {
+		return fmt.Errorf("%w: task %v has no logs", cubeerrors.ErrNotFound, id)
+	}
+	d := w.newDocker(task.NewConfig(t))
+	d.Writer = out
+	d.StdErr = out
+	return d.ContainerLogs(context.Background(), t.ContainerID)
+}
+
+// copyFiles writes the files at paths to out in order, skipping those that do
+// not exist, and reports whether any did.
+func copyFiles(out io.Writer, paths ...string) (bool, error) {
+	copied := false
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return copied, err
+		}
+		_, err = io.Copy(out, f)
+		f.Close()
+		if err != nil {
+			return true, err
+		}
+		copied = true
+	}
+	return copied, nil
+}
+
+// removeLogs deletes the log files of the task with the given ID.
+func (w *Worker) removeLogs(id uuid.UUID) error {
+	path := w.LogPath(id)
+	if path == "" {
+		return nil
+	}
+	for _, p := range []string{path, path + ".1"} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatingFile appends to a file until it reaches maxSize, then moves it
+// aside to a ".1" suffix, replacing the previous one, and starts afresh.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.f.Close()
+}
@@ -0,0 +1,92 @@
+package worker_test
+
+import (
+	"bytes"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitForFile waits for the file at path to hold want, failing the test if it
+// does not within a second.
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil && string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s holds %q (error %v), want %q", path, data, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorker_CapturesLogsToFile(t *testing.T) {
+	dir := t.TempDir()
+	fc := &fakeClient{stdout: "listening on :80\n", stderr: "warning: no config\n"}
+	w := newWorker(fc)
+	w.LogDir = filepath.Join(dir, "logs")
+	tsk := scheduledTask("web")
+	w.AddTask(tsk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	path := filepath.Join(dir, "logs", tsk.ID.String()+".log")
+	if got := w.LogPath(tsk.ID); got != path {
+		t.Errorf("LogPath() = %q, want %q", got, path)
+	}
+	waitForFile(t, path, "listening on :80\nwarning: no config\n")
+
+	rec := httptest.NewRecorder()
+	(&worker.Api{Worker: w}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tasks/"+tsk.ID.String()+"/logs", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "listening on :80\nwarning: no config\n" {
+		t.Errorf("GET logs = %d %q, want the captured logs", rec.Code, rec.Body)
+	}
+
+	stop := tsk
+	stop.State = task.Completed
+	w.AddTask(stop)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if err := w.PruneTask(tsk.ID); err != nil {
+		t.Fatalf("PruneTask() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("log file still exists after pruning: %v", err)
+	}
+}
+
+func TestWorker_LogFileRotates(t *testing.T) {
+	fc := &fakeClient{stdout: "first line\n", stderr: "second\n"}
+	w := newWorker(fc)
+	w.LogDir = t.TempDir()
+	w.LogMaxSize = 12
+	tsk := scheduledTask("web")
+	w.AddTask(tsk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	path := w.LogPath(tsk.ID)
+	waitForFile(t, path, "second\n")
+	waitForFile(t, path+".1", "first line\n")
+
+	buf := bytes.Buffer{}
+	if err := w.TaskLogs(tsk.ID, &buf); err != nil {
+		t.Fatalf("TaskLogs() error = %v", err)
+	}
+	if got := buf.String(); got != "first line\nsecond\n" {
+		t.Errorf("TaskLogs() wrote %q, want both files in order", got)
+	}
+}
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxResultSize is the most bytes of a task's result the worker keeps.
+const MaxResultSize = 4 << 10
+
+// resultTail is the number of lines at the end of a container's standard
+// output searched for its result.
+const resultTail = 10
+
+// result returns the last non-blank line the task's container wrote to
+// standard output, cut short at MaxResultSize. It returns "" if the
+// container wrote nothing or its output can no longer be read, as when
+// Docker removed it on exit.
+func (w *Worker) result(t task.Task) string {
+	buf := bytes.Buffer{}
+	d := w.newDocker(task.NewConfig(&t))
+	d.Writer = &buf
+	if err := d.StdoutTail(context.Background(), t.ContainerID, resultTail); err != nil {
+		logging.Errorf("Error reading the result of task %v: %v", t.ID, err)
+		return ""
+	}
+
+	var last string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			last = line
+		}
+	}
+	if len(last) > MaxResultSize {
+		cut := MaxResultSize
+		for cut > 0 && !utf8.RuneStart(last[cut]) {
+			cut--
+		}
+		last = last[:cut]
+	}
+	return last
+}
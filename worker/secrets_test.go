@@ -0,0 +1,106 @@
+package worker_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/christinavaneyssen/cube/manager"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/google/uuid"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorker_SecretsNeverLogged(t *testing.T) {
+	const password = "s3cr3t-hunter2"
+	logs := bytes.Buffer{}
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	srv := httptest.NewServer((&worker.Api{Worker: w}).Handler())
+	defer srv.Close()
+	m := &manager.Manager{
+		TaskDb:        make(map[string][]*task.Task),
+		EventDb:       make(map[string][]*task.TaskEvent),
+		Workers:       []string{strings.TrimPrefix(srv.URL, "http://")},
+		WorkerTaskMap: make(map[string][]uuid.UUID),
+		TaskWorkerMap: make(map[uuid.UUID]string),
+		Secrets:       task.SecretMap{"db-password": []byte(password)},
+	}
+
+	tsk := task.Task{ID: uuid.New(), Name: "db", State: task.Pending, Image: "postgres:16"}
+	tsk.Secrets = []task.SecretRef{{Name: "db-password", Env: "PGPASSWORD"}}
+	m.AddTask(task.TaskEvent{ID: uuid.New(), State: task.Pending, Timestamp: time.Now(), Task: tsk})
+	m.SendWork()
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	if len(fc.configs) != 1 || !slices.Contains(fc.configs[0].Env, "PGPASSWORD="+password) {
+		t.Fatalf("containers created with %v, want the secret in PGPASSWORD", fc.configs)
+	}
+	got, err := w.GetTask(tsk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if slices.ContainsFunc(got.Env, func(e string) bool { return strings.Contains(e, password) }) {
+		t.Errorf("task env = %v, want the secret kept out of it", got.Env)
+	}
+	if strings.Contains(logs.String(), password) {
+		t.Errorf("logs reveal the secret:\n%s", logs.String())
+	}
+	if events, _ := json.Marshal(m.EventDb); bytes.Contains(events, []byte(password)) {
+		t.Errorf("manager events reveal the secret: %s", events)
+	}
+
+	rec := httptest.NewRecorder()
+	(&manager.Api{Manager: m}).Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/secrets/db-password", nil))
+	if strings.Contains(rec.Body.String(), password) {
+		t.Errorf("manager serves the secret over its API: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestWorker_RestoreFailsTaskWithLostSecrets(t *testing.T) {
+	s := store.NewInMemoryStore()
+	w := newWorker(&fakeClient{})
+	w.Store = s
+
+	withSecret, plain := scheduledTask("db"), scheduledTask("web")
+	withSecret.Secrets = []task.SecretRef{{Name: "db-password", Env: "PGPASSWORD"}}
+	w.SubmitTask(task.TaskEvent{ID: uuid.New(), State: task.Scheduled, Task: withSecret, Secrets: task.SecretMap{"db-password": []byte("s3cr3t")}})
+	w.AddTask(plain)
+
+	// The secret's value is held in memory only, so a restarted worker
+	// cannot start the task that needs it.
+	restarted := newWorker(&fakeClient{})
+	restarted.Store = s
+	if err := restarted.RestoreQueue(); err != nil {
+		t.Fatalf("RestoreQueue() error = %v", err)
+	}
+
+	if got := restarted.Queue.Len(); got != 1 {
+		t.Errorf("queue length = %d, want 1", got)
+	}
+	got, err := restarted.GetTask(withSecret.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed || got.FailureReason != task.FailureSecretsLost {
+		t.Errorf("task = %v (%q), want %v (%q)", got.State, got.FailureReason, task.Failed, task.FailureSecretsLost)
+	}
+	if result := restarted.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if got, err := restarted.GetTask(plain.ID); err != nil || got.State != task.Running {
+		t.Errorf("task without secrets = %v, %v; want it running", got, err)
+	}
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/task"
+	"slices"
+	"strings"
+	"time"
+)
+
+// WarmImage is the state of an image in the worker's warm pool, as listed
+// by its /images endpoint.
+type WarmImage struct {
+	Image string
+
+	// PulledAt is when the image was last pulled; zero until a pull succeeds
+	PulledAt time.Time `json:",omitempty"`
+
+	// Error is why the latest pull failed, empty when it succeeded
+	Error string `json:",omitempty"`
+}
+
+// RefreshWarmPool pulls every image in WarmPool, recording when each was
+// pulled. An image that fails to pull keeps the time of its last
+// successful pull, so tasks still find it on the host.
+func (w *Worker) RefreshWarmPool(ctx context.Context) {
+	for _, img := range w.WarmPool {
+		err := w.newDocker(&task.Config{Image: img, PullPolicy: task.PullAlways}).ImagePull(ctx)
+
+		w.mu.Lock()
+		if w.warm == nil {
+			w.warm = make(map[string]WarmImage)
+		}
+		wi := w.warm[img]
+		wi.Image = img
+		wi.Error = ""
+		if err != nil {
+			logging.Warnf("Error pulling warm image %s: %v", img, err)
+			wi.Error = err.Error()
+		} else {
+			wi.PulledAt = w.now().UTC()
+		}
+		w.warm[img] = wi
+		w.mu.Unlock()
+	}
+}
+
+// RunWarmPool pulls the warm pool's images at once and again every
+// interval, as the worker's clock tells it, until ctx is cancelled.
+func (w *Worker) RunWarmPool(ctx context.Context, interval time.Duration) {
+	for {
+		w.RefreshWarmPool(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.after(interval):
+		}
+	}
+}
+
+// WarmImages returns the images in the warm pool, sorted by name, with the
+// outcome of their latest pull.
+func (w *Worker) WarmImages() []WarmImage {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	images := make([]WarmImage, 0, len(w.WarmPool))
+	for _, img := range w.WarmPool {
+		wi, ok := w.warm[img]
+		if !ok {
+			wi = WarmImage{Image: img}
+		}
+		images = append(images, wi)
+	}
+	slices.SortFunc(images, func(a, b WarmImage) int {
+		return strings.Compare(a.Image, b.Image)
+	})
+	return images
+}
+
+// isWarm reports whether img is in the warm pool and has been pulled.
+func (w *Worker) isWarm(img string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return !w.warm[img].PulledAt.IsZero()
+}
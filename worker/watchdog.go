@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/logging"
+	"runtime/debug"
+	"time"
+)
+
+// watchdogBackoff is how long Watchdog waits before restarting a loop.
+const watchdogBackoff = time.Second
+
+// Watchdog runs loop until ctx is cancelled, restarting it whenever it panics
+// or returns while ctx is still live. It is meant for long-running loops such
+// as RunTasks and RunUpdates, which should only stop on shutdown.
+func Watchdog(ctx context.Context, name string, loop func(context.Context)) {
+	for {
+		runGuarded(ctx, name, loop)
+		if ctx.Err() != nil {
+			return
+		}
+
+		logging.Warnf("%s exited unexpectedly, restarting in %v", name, watchdogBackoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchdogBackoff):
+		}
+	}
+}
+
+func runGuarded(ctx context.Context, name string, loop func(context.Context)) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("Recovered from panic in %s: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	loop(ctx)
+}
@@ -0,0 +1,81 @@
+package worker_test
+
+import (
+	"context"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/docker/docker/api/types/image"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panickyClient panics when asked to pull a malformed image reference.
+type panickyClient struct {
+	*fakeClient
+}
+
+func (p panickyClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	if ref == "" {
+		panic("empty image reference")
+	}
+	return p.fakeClient.ImagePull(ctx, ref, options)
+}
+
+func TestWorker_RunTasksSurvivesPanic(t *testing.T) {
+	w := newWorker(panickyClient{&fakeClient{}})
+	malformed := scheduledTask("malformed")
+	malformed.Image = ""
+	healthy := scheduledTask("healthy")
+	w.AddTask(malformed)
+	w.AddTask(healthy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		w.RunTasks(ctx, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := w.GetTask(healthy.ID)
+		if err == nil && got.State == task.Running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("task queued after the panicking one never started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got, err := w.GetTask(malformed.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed {
+		t.Errorf("panicking task state = %v, want %v", got.State, task.Failed)
+	}
+}
+
+func TestWatchdog_RestartsLoop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var runs atomic.Int32
+	worker.Watchdog(ctx, "test loop", func(ctx context.Context) {
+		if runs.Add(1) == 1 {
+			panic("first run fails")
+		}
+		cancel()
+	})
+
+	if n := runs.Load(); n != 2 {
+		t.Errorf("loop ran %d times, want 2", n)
+	}
+}
@@ -1,10 +1,35 @@
+// Package worker runs tasks handed to it by the manager as Docker containers
+// and keeps track of their state.
 package worker
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/logging"
+	"github.com/christinavaneyssen/cube/queues"
+	"github.com/christinavaneyssen/cube/store"
 	"github.com/christinavaneyssen/cube/task"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/golang-collections/collections/queue"
 	"github.com/google/uuid"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTaskNotFound is returned when the worker has no record of a task.
+	ErrTaskNotFound = fmt.Errorf("task %w", cubeerrors.ErrNotFound)
+
+	// ErrInvalidTransition is returned when a task cannot move to the requested state.
+	ErrInvalidTransition = fmt.Errorf("%w: transition not allowed", cubeerrors.ErrInvalidState)
 )
 
 type Worker struct {
@@ -12,20 +37,742 @@ type Worker struct {
 	Queue     queue.Queue
 	Db        map[uuid.UUID]*task.Task
 	TaskCount int
+
+	// Clock tells the time for task timestamps and paces the warm pool; the
+	// system clock when nil
+	Clock clock.Clock
+
+	// MaxConcurrent caps the number of tasks the worker runs at once.
+	// Zero means no limit. Tasks waiting to start are held in the queue
+	// while the worker is at capacity.
+	MaxConcurrent int
+
+	// Client is the Docker client used to run task containers
+	Client client.APIClient
+
+	// DiskPath, when set, is a directory on the filesystem Docker keeps
+	// images and containers on, such as /var/lib/docker. Tasks whose Disk
+	// exceeds the space free there wait in the queue rather than start.
+	DiskPath string
+
+	// RegistryMirror, when set, is the registry every task's image is
+	// pulled from; see task.MirrorImage
+	RegistryMirror string
+
+	// WarmPool lists images the worker keeps pulled, so that tasks using
+	// them start without waiting for a pull; see RunWarmPool
+	WarmPool []string
+
+	// LogDir, when set, is the directory each task's container output is
+	// captured to, in a file named by the task's ID that is rotated once it
+	// reaches LogMaxSize bytes; DefaultLogMaxSize when zero
+	LogDir     string
+	LogMaxSize int64
+
+	// SecretsDir is the host directory, on a tmpfs, that tasks' secret files
+	// are written under; task.DefaultSecretsDir when empty
+	SecretsDir string
+
+	// StartTimeout bounds pulling a task's image and starting its
+	// container; task.DefaultStartTimeout when zero
+	StartTimeout time.Duration
+
+	// Store, when set, persists the queue so tasks that have not started
+	// yet survive a restart of the worker
+	Store store.Store
+
+	// DrainTimeout is how long Shutdown waits for running tasks to finish
+	// on their own before stopping them; zero stops them straight away
+	DrainTimeout time.Duration
+
+	// exits holds, for auto-removed tasks, the channel that reports the
+	// container's exit code once Docker has removed it
+	exits map[uuid.UUID]<-chan int64
+
+	// draining is set once Shutdown begins, after which no task is started
+	draining bool
+
+	// warm records the outcome of the latest pull of each WarmPool image
+	warm map[string]WarmImage
+
+	// secrets holds the values of each unfinished task's secrets, as sent
+	// by the manager with the task. They are kept in memory only.
+	secrets map[uuid.UUID]task.SecretMap
+
+	mu sync.Mutex
+}
+
+// Stats reports the worker's current load.
+type Stats struct {
+	// TaskCount is the number of tasks the worker knows about
+	TaskCount int
+
+	// Running is the number of tasks currently running
+	Running int
+
+	// Queued is the number of tasks waiting in the queue
+	Queued int
+
+	// MaxConcurrent is the worker's capacity; zero means no limit
+	MaxConcurrent int
+
+	// DiskFree is the space, in MB, free on the filesystem at DiskPath;
+	// nil when the worker has no DiskPath or cannot inspect it
+	DiskFree *int `json:",omitempty"`
+}
+
+// CollectStats returns a snapshot of the worker's load.
+func (w *Worker) CollectStats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var diskFree *int
+	if free, known := w.diskFree(); known {
+		diskFree = &free
+	}
+	return Stats{
+		TaskCount:     len(w.Db),
+		Running:       w.running(),
+		Queued:        w.Queue.Len(),
+		MaxConcurrent: w.MaxConcurrent,
+		DiskFree:      diskFree,
+	}
+}
+
+// AddTask queues a task for the worker to start or stop.
+func (w *Worker) AddTask(t task.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Queue.Enqueue(t)
+	w.persistQueue()
+}
+
+// SubmitTask accepts a task the manager sent to run or stop, along with the
+// values of its secrets, and returns the task as queued.
+func (w *Worker) SubmitTask(te task.TaskEvent) task.Task {
+	w.AddTaskSecrets(te.Task.ID, te.Secrets)
+	w.AddTask(te.Task)
+	return te.Task
+}
+
+// CancelTask queues the task with the given ID to be stopped, recording
+// reason, or "cancelled by user" when it is empty, as why.
+func (w *Worker) CancelTask(id uuid.UUID, reason string) error {
+	t, ok := w.lookup(id)
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+
+	t.State = task.Cancelled
+	t.Reason = reason
+	if t.Reason == "" {
+		t.Reason = task.ReasonCancelledByUser
+	}
+	w.AddTask(t)
+	logging.Infof("Added task %v to stop container %v", t.ID, t.ContainerID)
+	return nil
+}
+
+// AddTaskSecrets holds the values of a task's secrets, sent by the manager
+// with the task, until the task finishes.
+func (w *Worker) AddTaskSecrets(id uuid.UUID, secrets task.SecretMap) {
+	if len(secrets) == 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.secrets == nil {
+		w.secrets = make(map[uuid.UUID]task.SecretMap)
+	}
+	w.secrets[id] = secrets
+}
+
+// taskSecrets returns the store of the task's secrets, or nil when the
+// manager sent none.
+func (w *Worker) taskSecrets(id uuid.UUID) task.SecretStore {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if secrets, ok := w.secrets[id]; ok {
+		return secrets
+	}
+	return nil
+}
+
+// RestoreQueue replaces the queue with the tasks persisted in the store, so
+// work queued before a restart is picked up again. The store mirrors the
+// queue, so restoring more than once, as RunTasks does each time the watchdog
+// restarts it, queues nothing twice. A task waiting to start that uses
+// secrets the worker no longer holds, having lost them with the restart,
+// fails with task.FailureSecretsLost instead of being queued.
+func (w *Worker) RestoreQueue() error {
+	if w.Store == nil {
+		return nil
+	}
+
+	var tasks []task.Task
+	err := w.Store.Get(w.queueKey(), &tasks)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("restoring queue: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Queue = queue.Queue{}
+	lost := 0
+	for _, t := range tasks {
+		if t.State == task.Scheduled && usesSecrets(t) && w.secrets[t.ID] == nil {
+			logging.Warnf("Failing restored task %v: its secrets were lost in the restart", t.ID)
+			t.State = task.Failed
+			t.FailureReason = task.FailureSecretsLost
+			t.FinishTime = w.now().UTC()
+			t.UpdatedAt = t.FinishTime
+			w.Db[t.ID] = &t
+			lost++
+			continue
+		}
+		w.Queue.Enqueue(t)
+	}
+	if lost > 0 {
+		w.persistQueue()
+	}
+	logging.Infof("Restored %d queued tasks", len(tasks)-lost)
+	return nil
+}
+
+// usesSecrets reports whether t or any of its init steps uses a secret.
+func usesSecrets(t task.Task) bool {
+	if len(t.Secrets) > 0 {
+		return true
+	}
+	return slices.ContainsFunc(t.InitTasks, func(cfg task.Config) bool { return len(cfg.Secrets) > 0 })
+}
+
+// GetTasks returns every task the worker knows about, in task.Compare order.
+func (w *Worker) GetTasks() []*task.Task {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	tasks := make([]*task.Task, 0, len(w.Db))
+	for _, t := range w.Db {
+		taskCopy := *t
+		tasks = append(tasks, &taskCopy)
+	}
+	slices.SortFunc(tasks, task.Compare)
+	return tasks
+}
+
+// GetTask returns a copy of the task with the given ID.
+func (w *Worker) GetTask(id uuid.UUID) (*task.Task, error) {
+	t, ok := w.lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrTaskNotFound, id)
+	}
+	return &t, nil
+}
+
+// RunTasks processes the queue every interval until ctx is cancelled. Tasks
+// persisted in the store before a restart are queued first.
+func (w *Worker) RunTasks(ctx context.Context, interval time.Duration) {
+	if err := w.RestoreQueue(); err != nil {
+		logging.Errorf("Error restoring queue: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for w.hasWork() {
+			result := w.RunTask()
+			if result.Error != nil {
+				logging.Errorf("Error running task: %v", result.Error)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TaskStats samples the resource usage of a running task's container.
+func (w *Worker) TaskStats(id uuid.UUID) (task.ContainerStats, error) {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return task.ContainerStats{}, err
+	}
+	if t.State != task.Running {
+		return task.ContainerStats{}, fmt.Errorf("task %v is not running", id)
+	}
+	return w.newDocker(task.NewConfig(t)).Stats(t.ContainerID)
+}
+
+// TaskDiff compares the container of a running or paused task with the
+// task's config; see task.Docker.Diff.
+func (w *Worker) TaskDiff(id uuid.UUID) ([]task.FieldDiff, error) {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return nil, err
+	}
+	if t.State != task.Running && t.State != task.Paused {
+		return nil, fmt.Errorf("%w: a %v task has no container to compare", ErrInvalidTransition, t.State)
+	}
+	return w.newDocker(task.NewConfig(t)).Diff(t.ContainerID)
+}
+
+// PauseTask freezes the container of a running task.
+func (w *Worker) PauseTask(id uuid.UUID) error {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return err
+	}
+	if t.State != task.Running {
+		return fmt.Errorf("%w: cannot pause a %v task", ErrInvalidTransition, t.State)
+	}
+
+	if err := w.newDocker(task.NewConfig(t)).Pause(context.Background(), t.ContainerID); err != nil {
+		return err
+	}
+	t.State = task.Paused
+	w.putTask(*t)
+	return nil
+}
+
+// PruneTask forgets a finished task and removes its container, if the
+// container is still around.
+func (w *Worker) PruneTask(id uuid.UUID) error {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return err
+	}
+	if !t.State.Terminal() {
+		return fmt.Errorf("%w: cannot prune a %v task", ErrInvalidTransition, t.State)
+	}
+
+	if t.ContainerID != "" && !t.AutoRemove {
+		if err := w.newDocker(task.NewConfig(t)).Remove(t.ContainerID); err != nil {
+			return err
+		}
+	}
+
+	if err := w.removeLogs(id); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.Db, id)
+	delete(w.exits, id)
+	return nil
+}
+
+// UnpauseTask resumes the container of a paused task.
+func (w *Worker) UnpauseTask(id uuid.UUID) error {
+	t, err := w.GetTask(id)
+	if err != nil {
+		return err
+	}
+	if t.State != task.Paused {
+		return fmt.Errorf("%w: cannot unpause a %v task", ErrInvalidTransition, t.State)
+	}
+
+	if err := w.newDocker(task.NewConfig(t)).Unpause(context.Background(), t.ContainerID); err != nil {
+		return err
+	}
+	t.State = task.Running
+	w.putTask(*t)
+	return nil
+}
+
+// RunUpdates inspects running tasks every interval until ctx is cancelled.
+func (w *Worker) RunUpdates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.UpdateTasks()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// UpdateTasks inspects the container of every running task and records the
+// tasks whose containers have exited or disappeared, along with their exit
+// codes. A task whose container exits with a non-zero code has failed. An
+// auto-removed container is expected to disappear, so its exit code is the
+// one captured when Docker removed it. A task whose container Docker's
+// healthcheck reports unhealthy has failed too; otherwise the task records
+// the health Docker reports.
+func (w *Worker) UpdateTasks() {
+	for _, t := range w.GetTasks() {
+		if t.State != task.Running {
+			continue
+		}
+
+		resp := w.newDocker(task.NewConfig(t)).Inspect(t.ContainerID)
+		switch {
+		case errdefs.IsNotFound(resp.Error) && t.AutoRemove:
+			logging.Infof("Container %s for task %v was removed on exit", t.ContainerID, t.ID)
+			t.ExitCode = w.exitCode(t.ID)
+			if t.ExitCode != 0 {
+				t.FailureReason = task.FailureExitCode
+			}
+			w.finish(*t, exitState(t.ExitCode))
+		case errdefs.IsNotFound(resp.Error):
+			logging.Infof("Container %s for task %v no longer exists", t.ContainerID, t.ID)
+			t.FailureReason = task.FailureContainerGone
+			w.finish(*t, task.Failed)
+		case resp.Error != nil:
+			logging.Errorf("Error inspecting container %s for task %v: %v", t.ContainerID, t.ID, resp.Error)
+		case resp.Container.State.Status == "exited":
+			t.ExitCode = resp.Container.State.ExitCode
+			logging.Infof("Container %s for task %v exited with code %d", t.ContainerID, t.ID, t.ExitCode)
+			if t.CaptureResult && t.ExitCode == 0 {
+				t.Result = w.result(*t)
+			}
+			if t.ExitCode != 0 {
+				t.FailureReason = task.ExitFailure(resp.Container.State)
+			}
+			w.finish(*t, exitState(t.ExitCode))
+		case resp.Container.State.Health != nil && resp.Container.State.Health.Status == types.Unhealthy:
+			logging.Infof("Container %s for task %v is unhealthy", t.ContainerID, t.ID)
+			t.Health = types.Unhealthy
+			t.FailureReason = task.FailureUnhealthy
+			w.finish(*t, task.Failed)
+		case resp.Container.State.Health != nil && resp.Container.State.Health.Status != t.Health:
+			t.Health = resp.Container.State.Health.Status
+			w.putTask(*t)
+		}
+	}
+}
+
+// exitState is the state of a task whose container exited with code.
+func exitState(code int) task.State {
+	if code != 0 {
+		return task.Failed
+	}
+	return task.Completed
+}
+
+// RunTask takes the next runnable task off the queue and starts or stops it
+// depending on its desired state. A panic while acting on the task is
+// recovered and fails the task, so one malformed task cannot take down the
+// run loop.
+func (w *Worker) RunTask() (result task.DockerResult) {
+	w.mu.Lock()
+	taskQueued, ok := w.nextTask()
+	if !ok {
+		w.mu.Unlock()
+		return task.DockerResult{}
+	}
+
+	taskPersisted := w.Db[taskQueued.ID]
+	if taskPersisted == nil {
+		taskPersisted = &taskQueued
+		w.Db[taskQueued.ID] = taskPersisted
+	}
+	current := *taskPersisted
+	w.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("Recovered from panic running task %v: %v\n%s", taskQueued.ID, r, debug.Stack())
+			w.finish(taskQueued, task.Failed)
+			result = task.DockerResult{Error: fmt.Errorf("panic running task %v: %v", taskQueued.ID, r)}
+		}
+	}()
+
+	if !task.ValidStateTransition(current.State, taskQueued.State) && !isRestart(current, taskQueued) {
+		return task.DockerResult{
+			Error: fmt.Errorf("invalid transition from %v to %v", current.State, taskQueued.State),
+		}
+	}
+
+	switch taskQueued.State {
+	case task.Scheduled:
+		return w.StartTask(taskQueued)
+	case task.Completed, task.Cancelled:
+		current.Reason = taskQueued.Reason
+		return w.StopTask(current, taskQueued.State)
+	default:
+		return task.DockerResult{Error: errors.New("unexpected desired task state")}
+	}
+}
+
+// isRestart reports whether queued starts a new lifecycle of the task, which
+// the manager signals by raising its RestartCount. A restart may follow any
+// state, including a finished one.
+func isRestart(current, queued task.Task) bool {
+	return queued.State == task.Scheduled && queued.RestartCount > current.RestartCount
+}
+
+// StartTask runs the task's init tasks and then its container, and records
+// the outcome.
+func (w *Worker) StartTask(t task.Task) task.DockerResult {
+	t.StartTime = w.now().UTC()
+	if err := w.runInitTasks(&t); err != nil {
+		logging.Errorf("Error running init tasks of task %v: %v", t.ID, err)
+		t.FinishTime = w.now().UTC()
+		t.State = task.Failed
+		w.putTask(t)
+		return task.DockerResult{Error: err}
+	}
+
+	cfg := task.NewConfig(&t)
+	if cfg.PullPolicy == "" || cfg.PullPolicy == task.PullAlways {
+		if w.isWarm(cfg.Image) {
+			cfg.PullPolicy = task.PullIfNotPresent
+		}
+	}
+	d := w.newDocker(cfg)
+	d.Secrets = w.taskSecrets(t.ID)
+	result := d.Run()
+	if result.Error != nil {
+		logging.Errorf("[trace %s] Error running task %v: %v", t.TraceID, t.ID, result.Error)
+		t.State = task.Failed
+		t.FailureReason = task.StartFailure(result.Error)
+		w.putTask(t)
+		return result
+	}
+
+	t.ContainerID = result.ContainerID
+	t.State = task.Running
+	t.Discrepancies = w.verifyLimits(d, t)
+	w.putTask(t)
+	w.captureLogs(t)
+
+	if result.Exited != nil {
+		w.mu.Lock()
+		if w.exits == nil {
+			w.exits = make(map[uuid.UUID]<-chan int64)
+		}
+		w.exits[t.ID] = result.Exited
+		w.mu.Unlock()
+	}
+	return result
+}
+
+// runInitTasks runs the task's init steps in order, each to completion, and
+// stops at the first one that fails. A step's non-zero exit code becomes the
+// task's.
+func (w *Worker) runInitTasks(t *task.Task) error {
+	for i, cfg := range t.InitTasks {
+		logging.Infof("Running init step %d (%s) of task %v", i, cfg.Name, t.ID)
+		d := w.newDocker(&cfg)
+		d.Secrets = w.taskSecrets(t.ID)
+		code, err := d.RunToCompletion()
+		if err != nil {
+			t.FailureReason = task.StartFailure(err)
+			return fmt.Errorf("init step %d (%s): %w", i, cfg.Name, err)
+		}
+		if code != 0 {
+			t.ExitCode = int(code)
+			t.FailureReason = task.FailureExitCode
+			return fmt.Errorf("init step %d (%s) exited with code %d", i, cfg.Name, code)
+		}
+	}
+	return nil
+}
+
+// StopTask stops the task's container and moves the task to state, which is
+// Completed for a graceful stop or Cancelled when it was stopped on request.
+func (w *Worker) StopTask(t task.Task, state task.State) task.DockerResult {
+	d := w.newDocker(task.NewConfig(&t))
+	result := d.Stop(t.ContainerID)
+	if result.Error != nil {
+		logging.Errorf("Error stopping container %v: %v", t.ContainerID, result.Error)
+		return result
+	}
+
+	t.FinishTime = w.now().UTC()
+	t.State = state
+	w.putTask(t)
+	logging.Infof("Stopped and removed container %v for task %v", t.ContainerID, t.ID)
+	return result
+}
+
+func (w *Worker) newDocker(cfg *task.Config) *task.Docker {
+	c := *cfg
+	if c.RegistryMirror == "" {
+		c.RegistryMirror = w.RegistryMirror
+	}
+	return &task.Docker{
+		Client:       w.Client,
+		Config:       c,
+		Logger:       logging.Printer(logging.Info),
+		Writer:       os.Stdout,
+		StdErr:       os.Stderr,
+		SecretsDir:   w.SecretsDir,
+		StartTimeout: w.StartTimeout,
+	}
+}
+
+// lookup returns a copy of the task with the given ID.
+func (w *Worker) lookup(id uuid.UUID) (task.Task, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	t, ok := w.Db[id]
+	if !ok {
+		return task.Task{}, false
+	}
+	return *t, true
 }
 
-func (w *Worker) CollectStats() {
-	fmt.Println("I will collect stats")
+// verifyLimits reports the resource limits the daemon did not apply as
+// requested for a task that has just started.
+func (w *Worker) verifyLimits(d *task.Docker, t task.Task) []string {
+	discrepancies, err := d.VerifyLimits(t.ContainerID)
+	if err != nil {
+		logging.Errorf("Error verifying resource limits of task %v: %v", t.ID, err)
+		return nil
+	}
+	for _, msg := range discrepancies {
+		logging.Warnf("Task %v %s", t.ID, msg)
+	}
+	return discrepancies
 }
 
-func (w *Worker) RunTask() {
-	fmt.Println("I will start or stop a task")
+// exitCode returns the exit code reported when Docker removed the task's
+// container, waiting briefly for it to arrive.
+func (w *Worker) exitCode(id uuid.UUID) int {
+	w.mu.Lock()
+	exited := w.exits[id]
+	delete(w.exits, id)
+	w.mu.Unlock()
+
+	if exited == nil {
+		return 0
+	}
+	select {
+	case code := <-exited:
+		return int(code)
+	case <-w.after(time.Second):
+		logging.Warnf("Timed out waiting for the exit code of task %v", id)
+		return 0
+	}
+}
+
+// finish records that a task's container has stopped running.
+func (w *Worker) finish(t task.Task, state task.State) {
+	t.State = state
+	t.FinishTime = w.now().UTC()
+	w.putTask(t)
+}
+
+// now returns the current time according to the worker's clock.
+func (w *Worker) now() time.Time {
+	if w.Clock == nil {
+		return time.Now()
+	}
+	return w.Clock.Now()
+}
+
+// after returns a channel that receives once d has passed on the worker's
+// clock.
+func (w *Worker) after(d time.Duration) <-chan time.Time {
+	if w.Clock == nil {
+		return time.After(d)
+	}
+	return w.Clock.After(d)
+}
+
+// putTask records t, whose state has just changed.
+func (w *Worker) putTask(t task.Task) {
+	t.UpdatedAt = w.now().UTC()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.Db[t.ID] = &t
+	if t.State.Terminal() {
+		delete(w.secrets, t.ID)
+	}
+}
+
+func (w *Worker) hasWork() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, found := queues.Find(&w.Queue, w.actionable())
+	return found
+}
+
+// nextTask dequeues the first task that can be acted on now. Tasks waiting to
+// start are skipped while the worker is at capacity, or while they need more
+// disk than is free, and keep their place in the queue. The caller must hold
+// w.mu.
+func (w *Worker) nextTask() (task.Task, bool) {
+	next, found := queues.Remove(&w.Queue, w.actionable())
+	if found {
+		w.persistQueue()
+	}
+	return next, found
+}
+
+// queueKey is the store key the worker's queue is persisted under. The value
+// is a JSON array of the queued tasks, head first.
+func (w *Worker) queueKey() string {
+	return "worker/" + w.Name + "/queue"
+}
+
+// persistQueue writes the queue to the store. The caller must hold w.mu.
+func (w *Worker) persistQueue() {
+	if w.Store == nil {
+		return
+	}
+
+	if err := w.Store.Put(w.queueKey(), queues.Items[task.Task](&w.Queue)); err != nil {
+		logging.Errorf("Error persisting queue: %v", err)
+	}
+}
+
+// actionable returns a predicate reporting whether a queued task can be
+// acted on now: stopping a task always can, while starting one needs a free
+// slot and, when the worker knows its free disk, room for the task's Disk,
+// and cannot happen at all once the worker is shutting down. The caller must
+// hold w.mu.
+func (w *Worker) actionable() func(task.Task) bool {
+	if w.draining {
+		return func(t task.Task) bool { return t.State != task.Scheduled }
+	}
+
+	atCapacity := w.atCapacity()
+	free, known := w.diskFree()
+	return func(t task.Task) bool {
+		if t.State != task.Scheduled {
+			return true
+		}
+		return !atCapacity && (!known || t.Disk <= free)
+	}
 }
 
-func (w *Worker) StartTask() {
-	fmt.Println("I will start a task")
+// atCapacity reports whether the worker is running as many tasks as it may.
+// The caller must hold w.mu.
+func (w *Worker) atCapacity() bool {
+	return w.MaxConcurrent > 0 && w.running() >= w.MaxConcurrent
 }
 
-func (w *Worker) StopTask() {
-	fmt.Println("I will stop a task")
+// running counts the tasks currently running. The caller must hold w.mu.
+func (w *Worker) running() int {
+	count := 0
+	for _, t := range w.Db {
+		if t.State == task.Running {
+			count++
+		}
+	}
+	return count
 }
@@ -0,0 +1,842 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/christinavaneyssen/cube/clock"
+	cubeerrors "github.com/christinavaneyssen/cube/errors"
+	"github.com/christinavaneyssen/cube/store"
+	"github.com/christinavaneyssen/cube/task"
+	"github.com/christinavaneyssen/cube/worker"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/golang-collections/collections/queue"
+	"github.com/google/uuid"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClient stands in for the Docker daemon. Methods a test does not
+// override panic through the embedded nil interface.
+type fakeClient struct {
+	client.APIClient
+	created     int
+	stopped     []string
+	calls       []string
+	configs     []*container.Config
+	hostConfigs []*container.HostConfig
+
+	// exitCode is reported to callers waiting on a container
+	exitCode int64
+
+	// inspect answers ContainerInspect; containers report running when nil
+	inspect func(containerID string) (types.ContainerJSON, error)
+
+	// stdout and stderr are what every container logs
+	stdout, stderr string
+
+	// containers answers ContainerList
+	containers []types.Container
+
+	// unreachable lists the images whose registry cannot be reached
+	unreachable []string
+
+	// pulls records every image pulled, which are then on the host
+	pulls []string
+
+	// execs records the command of every exec, which prints stdout and
+	// stderr and exits with exitCode
+	execs [][]string
+}
+
+func (f *fakeClient) DistributionInspect(ctx context.Context, image, encodedRegistryAuth string) (registry.DistributionInspect, error) {
+	if slices.Contains(f.unreachable, image) {
+		return registry.DistributionInspect{}, fmt.Errorf("Get https://%s: dial tcp: i/o timeout", image)
+	}
+	return registry.DistributionInspect{}, nil
+}
+
+func (f *fakeClient) ContainerList(ctx context.Context, options container.ListOptions) ([]types.Container, error) {
+	return f.containers, nil
+}
+
+func (f *fakeClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	buf := &bytes.Buffer{}
+	if f.stdout != "" {
+		stdcopy.NewStdWriter(buf, stdcopy.Stdout).Write([]byte(f.stdout))
+	}
+	if f.stderr != "" {
+		stdcopy.NewStdWriter(buf, stdcopy.Stderr).Write([]byte(f.stderr))
+	}
+	return io.NopCloser(buf), nil
+}
+
+func (f *fakeClient) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (types.IDResponse, error) {
+	f.execs = append(f.execs, options.Cmd)
+	return types.IDResponse{ID: fmt.Sprintf("exec-%d", len(f.execs))}, nil
+}
+
+func (f *fakeClient) ContainerExecAttach(ctx context.Context, execID string, options container.ExecAttachOptions) (types.HijackedResponse, error) {
+	conn, daemon := net.Pipe()
+	go func() {
+		defer daemon.Close()
+		stdcopy.NewStdWriter(daemon, stdcopy.Stdout).Write([]byte(f.stdout))
+		stdcopy.NewStdWriter(daemon, stdcopy.Stderr).Write([]byte(f.stderr))
+	}()
+	return types.NewHijackedResponse(conn, "application/vnd.docker.multiplexed-stream"), nil
+}
+
+func (f *fakeClient) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	return container.ExecInspect{ExecID: execID, ExitCode: int(f.exitCode)}, nil
+}
+
+func (f *fakeClient) ImagePull(ctx context.Context, ref string, options image.PullOptions) (io.ReadCloser, error) {
+	f.pulls = append(f.pulls, ref)
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (f *fakeClient) ImageInspectWithRaw(ctx context.Context, ref string) (types.ImageInspect, []byte, error) {
+	if !slices.Contains(f.pulls, ref) {
+		return types.ImageInspect{}, nil, errdefs.NotFound(fmt.Errorf("no such image: %s", ref))
+	}
+	return types.ImageInspect{ID: ref}, nil, nil
+}
+
+func (f *fakeClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	f.created++
+	f.calls = append(f.calls, "create")
+	f.configs = append(f.configs, config)
+	f.hostConfigs = append(f.hostConfigs, hostConfig)
+	return container.CreateResponse{ID: fmt.Sprintf("container-%d", f.created)}, nil
+}
+
+func (f *fakeClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	f.calls = append(f.calls, "start")
+	return nil
+}
+
+func (f *fakeClient) ContainerPause(ctx context.Context, containerID string) error {
+	f.calls = append(f.calls, "pause:"+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerUnpause(ctx context.Context, containerID string) error {
+	f.calls = append(f.calls, "unpause:"+containerID)
+	return nil
+}
+
+func (f *fakeClient) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.WaitResponse, <-chan error) {
+	f.calls = append(f.calls, "wait:"+string(condition))
+	statusCh := make(chan container.WaitResponse, 1)
+	statusCh <- container.WaitResponse{StatusCode: f.exitCode}
+	return statusCh, make(chan error)
+}
+
+func (f *fakeClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if f.inspect != nil {
+		return f.inspect(containerID)
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    containerID,
+			State: &types.ContainerState{Status: "running", Running: true},
+		},
+	}, nil
+}
+
+func (f *fakeClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.stopped = append(f.stopped, containerID)
+	return nil
+}
+
+// ContainerRemove fails as the daemon does for a container created with
+// AutoRemove that has been stopped, which Docker is already removing.
+func (f *fakeClient) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	var n int
+	fmt.Sscanf(containerID, "container-%d", &n)
+	if n > 0 && n <= len(f.hostConfigs) && f.hostConfigs[n-1].AutoRemove && slices.Contains(f.stopped, containerID) {
+		return errdefs.Conflict(fmt.Errorf("removal of container %s is already in progress", containerID))
+	}
+	return nil
+}
+
+func newWorker(c client.APIClient) *worker.Worker {
+	return &worker.Worker{
+		Name:   "test-worker",
+		Queue:  *queue.New(),
+		Db:     make(map[uuid.UUID]*task.Task),
+		Client: c,
+	}
+}
+
+func scheduledTask(name string) task.Task {
+	return task.Task{
+		ID:    uuid.New(),
+		Name:  name,
+		State: task.Scheduled,
+		Image: "strm/helloworld-http",
+	}
+}
+
+func TestWorker_MaxConcurrent(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	w.MaxConcurrent = 2
+
+	tasks := []task.Task{scheduledTask("task-1"), scheduledTask("task-2"), scheduledTask("task-3")}
+	for _, tk := range tasks {
+		w.AddTask(tk)
+	}
+
+	for range tasks {
+		if result := w.RunTask(); result.Error != nil {
+			t.Fatalf("RunTask() error = %v", result.Error)
+		}
+	}
+
+	stats := w.CollectStats()
+	if stats.Running != 2 || stats.Queued != 1 || stats.MaxConcurrent != 2 {
+		t.Fatalf("stats = %+v, want 2 running, 1 queued, capacity 2", stats)
+	}
+	if fc.created != 2 {
+		t.Errorf("created %d containers, want 2", fc.created)
+	}
+	for _, tk := range w.GetTasks() {
+		if tk.ID == tasks[2].ID {
+			t.Fatalf("third task %v started while worker was at capacity", tk.ID)
+		}
+	}
+
+	// Stopping a running task frees a slot for the waiting one.
+	stop := tasks[0]
+	stop.State = task.Completed
+	w.AddTask(stop)
+	for i := 0; i < 2; i++ {
+		if result := w.RunTask(); result.Error != nil {
+			t.Fatalf("RunTask() error = %v", result.Error)
+		}
+	}
+
+	stats = w.CollectStats()
+	if stats.Running != 2 || stats.Queued != 0 {
+		t.Fatalf("stats = %+v, want 2 running, 0 queued", stats)
+	}
+	if len(fc.stopped) != 1 || fc.stopped[0] != "container-1" {
+		t.Errorf("stopped = %v, want [container-1]", fc.stopped)
+	}
+}
+
+func TestWorker_LowDiskHoldsTask(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	w.DiskPath = t.TempDir()
+
+	stats := w.CollectStats()
+	if stats.DiskFree == nil {
+		t.Skip("free disk space is not known on this platform")
+	}
+
+	big := scheduledTask("big")
+	big.Disk = *stats.DiskFree * 1024
+	small := scheduledTask("small")
+	small.Disk = 1
+	w.AddTask(big)
+	w.AddTask(small)
+
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if fc.created != 1 {
+		t.Fatalf("created %d containers, want only the small task's", fc.created)
+	}
+	if _, err := w.GetTask(big.ID); err == nil {
+		t.Errorf("task needing %d MB started with %d MB free", big.Disk, *stats.DiskFree)
+	}
+	if result := w.RunTask(); result.Error != nil || result.ContainerID != "" {
+		t.Errorf("RunTask() = %+v, want nothing to run", result)
+	}
+	if stats := w.CollectStats(); stats.Queued != 1 {
+		t.Errorf("stats = %+v, want the big task still queued", stats)
+	}
+}
+
+func TestWorker_UpdateTasksAutoRemove(t *testing.T) {
+	fc := &fakeClient{exitCode: 3}
+	w := newWorker(fc)
+
+	tk := scheduledTask("short-job")
+	tk.AutoRemove = true
+	w.AddTask(tk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	if !fc.hostConfigs[0].AutoRemove {
+		t.Error("host config AutoRemove = false, want true")
+	}
+	wait := slices.Index(fc.calls, "wait:removed")
+	start := slices.Index(fc.calls, "start")
+	if wait < 0 || wait > start {
+		t.Errorf("calls = %v, want to wait for removal before starting", fc.calls)
+	}
+
+	// Docker removes the container as soon as it exits.
+	fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+		return types.ContainerJSON{}, errdefs.NotFound(errors.New("No such container: " + containerID))
+	}
+	w.UpdateTasks()
+
+	got, err := w.GetTask(tk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed {
+		t.Errorf("state = %v, want %v", got.State, task.Failed)
+	}
+	if got.ExitCode != 3 {
+		t.Errorf("exit code = %d, want 3", got.ExitCode)
+	}
+	if got.FinishTime.IsZero() {
+		t.Error("finish time not recorded")
+	}
+}
+
+func TestWorker_StopAutoRemoveTask(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+
+	tk := scheduledTask("short-job")
+	tk.AutoRemove = true
+	w.AddTask(tk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if err := w.CancelTask(tk.ID, ""); err != nil {
+		t.Fatalf("CancelTask() error = %v", err)
+	}
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	got, err := w.GetTask(tk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Cancelled {
+		t.Errorf("state = %v, want %v", got.State, task.Cancelled)
+	}
+	if !slices.Equal(fc.stopped, []string{"container-1"}) {
+		t.Errorf("stopped = %v, want [container-1]", fc.stopped)
+	}
+}
+
+func TestWorker_UpdateTasksClassifiesFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		state types.ContainerState
+		want  string
+	}{
+		{"oom killed", types.ContainerState{Status: "exited", ExitCode: 137, OOMKilled: true}, task.FailureOOMKilled},
+		{"non-zero exit", types.ContainerState{Status: "exited", ExitCode: 1}, task.FailureExitCode},
+		{"daemon error", types.ContainerState{Status: "exited", ExitCode: 127, Error: "exec format error"}, task.FailureDaemon},
+		{"clean exit", types.ContainerState{Status: "exited"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &fakeClient{}
+			w := newWorker(fc)
+			tk := scheduledTask("job")
+			w.AddTask(tk)
+			if result := w.RunTask(); result.Error != nil {
+				t.Fatalf("RunTask() error = %v", result.Error)
+			}
+
+			fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+				state := tt.state
+				return types.ContainerJSON{
+					ContainerJSONBase: &types.ContainerJSONBase{ID: containerID, State: &state},
+				}, nil
+			}
+			w.UpdateTasks()
+
+			got, err := w.GetTask(tk.ID)
+			if err != nil {
+				t.Fatalf("GetTask() error = %v", err)
+			}
+			if got.FailureReason != tt.want {
+				t.Errorf("failure reason = %q, want %q", got.FailureReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_QueueSurvivesRestart(t *testing.T) {
+	s := store.NewInMemoryStore()
+	w := newWorker(&fakeClient{})
+	w.Store = s
+
+	first, second := scheduledTask("task-1"), scheduledTask("task-2")
+	w.AddTask(first)
+	w.AddTask(second)
+
+	// Simulate a crash by building a fresh worker on the same store.
+	restarted := newWorker(&fakeClient{})
+	restarted.Store = s
+	if err := restarted.RestoreQueue(); err != nil {
+		t.Fatalf("RestoreQueue() error = %v", err)
+	}
+
+	if got := restarted.Queue.Len(); got != 2 {
+		t.Fatalf("queue length = %d, want 2", got)
+	}
+	if result := restarted.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+	if _, err := restarted.GetTask(first.ID); err != nil {
+		t.Errorf("first queued task was not started first: %v", err)
+	}
+
+	// Starting a task removes it from the persisted queue.
+	again := newWorker(&fakeClient{})
+	again.Store = s
+	if err := again.RestoreQueue(); err != nil {
+		t.Fatalf("RestoreQueue() error = %v", err)
+	}
+	if got := again.Queue.Len(); got != 1 {
+		t.Errorf("queue length after start = %d, want 1", got)
+	}
+}
+
+func TestWorker_RunTasksRestoresQueueOnce(t *testing.T) {
+	w := newWorker(&fakeClient{})
+	w.Store = store.NewInMemoryStore()
+	w.MaxConcurrent = 1
+	for _, name := range []string{"task-1", "task-2", "task-3"} {
+		w.AddTask(scheduledTask(name))
+	}
+
+	// The watchdog calls RunTasks again each time it panics.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.RunTasks(ctx, time.Hour)
+	w.RunTasks(ctx, time.Hour)
+
+	if got := w.Queue.Len(); got != 2 {
+		t.Errorf("queue length = %d, want 2", got)
+	}
+}
+
+func TestWorker_UpdateTasksRecordsExitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		want     task.State
+	}{
+		{"clean exit", 0, task.Completed},
+		{"killed", 137, task.Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &fakeClient{}
+			w := newWorker(fc)
+			tk := scheduledTask("job")
+			w.AddTask(tk)
+			if result := w.RunTask(); result.Error != nil {
+				t.Fatalf("RunTask() error = %v", result.Error)
+			}
+
+			fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+				return types.ContainerJSON{
+					ContainerJSONBase: &types.ContainerJSONBase{
+						ID:    containerID,
+						State: &types.ContainerState{Status: "exited", ExitCode: tt.exitCode},
+					},
+				}, nil
+			}
+			w.UpdateTasks()
+
+			got, err := w.GetTask(tk.ID)
+			if err != nil {
+				t.Fatalf("GetTask() error = %v", err)
+			}
+			if got.ExitCode != tt.exitCode || got.State != tt.want {
+				t.Errorf("got exit code %d and state %v, want %d and %v", got.ExitCode, got.State, tt.exitCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_UpdateTasksCapturesResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		stdout string
+		want   string
+	}{
+		{"result line", "rows: 10\nrows: 20\n{\"rows\": 30}\n\n", `{"rows": 30}`},
+		{"no output", "", ""},
+		{"oversized", strings.Repeat("x", worker.MaxResultSize+100), strings.Repeat("x", worker.MaxResultSize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fc := &fakeClient{stdout: tt.stdout, stderr: "warning: ignored\n"}
+			w := newWorker(fc)
+			tk := scheduledTask("report")
+			tk.CaptureResult = true
+			w.AddTask(tk)
+			if result := w.RunTask(); result.Error != nil {
+				t.Fatalf("RunTask() error = %v", result.Error)
+			}
+
+			fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+				return types.ContainerJSON{
+					ContainerJSONBase: &types.ContainerJSONBase{
+						ID:    containerID,
+						State: &types.ContainerState{Status: "exited"},
+					},
+				}, nil
+			}
+			w.UpdateTasks()
+
+			got, err := w.GetTask(tk.ID)
+			if err != nil {
+				t.Fatalf("GetTask() error = %v", err)
+			}
+			if got.State != task.Completed || got.Result != tt.want {
+				t.Errorf("got state %v and result %q, want %v and %q", got.State, got.Result, task.Completed, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorker_StartTaskVerifiesLimits(t *testing.T) {
+	fc := &fakeClient{}
+	fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+		// The daemon raised the memory limit to its minimum but kept the CPUs.
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    containerID,
+				State: &types.ContainerState{Status: "running", Running: true},
+				HostConfig: &container.HostConfig{
+					Resources: container.Resources{Memory: 6 * 1024 * 1024, NanoCPUs: 500_000_000},
+				},
+			},
+		}, nil
+	}
+	w := newWorker(fc)
+
+	tk := scheduledTask("tiny")
+	tk.Memory = 4
+	tk.Cpu = 0.5
+	w.AddTask(tk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	got, err := w.GetTask(tk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Running {
+		t.Errorf("state = %v, want %v", got.State, task.Running)
+	}
+	want := []string{"memory limit is 6291456 bytes, requested 4194304"}
+	if !slices.Equal(got.Discrepancies, want) {
+		t.Errorf("discrepancies = %q, want %q", got.Discrepancies, want)
+	}
+}
+
+func TestWorker_ErrorsMatchSentinels(t *testing.T) {
+	w := newWorker(&fakeClient{})
+	done := &task.Task{ID: uuid.New(), Name: "done", State: task.Completed}
+	w.Db[done.ID] = done
+
+	if _, err := w.GetTask(uuid.New()); !errors.Is(err, cubeerrors.ErrNotFound) {
+		t.Errorf("GetTask() of an unknown task error = %v, want ErrNotFound", err)
+	}
+	if err := w.PauseTask(done.ID); !errors.Is(err, cubeerrors.ErrInvalidState) {
+		t.Errorf("PauseTask() of a completed task error = %v, want ErrInvalidState", err)
+	}
+	if err := w.PauseTask(done.ID); !errors.Is(err, worker.ErrInvalidTransition) {
+		t.Errorf("PauseTask() of a completed task error = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestWorker_FailedInitTaskSkipsMainContainer(t *testing.T) {
+	fc := &fakeClient{exitCode: 2}
+	w := newWorker(fc)
+	tsk := scheduledTask("web")
+	tsk.InitTasks = []task.Config{
+		{Name: "migrate", Image: "migrate/migrate"},
+		{Name: "seed", Image: "seed"},
+	}
+	w.AddTask(tsk)
+
+	if result := w.RunTask(); result.Error == nil {
+		t.Fatal("RunTask() error = nil, want the init step's failure")
+	}
+
+	got, err := w.GetTask(tsk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed || got.ExitCode != 2 {
+		t.Errorf("task state = %v, exit code %d, want %v and 2", got.State, got.ExitCode, task.Failed)
+	}
+	if got.ContainerID != "" {
+		t.Errorf("task container ID = %q, want none", got.ContainerID)
+	}
+	if want := []string{"create", "wait:next-exit", "start"}; !slices.Equal(fc.calls, want) {
+		t.Errorf("calls = %v, want %v; only the first init step should run", fc.calls, want)
+	}
+}
+
+func TestWorker_UpdateTasksFailsUnhealthyTask(t *testing.T) {
+	fc := &fakeClient{
+		inspect: func(containerID string) (types.ContainerJSON, error) {
+			return types.ContainerJSON{
+				ContainerJSONBase: &types.ContainerJSONBase{
+					ID: containerID,
+					State: &types.ContainerState{
+						Status:  "running",
+						Running: true,
+						Health:  &types.Health{Status: types.Unhealthy, FailingStreak: 3},
+					},
+				},
+			}, nil
+		},
+	}
+	w := newWorker(fc)
+	running := &task.Task{ID: uuid.New(), Name: "web", State: task.Running, ContainerID: "container-1"}
+	w.Db[running.ID] = running
+
+	w.UpdateTasks()
+
+	got, err := w.GetTask(running.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Failed {
+		t.Errorf("state = %v, want %v", got.State, task.Failed)
+	}
+}
+
+func TestWorker_TimestampsFollowClock(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	w.Clock = c
+	tsk := scheduledTask("batch")
+	w.AddTask(tsk)
+
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	c.Advance(5 * time.Minute)
+	fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+		return types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{
+				ID:    containerID,
+				State: &types.ContainerState{Status: "exited"},
+			},
+		}, nil
+	}
+	w.UpdateTasks()
+
+	got, err := w.GetTask(tsk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if !got.StartTime.Equal(start) || !got.FinishTime.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("start, finish = %v, %v, want %v, %v", got.StartTime, got.FinishTime, start, start.Add(5*time.Minute))
+	}
+}
+
+func TestWorker_RestartStartsNewContainer(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	tk := scheduledTask("web")
+	w.AddTask(tk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	stop := tk
+	stop.State = task.Cancelled
+	stop.Reason = task.ReasonRestarted
+	w.AddTask(stop)
+	restart := tk
+	restart.RestartCount = 1
+	w.AddTask(restart)
+	for i := 0; i < 2; i++ {
+		if result := w.RunTask(); result.Error != nil {
+			t.Fatalf("RunTask() error = %v", result.Error)
+		}
+	}
+
+	got, err := w.GetTask(tk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if got.State != task.Running || got.ContainerID != "container-2" || got.RestartCount != 1 {
+		t.Errorf("task = %v in %q with %d restarts, want running in container-2 with 1", got.State, got.ContainerID, got.RestartCount)
+	}
+	if !slices.Equal(fc.stopped, []string{"container-1"}) {
+		t.Errorf("stopped = %v, want [container-1]", fc.stopped)
+	}
+
+	// Replaying the original start must not start a third container.
+	w.AddTask(tk)
+	if result := w.RunTask(); result.Error == nil {
+		t.Error("RunTask() replaying the first start succeeded, want an invalid transition")
+	}
+}
+
+func TestWorker_StateChangesBumpUpdatedAt(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	w := newWorker(&fakeClient{})
+	w.Clock = c
+	tsk := scheduledTask("web")
+	w.AddTask(tsk)
+	if result := w.RunTask(); result.Error != nil {
+		t.Fatalf("RunTask() error = %v", result.Error)
+	}
+
+	c.Advance(time.Minute)
+	if err := w.PauseTask(tsk.ID); err != nil {
+		t.Fatalf("PauseTask() error = %v", err)
+	}
+
+	got, err := w.GetTask(tsk.ID)
+	if err != nil {
+		t.Fatalf("GetTask() error = %v", err)
+	}
+	if want := start.Add(time.Minute); !got.UpdatedAt.Equal(want) {
+		t.Errorf("updated at %v, want %v", got.UpdatedAt, want)
+	}
+}
+
+func TestWorker_ShutdownDrainsTasks(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	w.DrainTimeout = 200 * time.Millisecond
+
+	quick, slow, queued := scheduledTask("quick"), scheduledTask("slow"), scheduledTask("queued")
+	w.AddTask(quick)
+	w.AddTask(slow)
+	for range 2 {
+		if result := w.RunTask(); result.Error != nil {
+			t.Fatalf("RunTask() error = %v", result.Error)
+		}
+	}
+	w.AddTask(queued)
+
+	// The quick task's container exits within the window; the slow one's
+	// keeps running.
+	fc.inspect = func(containerID string) (types.ContainerJSON, error) {
+		state := &types.ContainerState{Status: "running", Running: true}
+		if containerID == "container-1" {
+			state = &types.ContainerState{Status: "exited"}
+		}
+		return types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{ID: containerID, State: state}}, nil
+	}
+
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if got, _ := w.GetTask(quick.ID); got.State != task.Completed {
+		t.Errorf("quick task is %v, want %v", got.State, task.Completed)
+	}
+	got, _ := w.GetTask(slow.ID)
+	if got.State != task.Cancelled || got.Reason != task.ReasonWorkerShutdown {
+		t.Errorf("slow task is %v (%q), want %v (%q)", got.State, got.Reason, task.Cancelled, task.ReasonWorkerShutdown)
+	}
+	if !slices.Equal(fc.stopped, []string{"container-2"}) {
+		t.Errorf("stopped = %v, want only the slow task's container", fc.stopped)
+	}
+
+	if result := w.RunTask(); result.Error != nil || result.ContainerID != "" {
+		t.Errorf("RunTask() after shutdown = %+v, want nothing started", result)
+	}
+	if fc.created != 2 {
+		t.Errorf("created %d containers, want the queued task left unstarted", fc.created)
+	}
+}
+
+func TestWorker_RunWarmPoolFollowsClock(t *testing.T) {
+	start := time.Date(2025, 1, 2, 9, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	w := newWorker(&fakeClient{})
+	w.Clock = c
+	w.WarmPool = []string{"nginx:1.27"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.RunWarmPool(ctx, time.Hour)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Nothing but the fake clock moving on an hour pulls the image again.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		images := w.WarmImages()
+		if len(images) == 1 && images[0].PulledAt.After(start) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WarmImages() = %+v, want nginx:1.27 pulled again", images)
+		}
+		if len(images) == 1 && images[0].PulledAt.Equal(start) {
+			c.Advance(time.Hour)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorker_WarmImagesAreNotPulledAtStart(t *testing.T) {
+	fc := &fakeClient{}
+	w := newWorker(fc)
+	w.WarmPool = []string{"nginx:1.27"}
+
+	w.RefreshWarmPool(context.Background())
+	images := w.WarmImages()
+	if len(images) != 1 || images[0].Image != "nginx:1.27" || images[0].PulledAt.IsZero() {
+		t.Fatalf("WarmImages() = %+v, want nginx:1.27 pulled", images)
+	}
+
+	warm := scheduledTask("web")
+	warm.Image = "nginx:1.27"
+	cold := scheduledTask("hello")
+	for _, tk := range []task.Task{warm, cold} {
+		w.AddTask(tk)
+		if result := w.RunTask(); result.Error != nil {
+			t.Fatalf("RunTask() error = %v", result.Error)
+		}
+	}
+
+	want := []string{"nginx:1.27", "strm/helloworld-http"}
+	if !slices.Equal(fc.pulls, want) {
+		t.Errorf("pulled %v, want %v", fc.pulls, want)
+	}
+}